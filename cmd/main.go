@@ -1,48 +1,260 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"ecom-book-store-sample-api/internal/config"
 	"ecom-book-store-sample-api/internal/handlers"
+	"ecom-book-store-sample-api/internal/lifecycle"
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/metrics"
+	"ecom-book-store-sample-api/internal/models"
 	"ecom-book-store-sample-api/internal/services"
 	"ecom-book-store-sample-api/internal/storage"
 )
 
 func main() {
+	// defaults < config file < BOOKSTORE_* environment < flags
+	cfg, printConfig, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) { os.Exit(0) }
+	if err != nil { fmt.Fprintln(os.Stderr, "configuration:", err); os.Exit(2) }
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil { fmt.Fprintln(os.Stderr, err); os.Exit(1) }
+		return
+	}
+
+	// JSON logs on stdout
+	level, _ := logging.ParseLevel(cfg.LogLevel) // validated by config.Load
+	logger := logging.New(os.Stdout, level)
+	slog.SetDefault(logger)
+	gin.SetMode(cfg.GinMode)
+
 	store := storage.NewMemoryStore()
-	storage.Seed(store)
+	if cfg.Seed { storage.Seed(store) }
+	if cfg.CartHolds { store.EnableStockHolds(cfg.CartHoldTTL) }
+	store.SetAllocationStrategy(storage.AllocationStrategies[cfg.AllocationStrategy])
+	// background loops stop, and are waited for, on shutdown
+	workers := lifecycle.NewWorkers()
 
 	productSvc := services.NewProductService(store)
 	cartSvc := services.NewCartService(store)
 	orderSvc := services.NewOrderService(store)
+	if cfg.CartHolds { workers.Go("hold-sweeper", func(ctx context.Context) { cartSvc.RunHoldSweeper(ctx, 10*time.Second) }) }
+	workers.Go("price-scheduler", func(ctx context.Context) { productSvc.RunPriceScheduler(ctx, 15*time.Second) })
+	restockNotifier := services.LogRestockNotifier{}
+	workers.Go("restock-notifier", func(ctx context.Context) { productSvc.RunRestockNotifier(ctx, restockNotifier, 10*time.Second) })
+	userSvc := services.NewUserService(store)
+	inventorySvc := services.NewInventoryService(store)
+	promotionSvc := services.NewPromotionService(store)
+	// the fake gateway approves everything, so it only runs when the
+	// configuration selects it; it confirms challenges in-process, as a
+	// webhook would
+	var fakePayments *services.FakePaymentProvider
+	if cfg.Payments() == "fake" {
+		fakePayments = services.NewFakePaymentProvider()
+		fakePayments.Notify = func(ctx context.Context, ev models.PaymentEvent) error { return orderSvc.HandlePaymentEvent(ctx, &ev) }
+		orderSvc.SetPaymentProvider(fakePayments)
+		if cfg.GinMode == "release" { slog.Warn("fake payment provider enabled in release mode; every authorization is approved") }
+	}
+	workers.Go("saga-recovery", func(ctx context.Context) { orderSvc.RunSagaRecovery(ctx, 30*time.Second, services.CheckoutSagaStaleSeconds*time.Second) })
+	alertNotifier := services.LogAlertNotifier{}
+	workers.Go("alert-dispatcher", func(ctx context.Context) { inventorySvc.RunAlertDispatcher(ctx, alertNotifier) })
+	// domain events leave the store's outbox through the bus
+	events := services.NewEventBus(store)
+	events.AddSink(services.LogEventSink{})
+	webhookSvc := services.NewWebhookService(store)
+	webhookSvc.Attach(events)
+	live := services.NewLiveHub(store)
+	live.Attach(events)
+	adminFeed := services.NewAdminFeed()
+	adminFeed.Attach(events)
+	cartSvc.OnRuleRejected(adminFeed.RuleRejected)
+	orderSvc.OnRuleRejected(adminFeed.RuleRejected)
+	// customer emails land in a maildir unless SMTP is configured
+	var mail services.MailTransport = &services.MaildirTransport{Dir: cfg.MaildirDir}
+	if cfg.MailTransport == "smtp" { mail = &services.SMTPTransport{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword} }
+	notificationSvc := services.NewNotificationService(store, mail, cfg.MailFrom)
+	notificationSvc.Attach(events)
+	services.AttachMetrics(events)
+	store.RegisterMetrics(metrics.Default)
+	workers.Go("event-bus", func(ctx context.Context) { events.Run(ctx, time.Second) })
+	workers.Go("webhook-deliveries", func(ctx context.Context) { webhookSvc.RunDeliveries(ctx, 2*time.Second) })
+	workers.Go("email-deliveries", func(ctx context.Context) { notificationSvc.RunDeliveries(ctx, 5*time.Second) })
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(handlers.RequestID(), handlers.Metrics(), handlers.AccessLog(logger), handlers.Recovery(logger))
+	// Prometheus scrape target and orchestrator probes
+	r.GET("/metrics", gin.WrapH(metrics.Default))
+	health := handlers.NewHealthHandler(
+		handlers.HealthCheck{Name: "storage", Check: store.Ping},
+		handlers.HealthCheck{Name: "rules", Check: orderSvc.CheckRules},
+	)
+	r.GET("/healthz", health.Live)
+	r.GET("/readyz", health.Ready)
 
-	api := r.Group("/api/v1")
+	// Cache-Control per catalog read route. Responses also carry ETag and
+	// Last-Modified so clients and CDNs can revalidate once max-age lapses.
+	listCache := handlers.CacheControl(cfg.CacheControlProductsList)
+	itemCache := handlers.CacheControl(cfg.CacheControlProduct)
+
+	api := r.Group("/api/v1", handlers.IdentifyCaller(userSvc))
 	{
 		ph := handlers.NewProductHandler(productSvc)
-		api.GET("/products", ph.ListProducts)
+		api.GET("/products", listCache, ph.ListProducts)
 		api.POST("/products", ph.CreateProduct)
-		api.GET("/products/:id", ph.GetProduct)
+		api.GET("/products/:id", itemCache, ph.GetProduct)
 		api.PUT("/products/:id", ph.UpdateProduct)
+		api.PATCH("/products/:id", ph.PatchProduct)
 		api.DELETE("/products/:id", ph.DeleteProduct)
+		api.POST("/products/:id/notify-me", handlers.RequireUser(userSvc), ph.NotifyMe)
+		api.DELETE("/products/:id/notify-me", handlers.RequireUser(userSvc), ph.CancelNotifyMe)
+		api.GET("/products/:id/price-history", ph.PriceHistory)
 
 		ch := handlers.NewCartHandler(cartSvc)
 		api.POST("/cart/user/:id/items", ch.AddToCart)
 		api.DELETE("/cart/user/:id/items", ch.RemoveFromCart)
+		api.GET("/cart/user/:id", ch.GetCart)
+		api.POST("/cart/user/:id/coupon", ch.ApplyCoupon)
+		api.DELETE("/cart/user/:id/coupon", ch.RemoveCoupon)
 
 		oh := handlers.NewOrderHandler(orderSvc)
 		api.POST("/orders/user/:id", oh.PlaceOrder)
+
+		sh := handlers.NewStreamHandler(live)
+		api.GET("/events/stream", sh.Stream)
+
+		pyh := handlers.NewPaymentHandler(orderSvc, fakePayments)
+		api.POST("/payments/webhook", pyh.Webhook)
+		if fakePayments != nil { api.POST("/payments/fake/challenges/:authorizationId", pyh.CompleteFakeChallenge) }
+
+		uh := handlers.NewUserHandler(userSvc)
+		// a user's own records; admins may act for anyone
+		account := api.Group("/users/:id", handlers.RequireSelfOrAdmin(userSvc))
+		account.GET("/addresses", uh.ListAddresses)
+		account.POST("/addresses", uh.CreateAddress)
+		account.GET("/addresses/:addressId", uh.GetAddress)
+		account.PUT("/addresses/:addressId", uh.UpdateAddress)
+		account.DELETE("/addresses/:addressId", uh.DeleteAddress)
+		account.GET("/orders/:orderId", oh.GetUserOrder)
+		account.POST("/orders/:orderId/returns", oh.RequestReturn)
+
+		nh := handlers.NewNotificationHandler(notificationSvc)
+		account.GET("/notification-preferences", nh.GetPreferences)
+		account.PUT("/notification-preferences", nh.UpdatePreferences)
+
+		admin := api.Group("/admin", handlers.RequireAdmin(userSvc))
+		admin.POST("/products/import", ph.ImportProducts)
+		admin.GET("/products/export", ph.ExportProducts)
+		admin.GET("/products/:id/scheduled-prices", ph.ListScheduledPrices)
+		admin.POST("/products/:id/scheduled-prices", ph.SchedulePrice)
+		admin.DELETE("/products/:id/scheduled-prices/:scheduleId", ph.CancelScheduledPrice)
+
+		ih := handlers.NewInventoryHandler(inventorySvc)
+		admin.POST("/inventory/:productId/adjust", ih.Adjust)
+		admin.GET("/inventory/:productId/movements", ih.ListMovements)
+		admin.GET("/inventory/reconcile", ih.Reconcile)
+		admin.GET("/inventory/:productId/stock", ih.WarehouseStock)
+		admin.POST("/inventory/:productId/transfer", ih.Transfer)
+		admin.GET("/warehouses", ih.ListWarehouses)
+		admin.POST("/warehouses", ih.CreateWarehouse)
+		admin.GET("/inventory/low-stock", ih.LowStock)
+		admin.GET("/purchase-orders", ih.ListPurchaseOrders)
+		admin.POST("/purchase-orders/:id/approve", ih.ApprovePurchaseOrder)
+
+		prh := handlers.NewPromotionHandler(promotionSvc)
+		admin.GET("/promotions", prh.ListPromotions)
+		admin.POST("/promotions", prh.CreatePromotion)
+		admin.DELETE("/promotions/:id", prh.DeactivatePromotion)
+
+		admin.GET("/orders/:id", oh.GetOrder)
+		admin.POST("/orders/:id/approve", oh.ApproveOrder)
+		admin.POST("/orders/:id/ship", oh.ShipOrder)
+		admin.POST("/orders/:id/cancel", oh.CancelOrder)
+		admin.POST("/orders/:id/deliver", oh.DeliverOrder)
+		admin.GET("/returns", oh.ListReturns)
+		admin.POST("/returns/:id/approve", oh.ApproveReturn)
+		admin.POST("/returns/:id/reject", oh.RejectReturn)
+		admin.POST("/returns/:id/receive", oh.ReceiveReturn)
+		admin.POST("/returns/:id/refund", oh.RefundReturn)
+		admin.GET("/checkout-sagas", oh.ListCheckoutSagas)
+		admin.POST("/checkout-sagas/:id/recover", oh.RecoverCheckoutSaga)
+
+		wh := handlers.NewWebhookHandler(webhookSvc)
+		admin.GET("/webhooks", wh.ListWebhooks)
+		admin.POST("/webhooks", wh.CreateWebhook)
+		admin.GET("/webhooks/:id", wh.GetWebhook)
+		admin.PUT("/webhooks/:id", wh.UpdateWebhook)
+		admin.DELETE("/webhooks/:id", wh.DeleteWebhook)
+		admin.GET("/webhooks/:id/deliveries", wh.ListSubscriptionDeliveries)
+		admin.GET("/webhook-deliveries", wh.ListDeliveries)
+		admin.POST("/webhook-deliveries/:id/redeliver", wh.Redeliver)
+		admin.GET("/events/parked", handlers.NewEventHandler(events).ListParkedEvents)
+
+		ash := handlers.NewAdminSocketHandler(adminFeed)
+		admin.GET("/ws", ash.Serve)
+		admin.GET("/notifications", nh.ListEmails)
 	}
 
-	srv := &http.Server{Addr: ":8080", Handler: r, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second, MaxHeaderBytes: 1 << 20}
+	srv := &http.Server{Addr: cfg.Addr, Handler: r, ReadTimeout: cfg.ReadTimeout, WriteTimeout: cfg.WriteTimeout, MaxHeaderBytes: cfg.MaxHeaderBytes, ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError)}
+	if cfg.TLS() { srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12} }
+
+	// Shutdown order: stop taking traffic and finish in-flight requests,
+	// stop the workers, deliver what the outboxes still hold, then close the
+	// store.
+	var shutdown lifecycle.Shutdown
+	shutdown.Add("streams", func(ctx context.Context) error { live.Close(); adminFeed.Close(); return nil })
+	shutdown.Add("http", srv.Shutdown)
+	shutdown.Add("workers", workers.Stop)
+	shutdown.Add("outboxes", func(ctx context.Context) error {
+		for productSvc.DispatchRestockNotifications(ctx, restockNotifier, services.RestockNotifyBatch) > 0 && ctx.Err() == nil {}
+		inventorySvc.DispatchAlerts(ctx, alertNotifier)
+		for events.Dispatch(ctx) > 0 && ctx.Err() == nil {}
+		webhookSvc.DeliverDue(ctx)
+		notificationSvc.DeliverDue(ctx)
+		return ctx.Err()
+	})
+	shutdown.Add("storage", func(ctx context.Context) error { return store.Close() })
 
-	log.Println("ecom-book-store-sample-api listening on :8080")
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server error: %v", err)
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLS() { serveErr <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile); return }
+		serveErr <- srv.ListenAndServe()
+	}()
+	slog.Info("ecom-book-store-sample-api listening", "addr", srv.Addr, "tls", cfg.TLS())
+	exit := 0
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) { slog.Error("server error", "error", err); exit = 1 }
+	case <-stop.Done():
+		// fail /readyz but keep serving until load balancers have noticed;
+		// a second signal kills the process
+		cancel()
+		health.SetDraining()
+		slog.Info("draining", "delay", cfg.DrainDelay.String())
+		select {
+		case <-time.After(cfg.DrainDelay):
+		case err := <-serveErr:
+			if !errors.Is(err, http.ErrServerClosed) { slog.Error("server error", "error", err); exit = 1 }
+		}
+		slog.Info("shutting down", "timeout", cfg.ShutdownTimeout.String())
 	}
+	cancel()
+	health.SetDraining()
+	ctx, done := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	if err := shutdown.Run(ctx); err != nil { slog.Error("shutdown incomplete", "error", err); exit = 1 }
+	done()
+	os.Exit(exit)
 }
@@ -0,0 +1,256 @@
+// Package config loads the server's settings from, in increasing priority,
+// built-in defaults, a JSON config file, BOOKSTORE_* environment variables
+// and command-line flags. Every setting has one name, its flag name; the
+// environment variable and the config file key are derived from it.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// EnvPrefix starts every environment variable, e.g. BOOKSTORE_READ_TIMEOUT
+// for --read-timeout.
+const EnvPrefix = "BOOKSTORE_"
+
+// Config is the server's configuration.
+type Config struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	MaxHeaderBytes  int
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long the server keeps serving, with /readyz failing,
+	// after a shutdown signal, so load balancers stop routing to it before
+	// the listener closes.
+	DrainDelay time.Duration
+	// TLSCertFile and TLSKeyFile, when both set, serve HTTPS.
+	TLSCertFile string
+	TLSKeyFile  string
+	// Seed loads the demo catalog and users at startup.
+	Seed     bool
+	GinMode  string
+	LogLevel string
+	// MailTransport is "maildir" (write emails to MaildirDir) or "smtp".
+	MailTransport string
+	MaildirDir    string
+	MailFrom      string
+	SMTPAddr      string
+	SMTPUsername  string
+	SMTPPassword  string
+	// PaymentProvider names the card gateway. The only one is "fake", the
+	// in-process FakePaymentProvider, which approves every authorization;
+	// empty selects it outside release mode and is refused in release mode.
+	PaymentProvider string
+	// AllocationStrategy names the storage.AllocationStrategies entry that
+	// picks warehouses at checkout.
+	AllocationStrategy string
+	// CartHolds turns on soft reservations: a cart line holds its quantity
+	// for CartHoldTTL, and a sweeper expires lapsed holds.
+	CartHolds   bool
+	CartHoldTTL time.Duration
+	// CacheControlProductsList and CacheControlProduct are the Cache-Control
+	// headers of GET /products and GET /products/:id; empty sends none.
+	CacheControlProductsList string
+	CacheControlProduct      string
+}
+
+// Default returns the built-in defaults, which match the server's behaviour
+// before it was configurable.
+func Default() Config {
+	return Config{
+		Addr: ":8080", ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second, MaxHeaderBytes: 1 << 20, ShutdownTimeout: 20 * time.Second, DrainDelay: 5 * time.Second,
+		Seed: true, GinMode: "debug", LogLevel: "info",
+		MailTransport: "maildir", MaildirDir: "maildir", MailFrom: "Book Store <orders@bookstore.example>",
+		AllocationStrategy: "nearest",
+		CartHolds: true, CartHoldTTL: 15 * time.Minute,
+		CacheControlProductsList: "public, max-age=30, must-revalidate", CacheControlProduct: "public, max-age=60, must-revalidate",
+	}
+}
+
+// Payments returns the payment provider to wire, or "" for none.
+func (c Config) Payments() string {
+	if c.PaymentProvider == "" && c.GinMode != "release" { return "fake" }
+	return c.PaymentProvider
+}
+
+// TLS reports whether the server should serve HTTPS.
+func (c Config) TLS() bool { return c.TLSCertFile != "" && c.TLSKeyFile != "" }
+
+// bind defines one flag per setting on fs, bound to c's fields.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "listen address, host:port")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "maximum time to read a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "maximum time to write a response (event streams are exempt)")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "maximum size of request headers")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time allowed to drain requests and flush on shutdown")
+	fs.DurationVar(&c.DrainDelay, "drain-delay", c.DrainDelay, "time to keep serving with /readyz failing before shutdown starts")
+	fs.StringVar(&c.TLSCertFile, "tls-cert", c.TLSCertFile, "TLS certificate file (PEM); with --tls-key serves HTTPS")
+	fs.StringVar(&c.TLSKeyFile, "tls-key", c.TLSKeyFile, "TLS private key file (PEM)")
+	fs.BoolVar(&c.Seed, "seed", c.Seed, "load the demo catalog and users at startup")
+	fs.StringVar(&c.GinMode, "gin-mode", c.GinMode, "gin mode: debug, release or test")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&c.MailTransport, "mail-transport", c.MailTransport, "how emails are sent: maildir or smtp")
+	fs.StringVar(&c.MaildirDir, "maildir", c.MaildirDir, "directory the maildir transport writes to")
+	fs.StringVar(&c.MailFrom, "mail-from", c.MailFrom, "From address of customer emails")
+	fs.StringVar(&c.SMTPAddr, "smtp-addr", c.SMTPAddr, "SMTP server, host:port")
+	fs.StringVar(&c.SMTPUsername, "smtp-username", c.SMTPUsername, "SMTP username; empty disables authentication")
+	fs.StringVar(&c.SMTPPassword, "smtp-password", c.SMTPPassword, "SMTP password")
+	fs.StringVar(&c.PaymentProvider, "payment-provider", c.PaymentProvider, "payment gateway: fake; empty means fake outside release mode")
+	fs.StringVar(&c.AllocationStrategy, "allocation-strategy", c.AllocationStrategy, "how checkout picks warehouses: nearest (the shipping region first) or cheapest")
+	fs.BoolVar(&c.CartHolds, "cart-holds", c.CartHolds, "hold cart quantities so others cannot buy them until checkout or the hold lapses")
+	fs.DurationVar(&c.CartHoldTTL, "cart-hold-ttl", c.CartHoldTTL, "how long a cart line holds its stock")
+	fs.StringVar(&c.CacheControlProductsList, "cache-control-products-list", c.CacheControlProductsList, "Cache-Control header of GET /products; empty sends none")
+	fs.StringVar(&c.CacheControlProduct, "cache-control-product", c.CacheControlProduct, "Cache-Control header of GET /products/:id; empty sends none")
+}
+
+// Load builds the configuration from args (without the program name) and
+// the environment lookup. The config file is named by --config or
+// BOOKSTORE_CONFIG. print reports whether --print-config was given.
+func Load(args []string, getenv func(string) string) (cfg Config, print bool, err error) {
+	// parse the flags on their own first: they name the config file, and
+	// they are applied last
+	flags := Default()
+	fs := flag.NewFlagSet("ecom-book-store-sample-api", flag.ContinueOnError)
+	flags.bind(fs)
+	path := fs.String("config", "", "JSON config file; keys are flag names (env "+EnvPrefix+"CONFIG)")
+	fs.BoolVar(&print, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+	if err := fs.Parse(args); err != nil { return cfg, false, err }
+	if fs.NArg() > 0 { return cfg, false, fmt.Errorf("unexpected arguments %q", fs.Args()) }
+
+	cfg = Default()
+	target := flag.NewFlagSet("config", flag.ContinueOnError)
+	target.SetOutput(io.Discard)
+	cfg.bind(target)
+	if *path == "" { *path = getenv(EnvPrefix + "CONFIG") }
+	if *path != "" {
+		if err := loadFile(target, *path); err != nil { return cfg, false, err }
+	}
+	var errs []error
+	target.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		v := getenv(name)
+		if v == "" && f.Name == "log-level" { v = getenv("LOG_LEVEL") } // the variable used before configuration existed
+		if v == "" { return }
+		if err := target.Set(f.Name, v); err != nil { errs = append(errs, fmt.Errorf("%s: %w", name, err)) }
+	})
+	if len(errs) > 0 { return cfg, false, errors.Join(errs...) }
+	fs.Visit(func(f *flag.Flag) {
+		if target.Lookup(f.Name) != nil { target.Set(f.Name, f.Value.String()) }
+	})
+	return cfg, print, cfg.Validate()
+}
+
+// EnvName is the environment variable for a flag name.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadFile applies a flat JSON object whose keys are flag names.
+func loadFile(target *flag.FlagSet, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil { return fmt.Errorf("config file: %w", err) }
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil { return fmt.Errorf("config file %s: %w", path, err) }
+	keys := make([]string, 0, len(values))
+	for k := range values { keys = append(keys, k) }
+	sort.Strings(keys)
+	var errs []error
+	for _, k := range keys {
+		if target.Lookup(k) == nil { errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", path, k)); continue }
+		var v string
+		switch x := values[k].(type) {
+		case string:
+			v = x
+		case json.Number:
+			v = x.String()
+		case bool:
+			v = fmt.Sprint(x)
+		default:
+			errs = append(errs, fmt.Errorf("config file %s: %s must be a string, number or boolean", path, k))
+			continue
+		}
+		if err := target.Set(k, v); err != nil { errs = append(errs, fmt.Errorf("config file %s: %s: %w", path, k, err)) }
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok { errs = append(errs, fmt.Errorf(format, args...)) }
+	}
+	_, _, err := net.SplitHostPort(c.Addr)
+	check(err == nil, "addr %q is not host:port", c.Addr)
+	check(c.ReadTimeout > 0, "read-timeout must be positive")
+	check(c.WriteTimeout >= 0, "write-timeout must not be negative")
+	check(c.MaxHeaderBytes >= 4096, "max-header-bytes must be at least 4096")
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	check(c.DrainDelay >= 0, "drain-delay must not be negative")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "tls-cert and tls-key must be set together")
+	for _, f := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if f == "" { continue }
+		_, err := os.Stat(f)
+		check(err == nil, "TLS file: %v", err)
+	}
+	check(c.GinMode == "debug" || c.GinMode == "release" || c.GinMode == "test", "gin-mode %q is not debug, release or test", c.GinMode)
+	_, err = logging.ParseLevel(c.LogLevel)
+	check(err == nil, "log-level: %v", err)
+	switch c.MailTransport {
+	case "maildir":
+		check(c.MaildirDir != "", "maildir is required by the maildir transport")
+	case "smtp":
+		_, _, err := net.SplitHostPort(c.SMTPAddr)
+		check(err == nil, "smtp-addr %q is not host:port", c.SMTPAddr)
+	default:
+		check(false, "mail-transport %q is not maildir or smtp", c.MailTransport)
+	}
+	_, err = mail.ParseAddress(c.MailFrom)
+	check(err == nil, "mail-from %q is not an email address", c.MailFrom)
+	check(c.PaymentProvider == "" || c.PaymentProvider == "fake", "payment-provider %q is not fake", c.PaymentProvider)
+	_, ok := storage.AllocationStrategies[c.AllocationStrategy]
+	check(ok, "allocation-strategy %q is not nearest or cheapest", c.AllocationStrategy)
+	check(!c.CartHolds || c.CartHoldTTL > 0, "cart-hold-ttl must be positive when cart-holds is on")
+	check(!strings.ContainsAny(c.CacheControlProductsList, "\r\n"), "cache-control-products-list must be a single header line")
+	check(!strings.ContainsAny(c.CacheControlProduct, "\r\n"), "cache-control-product must be a single header line")
+	check(c.Payments() != "", "payment-provider must be set in release mode; the fake gateway approves every payment and has to be chosen explicitly")
+	return errors.Join(errs...)
+}
+
+// Print writes the configuration as a JSON config file, keyed by flag name,
+// with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	fs := flag.NewFlagSet("print", flag.ContinueOnError)
+	c.bind(fs)
+	values := make(map[string]any)
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch g := f.Value.(flag.Getter).Get().(type) {
+		case bool, int:
+			values[f.Name] = g
+			return
+		}
+		if v != "" && logging.Sensitive(f.Name) { v = logging.Redacted }
+		values[f.Name] = v
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(values)
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string { return func(k string) string { return vars[k] } }
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"addr": ":9000", "read-timeout": "5s", "write-timeout": "5s", "max-header-bytes": 8192, "seed": false}`), 0o644)
+	cfg, print, err := Load([]string{"--config", path, "--write-timeout", "30s"}, env(map[string]string{"BOOKSTORE_READ_TIMEOUT": "7s", "BOOKSTORE_WRITE_TIMEOUT": "8s", "LOG_LEVEL": "debug"}))
+	if err != nil || print { t.Fatalf("load: %v %v", err, print) }
+	if cfg.Addr != ":9000" || cfg.MaxHeaderBytes != 8192 || cfg.Seed { t.Fatalf("expected the file's values, got %+v", cfg) }
+	if cfg.ReadTimeout != 7*time.Second || cfg.LogLevel != "debug" { t.Fatalf("expected the environment over the file, got %+v", cfg) }
+	if cfg.WriteTimeout != 30*time.Second { t.Fatalf("expected the flag over the environment, got %v", cfg.WriteTimeout) }
+	if cfg.ShutdownTimeout != Default().ShutdownTimeout || cfg.GinMode != "debug" { t.Fatalf("expected defaults for the rest, got %+v", cfg) }
+
+	// the config file may also come from the environment
+	cfg, _, err = Load(nil, env(map[string]string{"BOOKSTORE_CONFIG": path}))
+	if err != nil || cfg.Addr != ":9000" { t.Fatalf("expected BOOKSTORE_CONFIG read, got %v %+v", err, cfg) }
+}
+
+func TestLoad_Rejects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"adr": ":9000", "seed": {"on": true}}`), 0o644)
+	for name, tc := range map[string]struct {
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		"file":       {[]string{"--config", path}, nil, []string{`unknown setting "adr"`, "seed must be"}},
+		"env":        {nil, map[string]string{"BOOKSTORE_READ_TIMEOUT": "soon"}, []string{"BOOKSTORE_READ_TIMEOUT"}},
+		"validation": {[]string{"--addr", "8080", "--tls-cert", "cert.pem", "--gin-mode", "prod", "--mail-transport", "smtp"}, nil, []string{"addr", "tls-cert and tls-key", "gin-mode", "smtp-addr"}},
+		"arguments":  {[]string{"serve"}, nil, []string{"unexpected arguments"}},
+		"payments":   {[]string{"--gin-mode", "release"}, nil, []string{"payment-provider must be set"}},
+		"provider":   {[]string{"--payment-provider", "stripe"}, nil, []string{`payment-provider "stripe"`}},
+		"allocation": {nil, map[string]string{"BOOKSTORE_ALLOCATION_STRATEGY": "random"}, []string{`allocation-strategy "random"`}},
+		"holds":      {[]string{"--cart-hold-ttl", "0s"}, nil, []string{"cart-hold-ttl must be positive"}},
+		"drain":      {[]string{"--drain-delay", "-1s"}, nil, []string{"drain-delay must not be negative"}},
+		"mail-from":  {[]string{"--mail-from", "Book Store"}, nil, []string{`mail-from "Book Store"`}},
+		"cache":      {nil, map[string]string{"BOOKSTORE_CACHE_CONTROL_PRODUCT": "no-store\r\nX-Evil: 1"}, []string{"cache-control-product must be"}},
+	} {
+		_, _, err := Load(tc.args, env(tc.env))
+		for _, w := range tc.want {
+			if err == nil || !strings.Contains(err.Error(), w) { t.Fatalf("%s: expected an error mentioning %q, got %v", name, w, err) }
+		}
+	}
+}
+
+func TestPrint_RedactsSecretsAndRoundTrips(t *testing.T) {
+	cfg, print, err := Load([]string{"--print-config", "--mail-transport", "smtp", "--smtp-addr", "mail:587", "--smtp-password", "hunter2"}, env(nil))
+	if err != nil || !print { t.Fatalf("load: %v %v", err, print) }
+	var buf bytes.Buffer
+	cfg.Print(&buf)
+	var printed map[string]any
+	json.Unmarshal(buf.Bytes(), &printed)
+	if printed["smtp-password"] != "[REDACTED]" || strings.Contains(buf.String(), "hunter2") { t.Fatalf("expected the password redacted:\n%s", buf.String()) }
+	if printed["smtp-addr"] != "mail:587" || printed["read-timeout"] != "10s" || printed["max-header-bytes"] != float64(1<<20) || printed["seed"] != true { t.Fatalf("unexpected output:\n%s", buf.String()) }
+
+	// the output is itself a valid config file
+	delete(printed, "smtp-password")
+	raw, _ := json.Marshal(printed)
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, raw, 0o644)
+	again, _, err := Load([]string{"--config", path}, env(nil))
+	if err != nil || again.SMTPAddr != "mail:587" || again.ReadTimeout != cfg.ReadTimeout { t.Fatalf("round trip: %v %+v", err, again) }
+}
+
+func TestPayments_FakeOnlyWhenChosen(t *testing.T) {
+	if p := Default().Payments(); p != "fake" { t.Fatalf("expected the fake gateway in debug mode, got %q", p) }
+	cfg, _, err := Load([]string{"--gin-mode", "release", "--payment-provider", "fake"}, env(nil))
+	if err != nil || cfg.Payments() != "fake" { t.Fatalf("expected an explicit fake accepted in release mode, got %v %q", err, cfg.Payments()) }
+	cfg.PaymentProvider = ""
+	if p := cfg.Payments(); p != "" { t.Fatalf("expected no gateway in release mode by default, got %q", p) }
+}
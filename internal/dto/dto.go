@@ -1,30 +1,64 @@
 package dto
 
 import (
+	"io"
+	"time"
+
 	"ecom-book-store-sample-api/internal/models"
 )
 
 // Product DTOs
 
 type CreateProductRequest struct {
+	ISBN         string  `json:"isbn"`
 	Title        string  `json:"title"`
 	Author       string  `json:"author"`
 	Description  string  `json:"description"`
+	Category     string  `json:"category"`
 	Price        float64 `json:"price"`
 	Stock        int     `json:"stock"`
 	Discontinued bool    `json:"discontinued"`
 	IsSpecial    bool    `json:"isSpecial"`
+	AllowBackorder    bool       `json:"allowBackorder"`
+	MaxBackorder      int        `json:"maxBackorder"`
+	BackorderLeadDays int        `json:"backorderLeadDays"`
+	ReleaseDate       *time.Time `json:"releaseDate"`
+	ReorderPoint      int        `json:"reorderPoint"`
+	ReorderQuantity   int        `json:"reorderQuantity"`
+	Supplier          string     `json:"supplier"`
+	Actor             string     `json:"actor"`
 }
 
 type UpdateProductRequest struct {
 	ID           uint    `json:"id"`
+	ISBN         string  `json:"isbn"`
 	Title        string  `json:"title"`
 	Author       string  `json:"author"`
 	Description  string  `json:"description"`
+	Category     string  `json:"category"`
 	Price        float64 `json:"price"`
 	Stock        int     `json:"stock"`
 	Discontinued bool    `json:"discontinued"`
 	IsSpecial    bool    `json:"isSpecial"`
+	AllowBackorder    bool       `json:"allowBackorder"`
+	MaxBackorder      int        `json:"maxBackorder"`
+	BackorderLeadDays int        `json:"backorderLeadDays"`
+	ReleaseDate       *time.Time `json:"releaseDate"`
+	ReorderPoint      int        `json:"reorderPoint"`
+	ReorderQuantity   int        `json:"reorderQuantity"`
+	Supplier          string     `json:"supplier"`
+	// Version is the version the caller last saw; 0 skips the concurrency check.
+	Version uint64 `json:"version"`
+	Actor   string `json:"actor"`
+}
+
+// PatchProductRequest carries a JSON Merge Patch (RFC 7386) document for a
+// single product. Version must match the stored version; zero matches any.
+type PatchProductRequest struct {
+	ID      uint   `json:"id"`
+	Patch   []byte `json:"patch"`
+	Version uint64 `json:"version"`
+	Actor   string `json:"actor"`
 }
 
 type GetProductRequest struct { ID uint `json:"id"` }
@@ -33,6 +67,98 @@ type DeleteProductRequest struct { ID uint `json:"id"` }
 
 type ListProductsRequest struct{}
 
+type GetPriceHistoryRequest struct { ProductID uint `json:"productId"` }
+
+type SchedulePriceRequest struct {
+	ProductID   uint      `json:"productId"`
+	Price       float64   `json:"price"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+	Actor       string    `json:"actor"`
+}
+
+type ListScheduledPricesRequest struct { ProductID uint `json:"productId"` }
+
+type CancelScheduledPriceRequest struct {
+	ProductID uint `json:"productId"`
+	ID        uint `json:"id"`
+}
+
+// RestockSubscriptionRequest subscribes (or unsubscribes) a user to a
+// back-in-stock notification for one product.
+type RestockSubscriptionRequest struct {
+	UserID    uint `json:"userId"`
+	ProductID uint `json:"productId"`
+}
+
+// Catalog import/export DTOs
+
+// Supported bulk catalog formats.
+const (
+	CatalogFormatCSV    = "csv"
+	CatalogFormatNDJSON = "ndjson"
+)
+
+// ImportProductsRequest streams rows from Body. Rows are matched to existing
+// products by id, then isbn; unmatched rows create new products.
+type ImportProductsRequest struct {
+	Format string    `json:"format"`
+	Body   io.Reader `json:"-"`
+	DryRun bool      `json:"dryRun"`
+	Actor  string    `json:"actor"`
+}
+
+// ImportRowError reports a rejected row by its line number in the input.
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	ID    uint   `json:"id,omitempty"`
+	ISBN  string `json:"isbn,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	DryRun  bool             `json:"dryRun"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Failed  int              `json:"failed"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+type ExportProductsRequest struct { Format string `json:"format"` }
+
+// User DTOs
+
+type GetUserRequest struct { ID uint `json:"id"` }
+
+type ListAddressesRequest struct { UserID uint `json:"userId"` }
+
+// AddressRequest creates or (with ID set) fully replaces an address.
+type AddressRequest struct {
+	UserID          uint   `json:"userId"`
+	ID              uint   `json:"id"`
+	Label           string `json:"label"`
+	Name            string `json:"name"`
+	Line1           string `json:"line1"`
+	Line2           string `json:"line2"`
+	City            string `json:"city"`
+	Region          string `json:"region"`
+	PostalCode      string `json:"postalCode"`
+	Country         string `json:"country"`
+	Phone           string `json:"phone"`
+	DefaultShipping bool   `json:"defaultShipping"`
+	DefaultBilling  bool   `json:"defaultBilling"`
+}
+
+type GetAddressRequest struct {
+	UserID uint `json:"userId"`
+	ID     uint `json:"id"`
+}
+
+type DeleteAddressRequest struct {
+	UserID uint `json:"userId"`
+	ID     uint `json:"id"`
+}
+
 // Cart DTOs
 
 type AddToCartRequest struct {
@@ -46,16 +172,259 @@ type RemoveFromCartRequest struct {
 	ProductID uint `json:"productId"`
 }
 
-type GetCartRequest struct { UserID uint `json:"userId"` }
+// Country and Region pick the tax and shipping destination; when Country is
+// empty the user's default shipping address is used.
+type GetCartRequest struct {
+	UserID  uint   `json:"userId"`
+	Country string `json:"country"`
+	Region  string `json:"region"`
+}
+
+// Inventory DTOs
+
+// AdjustInventoryRequest records a manual movement. Quantity is always given
+// as a positive count for receipt and damage; adjustment takes a signed delta.
+type AdjustInventoryRequest struct {
+	ProductID   uint   `json:"productId"`
+	WarehouseID uint   `json:"warehouseId"` // 0 means the default warehouse
+	Type        string `json:"type"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+	Actor     string `json:"actor"`
+}
+
+// TransferStockRequest moves stock between warehouses via the ledger.
+type TransferStockRequest struct {
+	ProductID       uint   `json:"productId"`
+	FromWarehouseID uint   `json:"fromWarehouseId"`
+	ToWarehouseID   uint   `json:"toWarehouseId"`
+	Quantity        int    `json:"quantity"`
+	Reason          string `json:"reason"`
+	Actor           string `json:"actor"`
+}
+
+type CreateWarehouseRequest struct {
+	Code        string  `json:"code"`
+	Name        string  `json:"name"`
+	Region      string  `json:"region"`
+	CostPerUnit float64 `json:"costPerUnit"`
+	Priority    int     `json:"priority"`
+}
+
+type ListWarehousesRequest struct{}
+
+type GetWarehouseStockRequest struct { ProductID uint `json:"productId"` }
+
+type ListMovementsRequest struct { ProductID uint `json:"productId"` }
+
+type ReconcileInventoryRequest struct{}
+
+type ReconciliationReport struct {
+	Consistent    bool                          `json:"consistent"`
+	Discrepancies []models.InventoryDiscrepancy `json:"discrepancies"`
+}
+
+type ListLowStockRequest struct{}
+
+// Purchasing DTOs
+
+type ListPurchaseOrdersRequest struct { Status string `json:"status"` }
+
+type ApprovePurchaseOrderRequest struct {
+	ID    uint   `json:"id"`
+	Actor string `json:"actor"`
+}
+
+// Promotion DTOs
+
+type CreatePromotionRequest struct {
+	Code           string     `json:"code"`
+	Name           string     `json:"name"`
+	Type           string     `json:"type"`
+	Value          float64    `json:"value"`
+	BuyQuantity    int        `json:"buyQuantity"`
+	GetQuantity    int        `json:"getQuantity"`
+	Scope          string     `json:"scope"`
+	ProductIDs     []uint     `json:"productIds"`
+	Categories     []string   `json:"categories"`
+	Authors        []string   `json:"authors"`
+	StartsAt       *time.Time `json:"startsAt"`
+	EndsAt         *time.Time `json:"endsAt"`
+	MaxUses        int        `json:"maxUses"`
+	MaxUsesPerUser int        `json:"maxUsesPerUser"`
+	Stackable      bool       `json:"stackable"`
+}
+
+type ListPromotionsRequest struct{}
+
+type DeactivatePromotionRequest struct { ID uint `json:"id"` }
+
+// CouponRequest applies or removes a coupon code on a user's cart.
+type CouponRequest struct {
+	UserID uint   `json:"userId"`
+	Code   string `json:"code"`
+}
 
 // Order DTOs
 
-type PlaceOrderRequest struct { UserID uint `json:"userId"` }
+// PlaceOrderRequest picks addresses from the user's book. Zero IDs fall back
+// to the default shipping address, and to the default billing address (or
+// the shipping address) for billing.
+// IdempotencyKey identifies the checkout: retrying a completed checkout with
+// the same key returns its order instead of placing another, and a checkout
+// that was rolled back can be retried with it.
+type PlaceOrderRequest struct {
+	UserID            uint   `json:"userId"`
+	ShippingAddressID uint   `json:"shippingAddressId"`
+	BillingAddressID  uint   `json:"billingAddressId"`
+	IdempotencyKey    string `json:"idempotencyKey"`
+}
+
+// GetOrderRequest fetches an order; a non-zero UserID must own it.
+type GetOrderRequest struct {
+	ID     uint `json:"id"`
+	UserID uint `json:"userId"`
+}
+
+// OrderActionRequest approves, ships or cancels an order.
+type OrderActionRequest struct {
+	ID    uint   `json:"id"`
+	Actor string `json:"-"`
+}
+
+// ListCheckoutSagasRequest lists checkout sagas; Stuck keeps only those that
+// are FAILED or have stopped progressing.
+type ListCheckoutSagasRequest struct { Stuck bool `json:"stuck"` }
+
+type RecoverCheckoutSagaRequest struct { ID uint `json:"id"` }
+
+// Webhook DTOs
+
+// WebhookRequest creates or (with ID set) replaces a webhook subscription.
+// Active defaults to true; an empty Secret is generated on create and kept on
+// update.
+type WebhookRequest struct {
+	ID     uint     `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+type GetWebhookRequest struct { ID uint `json:"id"` }
+
+type ListWebhooksRequest struct{}
+
+// ListWebhookDeliveriesRequest filters the delivery log; zero values match
+// everything.
+type ListWebhookDeliveriesRequest struct {
+	SubscriptionID uint   `json:"subscriptionId"`
+	Status         string `json:"status"`
+}
+
+type RedeliverWebhookRequest struct { ID uint `json:"id"` }
+
+// Notification DTOs
+
+type GetNotificationPreferencesRequest struct { UserID uint `json:"userId"` }
+
+// NotificationPreferencesRequest changes only the fields that are set.
+type NotificationPreferencesRequest struct {
+	UserID       uint     `json:"userId"`
+	EmailEnabled *bool    `json:"emailEnabled"`
+	Muted        []string `json:"muted"`
+	Locale       *string  `json:"locale"`
+}
+
+// ListEmailsRequest filters the email log; zero values match everything.
+type ListEmailsRequest struct {
+	UserID uint   `json:"userId"`
+	Status string `json:"status"`
+}
+
+// Return DTOs
+
+type ReturnLine struct {
+	ProductID uint `json:"productId"`
+	Quantity  int  `json:"quantity"`
+}
+
+type CreateReturnRequest struct {
+	UserID  uint         `json:"userId"`
+	OrderID uint         `json:"orderId"`
+	Items   []ReturnLine `json:"items"`
+	Reason  string       `json:"reason"`
+}
+
+type ListReturnsRequest struct { Status string `json:"status"` }
+
+// ReturnActionRequest approves or rejects a return.
+type ReturnActionRequest struct {
+	ID    uint   `json:"id"`
+	Actor string `json:"-"`
+}
+
+type ReceiveLine struct {
+	ProductID uint `json:"productId"`
+	Restock   int  `json:"restock"`
+	WriteOff  int  `json:"writeOff"`
+}
+
+type ReceiveReturnRequest struct {
+	ID          uint          `json:"id"`
+	WarehouseID uint          `json:"warehouseId"` // 0 means the default warehouse
+	Items       []ReceiveLine `json:"items"`
+	Actor       string        `json:"-"`
+}
+
+// RefundReturnRequest refunds a received return. A zero Amount refunds the
+// full refundable amount; RefundShipping forces the order's shipping to be
+// included even if other units are kept.
+type RefundReturnRequest struct {
+	ID             uint    `json:"id"`
+	Amount         float64 `json:"amount"`
+	RefundShipping bool    `json:"refundShipping"`
+	Actor          string  `json:"-"`
+}
 
 // Response aliases (1.9+ type aliases, valid in Go 1.10)
 
+type User = models.User
+
+type Address = models.Address
+
 type Product = models.Product
 
 type Cart = models.Cart
 
 type Order = models.Order
+
+type PaymentEvent = models.PaymentEvent
+
+type CheckoutSaga = models.CheckoutSaga
+
+type WebhookSubscription = models.WebhookSubscription
+
+type WebhookDelivery = models.WebhookDelivery
+
+type NotificationPreferences = models.NotificationPreferences
+
+type EmailNotification = models.EmailNotification
+
+type ReturnAuthorization = models.ReturnAuthorization
+
+type InventoryMovement = models.InventoryMovement
+
+type Warehouse = models.Warehouse
+
+type WarehouseStock = models.WarehouseStock
+
+type PurchaseOrder = models.PurchaseOrder
+
+type RestockSubscription = models.RestockSubscription
+
+type PriceChange = models.PriceChange
+
+type ScheduledPrice = models.ScheduledPrice
+
+type Promotion = models.Promotion
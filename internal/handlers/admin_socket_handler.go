@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"ecom-book-store-sample-api/internal/services"
+)
+
+// adminSocketMessage is what dashboards send: {"action": "subscribe",
+// "topics": ["orders"]}, "unsubscribe" likewise, or {"action": "ping"}.
+type adminSocketMessage struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+}
+
+// Dashboard sockets are pinged every adminSocketPing and closed when no pong
+// arrives within adminSocketPongWait.
+const (
+	adminSocketPing     = 30 * time.Second
+	adminSocketPongWait = 60 * time.Second
+)
+
+type AdminSocketHandler struct {
+	feed     *services.AdminFeed
+	upgrader websocket.Upgrader
+	ping     time.Duration
+	pongWait time.Duration
+}
+
+func NewAdminSocketHandler(feed *services.AdminFeed) *AdminSocketHandler {
+	return &AdminSocketHandler{feed: feed, ping: adminSocketPing, pongWait: adminSocketPongWait}
+}
+
+// Serve upgrades to a WebSocket carrying the admin feed. Clients subscribe
+// to topics with messages; the server pings and closes connections that stop
+// answering or fall behind. A caller subprotocol offered for authentication
+// is echoed back, as browsers fail handshakes that select none.
+func (h *AdminSocketHandler) Serve(c *gin.Context) {
+	var header http.Header
+	if p := callerProtocol(c.Request); p != "" { header = http.Header{"Sec-Websocket-Protocol": {p}} }
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, header)
+	if err != nil { return } // the upgrader has already answered
+	defer conn.Close()
+	sub := h.feed.Subscribe()
+	defer h.feed.Unsubscribe(sub)
+
+	// replies to client messages go through the writer, the only goroutine
+	// allowed to write to conn
+	replies := make(chan any, 8)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() { defer close(stopped); h.write(conn, sub, replies, done) }()
+	defer close(done)
+
+	conn.SetReadLimit(4096)
+	conn.SetReadDeadline(time.Now().Add(h.pongWait))
+	conn.SetPongHandler(func(string) error { return conn.SetReadDeadline(time.Now().Add(h.pongWait)) })
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil { return } // closed, timed out or closed by the writer
+		conn.SetReadDeadline(time.Now().Add(h.pongWait))
+		var msg adminSocketMessage
+		var reply gin.H
+		switch err := json.Unmarshal(data, &msg); {
+		case err != nil:
+			reply = gin.H{"type": "error", "error": "invalid message"}
+		case msg.Action == "subscribe", msg.Action == "unsubscribe":
+			topics, err := h.feed.SetTopics(sub, msg.Topics, msg.Action == "subscribe")
+			if err != nil { reply = gin.H{"type": "error", "error": err.Error()} } else { reply = gin.H{"type": "subscribed", "topics": topics} }
+		case msg.Action == "ping":
+			reply = gin.H{"type": "pong"}
+		default:
+			reply = gin.H{"type": "error", "error": "unknown action " + strconv.Quote(msg.Action)}
+		}
+		select {
+		case replies <- reply:
+		case <-stopped:
+			return
+		}
+	}
+}
+
+func (h *AdminSocketHandler) write(conn *websocket.Conn, sub *services.AdminSubscription, replies <-chan any, done <-chan struct{}) {
+	t := time.NewTicker(h.ping)
+	defer t.Stop()
+	send := func(v any) bool {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(v) == nil
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-sub.Dropped():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+			conn.Close()
+			return
+		case <-h.feed.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+			conn.Close()
+			return
+		case v := <-replies:
+			if !send(v) { conn.Close(); return }
+		case env := <-sub.Events():
+			if !send(env) { conn.Close(); return }
+		case <-t.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)) != nil { conn.Close(); return }
+		}
+	}
+}
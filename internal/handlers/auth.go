@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/services"
+)
+
+// CallerIDHeader names the calling user. This is a demo stand-in for real
+// authentication: the header is trusted as-is.
+const CallerIDHeader = "X-User-ID"
+
+// CallerProtocolPrefix marks the WebSocket subprotocol that names the caller
+// on upgrade requests, e.g. "caller.3". Browsers cannot set CallerIDHeader on
+// a WebSocket handshake but can offer subprotocols.
+const CallerProtocolPrefix = "caller."
+
+// callerKey is the gin context key holding the resolved *models.User.
+const callerKey = "caller"
+
+// RequireAdmin rejects requests whose caller is missing (401) or is not an
+// admin (403).
+func RequireAdmin(users *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := resolveCaller(c, users)
+		if !ok { return }
+		if u.Role != models.RoleAdmin { c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin only"}); return }
+		c.Next()
+	}
+}
+
+// RequireUser rejects requests without a known caller (401).
+func RequireUser(users *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := resolveCaller(c, users); !ok { return }
+		c.Next()
+	}
+}
+
+// RequireSelfOrAdmin guards routes about the user named by the :id path
+// parameter. It rejects requests without a known caller (401) and callers who
+// are neither that user nor an admin (403).
+func RequireSelfOrAdmin(users *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, ok := resolveCaller(c, users)
+		if !ok { return }
+		if id, err := parseUint(c.Param("id")); (err != nil || id != u.ID) && u.Role != models.RoleAdmin { c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not your account"}); return }
+		c.Next()
+	}
+}
+
+// IdentifyCaller resolves CallerIDHeader when present so audit fields can
+// name the caller. Unlike RequireUser it never rejects the request.
+func IdentifyCaller(users *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id, err := parseUint(c.GetHeader(CallerIDHeader)); err == nil {
+			if u, err := users.GetUser(c.Request.Context(), &dto.GetUserRequest{ID: id}); err == nil { setCaller(c, u) }
+		}
+		c.Next()
+	}
+}
+
+// resolveCaller loads the user named by CallerIDHeader, or by a
+// CallerProtocolPrefix subprotocol on WebSocket upgrades, and stores it on
+// the context. On failure it aborts with 401 and returns false.
+func resolveCaller(c *gin.Context, users *services.UserService) (*models.User, bool) {
+	raw := c.GetHeader(CallerIDHeader)
+	if p := callerProtocol(c.Request); raw == "" && p != "" { raw = strings.TrimPrefix(p, CallerProtocolPrefix) }
+	id, err := parseUint(raw)
+	if err != nil { c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid " + CallerIDHeader}); return nil, false }
+	u, err := users.GetUser(c.Request.Context(), &dto.GetUserRequest{ID: id})
+	if err != nil { c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unknown caller"}); return nil, false }
+	setCaller(c, u)
+	return u, true
+}
+
+// callerProtocol returns the CallerProtocolPrefix subprotocol offered by a
+// WebSocket upgrade request, or "".
+func callerProtocol(r *http.Request) string {
+	if !websocket.IsWebSocketUpgrade(r) { return "" }
+	for _, p := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(p, CallerProtocolPrefix) { return p }
+	}
+	return ""
+}
+
+// setCaller stores the resolved caller on the context and in the request's
+// log fields.
+func setCaller(c *gin.Context, u *models.User) {
+	c.Set(callerKey, u)
+	logging.SetUserID(c.Request.Context(), u.ID)
+}
+
+// caller returns the user resolved by an auth middleware, or nil.
+func caller(c *gin.Context) *models.User {
+	if v, ok := c.Get(callerKey); ok {
+		if u, ok := v.(*models.User); ok { return u }
+	}
+	return nil
+}
+
+// callerActor formats the caller for audit fields such as ledger actors.
+func callerActor(c *gin.Context) string {
+	if u := caller(c); u != nil { return fmt.Sprintf("user:%d", u.ID) }
+	return "anonymous"
+}
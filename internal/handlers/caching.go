@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheControl returns middleware that sets the Cache-Control header for a
+// route, e.g. r.GET("/products", CacheControl("public, max-age=60"), h.List).
+// Pass an empty value to leave the header unset.
+func CacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value != "" { c.Header("Cache-Control", value) }
+		c.Next()
+	}
+}
+
+// notModified sets the ETag and Last-Modified validators on the response and
+// reports whether the request's conditional headers match them. When it
+// returns true a 304 has already been written and the handler must stop.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2).
+//
+// Last-Modified has one-second resolution, so it is only sent, and
+// If-Modified-Since only honoured, once the second of the last change is
+// over; otherwise a second write in that second would go unnoticed.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	if !lastModified.Truncate(time.Second).Before(time.Now().Truncate(time.Second)) { lastModified = time.Time{} }
+	if !lastModified.IsZero() { c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat)) }
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if etagListMatches(inm, etag) { c.Status(http.StatusNotModified); return true }
+		return false
+	}
+	if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) { c.Status(http.StatusNotModified); return true }
+	}
+	return false
+}
+
+// etagListMatches applies the weak comparison used by If-None-Match.
+func etagListMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" { return true }
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == want { return true }
+	}
+	return false
+}
@@ -37,7 +37,7 @@ func allowCartOp(userID uint, limit int, window time.Duration) bool {
 	// prune old
 	pruned := evts[:0]
 	for _, t := range evts { if now.Sub(t) <= window { pruned = append(pruned, t) } }
-	if len(pruned) >= limit { cartOps[userID] = pruned; return false }
+	if len(pruned) >= limit { cartOps[userID] = pruned; rateLimited.Inc("cart"); return false }
 	pruned = append(pruned, now)
 	cartOps[userID] = pruned
 	return true
@@ -66,3 +66,36 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
 	c.JSON(http.StatusOK, cart)
 }
+
+func (h *CartHandler) GetCart(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	cart, err := h.svc.GetCart(c.Request.Context(), &dto.GetCartRequest{UserID: userID, Country: c.Query("country"), Region: c.Query("region")})
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, cart)
+}
+
+type couponRequest struct {
+	Code string `json:"code"`
+}
+
+func (h *CartHandler) ApplyCoupon(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	if !allowCartOp(userID, 10, time.Minute) { c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many cart updates"}); return }
+	var body couponRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	cart, err := h.svc.ApplyCoupon(c.Request.Context(), &dto.CouponRequest{UserID: userID, Code: body.Code})
+	if err != nil { c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, cart)
+}
+
+func (h *CartHandler) RemoveCoupon(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	var body couponRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	cart, err := h.svc.RemoveCoupon(c.Request.Context(), &dto.CouponRequest{UserID: userID, Code: body.Code})
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, cart)
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/services"
+)
+
+type EventHandler struct { bus *services.EventBus }
+
+func NewEventHandler(bus *services.EventBus) *EventHandler { return &EventHandler{bus: bus} }
+
+// ListParkedEvents lists the domain events a sink still refused after the
+// bus's last attempt, oldest first.
+func (h *EventHandler) ListParkedEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, h.bus.ParkedEvents(c.Request.Context()))
+}
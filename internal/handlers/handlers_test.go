@@ -1,15 +1,27 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/metrics"
 	"ecom-book-store-sample-api/internal/models"
 	"ecom-book-store-sample-api/internal/services"
 	"ecom-book-store-sample-api/internal/storage"
@@ -23,38 +35,170 @@ type cartResp models.Cart
 
 func setupRouter() (*gin.Engine, *storage.MemoryStore) {
 	gin.SetMode(gin.TestMode)
+	resetRateLimits()
 	store := storage.NewMemoryStore()
 	storage.Seed(store)
 
 	productSvc := services.NewProductService(store)
 	cartSvc := services.NewCartService(store)
 	orderSvc := services.NewOrderService(store)
+	userSvc := services.NewUserService(store)
+	inventorySvc := services.NewInventoryService(store)
+	promotionSvc := services.NewPromotionService(store)
+	// the fake gateway confirms challenges in-process, as a webhook would
+	payments := services.NewFakePaymentProvider()
+	testPayments = payments
+	payments.Notify = func(ctx context.Context, ev models.PaymentEvent) error { return orderSvc.HandlePaymentEvent(ctx, &ev) }
+	orderSvc.SetPaymentProvider(payments)
+	webhookSvc := services.NewWebhookService(store)
+	testEvents = services.NewEventBus(store)
+	for testEvents.Dispatch(context.Background()) > 0 {} // drain the seed's events
+	live := services.NewLiveHub(store)
+	live.Attach(testEvents)
+	testLive = live
+	adminFeed := services.NewAdminFeed()
+	adminFeed.Attach(testEvents)
+	cartSvc.OnRuleRejected(adminFeed.RuleRejected)
+	orderSvc.OnRuleRejected(adminFeed.RuleRejected)
+	notificationSvc := services.NewNotificationService(store, &services.MaildirTransport{Dir: os.TempDir()}, "Book Store <orders@bookstore.example>")
+	notificationSvc.Attach(testEvents)
+	services.AttachMetrics(testEvents)
 
+	testLog = &logBuffer{}
+	logger := logging.New(testLog, slog.LevelDebug)
+	slog.SetDefault(logger) // services log through the default logger
 	r := gin.New()
-	api := r.Group("/api/v1")
+	r.Use(RequestID(), Metrics(), AccessLog(logger), Recovery(logger))
+	r.GET("/metrics", gin.WrapH(metrics.Default))
+	api := r.Group("/api/v1", IdentifyCaller(userSvc))
 	{
 		ph := NewProductHandler(productSvc)
 		api.GET("/products", ph.ListProducts)
 		api.POST("/products", ph.CreateProduct)
 		api.GET("/products/:id", ph.GetProduct)
 		api.PUT("/products/:id", ph.UpdateProduct)
+		api.PATCH("/products/:id", ph.PatchProduct)
 		api.DELETE("/products/:id", ph.DeleteProduct)
+		api.POST("/products/:id/notify-me", RequireUser(userSvc), ph.NotifyMe)
+		api.DELETE("/products/:id/notify-me", RequireUser(userSvc), ph.CancelNotifyMe)
+		api.GET("/products/:id/price-history", ph.PriceHistory)
 
 		ch := NewCartHandler(cartSvc)
 		api.POST("/cart/user/:id/items", ch.AddToCart)
 		api.DELETE("/cart/user/:id/items", ch.RemoveFromCart)
+		api.GET("/cart/user/:id", ch.GetCart)
+		api.POST("/cart/user/:id/coupon", ch.ApplyCoupon)
+		api.DELETE("/cart/user/:id/coupon", ch.RemoveCoupon)
 
 		oh := NewOrderHandler(orderSvc)
 		api.POST("/orders/user/:id", oh.PlaceOrder)
+
+		sh := NewStreamHandler(live)
+		api.GET("/events/stream", sh.Stream)
+
+		pyh := NewPaymentHandler(orderSvc, payments)
+		api.POST("/payments/webhook", pyh.Webhook)
+		api.POST("/payments/fake/challenges/:authorizationId", pyh.CompleteFakeChallenge)
+
+		uh := NewUserHandler(userSvc)
+		// a user's own records; admins may act for anyone
+		account := api.Group("/users/:id", RequireSelfOrAdmin(userSvc))
+		account.GET("/addresses", uh.ListAddresses)
+		account.POST("/addresses", uh.CreateAddress)
+		account.GET("/addresses/:addressId", uh.GetAddress)
+		account.PUT("/addresses/:addressId", uh.UpdateAddress)
+		account.DELETE("/addresses/:addressId", uh.DeleteAddress)
+		account.GET("/orders/:orderId", oh.GetUserOrder)
+		account.POST("/orders/:orderId/returns", oh.RequestReturn)
+
+		nh := NewNotificationHandler(notificationSvc)
+		account.GET("/notification-preferences", nh.GetPreferences)
+		account.PUT("/notification-preferences", nh.UpdatePreferences)
+
+		admin := api.Group("/admin", RequireAdmin(userSvc))
+		admin.POST("/products/import", ph.ImportProducts)
+		admin.GET("/products/export", ph.ExportProducts)
+		admin.GET("/products/:id/scheduled-prices", ph.ListScheduledPrices)
+		admin.POST("/products/:id/scheduled-prices", ph.SchedulePrice)
+		admin.DELETE("/products/:id/scheduled-prices/:scheduleId", ph.CancelScheduledPrice)
+
+		ih := NewInventoryHandler(inventorySvc)
+		admin.POST("/inventory/:productId/adjust", ih.Adjust)
+		admin.GET("/inventory/:productId/movements", ih.ListMovements)
+		admin.GET("/inventory/reconcile", ih.Reconcile)
+		admin.GET("/inventory/:productId/stock", ih.WarehouseStock)
+		admin.POST("/inventory/:productId/transfer", ih.Transfer)
+		admin.GET("/warehouses", ih.ListWarehouses)
+		admin.POST("/warehouses", ih.CreateWarehouse)
+		admin.GET("/inventory/low-stock", ih.LowStock)
+		admin.GET("/purchase-orders", ih.ListPurchaseOrders)
+		admin.POST("/purchase-orders/:id/approve", ih.ApprovePurchaseOrder)
+
+		prh := NewPromotionHandler(promotionSvc)
+		admin.GET("/promotions", prh.ListPromotions)
+		admin.POST("/promotions", prh.CreatePromotion)
+		admin.DELETE("/promotions/:id", prh.DeactivatePromotion)
+
+		admin.GET("/orders/:id", oh.GetOrder)
+		admin.POST("/orders/:id/approve", oh.ApproveOrder)
+		admin.POST("/orders/:id/ship", oh.ShipOrder)
+		admin.POST("/orders/:id/cancel", oh.CancelOrder)
+		admin.POST("/orders/:id/deliver", oh.DeliverOrder)
+		admin.GET("/returns", oh.ListReturns)
+		admin.POST("/returns/:id/approve", oh.ApproveReturn)
+		admin.POST("/returns/:id/reject", oh.RejectReturn)
+		admin.POST("/returns/:id/receive", oh.ReceiveReturn)
+		admin.POST("/returns/:id/refund", oh.RefundReturn)
+		admin.GET("/checkout-sagas", oh.ListCheckoutSagas)
+		admin.POST("/checkout-sagas/:id/recover", oh.RecoverCheckoutSaga)
+
+		wh := NewWebhookHandler(webhookSvc)
+		admin.GET("/webhooks", wh.ListWebhooks)
+		admin.POST("/webhooks", wh.CreateWebhook)
+		admin.GET("/webhooks/:id", wh.GetWebhook)
+		admin.PUT("/webhooks/:id", wh.UpdateWebhook)
+		admin.DELETE("/webhooks/:id", wh.DeleteWebhook)
+		admin.GET("/webhooks/:id/deliveries", wh.ListSubscriptionDeliveries)
+		admin.GET("/webhook-deliveries", wh.ListDeliveries)
+		admin.POST("/webhook-deliveries/:id/redeliver", wh.Redeliver)
+		admin.GET("/events/parked", NewEventHandler(testEvents).ListParkedEvents)
+
+		ash := NewAdminSocketHandler(adminFeed)
+		admin.GET("/ws", ash.Serve)
+		admin.GET("/notifications", nh.ListEmails)
 	}
 	return r, store
 }
 
+// testLog receives the JSON logs of the last router from setupRouter.
+var testLog *logBuffer
+
+// testPayments is the fake gateway behind the last router from setupRouter.
+var testPayments *services.FakePaymentProvider
+
+// testLive is the live hub behind the last router from setupRouter.
+var testLive *services.LiveHub
+
+// testEvents is the event bus behind the last router from setupRouter; tests
+// call Dispatch to publish.
+var testEvents *services.EventBus
+
+// resetRateLimits clears the package-level limiters so each test starts fresh.
+func resetRateLimits() {
+	prodOpsMu.Lock(); prodOps = nil; prodOpsMu.Unlock()
+	cartOpsMu.Lock(); cartOps = map[uint][]time.Time{}; cartOpsMu.Unlock()
+}
+
 func do(r *gin.Engine, method, path string, body string) *httptest.ResponseRecorder {
+	return doWithHeaders(r, method, path, body, nil)
+}
+
+func doWithHeaders(r *gin.Engine, method, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
 	var reader *bytes.Reader
 	if body == "" { reader = bytes.NewReader(nil) } else { reader = bytes.NewReader([]byte(body)) }
 	req := httptest.NewRequest(method, path, reader)
 	if body != "" { req.Header.Set("Content-Type", "application/json") }
+	for k, v := range headers { req.Header.Set(k, v) }
 	rec := httptest.NewRecorder()
 	r.ServeHTTP(rec, req)
 	return rec
@@ -105,5 +249,726 @@ func TestCartAndOrderFlow(t *testing.T) {
 	if ord.Total <= 0 { t.Fatalf("expected positive total, got %f", ord.Total) }
 }
 
+func TestPatchProductConcurrency(t *testing.T) {
+	r, _ := setupRouter()
+	rec := do(r, http.MethodGet, "/api/v1/products/1", "")
+	etag := rec.Header().Get("ETag")
+	if etag == "" { t.Fatalf("expected ETag on GET") }
+	patch := map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag}
+	// missing If-Match
+	rec = do(r, http.MethodPatch, "/api/v1/products/1", `{"price":50}`)
+	if rec.Code != http.StatusPreconditionRequired { t.Fatalf("expected 428, got %d", rec.Code) }
+	// first editor wins
+	rec = doWithHeaders(r, http.MethodPatch, "/api/v1/products/1", `{"price":50}`, patch)
+	if rec.Code != http.StatusOK { t.Fatalf("patch: expected 200, got %d: %s", rec.Code, rec.Body.String()) }
+	var out productResp
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if out.Price != 50 || out.Stock != 50 { t.Fatalf("expected price 50 and untouched stock, got %+v", out) }
+	if rec.Header().Get("ETag") == etag { t.Fatalf("expected new ETag after patch") }
+	// second editor with the stale tag is rejected
+	rec = doWithHeaders(r, http.MethodPatch, "/api/v1/products/1", `{"stock":1}`, patch)
+	if rec.Code != http.StatusPreconditionFailed { t.Fatalf("expected 412, got %d", rec.Code) }
+	// PUT honours If-Match as well
+	rec = doWithHeaders(r, http.MethodPut, "/api/v1/products/1", `{"title":"T","author":"A","price":1,"stock":1}`, map[string]string{"If-Match": etag})
+	if rec.Code != http.StatusPreconditionFailed { t.Fatalf("put: expected 412, got %d", rec.Code) }
+	// a list matches when any member is current
+	current := doWithHeaders(r, http.MethodGet, "/api/v1/products/1", "", nil).Header().Get("ETag")
+	rec = doWithHeaders(r, http.MethodPatch, "/api/v1/products/1", `{"price":51}`, map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag + `, "2-1", ` + current})
+	if rec.Code != http.StatusOK { t.Fatalf("list: expected 200, got %d: %s", rec.Code, rec.Body.String()) }
+}
+
+func TestPatchProductIfMatchAny(t *testing.T) {
+	r, _ := setupRouter()
+	star := map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": "*"}
+	// "*" matches whatever version is current, however many edits happened
+	for i, price := range []string{"52", "53"} {
+		rec := doWithHeaders(r, http.MethodPatch, "/api/v1/products/1", `{"price":`+price+`}`, star)
+		if rec.Code != http.StatusOK { t.Fatalf("patch %d: expected 200, got %d: %s", i, rec.Code, rec.Body.String()) }
+	}
+	var out productResp
+	json.Unmarshal(do(r, http.MethodGet, "/api/v1/products/1", "").Body.Bytes(), &out)
+	if out.Price != 53 || out.Version != 3 { t.Fatalf("expected price 53 at version 3, got %+v", out) }
+	// ...but only of a product that exists
+	rec := doWithHeaders(r, http.MethodPatch, "/api/v1/products/999", `{"price":52}`, star)
+	if rec.Code != http.StatusPreconditionFailed { t.Fatalf("missing product: expected 412, got %d", rec.Code) }
+}
+
+func TestPatchProductIgnoresStockChurn(t *testing.T) {
+	r, _ := setupRouter()
+	etag := do(r, http.MethodGet, "/api/v1/products/2", "").Header().Get("ETag")
+	// a sale changes the representation but is not a catalog edit
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":2,"quantity":2}`)
+	if rec := do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusCreated { t.Fatalf("order: %d %s", rec.Code, rec.Body.String()) }
+	if rec := doWithHeaders(r, http.MethodGet, "/api/v1/products/2", "", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusOK { t.Fatalf("GET after sale: expected 200, got %d", rec.Code) }
+	rec := doWithHeaders(r, http.MethodPatch, "/api/v1/products/2", `{"title":"Renamed"}`, map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag})
+	if rec.Code != http.StatusOK { t.Fatalf("patch: expected 200, got %d: %s", rec.Code, rec.Body.String()) }
+	var out productResp
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if out.Title != "Renamed" || out.Stock != 58 || out.Version != 2 { t.Fatalf("expected the sale kept and version 2, got %+v", out) }
+}
+
+func TestPatchProductIgnoresCartHolds(t *testing.T) {
+	r, store := setupRouter()
+	store.EnableStockHolds(time.Minute)
+	etag := do(r, http.MethodGet, "/api/v1/products/1", "").Header().Get("ETag")
+	if rec := do(r, http.MethodPost, "/api/v1/cart/user/2/items", `{"productId":1,"quantity":1}`); rec.Code != http.StatusOK { t.Fatalf("add: %d %s", rec.Code, rec.Body.String()) }
+	// the hold changes held/available for readers...
+	if rec := doWithHeaders(r, http.MethodGet, "/api/v1/products/1", "", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusOK { t.Fatalf("GET after hold: expected 200, got %d", rec.Code) }
+	// ...but is no catalog edit
+	rec := doWithHeaders(r, http.MethodPatch, "/api/v1/products/1", `{"price":42}`, map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": etag})
+	if rec.Code != http.StatusOK { t.Fatalf("patch: expected 200, got %d: %s", rec.Code, rec.Body.String()) }
+	var out productResp
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if out.Price != 42 || out.Held != 1 { t.Fatalf("expected the new price and the hold kept, got %+v", out) }
+}
+
+func TestParseIfMatch(t *testing.T) {
+	cases := []struct {
+		header   string
+		versions []uint64
+		ok       bool
+	}{
+		{"", nil, true},
+		{"*", nil, true},
+		{`"1-3"`, []uint64{3}, true},
+		{`"1-3-9f2c"`, []uint64{3}, true},
+		{`"1-3-"`, nil, false},
+		{`"1-3-zz"`, nil, false},
+		{`"2-1", "1-3" ,"1-4"`, []uint64{3, 4}, true},
+		{`"1-3x"`, nil, false},
+		{`"1-3"x`, nil, false},
+		{`W/"1-3"`, nil, false},
+		{`1-3`, nil, false},
+		{`"1-3", junk`, nil, false},
+		{`"1-0"`, nil, false},
+		{`"2-3"`, nil, false},
+	}
+	for _, tc := range cases {
+		versions, ok := parseIfMatch(tc.header, 1)
+		if ok != tc.ok || fmt.Sprint(versions) != fmt.Sprint(tc.versions) { t.Errorf("parseIfMatch(%q) = %v, %v; want %v, %v", tc.header, versions, ok, tc.versions, tc.ok) }
+	}
+}
+
+// nextSecond sleeps until the current wall-clock second is over, after which
+// writes made so far carry a Last-Modified.
+func nextSecond() { time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second))) }
+
+func TestConditionalCatalogReads(t *testing.T) {
+	r, _ := setupRouter()
+	nextSecond()
+	rec := do(r, http.MethodGet, "/api/v1/products", "")
+	listTag := rec.Header().Get("ETag")
+	if listTag == "" || rec.Header().Get("Last-Modified") == "" { t.Fatalf("expected list validators, got %v", rec.Header()) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products", "", map[string]string{"If-None-Match": listTag})
+	if rec.Code != http.StatusNotModified { t.Fatalf("list: expected 304, got %d", rec.Code) }
+	if rec.Body.Len() != 0 { t.Fatalf("expected empty 304 body") }
+
+	rec = do(r, http.MethodGet, "/api/v1/products/2", "")
+	itemTag, lastMod := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products/2", "", map[string]string{"If-None-Match": `"x", W/` + itemTag})
+	if rec.Code != http.StatusNotModified { t.Fatalf("item: expected 304, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products/2", "", map[string]string{"If-Modified-Since": lastMod})
+	if rec.Code != http.StatusNotModified { t.Fatalf("item ims: expected 304, got %d", rec.Code) }
+
+	// a sale changes stock, so both validators must change
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":2,"quantity":1}`)
+	if rec = do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusCreated { t.Fatalf("order: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products/2", "", map[string]string{"If-None-Match": itemTag})
+	if rec.Code != http.StatusOK { t.Fatalf("item after sale: expected 200, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products", "", map[string]string{"If-None-Match": listTag})
+	if rec.Code != http.StatusOK { t.Fatalf("list after sale: expected 200, got %d", rec.Code) }
+	// within the second of a change no Last-Modified is sent, so a second
+	// write in the same second cannot hide behind it
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products/2", "", map[string]string{"If-Modified-Since": lastMod})
+	if rec.Code != http.StatusOK || rec.Header().Get("Last-Modified") == lastMod { t.Fatalf("item ims after sale: expected 200 and a new Last-Modified or none, got %d %v", rec.Code, rec.Header()) }
+}
+
+func TestConditionalProductReadSeesLapsedHolds(t *testing.T) {
+	r, store := setupRouter()
+	store.EnableStockHolds(50 * time.Millisecond)
+	if rec := do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":2,"quantity":1}`); rec.Code != http.StatusOK { t.Fatalf("add: %d %s", rec.Code, rec.Body.String()) }
+	rec := do(r, http.MethodGet, "/api/v1/products/2", "")
+	etag := rec.Header().Get("ETag")
+	var held productResp
+	json.Unmarshal(rec.Body.Bytes(), &held)
+	if held.Held != 1 { t.Fatalf("expected the hold, got %+v", held) }
+	// the hold lapses; no sweep has run
+	time.Sleep(60 * time.Millisecond)
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products/2", "", map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusOK { t.Fatalf("expected 200 once the hold lapsed, got %d", rec.Code) }
+	var lapsed productResp
+	json.Unmarshal(rec.Body.Bytes(), &lapsed)
+	if lapsed.Held != 0 || !lapsed.UpdatedAt.After(held.UpdatedAt) { t.Fatalf("expected the lapse reported as a change, got %+v -> %+v", held, lapsed) }
+}
+
+func TestCacheControlMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/x", CacheControl("public, max-age=5"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	rec := do(r, http.MethodGet, "/x", "")
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=5" { t.Fatalf("unexpected Cache-Control %q", got) }
+}
+
+func TestAdminCatalogImportExport(t *testing.T) {
+	r, _ := setupRouter()
+	csvBody := "isbn,title,author,price,stock\n9780321125217,DDD Reference,Eric Evans,30,4\n"
+	// no caller / non-admin caller
+	rec := do(r, http.MethodPost, "/api/v1/admin/products/import", csvBody)
+	if rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/products/import", csvBody, map[string]string{CallerIDHeader: "1", "Content-Type": "text/csv"})
+	if rec.Code != http.StatusForbidden { t.Fatalf("expected 403, got %d", rec.Code) }
+
+	admin := map[string]string{CallerIDHeader: "3", "Content-Type": "text/csv"}
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/products/import?dryRun=true", csvBody, admin)
+	if rec.Code != http.StatusOK { t.Fatalf("dry run: expected 200, got %d: %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/products/import", csvBody, admin)
+	var report struct{ Created int `json:"created"` }
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || report.Created != 1 { t.Fatalf("import: %d %s", rec.Code, rec.Body.String()) }
+
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/products/export?format=ndjson", "", admin)
+	if rec.Code != http.StatusOK { t.Fatalf("export: expected 200, got %d", rec.Code) }
+	if lines := bytes.Count(rec.Body.Bytes(), []byte("\n")); lines != 11 { t.Fatalf("expected 11 ndjson lines, got %d", lines) }
+}
+
+func TestAdminInventoryEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	admin := map[string]string{CallerIDHeader: "3"}
+	rec := doWithHeaders(r, http.MethodPost, "/api/v1/admin/inventory/2/adjust", `{"type":"damage","quantity":1,"reason":"torn cover"}`, admin)
+	if rec.Code != http.StatusCreated { t.Fatalf("adjust: expected 201, got %d: %s", rec.Code, rec.Body.String()) }
+	var mv models.InventoryMovement
+	json.Unmarshal(rec.Body.Bytes(), &mv)
+	if mv.Actor != "user:3" || mv.StockAfter != 59 { t.Fatalf("unexpected movement: %+v", mv) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/inventory/2/adjust", `{"type":"damage","quantity":100,"reason":"flood"}`, admin)
+	if rec.Code != http.StatusConflict { t.Fatalf("expected 409 for negative stock, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/inventory/2/movements", "", admin)
+	var moves []models.InventoryMovement
+	json.Unmarshal(rec.Body.Bytes(), &moves)
+	if rec.Code != http.StatusOK || len(moves) != 2 { t.Fatalf("movements: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/inventory/reconcile", "", admin)
+	if rec.Code != http.StatusOK || !bytes.Contains(rec.Body.Bytes(), []byte(`"consistent":true`)) { t.Fatalf("reconcile: %d %s", rec.Code, rec.Body.String()) }
+}
+
+func TestAdminLowStockAndPurchaseOrders(t *testing.T) {
+	r, _ := setupRouter()
+	admin := map[string]string{CallerIDHeader: "3"}
+	rec := doWithHeaders(r, http.MethodPatch, "/api/v1/products/2", `{"reorderPoint":59,"reorderQuantity":25,"supplier":"Acme"}`, map[string]string{"If-Match": `"2-1"`, "Content-Type": "application/merge-patch+json"})
+	if rec.Code != http.StatusOK { t.Fatalf("patch: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/inventory/2/adjust", `{"type":"damage","quantity":1,"reason":"torn cover"}`, admin)
+	if rec.Code != http.StatusCreated { t.Fatalf("adjust: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/inventory/low-stock", "", admin)
+	var low []models.Product
+	json.Unmarshal(rec.Body.Bytes(), &low)
+	if rec.Code != http.StatusOK || len(low) != 1 || low[0].ID != 2 { t.Fatalf("low-stock: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/purchase-orders?status=bogus", "", admin)
+	if rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 for bad status, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/purchase-orders/99/approve", "", admin)
+	if rec.Code != http.StatusNotFound { t.Fatalf("expected 404, got %d", rec.Code) }
+}
+
+func TestNotifyMeRequiresUser(t *testing.T) {
+	r, _ := setupRouter()
+	rec := do(r, http.MethodPost, "/api/v1/products/1/notify-me", "")
+	if rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	user := map[string]string{CallerIDHeader: "1"}
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/products/1/notify-me", "", user)
+	if rec.Code != http.StatusConflict { t.Fatalf("expected 409 for in-stock product, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPatch, "/api/v1/products/1", `{"discontinued":true}`, map[string]string{"If-Match": `"1-1"`, "Content-Type": "application/merge-patch+json"})
+	if rec.Code != http.StatusOK { t.Fatalf("patch: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/products/1/notify-me", "", user)
+	if rec.Code != http.StatusCreated { t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodDelete, "/api/v1/products/1/notify-me", "", user)
+	if rec.Code != http.StatusNoContent { t.Fatalf("expected 204, got %d", rec.Code) }
+}
+
+func TestPriceHistoryAndScheduledPrices(t *testing.T) {
+	r, _ := setupRouter()
+	rec := doWithHeaders(r, http.MethodPatch, "/api/v1/products/2", `{"price":35}`, map[string]string{"If-Match": `"2-1"`, "Content-Type": "application/merge-patch+json", CallerIDHeader: "3"})
+	if rec.Code != http.StatusOK { t.Fatalf("patch: %d %s", rec.Code, rec.Body.String()) }
+	rec = do(r, http.MethodGet, "/api/v1/products/2/price-history", "")
+	var hist []models.PriceChange
+	json.Unmarshal(rec.Body.Bytes(), &hist)
+	if rec.Code != http.StatusOK || len(hist) != 2 || hist[1].Actor != "user:3" { t.Fatalf("history: %d %s", rec.Code, rec.Body.String()) }
+
+	admin := map[string]string{CallerIDHeader: "3"}
+	at := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/products/2/scheduled-prices", `{"price":29.99,"effectiveAt":"`+at+`"}`, admin)
+	if rec.Code != http.StatusCreated { t.Fatalf("schedule: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/products/2/scheduled-prices", `{"price":29.99,"effectiveAt":"`+at+`"}`, admin)
+	if rec.Code != http.StatusConflict { t.Fatalf("expected 409, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/products/2/scheduled-prices", "", admin)
+	var pending []models.ScheduledPrice
+	json.Unmarshal(rec.Body.Bytes(), &pending)
+	if rec.Code != http.StatusOK || len(pending) != 1 { t.Fatalf("list: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodDelete, "/api/v1/admin/products/2/scheduled-prices/"+itoa(pending[0].ID), "", admin)
+	if rec.Code != http.StatusNoContent { t.Fatalf("cancel: expected 204, got %d", rec.Code) }
+}
+
+func TestPromotionsAndCoupons(t *testing.T) {
+	r, _ := setupRouter()
+	admin := map[string]string{CallerIDHeader: "3"}
+	rec := doWithHeaders(r, http.MethodPost, "/api/v1/admin/promotions", `{"code":"TENOFF","name":"Ten percent","type":"percent_off","value":10}`, admin)
+	if rec.Code != http.StatusCreated { t.Fatalf("create: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/promotions", `{"code":"TENOFF","name":"Again","type":"free_shipping"}`, admin)
+	if rec.Code != http.StatusConflict { t.Fatalf("expected 409, got %d", rec.Code) }
+
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":2,"quantity":2}`)
+	rec = do(r, http.MethodPost, "/api/v1/cart/user/1/coupon", `{"code":"BOGUS"}`)
+	if rec.Code != http.StatusUnprocessableEntity { t.Fatalf("expected 422, got %d", rec.Code) }
+	rec = do(r, http.MethodPost, "/api/v1/cart/user/1/coupon", `{"code":"tenoff"}`)
+	var cart models.Cart
+	json.Unmarshal(rec.Body.Bytes(), &cart)
+	if rec.Code != http.StatusOK || cart.DiscountTotal != 8 || cart.Total != 71.98 { t.Fatalf("apply: %d %s", rec.Code, rec.Body.String()) }
+	rec = do(r, http.MethodPost, "/api/v1/orders/user/1", "")
+	var order models.Order
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusCreated || order.Total != 71.98 || len(order.Discounts) != 1 { t.Fatalf("order: %d %s", rec.Code, rec.Body.String()) }
+}
+
+func TestCartPricingBreakdown(t *testing.T) {
+	r, _ := setupRouter()
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":7,"quantity":1}`)
+	rec := do(r, http.MethodGet, "/api/v1/cart/user/1?country=DE", "")
+	var cart models.Cart
+	json.Unmarshal(rec.Body.Bytes(), &cart)
+	if rec.Code != http.StatusOK || cart.Shipping != 3.99 || cart.Tax != 2.51 || cart.GrandTotal != 31.5 { t.Fatalf("cart: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/users/1/addresses", `{"name":"John Doe","line1":"Unter den Linden 1","city":"Berlin","postalCode":"10117","country":"de"}`, map[string]string{CallerIDHeader: "1"})
+	var addr models.Address
+	json.Unmarshal(rec.Body.Bytes(), &addr)
+	if rec.Code != http.StatusCreated { t.Fatalf("address: %d %s", rec.Code, rec.Body.String()) }
+	rec = do(r, http.MethodPost, "/api/v1/orders/user/1", fmt.Sprintf(`{"shippingAddressId":%d}`, addr.ID))
+	var order models.Order
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusCreated || order.Total != 25 || order.GrandTotal != 31.5 || len(order.TaxLines) != 2 { t.Fatalf("order: %d %s", rec.Code, rec.Body.String()) }
+}
+
+func TestAddressBookEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	jane, john, admin := map[string]string{CallerIDHeader: "2"}, map[string]string{CallerIDHeader: "1"}, map[string]string{CallerIDHeader: "3"}
+	// only the account holder and admins may see or change an address book
+	if rec := do(r, http.MethodGet, "/api/v1/users/2/addresses", ""); rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	if rec := doWithHeaders(r, http.MethodGet, "/api/v1/users/2/addresses", "", john); rec.Code != http.StatusForbidden { t.Fatalf("expected 403, got %d", rec.Code) }
+	if rec := doWithHeaders(r, http.MethodGet, "/api/v1/users/2/addresses", "", admin); rec.Code != http.StatusOK { t.Fatalf("expected admin allowed, got %d", rec.Code) }
+	rec := doWithHeaders(r, http.MethodPost, "/api/v1/users/2/addresses", `{"name":"Jane","line1":"1 Main St","city":"Austin","postalCode":"78701","country":"US"}`, jane)
+	if rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 without region, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/users/2/addresses", `{"label":"work","name":"Jane","line1":"1 Main St","city":"Austin","region":"TX","postalCode":"78701","country":"US","defaultBilling":true}`, jane)
+	var a models.Address
+	json.Unmarshal(rec.Body.Bytes(), &a)
+	if rec.Code != http.StatusCreated || !a.DefaultBilling || a.DefaultShipping { t.Fatalf("create: %d %s", rec.Code, rec.Body.String()) }
+	path := "/api/v1/users/2/addresses/" + itoa(a.ID)
+	if rec = doWithHeaders(r, http.MethodGet, "/api/v1/users/1/addresses/"+itoa(a.ID), "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404 for another user, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPut, path, `{"label":"work","name":"Jane","line1":"2 Main St","city":"Austin","region":"TX","postalCode":"78701","country":"US","defaultBilling":true}`, jane)
+	if rec.Code != http.StatusOK { t.Fatalf("update: %d %s", rec.Code, rec.Body.String()) }
+	var list []models.Address
+	json.Unmarshal(doWithHeaders(r, http.MethodGet, "/api/v1/users/2/addresses", "", jane).Body.Bytes(), &list)
+	if len(list) != 2 || list[1].Line1 != "2 Main St" { t.Fatalf("unexpected list: %+v", list) }
+
+	// checkout uses the default shipping address and the new billing one
+	do(r, http.MethodPost, "/api/v1/cart/user/2/items", `{"productId":1,"quantity":1}`)
+	rec = do(r, http.MethodPost, "/api/v1/orders/user/2", "")
+	var order models.Order
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusCreated || order.ShippingAddress.City != "New York" || order.BillingAddress.ID != a.ID { t.Fatalf("order: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodDelete, path, "", john); rec.Code != http.StatusForbidden { t.Fatalf("expected 403, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodDelete, path, "", jane); rec.Code != http.StatusNoContent { t.Fatalf("delete: %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodDelete, path, "", jane); rec.Code != http.StatusNotFound { t.Fatalf("expected 404 on second delete, got %d", rec.Code) }
+}
+
+func TestOrderPaymentEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	admin := map[string]string{CallerIDHeader: "3"}
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":1}`)
+	testPayments.SetMode(services.FakeDecline)
+	if rec := do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusPaymentRequired { t.Fatalf("expected 402, got %d", rec.Code) }
+	testPayments.SetMode(services.FakeTimeout)
+	retry := map[string]string{IdempotencyKeyHeader: "abc"}
+	if rec := doWithHeaders(r, http.MethodPost, "/api/v1/orders/user/1", "", retry); rec.Code != http.StatusGatewayTimeout { t.Fatalf("expected 504, got %d", rec.Code) }
+	// the timed-out checkout was rolled back, so the retry authorizes afresh
+	testPayments.SetMode(services.FakeApprove)
+	rec := doWithHeaders(r, http.MethodPost, "/api/v1/orders/user/1", "", retry)
+	var order models.Order
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusCreated || order.Status != models.OrderPlaced { t.Fatalf("retry: %d %s", rec.Code, rec.Body.String()) }
+	testPayments.SetMode(services.FakeChallenge)
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/orders/user/1", "", retry)
+	var again models.Order
+	json.Unmarshal(rec.Body.Bytes(), &again)
+	if rec.Code != http.StatusCreated || again.ID != order.ID { t.Fatalf("expected the completed order again: %d %s", rec.Code, rec.Body.String()) }
+	path := "/api/v1/admin/orders/" + itoa(order.ID)
+	if rec = do(r, http.MethodPost, path+"/ship", ""); rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPost, path+"/ship", "", admin)
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusOK || order.Payment.Status != models.PaymentCaptured { t.Fatalf("ship: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodPost, path+"/cancel", "", admin); rec.Code != http.StatusConflict { t.Fatalf("expected 409, got %d", rec.Code) }
+
+	// a challenged order waits for the webhook
+	do(r, http.MethodPost, "/api/v1/cart/user/2/items", `{"productId":2,"quantity":1}`)
+	rec = do(r, http.MethodPost, "/api/v1/orders/user/2", "")
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if order.Status != models.OrderPendingPayment { t.Fatalf("expected pending payment: %s", rec.Body.String()) }
+	if rec = do(r, http.MethodPost, "/api/v1/payments/webhook", `{"type":"payment.authorized","authorizationId":"`+order.Payment.AuthorizationID+`"}`); rec.Code != http.StatusNoContent { t.Fatalf("webhook: %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/orders/"+itoa(order.ID), "", admin)
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if order.Status != models.OrderPendingPayment { t.Fatalf("forged webhook changed the order: %s", rec.Body.String()) }
+	if rec = do(r, http.MethodPost, order.Payment.ChallengeURL, `{"approve":true}`); rec.Code != http.StatusNoContent { t.Fatalf("challenge: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/orders/"+itoa(order.ID)+"/cancel", "", admin)
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusOK || order.Status != models.OrderCancelled || order.Payment.Status != models.PaymentVoided { t.Fatalf("cancel: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/orders/999/approve", "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404, got %d", rec.Code) }
+}
+
+func TestReturnEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	admin, john := map[string]string{CallerIDHeader: "3"}, map[string]string{CallerIDHeader: "1"}
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":2,"quantity":2}`)
+	var order models.Order
+	json.Unmarshal(do(r, http.MethodPost, "/api/v1/orders/user/1", "").Body.Bytes(), &order)
+	orderPath := "/api/v1/admin/orders/" + itoa(order.ID)
+	returnsPath := "/api/v1/users/1/orders/" + itoa(order.ID) + "/returns"
+	if rec := do(r, http.MethodPost, returnsPath, `{"items":[{"productId":2,"quantity":1}],"reason":"torn"}`); rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	if rec := doWithHeaders(r, http.MethodPost, returnsPath, `{"items":[{"productId":2,"quantity":1}],"reason":"torn"}`, map[string]string{CallerIDHeader: "2"}); rec.Code != http.StatusForbidden { t.Fatalf("expected 403, got %d", rec.Code) }
+	if rec := doWithHeaders(r, http.MethodPost, returnsPath, `{"items":[{"productId":2,"quantity":1}],"reason":"torn"}`, john); rec.Code != http.StatusConflict { t.Fatalf("expected 409 before delivery, got %d", rec.Code) }
+	doWithHeaders(r, http.MethodPost, orderPath+"/ship", "", admin)
+	if rec := doWithHeaders(r, http.MethodPost, orderPath+"/deliver", "", admin); rec.Code != http.StatusOK { t.Fatalf("deliver: %d %s", rec.Code, rec.Body.String()) }
+
+	rec := doWithHeaders(r, http.MethodPost, returnsPath, `{"items":[{"productId":2,"quantity":1}],"reason":"torn"}`, john)
+	var ra models.ReturnAuthorization
+	json.Unmarshal(rec.Body.Bytes(), &ra)
+	if rec.Code != http.StatusCreated || ra.Status != models.ReturnRequested { t.Fatalf("request: %d %s", rec.Code, rec.Body.String()) }
+	var pending []models.ReturnAuthorization
+	json.Unmarshal(doWithHeaders(r, http.MethodGet, "/api/v1/admin/returns?status=requested", "", admin).Body.Bytes(), &pending)
+	if len(pending) != 1 { t.Fatalf("expected 1 pending return, got %d", len(pending)) }
+	base := "/api/v1/admin/returns/" + itoa(ra.ID)
+	doWithHeaders(r, http.MethodPost, base+"/approve", "", admin)
+	if rec = doWithHeaders(r, http.MethodPost, base+"/approve", "", admin); rec.Code != http.StatusConflict { t.Fatalf("expected 409 on second approve, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodPost, base+"/receive", `{"items":[{"productId":2,"restock":1}]}`, admin); rec.Code != http.StatusOK { t.Fatalf("receive: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPost, base+"/refund", "", admin)
+	json.Unmarshal(rec.Body.Bytes(), &ra)
+	if rec.Code != http.StatusOK || ra.Status != models.ReturnRefunded || ra.RefundAmount != 39.99 { t.Fatalf("refund: %d %s", rec.Code, rec.Body.String()) }
+
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/users/1/orders/"+itoa(order.ID), "", john)
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if len(order.Returns) != 1 || order.Payment.Refunded != 39.99 { t.Fatalf("order: %s", rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodGet, "/api/v1/users/1/orders/"+itoa(order.ID), "", map[string]string{CallerIDHeader: "2"}); rec.Code != http.StatusForbidden { t.Fatalf("expected 403, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodGet, "/api/v1/users/2/orders/"+itoa(order.ID), "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404 for another user, got %d", rec.Code) }
+}
+
 // helpers
 func itoa(u uint) string { return fmt.Sprintf("%d", u) }
+
+func TestCheckoutSagaEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	admin := map[string]string{CallerIDHeader: "3"}
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":1}`)
+	if rec := do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusCreated { t.Fatalf("order: %d %s", rec.Code, rec.Body.String()) }
+	if rec := do(r, http.MethodGet, "/api/v1/admin/checkout-sagas", ""); rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	rec := doWithHeaders(r, http.MethodGet, "/api/v1/admin/checkout-sagas", "", admin)
+	var sagas []models.CheckoutSaga
+	json.Unmarshal(rec.Body.Bytes(), &sagas)
+	if rec.Code != http.StatusOK || len(sagas) != 1 || sagas[0].Status != models.SagaCompleted || len(sagas[0].Steps) != 6 { t.Fatalf("list: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/checkout-sagas?stuck=true", "", admin)
+	if rec.Code != http.StatusOK || rec.Body.String() != "[]" { t.Fatalf("expected no stuck sagas: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/checkout-sagas/"+itoa(sagas[0].ID)+"/recover", "", admin); rec.Code != http.StatusConflict { t.Fatalf("expected 409, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/checkout-sagas/999/recover", "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404, got %d", rec.Code) }
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	admin := map[string]string{CallerIDHeader: "3"}
+	if rec := do(r, http.MethodGet, "/api/v1/admin/webhooks", ""); rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401, got %d", rec.Code) }
+	if rec := doWithHeaders(r, http.MethodPost, "/api/v1/admin/webhooks", `{"url":"ftp://example.com","events":["order.placed"]}`, admin); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 for bad url, got %d", rec.Code) }
+	if rec := doWithHeaders(r, http.MethodPost, "/api/v1/admin/webhooks", `{"url":"https://example.com/hook","events":["order.lost"]}`, admin); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 for unknown event, got %d", rec.Code) }
+	rec := doWithHeaders(r, http.MethodPost, "/api/v1/admin/webhooks", `{"url":"https://example.com/hook","events":["order.placed","order.cancelled"]}`, admin)
+	var w models.WebhookSubscription
+	json.Unmarshal(rec.Body.Bytes(), &w)
+	if rec.Code != http.StatusCreated || !w.Active || len(w.Secret) < 16 { t.Fatalf("create: %d %s", rec.Code, rec.Body.String()) }
+	path := "/api/v1/admin/webhooks/" + itoa(w.ID)
+
+	// the secret is only shown on create
+	var got models.WebhookSubscription
+	rec = doWithHeaders(r, http.MethodGet, path, "", admin)
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if rec.Code != http.StatusOK || got.Secret != "" { t.Fatalf("get: %d %s", rec.Code, rec.Body.String()) }
+	rec = doWithHeaders(r, http.MethodPut, path, `{"url":"https://example.com/v2","events":["*"],"active":false}`, admin)
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if rec.Code != http.StatusOK || got.Active || got.URL != "https://example.com/v2" || got.Secret != "" { t.Fatalf("update: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodGet, path+"/deliveries", "", admin); rec.Code != http.StatusOK || rec.Body.String() != "[]" { t.Fatalf("deliveries: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/webhook-deliveries?status=LOST", "", admin); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 for bad status, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodPost, "/api/v1/admin/webhook-deliveries/999/redeliver", "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodDelete, path, "", admin); rec.Code != http.StatusNoContent { t.Fatalf("delete: %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodGet, path, "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404 after delete, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodGet, path+"/deliveries", "", admin); rec.Code != http.StatusNotFound { t.Fatalf("expected 404 for deliveries, got %d", rec.Code) }
+}
+
+func TestParkedEventsEndpoint(t *testing.T) {
+	r, _ := setupRouter()
+	if rec := do(r, http.MethodGet, "/api/v1/admin/events/parked", ""); rec.Code != http.StatusUnauthorized && rec.Code != http.StatusForbidden { t.Fatalf("expected admins only, got %d", rec.Code) }
+	testEvents.MaxAttempts = 1
+	testEvents.Subscribe("broken", func(ctx context.Context, ev models.DomainEvent) error { return errors.New("down") })
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":1}`)
+	testEvents.Dispatch(context.Background())
+	rec := doWithHeaders(r, http.MethodGet, "/api/v1/admin/events/parked", "", map[string]string{CallerIDHeader: "3"})
+	var parked []models.ParkedEvent
+	json.Unmarshal(rec.Body.Bytes(), &parked)
+	if rec.Code != http.StatusOK || len(parked) == 0 || parked[0].Sinks[0] != "broken" || parked[0].LastError != "broken: down" { t.Fatalf("parked: %d %s", rec.Code, rec.Body.String()) }
+}
+
+func TestEventStream(t *testing.T) {
+	r, _ := setupRouter()
+	if rec := do(r, http.MethodGet, "/api/v1/events/stream", ""); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 without a subscription, got %d", rec.Code) }
+	if rec := do(r, http.MethodGet, "/api/v1/events/stream?products=1,x", ""); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400 for a bad id, got %d", rec.Code) }
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	open := func(lastID string) (*bufio.Reader, func()) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/events/stream?products=1", nil)
+		req.Header.Set(CallerIDHeader, "1")
+		if lastID != "" { req.Header.Set("Last-Event-ID", lastID) }
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil { t.Fatalf("stream: %v", err) }
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" { t.Fatalf("stream: %d %s", resp.StatusCode, resp.Header.Get("Content-Type")) }
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	// next reads one event as its id, event and data fields
+	next := func(br *bufio.Reader) map[string]string {
+		ev := map[string]string{}
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil { t.Fatalf("read: %v", err) }
+			line = strings.TrimRight(line, "\n")
+			if line == "" && len(ev) > 0 { return ev }
+			if k, v, ok := strings.Cut(line, ":"); ok && k != "" { ev[k] = v }
+		}
+	}
+
+	br, closeStream := open("")
+	do(r, http.MethodPost, "/api/v1/cart/user/2/items", `{"productId":1,"quantity":1}`)
+	testEvents.Dispatch(ctx)
+	first := next(br)
+	if first["event"] != "stock" || first["id"] != "1" || !strings.Contains(first["data"], `"productId":1`) { t.Fatalf("unexpected event %v", first) }
+	doWithHeaders(r, http.MethodPost, "/api/v1/admin/inventory/1/adjust", `{"type":"receipt","quantity":5}`, map[string]string{CallerIDHeader: "3"})
+	testEvents.Dispatch(ctx)
+	if ev := next(br); ev["id"] != "2" || !strings.Contains(ev["data"], `"stock":`) { t.Fatalf("unexpected event %v", ev) }
+	closeStream()
+
+	// a reconnect replays what came after the last seen ID
+	br, closeStream = open("1")
+	defer closeStream()
+	if ev := next(br); ev["id"] != "2" || ev["event"] != "stock" { t.Fatalf("expected the replayed event, got %v", ev) }
+
+	// shutting down ends open streams instead of holding the server open
+	testLive.Close()
+	if _, err := io.ReadAll(br); err != nil { t.Fatalf("expected the stream to end, got %v", err) }
+}
+
+func TestAdminSocket(t *testing.T) {
+	r, _ := setupRouter()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/admin/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{CallerIDHeader: {"1"}}); err == nil || resp.StatusCode != http.StatusForbidden { t.Fatalf("expected 403 for a non-admin, got %v", err) }
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{CallerIDHeader: {"3"}})
+	if err != nil { t.Fatalf("dial: %v", err) }
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() map[string]any {
+		var m map[string]any
+		if err := conn.ReadJSON(&m); err != nil { t.Fatalf("read: %v", err) }
+		return m
+	}
+
+	conn.WriteJSON(gin.H{"action": "subscribe", "topics": []string{"orders", "gossip"}})
+	if m := read(); m["type"] != "error" { t.Fatalf("expected an error for an unknown topic, got %v", m) }
+	conn.WriteJSON(gin.H{"action": "subscribe", "topics": []string{"orders", "rule_rejections", "inventory"}})
+	if m := read(); m["type"] != "subscribed" || len(m["topics"].([]any)) != 3 { t.Fatalf("unexpected reply %v", m) }
+	conn.WriteJSON(gin.H{"action": "unsubscribe", "topics": []string{"inventory"}})
+	if m := read(); m["type"] != "subscribed" || len(m["topics"].([]any)) != 2 { t.Fatalf("unexpected reply %v", m) }
+	conn.WriteJSON(gin.H{"action": "ping"})
+	if m := read(); m["type"] != "pong" { t.Fatalf("expected pong, got %v", m) }
+
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":6}`)
+	m := read()
+	if data, _ := m["data"].(map[string]any); m["topic"] != "rule_rejections" || data["rule"] != "quantity exceeds per-item limit" || data["operation"] != "add_to_cart" { t.Fatalf("unexpected rejection %v", m) }
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":1}`)
+	if rec := do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusCreated { t.Fatalf("order: %d", rec.Code) }
+	testEvents.Dispatch(context.Background())
+	// stock and cart events went by unseen: inventory is unsubscribed
+	if m := read(); m["topic"] != "orders" || m["type"] != models.EventOrderPlaced { t.Fatalf("unexpected message %v", m) }
+}
+
+func TestAdminSocket_CallerSubprotocol(t *testing.T) {
+	r, _ := setupRouter()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/admin/ws"
+	// what a browser sends: new WebSocket(url, ["caller.3"])
+	d := websocket.Dialer{Subprotocols: []string{CallerProtocolPrefix + "1"}}
+	if _, resp, err := d.Dial(url, nil); err == nil || resp.StatusCode != http.StatusForbidden { t.Fatalf("expected 403 for a non-admin, got %v", err) }
+	d.Subprotocols = []string{CallerProtocolPrefix + "x"}
+	if _, resp, err := d.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized { t.Fatalf("expected 401 for a bad token, got %v", err) }
+	d.Subprotocols = []string{CallerProtocolPrefix + "3"}
+	conn, resp, err := d.Dial(url, nil)
+	if err != nil { t.Fatalf("dial: %v", err) }
+	defer conn.Close()
+	if conn.Subprotocol() != "caller.3" || resp.Header.Get("Sec-Websocket-Protocol") != "caller.3" { t.Fatalf("expected the caller subprotocol echoed, got %q", conn.Subprotocol()) }
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.WriteJSON(gin.H{"action": "ping"})
+	var m map[string]any
+	if err := conn.ReadJSON(&m); err != nil || m["type"] != "pong" { t.Fatalf("expected pong, got %v %v", m, err) }
+
+	// the token is only honoured on upgrades
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/warehouses", nil)
+	req.Header.Set("Sec-WebSocket-Protocol", CallerProtocolPrefix+"3")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized { t.Fatalf("expected 401 without an upgrade, got %d", rec.Code) }
+}
+
+func TestNotificationEndpoints(t *testing.T) {
+	r, _ := setupRouter()
+	john := map[string]string{CallerIDHeader: "1"}
+	var prefs models.NotificationPreferences
+	rec := doWithHeaders(r, http.MethodGet, "/api/v1/users/1/notification-preferences", "", john)
+	json.Unmarshal(rec.Body.Bytes(), &prefs)
+	if rec.Code != http.StatusOK || !prefs.EmailEnabled || len(prefs.Muted) != 0 { t.Fatalf("defaults: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodPut, "/api/v1/users/1/notification-preferences", `{"locale":"not a locale"}`, john); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400, got %d", rec.Code) }
+	if rec = doWithHeaders(r, http.MethodPut, "/api/v1/users/99/notification-preferences", `{"locale":"de"}`, map[string]string{CallerIDHeader: "3"}); rec.Code != http.StatusNotFound { t.Fatalf("expected 404, got %d", rec.Code) }
+	rec = doWithHeaders(r, http.MethodPut, "/api/v1/users/1/notification-preferences", `{"locale":"de","muted":["order_shipped"]}`, john)
+	json.Unmarshal(rec.Body.Bytes(), &prefs)
+	if rec.Code != http.StatusOK || prefs.Locale != "de" || len(prefs.Muted) != 1 || !prefs.EmailEnabled { t.Fatalf("update: %d %s", rec.Code, rec.Body.String()) }
+
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":1}`)
+	if rec = do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusCreated { t.Fatalf("order: %d", rec.Code) }
+	testEvents.Dispatch(context.Background())
+	admin := map[string]string{CallerIDHeader: "3"}
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/notifications?userId=1&status=pending", "", admin)
+	var emails []models.EmailNotification
+	json.Unmarshal(rec.Body.Bytes(), &emails)
+	if rec.Code != http.StatusOK || len(emails) != 1 || emails[0].Kind != "order_placed" || !strings.HasPrefix(emails[0].Subject, "Ihre Bestellung") { t.Fatalf("emails: %d %s", rec.Code, rec.Body.String()) }
+	if rec = doWithHeaders(r, http.MethodGet, "/api/v1/admin/notifications?status=LOST", "", admin); rec.Code != http.StatusBadRequest { t.Fatalf("expected 400, got %d", rec.Code) }
+}
+
+// logBuffer collects log lines from concurrently served requests.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) { b.mu.Lock(); defer b.mu.Unlock(); return b.buf.Write(p) }
+
+// lines decodes the JSON log lines written so far.
+func (b *logBuffer) lines() []map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var res []map[string]any
+	for _, l := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var m map[string]any
+		if json.Unmarshal([]byte(l), &m) == nil { res = append(res, m) }
+	}
+	return res
+}
+
+func TestRequestLogging(t *testing.T) {
+	r, _ := setupRouter()
+	rec := doWithHeaders(r, http.MethodGet, "/api/v1/products/1", "", map[string]string{logging.RequestIDHeader: "client-req.42"})
+	if got := rec.Header().Get(logging.RequestIDHeader); got != "client-req.42" { t.Fatalf("expected the client's request ID echoed, got %q", got) }
+	rec = doWithHeaders(r, http.MethodGet, "/api/v1/products/1", "", map[string]string{logging.RequestIDHeader: "bad id\r\n"})
+	if got := rec.Header().Get(logging.RequestIDHeader); len(got) != 32 { t.Fatalf("expected a generated request ID, got %q", got) }
+
+	rec = doWithHeaders(r, http.MethodPost, "/api/v1/cart/user/1/items", fmt.Sprintf(`{"productId":1,"quantity":%d}`, services.MaxQuantityPerLineItem+1), map[string]string{CallerIDHeader: "1", logging.RequestIDHeader: "rule-1"})
+	if rec.Code != http.StatusBadRequest { t.Fatalf("expected a rule rejection, got %d", rec.Code) }
+	var access, violation map[string]any
+	for _, l := range testLog.lines() {
+		if l["request_id"] != "rule-1" { continue }
+		switch l["msg"] {
+		case "request":
+			access = l
+		case "rule violation":
+			violation = l
+		}
+	}
+	if access == nil || access["route"] != "/api/v1/cart/user/:id/items" || access["status"] != float64(rec.Code) || access["rule_code"] != "line_max_quantity" || access["user_id"] != float64(1) || access["level"] != "WARN" { t.Fatalf("unexpected access log: %v", access) }
+	if _, ok := access["latency_ms"]; !ok { t.Fatalf("expected latency in the access log: %v", access) }
+	if violation == nil || violation["rule_code"] != "line_max_quantity" || violation["operation"] != models.OperationAddToCart { t.Fatalf("unexpected rule violation log: %v", violation) }
+}
+
+// scrape returns the sample values served at /metrics, keyed by series.
+func scrape(t *testing.T, r *gin.Engine) map[string]float64 {
+	t.Helper()
+	rec := do(r, http.MethodGet, "/metrics", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != metrics.ContentType { t.Fatalf("metrics: %d %q", rec.Code, rec.Header().Get("Content-Type")) }
+	res := make(map[string]float64)
+	for _, l := range strings.Split(rec.Body.String(), "\n") {
+		i := strings.LastIndexByte(l, ' ')
+		if l == "" || l[0] == '#' || i < 0 { continue }
+		var v float64
+		fmt.Sscan(l[i+1:], &v)
+		res[l[:i]] = v
+	}
+	return res
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	r, store := setupRouter()
+	before := scrape(t, r)
+	for i := 0; i < 11; i++ { do(r, http.MethodPost, "/api/v1/cart/user/2/items", fmt.Sprintf(`{"productId":1,"quantity":%d}`, services.MaxQuantityPerLineItem+1)) }
+	do(r, http.MethodPost, "/api/v1/cart/user/1/items", `{"productId":1,"quantity":1}`)
+	if rec := do(r, http.MethodPost, "/api/v1/orders/user/1", ""); rec.Code != http.StatusCreated { t.Fatalf("order: %d", rec.Code) }
+	testEvents.Dispatch(context.Background())
+	do(r, http.MethodGet, "/api/v1/products/1", "")
+	after := scrape(t, r)
+
+	delta := func(series string) float64 { return after[series] - before[series] }
+	if d := delta(`bookstore_rule_rejections_total{operation="add_to_cart",rule="line_max_quantity"}`); d != 10 { t.Fatalf("expected 10 rule rejections, got %v", d) }
+	if d := delta(`bookstore_rate_limited_total{limiter="cart"}`); d != 1 { t.Fatalf("expected one rate-limited request, got %v", d) }
+	if d := delta(`bookstore_orders_placed_total`); d != 1 { t.Fatalf("expected one order placed, got %v", d) }
+	if d := delta(`bookstore_http_request_duration_seconds_count{method="POST",route="/api/v1/cart/user/:id/items",status="429"}`); d != 1 { t.Fatalf("expected the 429 in the latency histogram, got %v", d) }
+	if d := delta(`bookstore_http_request_duration_seconds_bucket{method="GET",route="/api/v1/products/:id",status="200",le="+Inf"}`); d != 1 { t.Fatalf("expected the product read in the latency histogram, got %v", d) }
+	// lock waits are only recorded under contention, which these serial requests never cause
+	if body := do(r, http.MethodGet, "/metrics", "").Body.String(); !strings.Contains(body, "# TYPE bookstore_store_lock_contended_wait_seconds histogram\n") { t.Fatalf("expected the store lock histogram exposed:\n%s", body) }
+
+	reg := metrics.NewRegistry()
+	store.RegisterMetrics(reg)
+	products, stock := store.CatalogStats()
+	var buf bytes.Buffer
+	reg.Expose(&buf)
+	if !strings.Contains(buf.String(), fmt.Sprintf("bookstore_catalog_products %d\n", products)) || !strings.Contains(buf.String(), fmt.Sprintf("bookstore_catalog_stock_units %d\n", stock)) || products == 0 { t.Fatalf("unexpected catalog gauges:\n%s", buf.String()) }
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemoryStore()
+	orders := services.NewOrderService(store)
+	health := NewHealthHandler(
+		HealthCheck{Name: "storage", Check: store.Ping},
+		HealthCheck{Name: "rules", Check: orders.CheckRules},
+	)
+	r := gin.New()
+	r.GET("/healthz", health.Live)
+	r.GET("/readyz", health.Ready)
+
+	if rec := do(r, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK { t.Fatalf("healthz: %d", rec.Code) }
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	rec := do(r, http.MethodGet, "/readyz", "")
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || body.Status != "ready" || body.Checks["storage"] != "ok" || body.Checks["rules"] != "ok" { t.Fatalf("readyz: %d %s", rec.Code, rec.Body.String()) }
+
+	// a pricer with a tier that can never apply is a broken rule set
+	orders.SetPricer(&services.Pricer{Shipping: &services.TieredShipping{Tiers: []services.ShippingTier{{Cost: 5}, {UpTo: 3, Cost: 2}}}})
+	rec = do(r, http.MethodGet, "/readyz", "")
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(body.Checks["rules"], "shipping tier 1 is unreachable") { t.Fatalf("expected rules unready: %d %s", rec.Code, rec.Body.String()) }
+	orders.SetPricer(services.DefaultPricer())
+
+	store.Close()
+	rec = do(r, http.MethodGet, "/readyz", "")
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusServiceUnavailable || body.Checks["storage"] != storage.ErrStoreClosed.Error() { t.Fatalf("expected storage unready: %d %s", rec.Code, rec.Body.String()) }
+	health.SetDraining()
+	if rec = do(r, http.MethodGet, "/readyz", ""); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "draining") { t.Fatalf("expected draining: %d %s", rec.Code, rec.Body.String()) }
+	if rec = do(r, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK { t.Fatalf("liveness must not follow readiness: %d", rec.Code) }
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type InventoryHandler struct { svc *services.InventoryService }
+
+func NewInventoryHandler(svc *services.InventoryService) *InventoryHandler { return &InventoryHandler{svc: svc} }
+
+type adjustInventoryRequest struct {
+	WarehouseID uint   `json:"warehouseId"`
+	Type        string `json:"type"`
+	Quantity    int    `json:"quantity"`
+	Reason      string `json:"reason"`
+}
+
+type transferStockRequest struct {
+	FromWarehouseID uint   `json:"fromWarehouseId"`
+	ToWarehouseID   uint   `json:"toWarehouseId"`
+	Quantity        int    `json:"quantity"`
+	Reason          string `json:"reason"`
+}
+
+type createWarehouseRequest struct {
+	Code        string  `json:"code"`
+	Name        string  `json:"name"`
+	Region      string  `json:"region"`
+	CostPerUnit float64 `json:"costPerUnit"`
+	Priority    int     `json:"priority"`
+}
+
+func (h *InventoryHandler) Adjust(c *gin.Context) {
+	productID, err := parseUint(c.Param("productId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"}); return }
+	var body adjustInventoryRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req := &dto.AdjustInventoryRequest{ProductID: productID, WarehouseID: body.WarehouseID, Type: body.Type, Quantity: body.Quantity, Reason: body.Reason, Actor: callerActor(c)}
+	mv, err := h.svc.Adjust(c.Request.Context(), req)
+	if err != nil { c.JSON(inventoryErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, mv)
+}
+
+func (h *InventoryHandler) Transfer(c *gin.Context) {
+	productID, err := parseUint(c.Param("productId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"}); return }
+	var body transferStockRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req := &dto.TransferStockRequest{ProductID: productID, FromWarehouseID: body.FromWarehouseID, ToWarehouseID: body.ToWarehouseID, Quantity: body.Quantity, Reason: body.Reason, Actor: callerActor(c)}
+	moves, err := h.svc.Transfer(c.Request.Context(), req)
+	if err != nil { c.JSON(inventoryErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, moves)
+}
+
+func (h *InventoryHandler) WarehouseStock(c *gin.Context) {
+	productID, err := parseUint(c.Param("productId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"}); return }
+	levels, err := h.svc.WarehouseStock(c.Request.Context(), &dto.GetWarehouseStockRequest{ProductID: productID})
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, levels)
+}
+
+func (h *InventoryHandler) ListWarehouses(c *gin.Context) {
+	items, err := h.svc.ListWarehouses(c.Request.Context(), &dto.ListWarehousesRequest{})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *InventoryHandler) CreateWarehouse(c *gin.Context) {
+	var body createWarehouseRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req := &dto.CreateWarehouseRequest{Code: body.Code, Name: body.Name, Region: body.Region, CostPerUnit: body.CostPerUnit, Priority: body.Priority}
+	w, err := h.svc.CreateWarehouse(c.Request.Context(), req)
+	if errors.Is(err, storage.ErrDuplicateWarehouse) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, w)
+}
+
+// inventoryErrorStatus maps inventory errors to HTTP status codes.
+func inventoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrProductNotFound), errors.Is(err, storage.ErrWarehouseNotFound): return http.StatusNotFound
+	case errors.Is(err, storage.ErrNegativeStock), errors.Is(err, storage.ErrStockLimit): return http.StatusConflict
+	default: return http.StatusBadRequest
+	}
+}
+
+func (h *InventoryHandler) ListMovements(c *gin.Context) {
+	productID, err := parseUint(c.Param("productId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"}); return }
+	items, err := h.svc.ListMovements(c.Request.Context(), &dto.ListMovementsRequest{ProductID: productID})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *InventoryHandler) Reconcile(c *gin.Context) {
+	report, err := h.svc.Reconcile(c.Request.Context(), &dto.ReconcileInventoryRequest{})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *InventoryHandler) LowStock(c *gin.Context) {
+	items, err := h.svc.LowStock(c.Request.Context(), &dto.ListLowStockRequest{})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *InventoryHandler) ListPurchaseOrders(c *gin.Context) {
+	items, err := h.svc.ListPurchaseOrders(c.Request.Context(), &dto.ListPurchaseOrdersRequest{Status: c.Query("status")})
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *InventoryHandler) ApprovePurchaseOrder(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	po, err := h.svc.ApprovePurchaseOrder(c.Request.Context(), &dto.ApprovePurchaseOrderRequest{ID: id, Actor: callerActor(c)})
+	if errors.Is(err, storage.ErrPurchaseOrderNotFound) { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	if errors.Is(err, storage.ErrPurchaseOrderNotDraft) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, po)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/metrics"
+)
+
+var (
+	httpDuration = metrics.NewHistogram("bookstore_http_request_duration_seconds", "HTTP request latency by method, route and status.", nil, "method", "route", "status")
+	rateLimited  = metrics.NewCounter("bookstore_rate_limited_total", "Requests refused with 429 by a rate limiter (cart or product).", "limiter")
+)
+
+// Metrics records each request's latency by route pattern, so path
+// parameters do not multiply series; unmatched paths share route "unmatched".
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" { route = "unmatched" }
+		httpDuration.ObserveDuration(time.Since(start), c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type NotificationHandler struct {
+	svc *services.NotificationService
+}
+
+func NewNotificationHandler(svc *services.NotificationService) *NotificationHandler { return &NotificationHandler{svc: svc} }
+
+func notificationError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrUserNotFound) { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	p, err := h.svc.GetPreferences(c.Request.Context(), &dto.GetNotificationPreferencesRequest{UserID: userID})
+	if err != nil { notificationError(c, err); return }
+	c.JSON(http.StatusOK, p)
+}
+
+// UpdatePreferences changes the fields present in the body.
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	var req dto.NotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req.UserID = userID
+	p, err := h.svc.UpdatePreferences(c.Request.Context(), &req)
+	if err != nil { notificationError(c, err); return }
+	c.JSON(http.StatusOK, p)
+}
+
+// ListEmails is the email log; ?status=FAILED lists emails that ran out of
+// attempts.
+func (h *NotificationHandler) ListEmails(c *gin.Context) {
+	req := &dto.ListEmailsRequest{Status: c.Query("status")}
+	if raw := c.Query("userId"); raw != "" {
+		id, err := parseUint(raw)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+		req.UserID = id
+	}
+	items, err := h.svc.ListEmails(c.Request.Context(), req)
+	if err != nil { notificationError(c, err); return }
+	c.JSON(http.StatusOK, items)
+}
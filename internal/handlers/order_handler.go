@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"ecom-book-store-sample-api/internal/dto"
 	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
 )
 
 type OrderHandler struct { svc *services.OrderService }
@@ -16,7 +19,67 @@ func NewOrderHandler(svc *services.OrderService) *OrderHandler { return &OrderHa
 func (h *OrderHandler) PlaceOrder(c *gin.Context) {
 	userID, err := parseUint(c.Param("id"))
 	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
-	order, err := h.svc.PlaceOrder(c.Request.Context(), &dto.PlaceOrderRequest{UserID: userID})
-	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	// the body is optional; without one the default addresses are used
+	var req dto.PlaceOrderRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	}
+	req.UserID = userID
+	if key := c.GetHeader(IdempotencyKeyHeader); key != "" { req.IdempotencyKey = key }
+	order, err := h.svc.PlaceOrder(c.Request.Context(), &req)
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
 	c.JSON(http.StatusCreated, order)
 }
+
+// IdempotencyKeyHeader lets clients retry a checkout without paying twice.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// orderErrorStatus maps order and payment errors to HTTP status codes.
+func orderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrOrderNotFound), errors.Is(err, storage.ErrReturnNotFound), errors.Is(err, storage.ErrSagaNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrOrderStatus), errors.Is(err, storage.ErrReturnStatus), errors.Is(err, services.ErrCheckoutInProgress), errors.Is(err, services.ErrSagaSettled), errors.Is(err, services.ErrSagaActive):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrPaymentDeclined):
+		return http.StatusPaymentRequired
+	case errors.Is(err, services.ErrPaymentTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadRequest
+}
+
+func (h *OrderHandler) GetOrder(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	order, err := h.svc.GetOrder(c.Request.Context(), &dto.GetOrderRequest{ID: id})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, order)
+}
+
+// GetUserOrder shows one of the user's orders, including its returns.
+func (h *OrderHandler) GetUserOrder(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	id, err := parseUint(c.Param("orderId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"}); return }
+	order, err := h.svc.GetOrder(c.Request.Context(), &dto.GetOrderRequest{ID: id, UserID: userID})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, order)
+}
+
+func (h *OrderHandler) ApproveOrder(c *gin.Context) { h.orderAction(c, h.svc.ApproveOrder) }
+
+func (h *OrderHandler) ShipOrder(c *gin.Context) { h.orderAction(c, h.svc.ShipOrder) }
+
+func (h *OrderHandler) CancelOrder(c *gin.Context) { h.orderAction(c, h.svc.CancelOrder) }
+
+func (h *OrderHandler) DeliverOrder(c *gin.Context) { h.orderAction(c, h.svc.DeliverOrder) }
+
+func (h *OrderHandler) orderAction(c *gin.Context, action func(context.Context, *dto.OrderActionRequest) (*dto.Order, error)) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	order, err := action(c.Request.Context(), &dto.OrderActionRequest{ID: id, Actor: callerActor(c)})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, order)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/services"
+)
+
+type PaymentHandler struct {
+	orders *services.OrderService
+	fake   *services.FakePaymentProvider // nil unless the fake gateway is in use
+}
+
+func NewPaymentHandler(orders *services.OrderService, fake *services.FakePaymentProvider) *PaymentHandler {
+	return &PaymentHandler{orders: orders, fake: fake}
+}
+
+// Webhook receives provider notifications. The payload is not trusted: only
+// the authorization ID is used, and its state is fetched from the provider.
+func (h *PaymentHandler) Webhook(c *gin.Context) {
+	var ev dto.PaymentEvent
+	if err := c.ShouldBindJSON(&ev); err != nil || ev.AuthorizationID == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	if err := h.orders.HandlePaymentEvent(c.Request.Context(), &ev); err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
+
+type challengeRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// CompleteFakeChallenge stands in for the bank's challenge page when the
+// fake gateway is used.
+func (h *PaymentHandler) CompleteFakeChallenge(c *gin.Context) {
+	if h.fake == nil { c.JSON(http.StatusNotFound, gin.H{"error": "fake gateway not enabled"}); return }
+	var body challengeRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	if err := h.fake.CompleteChallenge(c.Request.Context(), c.Param("authorizationId"), body.Approve); err != nil { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
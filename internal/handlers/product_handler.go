@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,6 +16,7 @@ import (
 
 	"ecom-book-store-sample-api/internal/dto"
 	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
 )
 
 type ProductHandler struct { svc *services.ProductService }
@@ -17,13 +24,22 @@ type ProductHandler struct { svc *services.ProductService }
 func NewProductHandler(svc *services.ProductService) *ProductHandler { return &ProductHandler{svc: svc} }
 
 type productInput struct {
+	ISBN         string  `json:"isbn"`
 	Title        string  `json:"title"`
 	Author       string  `json:"author"`
 	Description  string  `json:"description"`
+	Category     string  `json:"category"`
 	Price        float64 `json:"price"`
 	Stock        int     `json:"stock"`
 	Discontinued bool    `json:"discontinued"`
 	IsSpecial    bool    `json:"isSpecial"`
+	AllowBackorder    bool       `json:"allowBackorder"`
+	MaxBackorder      int        `json:"maxBackorder"`
+	BackorderLeadDays int        `json:"backorderLeadDays"`
+	ReleaseDate       *time.Time `json:"releaseDate"`
+	ReorderPoint    int    `json:"reorderPoint"`
+	ReorderQuantity int    `json:"reorderQuantity"`
+	Supplier        string `json:"supplier"`
 }
 
 var (
@@ -37,13 +53,15 @@ func allowProductMutation(limit int, window time.Duration) bool {
 	now := time.Now()
 	pruned := prodOps[:0]
 	for _, t := range prodOps { if now.Sub(t) <= window { pruned = append(pruned, t) } }
-	if len(pruned) >= limit { prodOps = pruned; return false }
+	if len(pruned) >= limit { prodOps = pruned; rateLimited.Inc("product"); return false }
 	pruned = append(pruned, now)
 	prodOps = pruned
 	return true
 }
 
 func (h *ProductHandler) ListProducts(c *gin.Context) {
+	version, modified := h.svc.CatalogVersion(c.Request.Context())
+	if notModified(c, fmt.Sprintf(`"catalog-%d"`, version), modified) { return }
 	items, err := h.svc.ListProducts(c.Request.Context(), &dto.ListProductsRequest{})
 	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
 	c.JSON(http.StatusOK, items)
@@ -53,8 +71,9 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	if !allowProductMutation(5, time.Minute) { c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many product changes"}); return }
 	var in productInput
 	if err := c.ShouldBindJSON(&in); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
-	req := &dto.CreateProductRequest{Title: in.Title, Author: in.Author, Description: in.Description, Price: in.Price, Stock: in.Stock, Discontinued: in.Discontinued, IsSpecial: in.IsSpecial}
+	req := &dto.CreateProductRequest{ISBN: in.ISBN, Title: in.Title, Author: in.Author, Description: in.Description, Category: in.Category, Price: in.Price, Stock: in.Stock, Discontinued: in.Discontinued, IsSpecial: in.IsSpecial, AllowBackorder: in.AllowBackorder, MaxBackorder: in.MaxBackorder, BackorderLeadDays: in.BackorderLeadDays, ReleaseDate: in.ReleaseDate, ReorderPoint: in.ReorderPoint, ReorderQuantity: in.ReorderQuantity, Supplier: in.Supplier, Actor: callerActor(c)}
 	created, err := h.svc.CreateProduct(c.Request.Context(), req)
+	if errors.Is(err, storage.ErrDuplicateISBN) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
 	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
 	c.JSON(http.StatusCreated, created)
 }
@@ -64,6 +83,7 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
 	p, err := h.svc.GetProduct(c.Request.Context(), &dto.GetProductRequest{ID: id})
 	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	if notModified(c, productETag(p), p.UpdatedAt) { return }
 	c.JSON(http.StatusOK, p)
 }
 
@@ -71,14 +91,101 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	if !allowProductMutation(5, time.Minute) { c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many product changes"}); return }
 	id, err := parseUint(c.Param("id"))
 	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	version, ok := h.ifMatchVersion(c, id)
+	if !ok { c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match this product"}); return }
 	var in productInput
 	if err := c.ShouldBindJSON(&in); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
-	req := &dto.UpdateProductRequest{ID: id, Title: in.Title, Author: in.Author, Description: in.Description, Price: in.Price, Stock: in.Stock, Discontinued: in.Discontinued, IsSpecial: in.IsSpecial}
+	req := &dto.UpdateProductRequest{ID: id, ISBN: in.ISBN, Title: in.Title, Author: in.Author, Description: in.Description, Category: in.Category, Price: in.Price, Stock: in.Stock, Discontinued: in.Discontinued, IsSpecial: in.IsSpecial, AllowBackorder: in.AllowBackorder, MaxBackorder: in.MaxBackorder, BackorderLeadDays: in.BackorderLeadDays, ReleaseDate: in.ReleaseDate, ReorderPoint: in.ReorderPoint, ReorderQuantity: in.ReorderQuantity, Supplier: in.Supplier, Version: version, Actor: callerActor(c)}
 	updated, err := h.svc.UpdateProduct(c.Request.Context(), req)
+	if errors.Is(err, storage.ErrVersionConflict) { c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()}); return }
+	if errors.Is(err, storage.ErrDuplicateISBN) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
 	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.Header("ETag", productETag(updated))
 	c.JSON(http.StatusOK, updated)
 }
 
+// PatchProduct applies an application/merge-patch+json body. The request must
+// carry an If-Match header with the product's current ETag so concurrent
+// editors get 412 instead of silently overwriting each other; "*" matches any
+// current version of an existing product.
+func (h *ProductHandler) PatchProduct(c *gin.Context) {
+	if !allowProductMutation(5, time.Minute) { c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many product changes"}); return }
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" { c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the product ETag is required"}); return }
+	version, ok := h.ifMatchVersion(c, id)
+	if !ok { c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match this product"}); return }
+	if ct := c.ContentType(); ct != "application/merge-patch+json" && ct != "application/json" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "use application/merge-patch+json"}); return
+	}
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	updated, err := h.svc.PatchProduct(c.Request.Context(), &dto.PatchProductRequest{ID: id, Patch: patch, Version: version, Actor: callerActor(c)})
+	switch {
+	case errors.Is(err, storage.ErrProductNotFound) && version == 0: c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match this product"}); return
+	case errors.Is(err, storage.ErrProductNotFound): c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return
+	case errors.Is(err, storage.ErrVersionConflict): c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()}); return
+	case errors.Is(err, storage.ErrDuplicateISBN): c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return
+	case err != nil: c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return
+	}
+	c.Header("ETag", productETag(updated))
+	c.JSON(http.StatusOK, updated)
+}
+
+// productETag is the strong entity tag for a single product representation,
+// "<id>-<version>-<digest>". If-Match checks only the version, which counts
+// catalog edits; the digest covers everything serialized, so sales, holds and
+// scheduled prices change the tag for caches without failing an editor's
+// If-Match.
+func productETag(p *dto.Product) string {
+	h := fnv.New64a()
+	json.NewEncoder(h).Encode(p)
+	return fmt.Sprintf(`"%d-%d-%x"`, p.ID, p.Version, h.Sum64())
+}
+
+// ifMatchVersion resolves the If-Match header to the version the update must
+// find. When one of the listed tags is current, that version is returned;
+// otherwise a listed (stale) version is, so the store answers with a version
+// conflict. ok is false when the header is malformed or names no version of
+// this product.
+func (h *ProductHandler) ifMatchVersion(c *gin.Context, id uint) (version uint64, ok bool) {
+	versions, ok := parseIfMatch(c.GetHeader("If-Match"), id)
+	if !ok || len(versions) == 0 { return 0, ok }
+	if p, err := h.svc.GetProduct(c.Request.Context(), &dto.GetProductRequest{ID: id}); err == nil {
+		for _, v := range versions {
+			if v == p.Version { return v, true }
+		}
+	}
+	return versions[0], true
+}
+
+// parseIfMatch extracts the listed versions of product id from an If-Match
+// header. An empty header or "*" yields no versions (any version matches). Every list
+// member must be an exact strong tag "<id>-<version>", optionally followed by
+// productETag's "-<digest>", which is ignored; tags for other products are
+// skipped. ok is false when a member is malformed or none names id.
+func parseIfMatch(header string, id uint) (versions []uint64, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" { return nil, true }
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' { return nil, false }
+		rawID, rawVersion, found := strings.Cut(tag[1:len(tag)-1], "-")
+		if !found { return nil, false }
+		if v, digest, hasDigest := strings.Cut(rawVersion, "-"); hasDigest {
+			if _, err := strconv.ParseUint(digest, 16, 64); err != nil { return nil, false }
+			rawVersion = v
+		}
+		gotID, err := strconv.ParseUint(rawID, 10, 64)
+		if err != nil { return nil, false }
+		version, err := strconv.ParseUint(rawVersion, 10, 64)
+		if err != nil || version == 0 { return nil, false }
+		if uint(gotID) == id { versions = append(versions, version) }
+	}
+	return versions, len(versions) > 0
+}
+
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	id, err := parseUint(c.Param("id"))
 	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
@@ -86,7 +193,115 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// NotifyMe subscribes the caller to a back-in-stock notification. Products
+// that can be bought right now return 409.
+func (h *ProductHandler) NotifyMe(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	sub, err := h.svc.NotifyMe(c.Request.Context(), &dto.RestockSubscriptionRequest{UserID: caller(c).ID, ProductID: id})
+	if errors.Is(err, storage.ErrProductInStock) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (h *ProductHandler) CancelNotifyMe(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	if err := h.svc.CancelNotifyMe(c.Request.Context(), &dto.RestockSubscriptionRequest{UserID: caller(c).ID, ProductID: id}); err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ProductHandler) PriceHistory(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	items, err := h.svc.PriceHistory(c.Request.Context(), &dto.GetPriceHistoryRequest{ProductID: id})
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+type schedulePriceInput struct {
+	Price       float64   `json:"price"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+}
+
+func (h *ProductHandler) SchedulePrice(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	var in schedulePriceInput
+	if err := c.ShouldBindJSON(&in); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	sp, err := h.svc.SchedulePrice(c.Request.Context(), &dto.SchedulePriceRequest{ProductID: id, Price: in.Price, EffectiveAt: in.EffectiveAt, Actor: callerActor(c)})
+	switch {
+	case errors.Is(err, storage.ErrProductNotFound): c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return
+	case errors.Is(err, storage.ErrScheduleConflict): c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return
+	case err != nil: c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return
+	}
+	c.JSON(http.StatusCreated, sp)
+}
+
+func (h *ProductHandler) ListScheduledPrices(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	items, err := h.svc.ListScheduledPrices(c.Request.Context(), &dto.ListScheduledPricesRequest{ProductID: id})
+	if err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *ProductHandler) CancelScheduledPrice(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	scheduleID, err := parseUint(c.Param("scheduleId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"}); return }
+	if err := h.svc.CancelScheduledPrice(c.Request.Context(), &dto.CancelScheduledPriceRequest{ProductID: id, ID: scheduleID}); err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
+
 func parseUint(s string) (uint, error) {
 	v, err := strconv.ParseUint(s, 10, 64)
 	return uint(v), err
 }
+
+// maxImportBytes caps a single catalog import upload.
+const maxImportBytes = 64 << 20
+
+// ImportProducts streams a CSV or NDJSON catalog from the request body and
+// upserts each row. ?dryRun=true validates without writing. The format comes
+// from ?format= or, failing that, the Content-Type.
+func (h *ProductHandler) ImportProducts(c *gin.Context) {
+	format := catalogFormat(c.Query("format"), c.ContentType())
+	if format == "" { c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "use text/csv or application/x-ndjson"}); return }
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	report, err := h.svc.ImportProducts(c.Request.Context(), &dto.ImportProductsRequest{Format: format, Body: body, DryRun: dryRun, Actor: callerActor(c)})
+	if err != nil && report == nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "report": report}); return }
+	c.JSON(http.StatusOK, report)
+}
+
+// ExportProducts streams the catalog as CSV or NDJSON (?format=, default csv).
+func (h *ProductHandler) ExportProducts(c *gin.Context) {
+	format := catalogFormat(c.DefaultQuery("format", dto.CatalogFormatCSV), "")
+	if format == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"}); return }
+	contentType, ext := "text/csv; charset=utf-8", "csv"
+	if format == dto.CatalogFormatNDJSON { contentType, ext = "application/x-ndjson", "ndjson" }
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename=catalog."+ext)
+	c.Status(http.StatusOK)
+	if err := h.svc.ExportProducts(c.Request.Context(), &dto.ExportProductsRequest{Format: format}, c.Writer); err != nil {
+		// headers are already sent; the truncated stream is all we can signal
+		_ = c.Error(err)
+	}
+}
+
+func catalogFormat(query, contentType string) string {
+	switch strings.ToLower(query) {
+	case dto.CatalogFormatCSV: return dto.CatalogFormatCSV
+	case dto.CatalogFormatNDJSON, "jsonl": return dto.CatalogFormatNDJSON
+	case "":
+	default: return ""
+	}
+	switch contentType {
+	case "text/csv": return dto.CatalogFormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines": return dto.CatalogFormatNDJSON
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type PromotionHandler struct { svc *services.PromotionService }
+
+func NewPromotionHandler(svc *services.PromotionService) *PromotionHandler { return &PromotionHandler{svc: svc} }
+
+func (h *PromotionHandler) CreatePromotion(c *gin.Context) {
+	var req dto.CreatePromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	p, err := h.svc.CreatePromotion(c.Request.Context(), &req)
+	if errors.Is(err, storage.ErrDuplicateCoupon) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, p)
+}
+
+func (h *PromotionHandler) ListPromotions(c *gin.Context) {
+	items, err := h.svc.ListPromotions(c.Request.Context(), &dto.ListPromotionsRequest{})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *PromotionHandler) DeactivatePromotion(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	if err := h.svc.DeactivatePromotion(c.Request.Context(), &dto.DeactivatePromotionRequest{ID: id}); err != nil { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/logging"
+)
+
+// maxRequestIDLength bounds client-supplied request IDs.
+const maxRequestIDLength = 128
+
+// RequestID accepts a well-formed X-Request-ID from the client or generates
+// one, echoes it on the response and carries it in the request context so
+// every log line for the request can be correlated.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.RequestIDHeader)
+		if !validRequestID(id) { id = newRequestID() }
+		c.Header(logging.RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// validRequestID allows 1 to maxRequestIDLength characters of [A-Za-z0-9._-],
+// which keeps client IDs safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength { return false }
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') { return false }
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog writes one line per request to logger once it completes: route,
+// status, latency, the caller and the business rule that refused it, if any.
+// Server errors log at ERROR, client errors at WARN.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		ctx := c.Request.Context()
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("ip", c.ClientIP()),
+		}
+		if code := logging.RuleCode(ctx); code != "" { attrs = append(attrs, slog.String("rule_code", code)) }
+		if len(c.Errors) > 0 { attrs = append(attrs, slog.String("error", c.Errors.String())) }
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
+
+// Recovery turns a panic in a handler into a 500 and logs it with the
+// request's fields instead of gin's plain-text recovery output.
+func Recovery(logger *slog.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logger.ErrorContext(c.Request.Context(), "panic", "route", c.FullPath(), "panic", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+)
+
+type createReturnRequest struct {
+	Items  []dto.ReturnLine `json:"items"`
+	Reason string           `json:"reason"`
+}
+
+// RequestReturn opens a return on one of the user's delivered orders.
+func (h *OrderHandler) RequestReturn(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	orderID, err := parseUint(c.Param("orderId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"}); return }
+	var body createReturnRequest
+	if err := c.ShouldBindJSON(&body); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	r, err := h.svc.RequestReturn(c.Request.Context(), &dto.CreateReturnRequest{UserID: userID, OrderID: orderID, Items: body.Items, Reason: body.Reason})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, r)
+}
+
+func (h *OrderHandler) ListReturns(c *gin.Context) {
+	items, err := h.svc.ListReturns(c.Request.Context(), &dto.ListReturnsRequest{Status: c.Query("status")})
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *OrderHandler) ApproveReturn(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	r, err := h.svc.ApproveReturn(c.Request.Context(), &dto.ReturnActionRequest{ID: id, Actor: callerActor(c)})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, r)
+}
+
+func (h *OrderHandler) RejectReturn(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	r, err := h.svc.RejectReturn(c.Request.Context(), &dto.ReturnActionRequest{ID: id, Actor: callerActor(c)})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, r)
+}
+
+func (h *OrderHandler) ReceiveReturn(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	var req dto.ReceiveReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req.ID, req.Actor = id, callerActor(c)
+	r, err := h.svc.ReceiveReturn(c.Request.Context(), &req)
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, r)
+}
+
+func (h *OrderHandler) RefundReturn(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	var req dto.RefundReturnRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	}
+	req.ID, req.Actor = id, callerActor(c)
+	r, err := h.svc.RefundReturn(c.Request.Context(), &req)
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, r)
+}
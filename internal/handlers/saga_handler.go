@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+)
+
+// ListCheckoutSagas lists checkout sagas; ?stuck=true shows only those that
+// need attention.
+func (h *OrderHandler) ListCheckoutSagas(c *gin.Context) {
+	items, err := h.svc.ListSagas(c.Request.Context(), &dto.ListCheckoutSagasRequest{Stuck: c.Query("stuck") == "true"})
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+// RecoverCheckoutSaga resumes or compensates a saga without waiting for the
+// recovery worker.
+func (h *OrderHandler) RecoverCheckoutSaga(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	sg, err := h.svc.RecoverSaga(c.Request.Context(), &dto.RecoverCheckoutSagaRequest{ID: id})
+	if err != nil { c.JSON(orderErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, sg)
+}
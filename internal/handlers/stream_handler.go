@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/services"
+)
+
+const (
+	// streamHeartbeat is how often an idle stream gets a comment, so proxies
+	// keep the connection open.
+	streamHeartbeat = 15 * time.Second
+	// maxStreamProducts caps the ?products= list of one stream.
+	maxStreamProducts = 50
+)
+
+type StreamHandler struct {
+	hub       *services.LiveHub
+	heartbeat time.Duration
+}
+
+func NewStreamHandler(hub *services.LiveHub) *StreamHandler {
+	return &StreamHandler{hub: hub, heartbeat: streamHeartbeat}
+}
+
+// Stream serves live updates as Server-Sent Events: stock levels for the
+// products in ?products=1,2,3 and, for an identified caller, status changes
+// of their orders. A reconnecting client sends Last-Event-ID (or
+// ?lastEventId=) to replay what it missed; a "resync" event means the gap
+// could not be replayed and the client should reload. Clients that fall
+// behind are disconnected and expected to reconnect the same way.
+func (h *StreamHandler) Stream(c *gin.Context) {
+	var products []uint
+	if raw := c.Query("products"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			id, err := parseUint(strings.TrimSpace(part))
+			if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id " + strconv.Quote(part)}); return }
+			products = append(products, id)
+		}
+	}
+	if len(products) > maxStreamProducts { c.JSON(http.StatusBadRequest, gin.H{"error": "too many products"}); return }
+	var userID uint
+	if u := caller(c); u != nil { userID = u.ID }
+	if len(products) == 0 && userID == 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "subscribe to products or identify with " + CallerIDHeader}); return }
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" { lastID = c.Query("lastEventId") }
+	var last uint64
+	if lastID != "" {
+		var err error
+		if last, err = strconv.ParseUint(lastID, 10, 64); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"}); return }
+	}
+
+	sub, replay, complete := h.hub.Subscribe(userID, products, last)
+	defer h.hub.Unsubscribe(sub)
+	// the server's WriteTimeout would otherwise cut the stream off
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if !complete { c.Render(-1, sse.Event{Event: "resync", Data: gin.H{"lastEventId": last}}) }
+	for _, ev := range replay { writeLiveEvent(c, ev) }
+	c.Writer.Flush()
+
+	t := time.NewTicker(h.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.Dropped():
+			return
+		case <-h.hub.Done():
+			return
+		case ev := <-sub.Events():
+			writeLiveEvent(c, ev)
+		case <-t.C:
+			c.Writer.WriteString(": heartbeat\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeLiveEvent(c *gin.Context, ev services.LiveEvent) {
+	c.Render(-1, sse.Event{Id: strconv.FormatUint(ev.ID, 10), Event: ev.Type, Data: ev.Data})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type UserHandler struct { svc *services.UserService }
+
+func NewUserHandler(svc *services.UserService) *UserHandler { return &UserHandler{svc: svc} }
+
+// addressError maps address book errors: unknown users and addresses are
+// 404, anything else is a validation failure.
+func addressError(c *gin.Context, err error) {
+	if errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrAddressNotFound) { c.JSON(http.StatusNotFound, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+func (h *UserHandler) ListAddresses(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	items, err := h.svc.ListAddresses(c.Request.Context(), &dto.ListAddressesRequest{UserID: userID})
+	if err != nil { addressError(c, err); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *UserHandler) GetAddress(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	id, err := parseUint(c.Param("addressId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address id"}); return }
+	a, err := h.svc.GetAddress(c.Request.Context(), &dto.GetAddressRequest{UserID: userID, ID: id})
+	if err != nil { addressError(c, err); return }
+	c.JSON(http.StatusOK, a)
+}
+
+func (h *UserHandler) CreateAddress(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	var req dto.AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req.UserID, req.ID = userID, 0
+	a, err := h.svc.CreateAddress(c.Request.Context(), &req)
+	if err != nil { addressError(c, err); return }
+	c.JSON(http.StatusCreated, a)
+}
+
+func (h *UserHandler) UpdateAddress(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	id, err := parseUint(c.Param("addressId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address id"}); return }
+	var req dto.AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req.UserID, req.ID = userID, id
+	a, err := h.svc.UpdateAddress(c.Request.Context(), &req)
+	if err != nil { addressError(c, err); return }
+	c.JSON(http.StatusOK, a)
+}
+
+func (h *UserHandler) DeleteAddress(c *gin.Context) {
+	userID, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"}); return }
+	id, err := parseUint(c.Param("addressId"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address id"}); return }
+	if err := h.svc.DeleteAddress(c.Request.Context(), &dto.DeleteAddressRequest{UserID: userID, ID: id}); err != nil { addressError(c, err); return }
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/services"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type WebhookHandler struct {
+	svc *services.WebhookService
+}
+
+func NewWebhookHandler(s *services.WebhookService) *WebhookHandler { return &WebhookHandler{svc: s} }
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrWebhookNotFound), errors.Is(err, storage.ErrDeliveryNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	items, err := h.svc.ListWebhooks(c.Request.Context(), &dto.ListWebhooksRequest{})
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateWebhook adds a subscription. The response carries the signing secret;
+// later reads do not.
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req dto.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	w, err := h.svc.CreateWebhook(c.Request.Context(), &req)
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, w)
+}
+
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	w, err := h.svc.GetWebhook(c.Request.Context(), &dto.GetWebhookRequest{ID: id})
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, w)
+}
+
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	var req dto.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"}); return }
+	req.ID = id
+	w, err := h.svc.UpdateWebhook(c.Request.Context(), &req)
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, w)
+}
+
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	if err := h.svc.DeleteWebhook(c.Request.Context(), &dto.GetWebhookRequest{ID: id}); err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
+
+// ListSubscriptionDeliveries is one subscription's delivery log.
+func (h *WebhookHandler) ListSubscriptionDeliveries(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	items, err := h.svc.ListDeliveries(c.Request.Context(), &dto.ListWebhookDeliveriesRequest{SubscriptionID: id, Status: c.Query("status")})
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+// ListDeliveries is the delivery log across subscriptions; ?status=DEAD lists
+// the dead letters.
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	items, err := h.svc.ListDeliveries(c.Request.Context(), &dto.ListWebhookDeliveriesRequest{Status: c.Query("status")})
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	id, err := parseUint(c.Param("id"))
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return }
+	d, err := h.svc.Redeliver(c.Request.Context(), &dto.RedeliverWebhookRequest{ID: id})
+	if err != nil { c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusAccepted, d)
+}
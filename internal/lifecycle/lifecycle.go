@@ -0,0 +1,79 @@
+// Package lifecycle runs background workers and an ordered list of shutdown
+// steps, so a stopping server finishes what it started before exiting.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Workers runs long-lived background loops under one context.
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewWorkers() *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{ctx: ctx, cancel: cancel}
+}
+
+// Go starts fn in its own goroutine. fn must return once its context is done.
+func (w *Workers) Go(name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+		slog.Debug("worker stopped", "worker", name)
+	}()
+}
+
+// Stop cancels the workers and waits for them until ctx is done.
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+	done := make(chan struct{})
+	go func() { w.wg.Wait(); close(done) }()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("workers still running: %w", ctx.Err())
+	}
+}
+
+// Shutdown is an ordered list of shutdown steps.
+type Shutdown struct {
+	steps []step
+}
+
+type step struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Add appends a step; steps run in the order they were added.
+func (s *Shutdown) Add(name string, fn func(ctx context.Context) error) {
+	s.steps = append(s.steps, step{name, fn})
+}
+
+// Run runs every step in order, logging each. A failed step does not stop
+// the later ones; their errors are joined. ctx bounds the whole shutdown.
+func (s *Shutdown) Run(ctx context.Context) error {
+	var errs []error
+	for _, st := range s.steps {
+		start := time.Now()
+		err := st.fn(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "shutdown step failed", "step", st.name, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", st.name, err))
+			continue
+		}
+		slog.InfoContext(ctx, "shutdown step done", "step", st.name, "duration_ms", time.Since(start).Milliseconds())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown_RunsStepsInOrder(t *testing.T) {
+	var s Shutdown
+	var got []string
+	s.Add("http", func(ctx context.Context) error { got = append(got, "http"); return nil })
+	s.Add("workers", func(ctx context.Context) error { got = append(got, "workers"); return errors.New("stuck") })
+	s.Add("storage", func(ctx context.Context) error { got = append(got, "storage"); return nil })
+	err := s.Run(context.Background())
+	if strings.Join(got, ",") != "http,workers,storage" { t.Fatalf("unexpected order %v", got) }
+	if err == nil || !strings.Contains(err.Error(), "workers: stuck") { t.Fatalf("expected the failed step reported, got %v", err) }
+}
+
+func TestWorkers_Stop(t *testing.T) {
+	w := NewWorkers()
+	stopped := make(chan struct{})
+	w.Go("loop", func(ctx context.Context) { <-ctx.Done(); close(stopped) })
+	if err := w.Stop(context.Background()); err != nil { t.Fatalf("stop: %v", err) }
+	select {
+	case <-stopped:
+	default:
+		t.Fatalf("expected the worker to have returned")
+	}
+
+	w = NewWorkers()
+	release := make(chan struct{})
+	defer close(release)
+	w.Go("stuck", func(ctx context.Context) { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("expected a timeout, got %v", err) }
+}
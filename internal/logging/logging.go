@@ -0,0 +1,143 @@
+// Package logging sets up JSON structured logging with log/slog and carries
+// per-request fields (the request ID, the caller, a rule violation) through
+// context.Context, so log lines from handlers, services and background work
+// started by a request can be correlated.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// RequestIDHeader is accepted from clients and echoed on every response.
+const RequestIDHeader = "X-Request-ID"
+
+// Redacted replaces the values of sensitive attributes.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys (matched case-insensitively, also as a
+// suffix such as "smtp_password") whose values are never logged.
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "cookie", "api_key", "apikey", "card_number", "cvv", "email", "signature"}
+
+// New returns a JSON logger writing to w at level. Records logged with a
+// context carry its request fields, and sensitive attributes are redacted.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: redact})
+	return slog.New(contextHandler{h})
+}
+
+// ParseLevel accepts debug, info, warn (or warning) and error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if Sensitive(a.Key) { return slog.String(a.Key, Redacted) }
+	return a
+}
+
+// Sensitive reports whether values under key must not be logged.
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if key == k || strings.HasSuffix(key, "_"+k) || strings.HasSuffix(key, "-"+k) { return true }
+	}
+	return false
+}
+
+// contextHandler adds the request fields found in the record's context.
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		r.AddAttrs(slog.String("request_id", info.id))
+		if info.userID != 0 { r.AddAttrs(slog.Uint64("user_id", uint64(info.userID))) }
+		info.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return contextHandler{h.Handler.WithAttrs(attrs)} }
+
+func (h contextHandler) WithGroup(name string) slog.Handler { return contextHandler{h.Handler.WithGroup(name)} }
+
+// requestInfo is shared by everything running for one request. Later layers
+// fill in what they learn (the caller, a rule violation) for the access log.
+type requestInfo struct {
+	mu       sync.Mutex
+	id       string
+	userID   uint
+	ruleCode string
+}
+
+type contextKey struct{}
+
+func fromContext(ctx context.Context) *requestInfo {
+	if ctx == nil { return nil }
+	info, _ := ctx.Value(contextKey{}).(*requestInfo)
+	return info
+}
+
+// WithRequestID starts the request fields for a request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestInfo{id: id})
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil { return info.id }
+	return ""
+}
+
+// SetUserID records the authenticated caller for the request's log lines.
+func SetUserID(ctx context.Context, id uint) {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		info.userID = id
+		info.mu.Unlock()
+	}
+}
+
+// UserID returns the caller recorded with SetUserID, or 0.
+func UserID(ctx context.Context) uint {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.userID
+	}
+	return 0
+}
+
+// SetRuleCode records the business rule that rejected the request.
+func SetRuleCode(ctx context.Context, code string) {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		info.ruleCode = code
+		info.mu.Unlock()
+	}
+}
+
+// RuleCode returns the code recorded with SetRuleCode, or "".
+func RuleCode(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.ruleCode
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNew_AddsRequestFieldsAndRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+	ctx := WithRequestID(context.Background(), "req-1")
+	SetUserID(ctx, 7)
+	logger.InfoContext(ctx, "login", "email", "jane@email.com", "smtp_password", "hunter2", "Authorization", "Bearer x", "order_id", 3)
+	logger.DebugContext(ctx, "hidden")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil { t.Fatalf("expected one JSON line, got %q", buf.String()) }
+	if line["request_id"] != "req-1" || line["user_id"] != float64(7) || line["order_id"] != float64(3) { t.Fatalf("missing request fields: %v", line) }
+	for _, k := range []string{"email", "smtp_password", "Authorization"} {
+		if line[k] != Redacted { t.Fatalf("expected %s redacted, got %v", k, line[k]) }
+	}
+}
+
+func TestRuleCode_SharedAcrossDerivedContexts(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "req-2")
+	SetRuleCode(context.WithValue(ctx, struct{}{}, 1), "daily_spend_cap")
+	if RuleCode(ctx) != "daily_spend_cap" || RequestID(ctx) != "req-2" { t.Fatalf("expected fields visible to the request, got %q %q", RuleCode(ctx), RequestID(ctx)) }
+	SetRuleCode(context.Background(), "ignored") // no request: a no-op
+	if RequestID(context.Background()) != "" { t.Fatalf("expected no request ID") }
+}
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{"": slog.LevelInfo, "DEBUG": slog.LevelDebug, "warning": slog.LevelWarn, "error": slog.LevelError} {
+		if got, err := ParseLevel(in); err != nil || got != want { t.Fatalf("ParseLevel(%q) = %v, %v", in, got, err) }
+	}
+	if _, err := ParseLevel("loud"); err == nil { t.Fatalf("expected an error for an unknown level") }
+}
@@ -0,0 +1,273 @@
+// Package metrics is a small, dependency-free implementation of counters,
+// histograms and gauges exposed in the Prometheus text exposition format
+// (version 0.0.4). Collectors are usually package variables registered on
+// Default, in the style of promauto.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds collectors and writes them in name order.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+type collector interface {
+	write(w io.Writer, name string)
+}
+
+func NewRegistry() *Registry { return &Registry{collectors: make(map[string]collector)} }
+
+// Default is the registry served by cmd/main.go at /metrics.
+var Default = NewRegistry()
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[name]; ok { panic("metrics: duplicate metric " + name) }
+	r.collectors[name] = c
+}
+
+// Expose writes every collector in the text exposition format.
+func (r *Registry) Expose(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for n := range r.collectors { names = append(names, n) }
+	sort.Strings(names)
+	cs := make([]collector, len(names))
+	for i, n := range names { cs[i] = r.collectors[n] }
+	r.mu.RUnlock()
+	for i, n := range names { cs[i].write(w, n) }
+}
+
+// ServeHTTP serves the registry as a scrape target.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.Expose(w)
+}
+
+// vec keeps one series per combination of label values.
+type vec[T any] struct {
+	help   string
+	typ    string
+	labels []string
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+	newT   func() *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) { panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(v.labels), len(values))) }
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok { return s }
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok { return s }
+	s = v.newT()
+	v.series[key] = s
+	v.values[key] = append([]string(nil), values...)
+	return s
+}
+
+// each calls fn for every series in label order.
+func (v *vec[T]) each(fn func(labels string, s *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series { keys = append(keys, k) }
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.mu.RLock()
+		s, values := v.series[k], v.values[k]
+		v.mu.RUnlock()
+		fn(formatLabels(v.labels, values), s)
+	}
+}
+
+func (v *vec[T]) header(w io.Writer, name string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(v.help), name, v.typ)
+}
+
+// CounterVec is a set of monotonically increasing counters.
+type CounterVec struct{ v *vec[counter] }
+
+type counter struct {
+	mu sync.Mutex
+	n  float64
+}
+
+// NewCounter registers a counter on Default.
+func NewCounter(name, help string, labels ...string) *CounterVec { return Default.NewCounter(name, help, labels...) }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{&vec[counter]{help: help, typ: "counter", labels: labels, series: map[string]*counter{}, values: map[string][]string{}, newT: func() *counter { return &counter{} }}}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds 1 to the series with the given label values.
+func (c *CounterVec) Inc(values ...string) { c.Add(1, values...) }
+
+// Add adds n, which must not be negative.
+func (c *CounterVec) Add(n float64, values ...string) {
+	if n < 0 { panic("metrics: counter cannot decrease") }
+	s := c.v.with(values)
+	s.mu.Lock()
+	s.n += n
+	s.mu.Unlock()
+}
+
+// Value returns the current value of a series, mainly for tests.
+func (c *CounterVec) Value(values ...string) float64 {
+	s := c.v.with(values)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+func (c *CounterVec) write(w io.Writer, name string) {
+	c.v.header(w, name)
+	c.v.each(func(labels string, s *counter) {
+		s.mu.Lock()
+		n := s.n
+		s.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(n))
+	})
+}
+
+// HistogramVec counts observations into cumulative buckets.
+type HistogramVec struct {
+	v       *vec[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram on Default. Buckets are upper bounds in
+// increasing order; nil means DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec { return Default.NewHistogram(name, help, buckets, labels...) }
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil { buckets = DefaultBuckets }
+	if !sort.Float64sAreSorted(buckets) { panic("metrics: buckets of " + name + " are not sorted") }
+	n := len(buckets) + 1
+	h := &HistogramVec{buckets: buckets}
+	h.v = &vec[histogram]{help: help, typ: "histogram", labels: labels, series: map[string]*histogram{}, values: map[string][]string{}, newT: func() *histogram { return &histogram{counts: make([]uint64, n)} }}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v in the series with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	s := h.v.with(values)
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with v <= bound
+	s.mu.Lock()
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	s.mu.Unlock()
+}
+
+// ObserveDuration records d in seconds.
+func (h *HistogramVec) ObserveDuration(d time.Duration, values ...string) { h.Observe(d.Seconds(), values...) }
+
+// Count returns how many observations a series has, mainly for tests.
+func (h *HistogramVec) Count(values ...string) uint64 {
+	s := h.v.with(values)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) {
+	h.v.header(w, name)
+	h.v.each(func(labels string, s *histogram) {
+		s.mu.Lock()
+		counts, sum, count := append([]uint64(nil), s.counts...), s.sum, s.count
+		s.mu.Unlock()
+		var cum uint64
+		for i, c := range counts {
+			cum += c
+			le := "+Inf"
+			if i < len(h.buckets) { le = formatFloat(h.buckets[i]) }
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", le), cum)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatFloat(sum), name, labels, count)
+	})
+}
+
+// gaugeFunc reads its value when scraped.
+type gaugeFunc struct {
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers on Default a gauge whose value is fn's at scrape
+// time.
+func NewGaugeFunc(name, help string, fn func() float64) { Default.NewGaugeFunc(name, help, fn) }
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) { r.register(name, &gaugeFunc{help: help, fn: fn}) }
+
+func (g *gaugeFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, escapeHelp(g.help), name, name, formatFloat(g.fn()))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 { return "" }
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 { b.WriteByte(',') }
+		b.WriteString(n + `="` + escapeLabel(values[i]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func withLabel(labels, name, value string) string {
+	if labels == "" { return "{" + name + `="` + value + `"}` }
+	return labels[:len(labels)-1] + "," + name + `="` + value + `"}`
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry_Expose(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("jobs_total", "Jobs run.", "queue")
+	h := r.NewHistogram("job_seconds", "Job duration.", []float64{0.1, 1})
+	r.NewGaugeFunc("queue_depth", "Queued jobs.", func() float64 { return 3 })
+	c.Inc(`say "hi"`)
+	c.Add(2, "bulk")
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(7)
+
+	var buf bytes.Buffer
+	r.Expose(&buf)
+	want := `# HELP job_seconds Job duration.
+# TYPE job_seconds histogram
+job_seconds_bucket{le="0.1"} 1
+job_seconds_bucket{le="1"} 2
+job_seconds_bucket{le="+Inf"} 3
+job_seconds_sum 7.55
+job_seconds_count 3
+# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total{queue="bulk"} 2
+jobs_total{queue="say \"hi\""} 1
+# HELP queue_depth Queued jobs.
+# TYPE queue_depth gauge
+queue_depth 3
+`
+	if buf.String() != want { t.Fatalf("unexpected exposition:\n%s", buf.String()) }
+	if c.Value("bulk") != 2 || h.Count() != 3 { t.Fatalf("unexpected values %v %d", c.Value("bulk"), h.Count()) }
+}
+
+func TestRegistry_RejectsDuplicatesAndWrongLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("x_total", "X.", "a")
+	mustPanic(t, func() { r.NewCounter("x_total", "X again.") })
+	mustPanic(t, func() { c.Inc() })
+	mustPanic(t, func() { c.Add(-1, "v") })
+}
+
+func mustPanic(t *testing.T, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil { t.Fatalf("expected a panic") }
+	}()
+	fn()
+}
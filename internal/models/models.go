@@ -1,50 +1,690 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RoleAdmin marks users allowed to call /admin endpoints.
+const RoleAdmin = "admin"
 
 type User struct {
 	ID    uint   `json:"id"`
 	Email string `json:"email"`
 	Name  string `json:"name"`
+	Role  string `json:"role"`
+}
+
+// Address is an entry in a user's address book. Orders keep their own copy,
+// so editing or deleting an address never changes a placed order.
+type Address struct {
+	ID              uint      `json:"id"`
+	UserID          uint      `json:"userId"`
+	Label           string    `json:"label,omitempty"` // e.g. "home", "work"
+	Name            string    `json:"name"`
+	Line1           string    `json:"line1"`
+	Line2           string    `json:"line2,omitempty"`
+	City            string    `json:"city"`
+	Region          string    `json:"region,omitempty"` // state/province/county
+	PostalCode      string    `json:"postalCode"`
+	Country         string    `json:"country"` // ISO 3166-1 alpha-2
+	Phone           string    `json:"phone,omitempty"`
+	DefaultShipping bool      `json:"defaultShipping"`
+	DefaultBilling  bool      `json:"defaultBilling"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
 }
 
 type Product struct {
-	ID           uint      `json:"id"`
-	Title        string    `json:"title"`
-	Author       string    `json:"author"`
-	Description  string    `json:"description"`
-	Price        float64   `json:"price"`
-	Stock        int       `json:"stock"`
-	Discontinued bool      `json:"discontinued"`
-	IsSpecial    bool      `json:"isSpecial"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID           uint    `json:"id"`
+	ISBN         string  `json:"isbn"`
+	Title        string  `json:"title"`
+	Author       string  `json:"author"`
+	Description  string  `json:"description"`
+	Category     string  `json:"category"`
+	Price        float64 `json:"price"`
+	Stock        int     `json:"stock"`     // on hand
+	Held         int     `json:"held"`      // reserved by active cart holds; computed on read
+	Available    int     `json:"available"` // Stock minus Held; computed on read
+	Discontinued bool    `json:"discontinued"`
+	IsSpecial    bool    `json:"isSpecial"`
+	// Backorders and pre-orders. A product with a future ReleaseDate is a
+	// pre-order. Either way at most MaxBackorder units may be outstanding
+	// beyond available stock.
+	AllowBackorder    bool       `json:"allowBackorder"`
+	MaxBackorder      int        `json:"maxBackorder"`
+	BackorderLeadDays int        `json:"backorderLeadDays"`
+	ReleaseDate       *time.Time `json:"releaseDate,omitempty"`
+	Backordered       int        `json:"backordered"` // outstanding units; computed on read
+	// Replenishment. When stock falls to ReorderPoint or below a low-stock
+	// alert fires and ReorderQuantity is added to Supplier's draft purchase
+	// order. A ReorderPoint of 0 disables this.
+	ReorderPoint    int       `json:"reorderPoint"`
+	ReorderQuantity int       `json:"reorderQuantity"`
+	Supplier        string    `json:"supplier"`
+	Version         uint64    `json:"version"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
 }
 
 type CartItem struct {
 	ProductID uint    `json:"productId"`
 	Quantity  int     `json:"quantity"`
 	UnitPrice float64 `json:"unitPrice"`
+	// PreviousUnitPrice is set when a price change repriced this line after
+	// it was added, so the storefront can point it out.
+	PreviousUnitPrice float64   `json:"previousUnitPrice,omitempty"`
+	Backordered       int       `json:"backordered,omitempty"` // units beyond available stock
+	ExpectedShipDate  time.Time `json:"expectedShipDate"`
 }
 
 type Cart struct {
-	ID     uint       `json:"id"`
-	UserID uint       `json:"userId"`
-	Items  []CartItem `json:"items"`
+	ID      uint       `json:"id"`
+	UserID  uint       `json:"userId"`
+	Items   []CartItem `json:"items"`
+	Coupons []string   `json:"coupons,omitempty"` // applied coupon codes
+	// Pricing, computed on read.
+	Subtotal      float64        `json:"subtotal"`
+	Discounts     []DiscountLine `json:"discounts,omitempty"`
+	DiscountTotal float64        `json:"discountTotal"`
+	FreeShipping  bool           `json:"freeShipping,omitempty"`
+	Total         float64        `json:"total"` // Subtotal minus DiscountTotal
+	Shipping      float64        `json:"shipping"`
+	TaxLines      []TaxLine      `json:"taxLines,omitempty"`
+	Tax           float64        `json:"tax"`
+	GrandTotal    float64        `json:"grandTotal"` // Total plus Shipping and Tax
 }
 
 type OrderItem struct {
-	ProductID uint    `json:"productId"`
-	Quantity  int     `json:"quantity"`
-	UnitPrice float64 `json:"unitPrice"`
-	Subtotal  float64 `json:"subtotal"`
+	ProductID   uint         `json:"productId"`
+	Quantity    int          `json:"quantity"`
+	UnitPrice   float64      `json:"unitPrice"`
+	Subtotal    float64      `json:"subtotal"`
+	Allocations []Allocation `json:"allocations,omitempty"` // warehouses fulfilling this line
+	// Backordered counts units still waiting for stock; they are allocated
+	// first-come-first-served as stock is received.
+	Backordered      int       `json:"backordered,omitempty"`
+	ExpectedShipDate time.Time `json:"expectedShipDate"`
+}
+
+// IsPreOrder reports whether the product has not been released yet at now.
+func (p *Product) IsPreOrder(now time.Time) bool {
+	return p.ReleaseDate != nil && p.ReleaseDate.After(now)
+}
+
+// AcceptsBackorders reports whether units beyond stock may be ordered.
+func (p *Product) AcceptsBackorders(now time.Time) bool {
+	return (p.AllowBackorder || p.IsPreOrder(now)) && p.MaxBackorder > 0
+}
+
+// Allocation is the part of an order line shipped from one warehouse.
+type Allocation struct {
+	WarehouseID uint `json:"warehouseId"`
+	Quantity    int  `json:"quantity"`
 }
 
 type Order struct {
-	ID        uint        `json:"id"`
-	UserID    uint        `json:"userId"`
-	Items     []OrderItem `json:"items"`
-	Total     float64     `json:"total"`
-	Status    string      `json:"status"`
-	CreatedAt time.Time   `json:"createdAt"`
+	ID            uint           `json:"id"`
+	UserID        uint           `json:"userId"`
+	Items         []OrderItem    `json:"items"`
+	Subtotal      float64        `json:"subtotal"`
+	Discounts     []DiscountLine `json:"discounts,omitempty"`
+	DiscountTotal float64        `json:"discountTotal"`
+	FreeShipping  bool           `json:"freeShipping,omitempty"`
+	Total         float64        `json:"total"` // Subtotal minus DiscountTotal
+	Shipping      float64        `json:"shipping"`
+	TaxLines      []TaxLine      `json:"taxLines,omitempty"`
+	Tax           float64        `json:"tax"`
+	GrandTotal    float64        `json:"grandTotal"` // Total plus Shipping and Tax
+	// ShippingAddress and BillingAddress are snapshots taken at checkout.
+	ShippingAddress *Address   `json:"shippingAddress,omitempty"`
+	BillingAddress  *Address   `json:"billingAddress,omitempty"`
+	Payment         *Payment   `json:"payment,omitempty"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	ShippedAt       *time.Time `json:"shippedAt,omitempty"`
+	DeliveredAt     *time.Time `json:"deliveredAt,omitempty"`
+	CancelledAt     *time.Time `json:"cancelledAt,omitempty"`
+	// Returns lists the order's return authorizations, oldest first.
+	Returns []ReturnAuthorization `json:"returns,omitempty"`
+}
+
+// Order statuses. PENDING_PAYMENT orders hold stock while the payment
+// provider waits on a customer challenge.
+const (
+	OrderPlaced         = "PLACED"
+	OrderPendingReview  = "PENDING_REVIEW"
+	OrderPendingPayment = "PENDING_PAYMENT"
+	OrderShipped        = "SHIPPED"
+	OrderDelivered      = "DELIVERED"
+	OrderCancelled      = "CANCELLED"
+)
+
+// ReturnAuthorization (RMA) is a customer's request to send back some units
+// of a delivered order, and its progress through approval, receipt and
+// refund.
+type ReturnAuthorization struct {
+	ID               uint         `json:"id"`
+	OrderID          uint         `json:"orderId"`
+	UserID           uint         `json:"userId"`
+	Items            []ReturnItem `json:"items"`
+	Reason           string       `json:"reason"`
+	Status           string       `json:"status"`
+	RefundAmount     float64      `json:"refundAmount"`
+	ShippingRefunded bool         `json:"shippingRefunded,omitempty"`
+	CreatedAt        time.Time    `json:"createdAt"`
+	UpdatedAt        time.Time    `json:"updatedAt"`
+}
+
+// ReturnItem is one order line's returned units. Restocked and WrittenOff
+// are set when the parcel is received and add up to Quantity.
+type ReturnItem struct {
+	ProductID  uint `json:"productId"`
+	Quantity   int  `json:"quantity"`
+	Restocked  int  `json:"restocked"`
+	WrittenOff int  `json:"writtenOff"`
+}
+
+// Return statuses.
+const (
+	ReturnRequested = "REQUESTED"
+	ReturnApproved  = "APPROVED"
+	ReturnRejected  = "REJECTED"
+	ReturnReceived  = "RECEIVED"
+	ReturnRefunded  = "REFUNDED"
+)
+
+// Payment is the order's payment state as last reported by the provider.
+type Payment struct {
+	Provider        string    `json:"provider"`
+	AuthorizationID string    `json:"authorizationId"`
+	Status          string    `json:"status"`
+	Amount          float64   `json:"amount"` // authorized
+	Captured        float64   `json:"captured"`
+	Refunded        float64   `json:"refunded"`
+	ChallengeURL    string    `json:"challengeUrl,omitempty"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// Payment statuses.
+const (
+	PaymentRequiresAction    = "requires_action"
+	PaymentAuthorized        = "authorized"
+	PaymentCaptured          = "captured"
+	PaymentVoided            = "voided"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentRefunded          = "refunded"
+	PaymentFailed            = "failed"
+)
+
+// PaymentEvent is an asynchronous notification from a payment provider.
+// Receivers treat it as a hint and look the authorization up again.
+type PaymentEvent struct {
+	Type            string `json:"type"`
+	AuthorizationID string `json:"authorizationId"`
+}
+
+// Payment event types.
+const (
+	PaymentEventAuthorized = "payment.authorized"
+	PaymentEventFailed     = "payment.failed"
+)
+
+// CheckoutSaga records a checkout's progress through its steps so that an
+// interrupted checkout can be resumed or compensated. Order is the draft
+// being built; Items are the cart lines being bought.
+type CheckoutSaga struct {
+	ID             uint       `json:"id"`
+	UserID         uint       `json:"userId"`
+	IdempotencyKey string     `json:"idempotencyKey,omitempty"`
+	RequestID      string     `json:"requestId,omitempty"` // the request that started it
+	Status         string     `json:"status"`
+	OrderID        uint       `json:"orderId"`
+	Steps          []SagaStep `json:"steps"`
+	Order          *Order     `json:"order,omitempty"`
+	Items          []CartItem `json:"items"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// SagaStep is one step of a checkout saga.
+type SagaStep struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Checkout saga statuses. A FAILED saga could not be compensated and needs
+// an operator.
+const (
+	SagaRunning      = "RUNNING"
+	SagaCompleted    = "COMPLETED"
+	SagaCompensating = "COMPENSATING"
+	SagaCompensated  = "COMPENSATED"
+	SagaFailed       = "FAILED"
+)
+
+// Saga step statuses.
+const (
+	StepPending     = "PENDING"
+	StepStarted     = "STARTED"
+	StepDone        = "DONE"
+	StepFailed      = "FAILED"
+	StepCompensated = "COMPENSATED"
+)
+
+// StockHold is a time-limited soft reservation placed when an item is added
+// to a cart. Active holds reduce what other users can buy.
+type StockHold struct {
+	UserID    uint      `json:"userId"`
+	ProductID uint      `json:"productId"`
+	Quantity  int       `json:"quantity"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Inventory movement types. Sale, cancellation and return are recorded by
+// checkout, order and return flows; the rest come from admin adjustments.
+const (
+	MovementReceipt      = "receipt"
+	MovementSale         = "sale"
+	MovementCancellation = "cancellation"
+	MovementReturn       = "return"
+	MovementAdjustment   = "adjustment"
+	MovementDamage       = "damage"
+	MovementTransfer     = "transfer"
+)
+
+// Warehouse is a stock location. Region drives nearest-first allocation and
+// CostPerUnit cheapest-first allocation; Priority breaks ties (lower first).
+type Warehouse struct {
+	ID          uint      `json:"id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Region      string    `json:"region"`
+	CostPerUnit float64   `json:"costPerUnit"`
+	Priority    int       `json:"priority"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// WarehouseStock is a product's on-hand quantity at one warehouse.
+type WarehouseStock struct {
+	WarehouseID uint `json:"warehouseId"`
+	Quantity    int  `json:"quantity"`
+}
+
+// InventoryMovement is one append-only ledger entry. Quantity is the signed
+// change applied to the product's stock at WarehouseID, so both per-warehouse
+// and total stock always equal the ledger sum. StockAfter is the product total.
+type InventoryMovement struct {
+	ID          uint      `json:"id"`
+	ProductID   uint      `json:"productId"`
+	WarehouseID uint      `json:"warehouseId"`
+	Type        string    `json:"type"`
+	Quantity    int       `json:"quantity"`
+	StockAfter  int       `json:"stockAfter"`
+	Reason      string    `json:"reason"`
+	Actor       string    `json:"actor"`
+	OrderID     uint      `json:"orderId,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// InventoryDiscrepancy is reported when a product's stock, in total or at a
+// single warehouse (WarehouseID set), differs from the sum of its movements.
+type InventoryDiscrepancy struct {
+	ProductID   uint `json:"productId"`
+	WarehouseID uint `json:"warehouseId,omitempty"`
+	Stock       int  `json:"stock"`
+	LedgerSum   int  `json:"ledgerSum"`
+}
+
+// StockAlert is emitted when a movement takes a product's stock from above
+// its reorder point to at or below it.
+type StockAlert struct {
+	ProductID       uint      `json:"productId"`
+	Title           string    `json:"title"`
+	Supplier        string    `json:"supplier"`
+	Stock           int       `json:"stock"`
+	ReorderPoint    int       `json:"reorderPoint"`
+	ReorderQuantity int       `json:"reorderQuantity"`
+	Cause           string    `json:"cause"` // movement type that crossed the threshold
+	At              time.Time `json:"at"`
+	// Attempts counts failed deliveries to the notifier; the alert reached
+	// the draft purchase order on the first one.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Purchase order statuses.
+const (
+	PurchaseOrderDraft    = "DRAFT"
+	PurchaseOrderApproved = "APPROVED"
+)
+
+// PurchaseOrder groups replenishment lines for one supplier. Drafts collect
+// lines from low-stock alerts until a buyer approves them.
+type PurchaseOrder struct {
+	ID         uint                `json:"id"`
+	Supplier   string              `json:"supplier"`
+	Status     string              `json:"status"`
+	Lines      []PurchaseOrderLine `json:"lines"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	ApprovedAt *time.Time          `json:"approvedAt,omitempty"`
+	ApprovedBy string              `json:"approvedBy,omitempty"`
+}
+
+type PurchaseOrderLine struct {
+	ProductID uint `json:"productId"`
+	Quantity  int  `json:"quantity"`
 }
+
+// RestockSubscription is a customer's request to hear when a product that
+// is out of stock or discontinued can be bought again.
+type RestockSubscription struct {
+	UserID    uint      `json:"userId"`
+	ProductID uint      `json:"productId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RestockNotification is an outbox entry telling one subscriber a product is
+// back in stock. It stays queued until delivered.
+type RestockNotification struct {
+	ID           uint      `json:"id"`
+	UserID       uint      `json:"userId"`
+	ProductID    uint      `json:"productId"`
+	Title        string    `json:"title"`
+	SubscribedAt time.Time `json:"subscribedAt"`
+	QueuedAt     time.Time `json:"queuedAt"`
+}
+
+// PriceChange is one entry in a product's price history.
+type PriceChange struct {
+	ProductID     uint      `json:"productId"`
+	Price         float64   `json:"price"`
+	PreviousPrice float64   `json:"previousPrice"` // 0 for the initial price
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	Actor         string    `json:"actor"`
+	ScheduleID    uint      `json:"scheduleId,omitempty"` // set when applied by the scheduler
+}
+
+// ScheduledPrice is a future price change waiting for EffectiveAt.
+type ScheduledPrice struct {
+	ID          uint      `json:"id"`
+	ProductID   uint      `json:"productId"`
+	Price       float64   `json:"price"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+	Actor       string    `json:"actor"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Promotion types.
+const (
+	PromoPercentOff   = "percent_off"
+	PromoFixedAmount  = "fixed_amount"
+	PromoBuyXGetY     = "buy_x_get_y"
+	PromoFreeShipping = "free_shipping"
+)
+
+// Promotion scopes: which cart lines a promotion applies to.
+const (
+	PromoScopeCart       = "cart"
+	PromoScopeProducts   = "products"
+	PromoScopeCategories = "categories"
+	PromoScopeAuthors    = "authors"
+)
+
+// Promotion is a discount rule. Promotions without a Code apply
+// automatically; coded ones only once the code is applied to the cart.
+type Promotion struct {
+	ID   uint   `json:"id"`
+	Code string `json:"code,omitempty"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Value is the percentage for percent_off and the amount for fixed_amount.
+	Value float64 `json:"value,omitempty"`
+	// BuyQuantity and GetQuantity configure buy_x_get_y: for every
+	// BuyQuantity+GetQuantity eligible units, the cheapest GetQuantity are free.
+	BuyQuantity int        `json:"buyQuantity,omitempty"`
+	GetQuantity int        `json:"getQuantity,omitempty"`
+	Scope       string     `json:"scope"`
+	ProductIDs  []uint     `json:"productIds,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
+	Authors     []string   `json:"authors,omitempty"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+	EndsAt      *time.Time `json:"endsAt,omitempty"`
+	// Usage limits counted over placed orders; 0 means unlimited.
+	MaxUses        int `json:"maxUses"`
+	MaxUsesPerUser int `json:"maxUsesPerUser"`
+	Uses           int `json:"uses"`
+	// Stackable promotions combine with each other; a non-stackable one is
+	// only ever applied alone.
+	Stackable bool      `json:"stackable"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ActiveAt reports whether the promotion is enabled and inside its window.
+func (p *Promotion) ActiveAt(now time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return false
+	}
+	if p.EndsAt != nil && !now.Before(*p.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// DiscountLine is one promotion's effect on a cart or order.
+type DiscountLine struct {
+	PromotionID  uint    `json:"promotionId"`
+	Code         string  `json:"code,omitempty"`
+	Description  string  `json:"description"`
+	Amount       float64 `json:"amount"`
+	FreeShipping bool    `json:"freeShipping,omitempty"`
+}
+
+// TaxLine is the tax charged by one jurisdiction on one class of amounts.
+type TaxLine struct {
+	Jurisdiction string  `json:"jurisdiction"` // e.g. "GB" or "US-CA"
+	Category     string  `json:"category"`     // "books" or "shipping"
+	Rate         float64 `json:"rate"`
+	Taxable      float64 `json:"taxable"`
+	Amount       float64 `json:"amount"`
+}
+
+// DomainEvent records something that happened to an aggregate (a product,
+// cart, order or return). Events are written to the store's outbox together
+// with the change they describe; ID is the outbox sequence number.
+type DomainEvent struct {
+	ID          uint64          `json:"id"`
+	Type        string          `json:"type"`
+	Aggregate   string          `json:"aggregate"`
+	AggregateID uint            `json:"aggregateId"`
+	Data        json.RawMessage `json:"data"`
+	OccurredAt  time.Time       `json:"occurredAt"`
+	// RequestID is the ID of the request whose change raised the event;
+	// empty for background work.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// AggregateKey identifies the event's aggregate, e.g. "order/7". Events with
+// the same key are delivered in order.
+func (e DomainEvent) AggregateKey() string { return fmt.Sprintf("%s/%d", e.Aggregate, e.AggregateID) }
+
+// OutboxEntry is an event that has not reached every consumer yet.
+type OutboxEntry struct {
+	Event         DomainEvent `json:"event"`
+	Delivered     []string    `json:"delivered,omitempty"` // consumers that have it
+	Attempts      int         `json:"attempts"`
+	NextAttemptAt time.Time   `json:"nextAttemptAt"`
+	LastError     string      `json:"lastError,omitempty"`
+}
+
+// ParkedEvent is an event that Sinks still refused after the bus's last
+// attempt. Every other consumer has it; it left the outbox so the
+// aggregate's later events are not held back.
+type ParkedEvent struct {
+	Event     DomainEvent `json:"event"`
+	Sinks     []string    `json:"sinks"`
+	Attempts  int         `json:"attempts"`
+	LastError string      `json:"lastError"`
+	ParkedAt  time.Time   `json:"parkedAt"`
+}
+
+// Domain event types.
+const (
+	EventProductCreated      = "ProductCreated"
+	EventProductUpdated      = "ProductUpdated"
+	EventProductDeleted      = "ProductDeleted"
+	EventPriceChanged        = "PriceChanged"
+	EventStockChanged        = "StockChanged"
+	EventHoldsChanged        = "HoldsChanged"
+	EventCartItemAdded       = "CartItemAdded"
+	EventCartItemRemoved     = "CartItemRemoved"
+	EventCartCheckedOut      = "CartCheckedOut"
+	EventOrderPlaced         = "OrderPlaced"
+	EventOrderStatusChanged  = "OrderStatusChanged"
+	EventReturnStatusChanged = "ReturnStatusChanged"
+)
+
+// Event aggregates.
+const (
+	AggregateProduct = "product"
+	AggregateCart    = "cart" // keyed by user ID
+	AggregateOrder   = "order"
+	AggregateReturn  = "return"
+)
+
+// StatusChange is the payload of OrderStatusChanged and ReturnStatusChanged.
+// From is empty for a return that was just requested.
+type StatusChange struct {
+	ID      uint   `json:"id"`
+	OrderID uint   `json:"orderId,omitempty"`
+	UserID  uint   `json:"userId"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+}
+
+// HoldChange is the payload of HoldsChanged: the product's cart holds after
+// a hold was placed, changed, released or swept after expiring.
+type HoldChange struct {
+	ProductID uint   `json:"productId"`
+	Version   uint64 `json:"version"`
+	Held      int    `json:"held"`
+	Available int    `json:"available"`
+}
+
+// CartChange is the payload of cart events. Quantity is the line's quantity
+// after the change.
+type CartChange struct {
+	UserID    uint `json:"userId"`
+	ProductID uint `json:"productId,omitempty"`
+	Quantity  int  `json:"quantity"`
+	OrderID   uint `json:"orderId,omitempty"`
+}
+
+// WebhookSubscription sends the listed webhook event types (or "*" for all)
+// to URL. Secret signs every payload; it is only shown when the subscription
+// is created.
+type WebhookSubscription struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WebhookDelivery is one event's delivery to one subscription, with every
+// attempt made so far. A delivery that runs out of attempts is DEAD until it
+// is redelivered; Tries counts the attempts since it was last queued.
+type WebhookDelivery struct {
+	ID             uint             `json:"id"`
+	SubscriptionID uint             `json:"subscriptionId"`
+	EventID        uint64           `json:"eventId"`
+	Event          string           `json:"event"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       []WebhookAttempt `json:"attempts"`
+	Tries          int              `json:"tries"`
+	NextAttemptAt  time.Time        `json:"nextAttemptAt"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	UpdatedAt      time.Time        `json:"updatedAt"`
+}
+
+// WebhookAttempt is one HTTP request of a delivery. StatusCode is 0 when no
+// response arrived.
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "PENDING"
+	DeliverySucceeded = "SUCCEEDED"
+	DeliveryDead      = "DEAD"
+)
+
+// RuleRejection reports a request refused by a business rule.
+type RuleRejection struct {
+	Operation string    `json:"operation"`
+	UserID    uint      `json:"userId"`
+	ProductID uint      `json:"productId,omitempty"`
+	Quantity  int       `json:"quantity,omitempty"`
+	Rule      string    `json:"rule"`
+	Code      string    `json:"code"`
+	At        time.Time `json:"at"`
+}
+
+// RuleRejection operations.
+const (
+	OperationAddToCart  = "add_to_cart"
+	OperationPlaceOrder = "place_order"
+)
+
+// NotificationPreferences controls the emails a user receives. Muted lists
+// notification kinds the user opted out of; Locale ("de", "en-GB") picks
+// template variants.
+type NotificationPreferences struct {
+	UserID       uint      `json:"userId"`
+	EmailEnabled bool      `json:"emailEnabled"`
+	Muted        []string  `json:"muted"`
+	Locale       string    `json:"locale"`
+	UpdatedAt    time.Time `json:"updatedAt,omitempty"`
+}
+
+// EmailNotification is a rendered email queued for sending. It is rendered
+// when queued, so later template or order changes do not alter it.
+type EmailNotification struct {
+	ID            uint       `json:"id"`
+	UserID        uint       `json:"userId"`
+	Kind          string     `json:"kind"`
+	EventID       uint64     `json:"eventId"`
+	OrderID       uint       `json:"orderId,omitempty"`
+	To            string     `json:"to"`
+	Locale        string     `json:"locale"`
+	Subject       string     `json:"subject"`
+	Text          string     `json:"text"`
+	HTML          string     `json:"html"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+// EmailNotification statuses.
+const (
+	EmailPending = "PENDING"
+	EmailSent    = "SENT"
+	EmailFailed  = "FAILED"
+)
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var countryCodeRe = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// postcodeFormats validates postal codes for countries we ship to often.
+// Codes are upper-cased before matching. Countries not listed accept any
+// short alphanumeric code, or none.
+var postcodeFormats = map[string]*regexp.Regexp{
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}[0-9][A-Z0-9]? [0-9][A-Z]{2}$`),
+	"IE": regexp.MustCompile(`^[A-Z][0-9][0-9W] [A-Z0-9]{4}$`),
+	"US": regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`),
+	"CA": regexp.MustCompile(`^[A-Z][0-9][A-Z] [0-9][A-Z][0-9]$`),
+	"DE": regexp.MustCompile(`^[0-9]{5}$`),
+	"FR": regexp.MustCompile(`^[0-9]{5}$`),
+	"NL": regexp.MustCompile(`^[0-9]{4} [A-Z]{2}$`),
+	"AU": regexp.MustCompile(`^[0-9]{4}$`),
+}
+
+var otherPostcodeRe = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{0,11}$`)
+
+// regionRequired lists countries whose addresses need a state or province.
+var regionRequired = map[string]bool{"US": true, "CA": true, "AU": true}
+
+func (s *UserService) ListAddresses(ctx context.Context, req *dto.ListAddressesRequest) ([]*dto.Address, error) {
+	_ = ctx
+	return s.store.GetAddresses(req.UserID)
+}
+
+func (s *UserService) GetAddress(ctx context.Context, req *dto.GetAddressRequest) (*dto.Address, error) {
+	_ = ctx
+	return s.store.GetAddress(req.UserID, req.ID)
+}
+
+func (s *UserService) CreateAddress(ctx context.Context, req *dto.AddressRequest) (*dto.Address, error) {
+	_ = ctx
+	a, err := normalizeAddress(req)
+	if err != nil { return nil, err }
+	return s.store.CreateAddress(a)
+}
+
+func (s *UserService) UpdateAddress(ctx context.Context, req *dto.AddressRequest) (*dto.Address, error) {
+	_ = ctx
+	a, err := normalizeAddress(req)
+	if err != nil { return nil, err }
+	return s.store.UpdateAddress(a)
+}
+
+// DeleteAddress removes an address from the book. Orders keep their copies;
+// if it was a default the user has none until another is flagged.
+func (s *UserService) DeleteAddress(ctx context.Context, req *dto.DeleteAddressRequest) error {
+	_ = ctx
+	return s.store.DeleteAddress(req.UserID, req.ID)
+}
+
+// normalizeAddress trims and upper-cases the request into an address and
+// checks it is structurally deliverable for its country.
+func normalizeAddress(req *dto.AddressRequest) (*models.Address, error) {
+	a := &models.Address{
+		ID: req.ID, UserID: req.UserID,
+		Label: strings.TrimSpace(req.Label), Name: strings.TrimSpace(req.Name),
+		Line1: strings.TrimSpace(req.Line1), Line2: strings.TrimSpace(req.Line2),
+		City: strings.TrimSpace(req.City), Region: strings.ToUpper(strings.TrimSpace(req.Region)),
+		PostalCode: strings.Join(strings.Fields(strings.ToUpper(req.PostalCode)), " "),
+		Country: strings.ToUpper(strings.TrimSpace(req.Country)), Phone: strings.TrimSpace(req.Phone),
+		DefaultShipping: req.DefaultShipping, DefaultBilling: req.DefaultBilling,
+	}
+	if a.Name == "" || len(a.Name) > 100 { return nil, errors.New("invalid address name") }
+	if a.Line1 == "" || len(a.Line1) > 200 || len(a.Line2) > 200 { return nil, errors.New("invalid address line") }
+	if a.City == "" || len(a.City) > 100 { return nil, errors.New("invalid city") }
+	if len(a.Label) > 50 || len(a.Phone) > 30 || len(a.Region) > 50 { return nil, errors.New("address field too long") }
+	if !countryCodeRe.MatchString(a.Country) { return nil, errors.New("country must be an ISO 3166-1 alpha-2 code") }
+	if regionRequired[a.Country] && a.Region == "" { return nil, fmt.Errorf("region is required for %s addresses", a.Country) }
+	if re, ok := postcodeFormats[a.Country]; ok {
+		if !re.MatchString(postcodeSpaced(a.Country, a.PostalCode)) { return nil, fmt.Errorf("invalid postal code for %s", a.Country) }
+		a.PostalCode = postcodeSpaced(a.Country, a.PostalCode)
+	} else if a.PostalCode != "" && !otherPostcodeRe.MatchString(a.PostalCode) {
+		return nil, errors.New("invalid postal code")
+	}
+	return a, nil
+}
+
+// postcodeSpaced inserts the conventional space into codes typed without
+// one, so "sw1a1aa" and "SW1A 1AA" are stored alike.
+func postcodeSpaced(country, code string) string {
+	if strings.Contains(code, " ") { return code }
+	switch country {
+	case "GB", "CA":
+		if len(code) > 3 { return code[:len(code)-3] + " " + code[len(code)-3:] }
+	case "IE":
+		if len(code) == 7 { return code[:3] + " " + code[3:] }
+	case "NL":
+		if len(code) == 6 { return code[:4] + " " + code[4:] }
+	}
+	return code
+}
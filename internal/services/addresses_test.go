@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestAddresses_ValidationAndDefaults(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	store.CreateUser(&models.User{Email: "a@b.c", Name: "A"})
+	svc := NewUserService(store)
+
+	bad := []dto.AddressRequest{
+		{UserID: 1, Name: "A", Line1: "1 High St", City: "Leeds", PostalCode: "12345", Country: "GB"},
+		{UserID: 1, Name: "A", Line1: "1 Main St", City: "Austin", PostalCode: "78701", Country: "US"},
+		{UserID: 1, Name: "A", Line1: "1 Main St", City: "Austin", Region: "TX", PostalCode: "7870", Country: "US"},
+		{UserID: 1, Name: "A", Line1: "1 Rue", City: "Paris", PostalCode: "75001", Country: "France"},
+		{UserID: 1, Line1: "1 Rue", City: "Paris", PostalCode: "75001", Country: "FR"},
+	}
+	for i := range bad {
+		if _, err := svc.CreateAddress(ctx, &bad[i]); err == nil { t.Fatalf("expected validation error for %+v", bad[i]) }
+	}
+	if _, err := svc.CreateAddress(ctx, &dto.AddressRequest{UserID: 9, Name: "A", Line1: "x", City: "y", Country: "NZ"}); err != storage.ErrUserNotFound { t.Fatalf("expected unknown user, got %v", err) }
+
+	// the first address becomes both defaults; postcodes are normalised
+	home, err := svc.CreateAddress(ctx, &dto.AddressRequest{UserID: 1, Name: "A", Line1: "10 Downing St", City: "London", PostalCode: "sw1a2aa", Country: "gb"})
+	if err != nil { t.Fatalf("create: %v", err) }
+	if !home.DefaultShipping || !home.DefaultBilling || home.PostalCode != "SW1A 2AA" || home.Country != "GB" { t.Fatalf("unexpected first address: %+v", home) }
+	work, err := svc.CreateAddress(ctx, &dto.AddressRequest{UserID: 1, Name: "A", Line1: "1 Main St", City: "Austin", Region: "tx", PostalCode: "78701-1234", Country: "US", DefaultShipping: true})
+	if err != nil { t.Fatalf("create: %v", err) }
+	ship, bill := store.GetDefaultAddresses(1)
+	if ship.ID != work.ID || bill.ID != home.ID { t.Fatalf("expected shipping default to move, got ship=%d bill=%d", ship.ID, bill.ID) }
+
+	// updates replace the address; other users cannot touch it
+	if _, err := svc.UpdateAddress(ctx, &dto.AddressRequest{UserID: 2, ID: home.ID, Name: "B", Line1: "x", City: "y", Country: "NZ"}); err != storage.ErrAddressNotFound { t.Fatalf("expected not found, got %v", err) }
+	upd, err := svc.UpdateAddress(ctx, &dto.AddressRequest{UserID: 1, ID: home.ID, Name: "A", Line1: "11 Downing St", City: "London", PostalCode: "SW1A 2AB", Country: "GB", DefaultShipping: true, DefaultBilling: true})
+	if err != nil { t.Fatalf("update: %v", err) }
+	if upd.Line1 != "11 Downing St" || !upd.CreatedAt.Equal(home.CreatedAt) { t.Fatalf("unexpected update: %+v", upd) }
+	list, _ := svc.ListAddresses(ctx, &dto.ListAddressesRequest{UserID: 1})
+	if len(list) != 2 || list[1].DefaultShipping { t.Fatalf("expected default to move back, got %+v", list[1]) }
+}
+
+func TestAddresses_OrderSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	users := NewUserService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	// a user without an address book cannot check out
+	u, _ := store.CreateUser(&models.User{Email: "new@email.com", Name: "New"})
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: u.ID, ProductID: 1, Quantity: 1}); err != nil { t.Fatalf("add: %v", err) }
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: u.ID}); err == nil { t.Fatalf("expected shipping address required") }
+	// nor use someone else's address
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: u.ID, ShippingAddressID: 1}); err == nil { t.Fatalf("expected address not found") }
+
+	a, err := users.CreateAddress(ctx, &dto.AddressRequest{UserID: u.ID, Name: "New", Line1: "5 Quay St", City: "Galway", PostalCode: "H91 X2Y3", Country: "IE"})
+	if err != nil { t.Fatalf("address: %v", err) }
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: u.ID})
+	if err != nil { t.Fatalf("place: %v", err) }
+	if order.ShippingAddress == nil || order.ShippingAddress.ID != a.ID || order.BillingAddress.ID != a.ID { t.Fatalf("expected default addresses on order, got %+v", order) }
+
+	// editing or deleting the address leaves the order's copy alone
+	if _, err := users.UpdateAddress(ctx, &dto.AddressRequest{UserID: u.ID, ID: a.ID, Name: "New", Line1: "6 Quay St", City: "Galway", PostalCode: "H91X2Y3", Country: "IE"}); err != nil { t.Fatalf("update: %v", err) }
+	if err := users.DeleteAddress(ctx, &dto.DeleteAddressRequest{UserID: u.ID, ID: a.ID}); err != nil { t.Fatalf("delete: %v", err) }
+	orders, _ := store.GetOrdersByUser(u.ID)
+	if orders[0].ShippingAddress.Line1 != "5 Quay St" { t.Fatalf("order address changed: %+v", orders[0].ShippingAddress) }
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// Admin feed topics.
+const (
+	TopicOrders         = "orders"
+	TopicInventory      = "inventory"
+	TopicRuleRejections = "rule_rejections"
+)
+
+var adminTopics = []string{TopicOrders, TopicInventory, TopicRuleRejections}
+
+// AdminClientBuffer is how many messages a dashboard subscriber may fall
+// behind before it is dropped.
+const AdminClientBuffer = 256
+
+// AdminEnvelope is one message of the admin feed. Type is the domain event
+// type (or "RuleRejected"); EventID is set for domain events.
+type AdminEnvelope struct {
+	Topic   string    `json:"topic"`
+	Type    string    `json:"type"`
+	EventID uint64    `json:"eventId,omitempty"`
+	At      time.Time `json:"at"`
+	Data    any       `json:"data"`
+}
+
+// AdminFeed fans order activity, product and stock changes, and business
+// rule rejections out to dashboard subscribers by topic. Like LiveHub it
+// never blocks its sources: a subscriber whose buffer is full is dropped.
+type AdminFeed struct {
+	mu        sync.Mutex
+	subs      map[*AdminSubscription]struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewAdminFeed() *AdminFeed { return &AdminFeed{subs: make(map[*AdminSubscription]struct{}), done: make(chan struct{})} }
+
+// Close tells every socket to close; hijacked WebSocket connections are not
+// tracked by http.Server.Shutdown.
+func (f *AdminFeed) Close() { f.closeOnce.Do(func() { close(f.done) }) }
+
+// Done is closed by Close.
+func (f *AdminFeed) Done() <-chan struct{} { return f.done }
+
+// AdminSubscription starts with no topics.
+type AdminSubscription struct {
+	topics  map[string]bool
+	events  chan AdminEnvelope
+	dropped chan struct{}
+}
+
+func (s *AdminSubscription) Events() <-chan AdminEnvelope { return s.events }
+
+// Dropped is closed when the feed drops the subscription for falling behind.
+func (s *AdminSubscription) Dropped() <-chan struct{} { return s.dropped }
+
+func (f *AdminFeed) Subscribe() *AdminSubscription {
+	sub := &AdminSubscription{topics: make(map[string]bool), events: make(chan AdminEnvelope, AdminClientBuffer), dropped: make(chan struct{})}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[sub] = struct{}{}
+	return sub
+}
+
+func (f *AdminFeed) Unsubscribe(sub *AdminSubscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, sub)
+}
+
+// SetTopics turns topics on or off for sub and returns its topics after the
+// change. Unknown topics are rejected without changing anything.
+func (f *AdminFeed) SetTopics(sub *AdminSubscription, topics []string, on bool) ([]string, error) {
+	for _, t := range topics {
+		if !listed(t, adminTopics) { return nil, fmt.Errorf("unknown topic %q; topics are %s", t, strings.Join(adminTopics, ", ")) }
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range topics {
+		if on { sub.topics[t] = true } else { delete(sub.topics, t) }
+	}
+	res := make([]string, 0, len(sub.topics))
+	for _, t := range adminTopics {
+		if sub.topics[t] { res = append(res, t) }
+	}
+	return res, nil
+}
+
+func (f *AdminFeed) publish(env AdminEnvelope) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		if !sub.topics[env.Topic] { continue }
+		select {
+		case sub.events <- env:
+		default:
+			delete(f.subs, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+// Attach feeds the orders and inventory topics from bus.
+func (f *AdminFeed) Attach(bus *EventBus) {
+	bus.Subscribe("admin-feed", f.handle, models.EventOrderPlaced, models.EventOrderStatusChanged, models.EventProductCreated, models.EventProductUpdated, models.EventProductDeleted, models.EventPriceChanged, models.EventStockChanged)
+}
+
+func (f *AdminFeed) handle(ctx context.Context, ev models.DomainEvent) error {
+	_ = ctx
+	topic := TopicInventory
+	if ev.Aggregate == models.AggregateOrder { topic = TopicOrders }
+	f.publish(AdminEnvelope{Topic: topic, Type: ev.Type, EventID: ev.ID, At: ev.OccurredAt, Data: ev.Data})
+	return nil
+}
+
+// RuleRejected publishes a rejection on the rule_rejections topic; register
+// it with CartService.OnRuleRejected and OrderService.OnRuleRejected.
+func (f *AdminFeed) RuleRejected(ctx context.Context, r models.RuleRejection) {
+	_ = ctx
+	f.publish(AdminEnvelope{Topic: TopicRuleRejections, Type: "RuleRejected", At: r.At, Data: r})
+}
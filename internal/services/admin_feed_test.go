@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestAdminFeed_TopicsAndRejections(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	for bus.Dispatch(ctx) > 0 {}
+	feed := NewAdminFeed()
+	feed.Attach(bus)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	cartSvc.OnRuleRejected(feed.RuleRejected)
+	orderSvc.OnRuleRejected(feed.RuleRejected)
+	orders, inventory := feed.Subscribe(), feed.Subscribe()
+	feed.SetTopics(orders, []string{TopicOrders, TopicRuleRejections}, true)
+	if _, err := feed.SetTopics(inventory, []string{TopicInventory, "gossip"}, true); err == nil { t.Fatalf("expected unknown topic rejected") }
+	feed.SetTopics(inventory, []string{TopicInventory}, true)
+
+	// the second checkout comes within the duplicate order window
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1})
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1}); err != nil { t.Fatalf("place: %v", err) }
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 2, Quantity: 1})
+	_, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if _, ok := err.(*RuleError); !ok || err.Error() != "duplicate order detected" { t.Fatalf("expected a rule error, got %v", err) }
+	// not a rule: the product does not exist
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 999, Quantity: 1})
+	bus.Dispatch(ctx)
+
+	var got []AdminEnvelope
+	for len(orders.Events()) > 0 { got = append(got, <-orders.Events()) }
+	if len(got) != 2 || got[0].Topic != TopicRuleRejections || got[1].Type != models.EventOrderPlaced { t.Fatalf("unexpected orders feed %+v", got) }
+	if r := got[0].Data.(models.RuleRejection); r.Operation != models.OperationPlaceOrder || r.UserID != 1 { t.Fatalf("unexpected rejection %+v", r) }
+	var placed models.Order
+	json.Unmarshal(got[1].Data.(json.RawMessage), &placed)
+	if placed.UserID != 1 || placed.Status == "" { t.Fatalf("unexpected order %s", got[1].Data) }
+	n := 0
+	for len(inventory.Events()) > 0 {
+		if env := <-inventory.Events(); env.Topic != TopicInventory { t.Fatalf("unexpected topic %s", env.Topic) }
+		n++
+	}
+	if n == 0 { t.Fatalf("expected stock movements on the inventory topic") }
+
+	// a subscriber that stops reading is dropped instead of blocking
+	for i := 0; i <= AdminClientBuffer; i++ { feed.RuleRejected(ctx, models.RuleRejection{Rule: "test"}) }
+	select {
+	case <-orders.Dropped():
+	default:
+		t.Fatalf("expected the full subscriber dropped")
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// AlertNotifier delivers low-stock alerts to whoever restocks the shelves.
+type AlertNotifier interface {
+	NotifyLowStock(ctx context.Context, a models.StockAlert) error
+}
+
+// LogAlertNotifier writes alerts to the default slog logger.
+type LogAlertNotifier struct{}
+
+func (LogAlertNotifier) NotifyLowStock(ctx context.Context, a models.StockAlert) error {
+	slog.WarnContext(ctx, "low stock", "product_id", a.ProductID, "title", a.Title, "stock", a.Stock, "reorder_point", a.ReorderPoint, "reorder_quantity", a.ReorderQuantity, "supplier", a.Supplier)
+	return nil
+}
+
+// WebhookAlertNotifier POSTs each alert as JSON to URL.
+type WebhookAlertNotifier struct {
+	URL    string
+	Client *http.Client // nil means a client with a 5s timeout
+}
+
+func (n *WebhookAlertNotifier) NotifyLowStock(ctx context.Context, a models.StockAlert) error {
+	body, err := json.Marshal(a)
+	if err != nil { return err }
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil { return err }
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil { client = &http.Client{Timeout: 5 * time.Second} }
+	resp, err := client.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 { return fmt.Errorf("alert webhook returned %s", resp.Status) }
+	return nil
+}
+
+// FileAlertNotifier appends each alert as a JSON line to Path.
+type FileAlertNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileAlertNotifier) NotifyLowStock(ctx context.Context, a models.StockAlert) error {
+	_ = ctx
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil { return err }
+	defer f.Close()
+	return json.NewEncoder(f).Encode(a)
+}
@@ -3,55 +3,148 @@ package services
 import (
 	"context"
 	"errors"
+	"time"
 
 	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
 	"ecom-book-store-sample-api/internal/storage"
 )
 
-type CartService struct { store *storage.MemoryStore }
+type CartService struct {
+	store    *storage.MemoryStore
+	pricer   *Pricer
+	rejected rejectionListeners
+}
+
+func NewCartService(store *storage.MemoryStore) *CartService { return &CartService{store: store, pricer: DefaultPricer()} }
 
-func NewCartService(store *storage.MemoryStore) *CartService { return &CartService{store: store} }
+// SetPricer replaces the tax and shipping calculators used for cart totals.
+func (s *CartService) SetPricer(p *Pricer) { s.pricer = p }
 
+// OnRuleRejected registers a listener for add-to-cart requests refused by a
+// business rule.
+func (s *CartService) OnRuleRejected(fn func(ctx context.Context, r models.RuleRejection)) { s.rejected = append(s.rejected, fn) }
+
+// AddToCart checks the cart rules and adds the item. Rule rejections are
+// reported to OnRuleRejected listeners.
 func (s *CartService) AddToCart(ctx context.Context, req *dto.AddToCartRequest) (*dto.Cart, error) {
-	_ = ctx
+	cart, err := s.addToCart(ctx, req)
+	s.rejected.notify(ctx, err, models.RuleRejection{Operation: models.OperationAddToCart, UserID: req.UserID, ProductID: req.ProductID, Quantity: req.Quantity})
+	return cart, err
+}
+
+func (s *CartService) addToCart(ctx context.Context, req *dto.AddToCartRequest) (*dto.Cart, error) {
 	// Pre-validate against business rules
 	p, err := s.store.GetProductByID(req.ProductID)
 	if err != nil { return nil, err }
-	if p.Discontinued { return nil, errors.New("product unavailable") }
+	if p.Discontinued { return nil, ruleViolation("product_unavailable", "product unavailable") }
 	cart, err := s.store.GetCartByUser(req.UserID)
 	if err != nil { return nil, err }
 	// distinct items limit
 	found := false
 	for _, it := range cart.Items { if it.ProductID == req.ProductID { found = true; break } }
-	if !found && len(cart.Items) >= MaxDistinctCartItems { return nil, errors.New("cart has too many distinct items") }
+	if !found && len(cart.Items) >= MaxDistinctCartItems { return nil, ruleViolation("cart_max_distinct_items", "cart has too many distinct items") }
 	// per-line max and stock checks
 	currentQty := 0
 	for _, it := range cart.Items { if it.ProductID == req.ProductID { currentQty = it.Quantity; break } }
-	if currentQty+req.Quantity > MaxQuantityPerLineItem { return nil, errors.New("quantity exceeds per-item limit") }
-	if p.Stock < currentQty+req.Quantity { return nil, errors.New("insufficient stock for requested quantity") }
-	if p.Stock < 3 && currentQty+req.Quantity > 1 { return nil, errors.New("low-stock item limited to 1 per order") }
+	if currentQty+req.Quantity > MaxQuantityPerLineItem { return nil, ruleViolation("line_max_quantity", "quantity exceeds per-item limit") }
+	available, err := s.store.AvailableToUser(req.ProductID, req.UserID)
+	if err != nil { return nil, err }
+	backorderable, err := s.store.BackorderCapacity(req.ProductID)
+	if err != nil { return nil, err }
+	if available+backorderable < currentQty+req.Quantity { return nil, ruleViolation("insufficient_stock", "insufficient stock for requested quantity") }
+	// backorder/pre-order titles are expected to run at zero stock
+	if p.Stock < 3 && currentQty+req.Quantity > 1 && !p.AcceptsBackorders(time.Now()) { return nil, ruleViolation("low_stock_limit", "low-stock item limited to 1 per order") }
 	// total items cap
 	sumQty := 0
 	for _, it := range cart.Items { sumQty += it.Quantity }
-	if sumQty+req.Quantity > MaxTotalItemsInCart { return nil, errors.New("cart has too many items") }
-	// risk cap (compute total using UnitPrice when present, else current price)
-	total := 0.0
-	for _, it := range cart.Items {
-		price := it.UnitPrice
-		if price == 0 { price = p.Price }
-		total += float64(it.Quantity) * price
+	if sumQty+req.Quantity > MaxTotalItemsInCart { return nil, ruleViolation("cart_max_items", "cart has too many items") }
+	// risk cap, evaluated after discounts on the cart as it would be
+	next := &models.Cart{UserID: req.UserID, Items: append([]models.CartItem(nil), cart.Items...), Coupons: cart.Coupons}
+	if found {
+		for i := range next.Items {
+			if next.Items[i].ProductID == req.ProductID { next.Items[i].Quantity += req.Quantity; next.Items[i].UnitPrice = p.Price }
+		}
+	} else {
+		next.Items = append(next.Items, models.CartItem{ProductID: req.ProductID, Quantity: req.Quantity, UnitPrice: p.Price})
 	}
-	total += float64(req.Quantity) * p.Price
-	if total > CartRiskLimitTotal { return nil, errors.New("cart total exceeds limit") }
-	return s.store.AddToCart(req.UserID, req.ProductID, req.Quantity)
+	if err := priceCart(s.store, next, time.Now()); err != nil { return nil, err }
+	if next.Total > CartRiskLimitTotal { return nil, ruleViolation("cart_risk_limit", "cart total exceeds limit") }
+	cart, err = s.store.AddToCart(ctx, req.UserID, req.ProductID, req.Quantity)
+	if err != nil { return nil, err }
+	return s.decorate(cart, Destination{})
 }
 
 func (s *CartService) RemoveFromCart(ctx context.Context, req *dto.RemoveFromCartRequest) (*dto.Cart, error) {
-	_ = ctx
-	return s.store.RemoveFromCart(req.UserID, req.ProductID)
+	cart, err := s.store.RemoveFromCart(ctx, req.UserID, req.ProductID)
+	if err != nil { return nil, err }
+	return s.decorate(cart, Destination{})
 }
 
 func (s *CartService) GetCart(ctx context.Context, req *dto.GetCartRequest) (*dto.Cart, error) {
 	_ = ctx
-	return s.store.GetCartByUser(req.UserID)
+	cart, err := s.store.GetCartByUser(req.UserID)
+	if err != nil { return nil, err }
+	return s.decorate(cart, Destination{Country: req.Country, Region: req.Region})
+}
+
+// decorate fills in each line's backordered units and expected ship date
+// from current availability, and the cart's full price breakdown for dest,
+// or for the user's default shipping address when dest is empty.
+func (s *CartService) decorate(cart *dto.Cart, dest Destination) (*dto.Cart, error) {
+	now := time.Now()
+	if dest.Country == "" {
+		if a, _ := s.store.GetDefaultAddresses(cart.UserID); a != nil { dest = Destination{Country: a.Country, Region: a.Region} }
+	}
+	for i := range cart.Items {
+		it := &cart.Items[i]
+		p, err := s.store.GetProductByID(it.ProductID)
+		if err != nil { return nil, err }
+		available, err := s.store.AvailableToUser(it.ProductID, cart.UserID)
+		if err != nil { return nil, err }
+		it.Backordered = 0
+		if it.Quantity > available { it.Backordered = it.Quantity - max(available, 0) }
+		it.ExpectedShipDate = expectedShipDate(p, it.Backordered > 0, now)
+	}
+	if err := s.pricer.Price(s.store, cart, dest, now); err != nil { return nil, err }
+	return cart, nil
+}
+
+// ApplyCoupon adds a coupon code to the cart. The code must belong to a live
+// promotion with uses left that applies to something in the cart.
+func (s *CartService) ApplyCoupon(ctx context.Context, req *dto.CouponRequest) (*dto.Cart, error) {
+	_ = ctx
+	code := normalizeCoupon(req.Code)
+	promo, err := s.store.GetPromotionByCode(code)
+	if err != nil { return nil, errors.New("invalid coupon code") }
+	if !promotionUsable(s.store, promo, req.UserID, time.Now()) { return nil, errors.New("coupon is expired or fully used") }
+	cart, err := s.store.GetCartByUser(req.UserID)
+	if err != nil { return nil, err }
+	lines, err := cartLines(s.store, cart)
+	if err != nil { return nil, err }
+	if _, ok := discountFor(promo, lines); !ok { return nil, errors.New("coupon does not apply to this cart") }
+	cart, err = s.store.ApplyCoupon(req.UserID, code)
+	if err != nil { return nil, err }
+	return s.decorate(cart, Destination{})
+}
+
+func (s *CartService) RemoveCoupon(ctx context.Context, req *dto.CouponRequest) (*dto.Cart, error) {
+	_ = ctx
+	cart, err := s.store.RemoveCoupon(req.UserID, normalizeCoupon(req.Code))
+	if err != nil { return nil, err }
+	return s.decorate(cart, Destination{})
+}
+
+// RunHoldSweeper expires stale cart holds every interval until ctx is done.
+func (s *CartService) RunHoldSweeper(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.store.ExpireHolds(ctx, now)
+		}
+	}
 }
@@ -2,9 +2,12 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
 	"ecom-book-store-sample-api/internal/storage"
 )
 
@@ -100,3 +103,65 @@ func TestCartService_Rules(t *testing.T) {
 		t.Fatalf("expected product unavailable error")
 	}
 }
+
+func TestCartService_HoldChangesInvalidateCachedViews(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	store.EnableStockHolds(time.Minute)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx) // drain the seed's events
+	var changes []models.HoldChange
+	bus.Subscribe("test", func(ctx context.Context, ev models.DomainEvent) error {
+		var c models.HoldChange
+		json.Unmarshal(ev.Data, &c)
+		changes = append(changes, c)
+		return nil
+	}, models.EventHoldsChanged)
+	prodSvc := NewProductService(store)
+	svc := NewCartService(store)
+
+	before, _ := prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: 1})
+	catalog, _ := prodSvc.CatalogVersion(ctx)
+	svc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	held, _ := prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: 1})
+	if held.Held != before.Held+2 || held.Version != before.Version || !held.UpdatedAt.After(before.UpdatedAt) { t.Fatalf("expected a hold to touch the product but keep its version: %+v -> %+v", before, held) }
+	if v, _ := prodSvc.CatalogVersion(ctx); v <= catalog { t.Fatalf("expected a hold to touch the catalog") }
+
+	// a lapsed hold is reported by the sweep
+	store.ExpireHolds(ctx, time.Now().Add(2 * time.Minute))
+	swept, _ := prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: 1})
+	if swept.Held != before.Held || swept.Version != held.Version || !swept.UpdatedAt.After(held.UpdatedAt) { t.Fatalf("expected the sweep to touch the product but keep its version: %+v -> %+v", held, swept) }
+	bus.Dispatch(ctx)
+	if len(changes) != 2 || changes[0].Held != held.Held || changes[1].Held != swept.Held || changes[1].Available != swept.Available || changes[1].Version != swept.Version { t.Fatalf("unexpected HoldsChanged events: %+v", changes) }
+}
+
+func TestCartService_StockHolds(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	store.EnableStockHolds(time.Minute)
+	prodSvc := NewProductService(store)
+	svc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	p, err := prodSvc.CreateProduct(ctx, &dto.CreateProductRequest{Title: "Last copies", Author: "A", Price: 10, Stock: 4})
+	if err != nil { t.Fatalf("create: %v", err) }
+	if _, err := svc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: p.ID, Quantity: 3}); err != nil { t.Fatalf("hold 3: %v", err) }
+	got, _ := prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: p.ID})
+	if got.Stock != 4 || got.Held != 3 || got.Available != 1 { t.Fatalf("unexpected counts: %+v", got) }
+
+	// user 2 cannot take the held copies, and user 1 may top up to on-hand stock
+	if _, err := svc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: p.ID, Quantity: 2}); err == nil { t.Fatalf("expected held stock to be unavailable") }
+	if _, err := svc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: p.ID, Quantity: 1}); err != nil { t.Fatalf("add last available: %v", err) }
+
+	// checkout converts user 1's hold into a sale
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1}); err != nil { t.Fatalf("place: %v", err) }
+	got, _ = prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: p.ID})
+	if got.Stock != 1 || got.Held != 1 || got.Available != 0 { t.Fatalf("unexpected counts after checkout: %+v", got) }
+
+	// sweeping expired holds frees stock for others
+	if n := store.ExpireHolds(ctx, time.Now().Add(2 * time.Minute)); n != 1 { t.Fatalf("expected 1 expired hold, got %d", n) }
+	got, _ = prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: p.ID})
+	if got.Held != 0 || got.Available != 1 { t.Fatalf("unexpected counts after sweep: %+v", got) }
+}
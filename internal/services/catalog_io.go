@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// catalogColumns is the CSV layout used for both import and export. Import
+// accepts any subset in any order as long as the header names them.
+var catalogColumns = []string{"id", "isbn", "title", "author", "description", "category", "price", "stock", "discontinued", "isSpecial", "allowBackorder", "maxBackorder", "backorderLeadDays", "releaseDate", "reorderPoint", "reorderQuantity", "supplier"}
+
+// Read-only members that exported NDJSON carries; they are ignored on import
+// so an export can be fed straight back in.
+var readOnlyProductMembers = []string{"held", "available", "backordered", "version", "createdAt", "updatedAt"}
+
+const maxNDJSONLine = 1 << 20
+
+// rowFunc receives one parsed input row keyed by product JSON member name,
+// or the error that prevented parsing it.
+type rowFunc func(line int, members map[string]json.RawMessage, err error)
+
+// ImportProducts upserts products streamed from req.Body. Each row is matched
+// by id, then by isbn; unmatched rows are created. Rows that fail parsing or
+// validation are reported and skipped, the rest are applied. In dry-run mode
+// nothing is written but the report is computed the same way.
+func (s *ProductService) ImportProducts(ctx context.Context, req *dto.ImportProductsRequest) (*dto.ImportReport, error) {
+	report := &dto.ImportReport{DryRun: req.DryRun, Errors: []dto.ImportRowError{}}
+	staged := map[string]*dto.UpdateProductRequest{} // dry-run creates, by isbn
+	onRow := func(line int, members map[string]json.RawMessage, err error) {
+		report.Rows++
+		var id uint
+		var isbn string
+		if err == nil { id, isbn, err = importKeys(members) }
+		created := false
+		if err == nil { created, err = s.importRow(ctx, id, isbn, members, req.DryRun, req.Actor, staged) }
+		switch {
+		case err != nil:
+			report.Failed++
+			report.Errors = append(report.Errors, dto.ImportRowError{Line: line, ID: id, ISBN: isbn, Error: err.Error()})
+		case created:
+			report.Created++
+		default:
+			report.Updated++
+		}
+	}
+	var err error
+	switch req.Format {
+	case dto.CatalogFormatCSV: err = readCSVRows(req.Body, onRow)
+	case dto.CatalogFormatNDJSON: err = readNDJSONRows(req.Body, onRow)
+	default: return nil, errors.New("unsupported format")
+	}
+	if err != nil { return report, err }
+	return report, nil
+}
+
+// importKeys pulls the matching keys out of a row and drops members that
+// must not be merged.
+func importKeys(members map[string]json.RawMessage) (id uint, isbn string, err error) {
+	if raw, ok := members["id"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &id); err != nil { return 0, "", errors.New("invalid id") }
+	}
+	if raw, ok := members["isbn"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &isbn); err != nil { return id, "", errors.New("invalid isbn") }
+		if isbn, err = normalizeISBN(isbn); err != nil { return id, isbn, err }
+	}
+	delete(members, "id")
+	for _, name := range readOnlyProductMembers { delete(members, name) }
+	return id, isbn, nil
+}
+
+func (s *ProductService) importRow(ctx context.Context, id uint, isbn string, members map[string]json.RawMessage, dryRun bool, actor string, staged map[string]*dto.UpdateProductRequest) (created bool, err error) {
+	upd := &dto.UpdateProductRequest{}
+	var existing *dto.Product
+	switch {
+	case id != 0:
+		if existing, err = s.store.GetProductByID(id); err != nil { return false, err }
+	case isbn != "":
+		if existing, err = s.store.GetProductByISBN(isbn); err != nil && !errors.Is(err, storage.ErrProductNotFound) { return false, err }
+	}
+	if existing != nil {
+		upd = &dto.UpdateProductRequest{ID: existing.ID, ISBN: existing.ISBN, Title: existing.Title, Author: existing.Author, Description: existing.Description, Category: existing.Category, Price: existing.Price, Stock: existing.Stock, Discontinued: existing.Discontinued, IsSpecial: existing.IsSpecial, AllowBackorder: existing.AllowBackorder, MaxBackorder: existing.MaxBackorder, BackorderLeadDays: existing.BackorderLeadDays, ReleaseDate: existing.ReleaseDate, ReorderPoint: existing.ReorderPoint, ReorderQuantity: existing.ReorderQuantity, Supplier: existing.Supplier, Version: existing.Version, Actor: actor}
+	} else if prev, ok := staged[isbn]; ok && isbn != "" {
+		upd = prev
+	}
+	if err := mergeProductMembers(upd, members); err != nil { return false, err }
+	created = existing == nil && staged[isbn] == nil
+	if dryRun {
+		if err := validateProductInput(upd.Title, upd.Author, upd.Description, upd.Price, upd.Stock); err != nil { return created, err }
+		if err := validateBackorderSettings(upd.AllowBackorder, upd.MaxBackorder, upd.BackorderLeadDays, upd.ReleaseDate); err != nil { return created, err }
+		if err := validateReorderSettings(upd.ReorderPoint, upd.ReorderQuantity, upd.Supplier); err != nil { return created, err }
+		if len(strings.TrimSpace(upd.Category)) > 100 { return created, errors.New("category too long") }
+		norm, err := normalizeISBN(upd.ISBN)
+		if err != nil { return created, err }
+		if owner, err := s.store.GetProductByISBN(norm); err == nil && (existing == nil || owner.ID != existing.ID) { return created, storage.ErrDuplicateISBN }
+		if created && norm != "" { staged[norm] = upd }
+		return created, nil
+	}
+	if existing != nil {
+		_, err = s.UpdateProduct(ctx, upd)
+		return false, err
+	}
+	_, err = s.CreateProduct(ctx, &dto.CreateProductRequest{ISBN: upd.ISBN, Title: upd.Title, Author: upd.Author, Description: upd.Description, Category: upd.Category, Price: upd.Price, Stock: upd.Stock, Discontinued: upd.Discontinued, IsSpecial: upd.IsSpecial, AllowBackorder: upd.AllowBackorder, MaxBackorder: upd.MaxBackorder, BackorderLeadDays: upd.BackorderLeadDays, ReleaseDate: upd.ReleaseDate, ReorderPoint: upd.ReorderPoint, ReorderQuantity: upd.ReorderQuantity, Supplier: upd.Supplier, Actor: actor})
+	return true, err
+}
+
+func readCSVRows(r io.Reader, onRow rowFunc) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil { return errors.New("csv header row is required") }
+	cols := append([]string(nil), header...)
+	if len(cols) > 0 { cols[0] = strings.TrimPrefix(cols[0], "\ufeff") }
+	for _, name := range cols {
+		if !isCatalogColumn(name) { return fmt.Errorf("unknown column %q", name) }
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF { return nil }
+		var pe *csv.ParseError
+		if errors.As(err, &pe) { onRow(pe.Line, nil, pe.Err); continue }
+		if err != nil { return err }
+		line, _ := cr.FieldPos(0)
+		members := make(map[string]json.RawMessage, len(rec))
+		for i, cell := range rec {
+			if cell == "" { continue }
+			raw, err := csvCellJSON(cols[i], cell)
+			if err != nil { members = nil; onRow(line, nil, err); break }
+			members[cols[i]] = raw
+		}
+		if members != nil { onRow(line, members, nil) }
+	}
+}
+
+// csvCellJSON converts a CSV cell into the JSON member mergeProductMembers
+// expects for that column.
+func csvCellJSON(col, cell string) (json.RawMessage, error) {
+	switch col {
+	case "isbn", "title", "author", "description", "category", "supplier":
+		return json.Marshal(cell)
+	case "releaseDate":
+		t, err := time.Parse(time.RFC3339, cell)
+		if err != nil { return nil, fmt.Errorf("invalid value for %q", col) }
+		return json.Marshal(t)
+	case "discontinued", "isSpecial", "allowBackorder":
+		b, err := strconv.ParseBool(cell)
+		if err != nil { return nil, fmt.Errorf("invalid value for %q", col) }
+		return json.Marshal(b)
+	default:
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil { return nil, fmt.Errorf("invalid value for %q", col) }
+		return json.Marshal(f)
+	}
+}
+
+func isCatalogColumn(name string) bool {
+	for _, c := range catalogColumns {
+		if c == name { return true }
+	}
+	return false
+}
+
+func readNDJSONRows(r io.Reader, onRow rowFunc) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 { continue }
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(text, &members); err != nil || members == nil { onRow(line, nil, errors.New("row must be a JSON object")); continue }
+		onRow(line, members, nil)
+	}
+	return sc.Err()
+}
+
+// ExportProducts writes the whole catalog to w in the requested format,
+// one product per row, in ID order.
+func (s *ProductService) ExportProducts(ctx context.Context, req *dto.ExportProductsRequest, w io.Writer) error {
+	_ = ctx
+	items, err := s.store.GetAllProducts()
+	if err != nil { return err }
+	switch req.Format {
+	case dto.CatalogFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(catalogColumns); err != nil { return err }
+		for _, p := range items {
+			release := ""
+			if p.ReleaseDate != nil { release = p.ReleaseDate.Format(time.RFC3339) }
+			rec := []string{strconv.FormatUint(uint64(p.ID), 10), p.ISBN, p.Title, p.Author, p.Description, p.Category, strconv.FormatFloat(p.Price, 'f', -1, 64), strconv.Itoa(p.Stock), strconv.FormatBool(p.Discontinued), strconv.FormatBool(p.IsSpecial), strconv.FormatBool(p.AllowBackorder), strconv.Itoa(p.MaxBackorder), strconv.Itoa(p.BackorderLeadDays), release, strconv.Itoa(p.ReorderPoint), strconv.Itoa(p.ReorderQuantity), p.Supplier}
+			if err := cw.Write(rec); err != nil { return err }
+		}
+		cw.Flush()
+		return cw.Error()
+	case dto.CatalogFormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, p := range items {
+			if err := enc.Encode(p); err != nil { return err }
+		}
+		return nil
+	default:
+		return errors.New("unsupported format")
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestProductService_ImportCSV(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	svc := NewProductService(store)
+
+	csvBody := "isbn,title,author,price,stock\n" +
+		"978-0-13-235088-4,Clean Code 2e,Robert C. Martin,44.5,12\n" +
+		"9780201633610,Design Patterns,Erich Gamma,59.99,7\n" +
+		",,Nobody,1,1\n" +
+		"9780135957059,Pragmatic,Hunt,abc,1\n" +
+		"9780132350884,,,,3\n"
+
+	// dry run reports but writes nothing; the last row updates the staged first row
+	report, err := svc.ImportProducts(ctx, &dto.ImportProductsRequest{Format: dto.CatalogFormatCSV, Body: strings.NewReader(csvBody), DryRun: true})
+	if err != nil { t.Fatalf("dry run: %v", err) }
+	if report.Created != 2 || report.Updated != 1 || report.Failed != 2 { t.Fatalf("unexpected dry-run report: %+v", report) }
+	if report.Errors[0].Line != 4 || report.Errors[1].Line != 5 { t.Fatalf("unexpected error lines: %+v", report.Errors) }
+	if items, _ := store.GetAllProducts(); len(items) != 10 { t.Fatalf("dry run must not write, got %d products", len(items)) }
+
+	report, err = svc.ImportProducts(ctx, &dto.ImportProductsRequest{Format: dto.CatalogFormatCSV, Body: strings.NewReader(csvBody)})
+	if err != nil { t.Fatalf("import: %v", err) }
+	if report.Created != 2 || report.Updated != 1 || report.Failed != 2 { t.Fatalf("unexpected report: %+v", report) }
+	p, err := store.GetProductByISBN("9780132350884")
+	if err != nil { t.Fatalf("lookup by isbn: %v", err) }
+	if p.Title != "Clean Code 2e" || p.Stock != 3 || p.Price != 44.5 { t.Fatalf("upsert by isbn did not merge: %+v", p) }
+
+	// unknown columns are rejected up front
+	if _, err := svc.ImportProducts(ctx, &dto.ImportProductsRequest{Format: dto.CatalogFormatCSV, Body: strings.NewReader("sku,title\n1,x\n")}); err == nil {
+		t.Fatalf("expected unknown column error")
+	}
+}
+
+func TestProductService_ImportNDJSONAndExportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	svc := NewProductService(store)
+
+	body := `{"id":1,"stock":99}` + "\n\n" + `not json` + "\n" + `{"id":999,"title":"x"}` + "\n"
+	report, err := svc.ImportProducts(ctx, &dto.ImportProductsRequest{Format: dto.CatalogFormatNDJSON, Body: strings.NewReader(body)})
+	if err != nil { t.Fatalf("import: %v", err) }
+	if report.Updated != 1 || report.Failed != 2 || report.Errors[0].Line != 3 { t.Fatalf("unexpected report: %+v", report) }
+	if p, _ := store.GetProductByID(1); p.Stock != 99 || p.Title != "The Pragmatic Programmer" { t.Fatalf("unexpected product 1: %+v", p) }
+
+	for _, format := range []string{dto.CatalogFormatCSV, dto.CatalogFormatNDJSON} {
+		var buf bytes.Buffer
+		if err := svc.ExportProducts(ctx, &dto.ExportProductsRequest{Format: format}, &buf); err != nil { t.Fatalf("export %s: %v", format, err) }
+		report, err := svc.ImportProducts(ctx, &dto.ImportProductsRequest{Format: format, Body: &buf, DryRun: true})
+		if err != nil { t.Fatalf("re-import %s: %v", format, err) }
+		if report.Updated != 10 || report.Created != 0 || report.Failed != 0 { t.Fatalf("%s round trip: %+v", format, report) }
+	}
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+var (
+	// ErrCheckoutInProgress is returned when a checkout is retried with the
+	// idempotency key of one that has not finished.
+	ErrCheckoutInProgress = errors.New("checkout with this idempotency key is in progress")
+	ErrSagaSettled        = errors.New("checkout saga is already settled")
+	// ErrSagaActive refuses to recover a saga that may still be running.
+	ErrSagaActive = errors.New("checkout saga is still making progress")
+)
+
+// Checkout saga steps, in order. The payment is authorized before stock is
+// reserved, so a declined card never holds stock other shoppers could buy.
+const (
+	StepAuthorizePayment = "authorize_payment"
+	StepReserveInventory = "reserve_inventory"
+	StepApplyPromotions  = "apply_promotions"
+	StepCreateOrder      = "create_order"
+	StepClearCart        = "clear_cart"
+	StepEmitEvents       = "emit_events"
+)
+
+// checkoutStep pairs a step with the action that undoes it. Steps after
+// create_order have no compensation: once the order exists the saga can only
+// go forward.
+type checkoutStep struct {
+	name       string
+	run        func(s *OrderService, ctx context.Context, sg *models.CheckoutSaga) error
+	compensate func(s *OrderService, ctx context.Context, sg *models.CheckoutSaga) error
+}
+
+var checkoutSteps = []checkoutStep{
+	{StepAuthorizePayment, (*OrderService).authorizePayment, (*OrderService).voidPayment},
+	{StepReserveInventory, (*OrderService).reserveInventory, (*OrderService).releaseInventory},
+	{StepApplyPromotions, (*OrderService).applyPromotions, (*OrderService).releasePromotions},
+	{StepCreateOrder, (*OrderService).createOrder, nil},
+	{StepClearCart, (*OrderService).clearCart, nil},
+	{StepEmitEvents, (*OrderService).emitEvents, nil},
+}
+
+func newCheckoutSaga(ctx context.Context, userID uint, key string, draft *models.Order, items []models.CartItem) *models.CheckoutSaga {
+	sg := &models.CheckoutSaga{UserID: userID, IdempotencyKey: key, RequestID: logging.RequestID(ctx), Status: models.SagaRunning, Order: draft, Items: items}
+	for _, st := range checkoutSteps {
+		sg.Steps = append(sg.Steps, models.SagaStep{Name: st.name, Status: models.StepPending})
+	}
+	return sg
+}
+
+// OnOrderPlaced registers a listener called by the emit_events step. A saga
+// resumed after a crash may call listeners again for the same order.
+func (s *OrderService) OnOrderPlaced(fn func(ctx context.Context, o *models.Order)) { s.placed = append(s.placed, fn) }
+
+// runSaga runs the saga's remaining steps, saving its progress after each.
+// A failure before the order exists compensates the finished steps in
+// reverse; a failure after that leaves the saga RUNNING for RecoverSagas and
+// still returns the order.
+func (s *OrderService) runSaga(ctx context.Context, sg *models.CheckoutSaga) (*models.Order, error) {
+	sg.Status = models.SagaRunning
+	for i, step := range checkoutSteps {
+		if sg.Steps[i].Status == models.StepDone { continue }
+		s.markStep(sg, i, models.StepStarted, nil)
+		if s.sagaHook != nil {
+			if err := s.sagaHook(step.name); err != nil { return nil, err }
+		}
+		if err := step.run(s, ctx, sg); err != nil {
+			sg.Error = fmt.Sprintf("%s: %v", step.name, err)
+			s.markStep(sg, i, models.StepFailed, err)
+			if committed(sg) {
+				slog.ErrorContext(ctx, "checkout saga step failed after order was created", "saga_id", sg.ID, "step", step.name, "order_id", sg.OrderID, "error", err)
+				return s.store.GetOrderByID(sg.OrderID)
+			}
+			if cerr := s.compensate(ctx, sg); cerr != nil { slog.ErrorContext(ctx, "checkout saga compensation failed", "saga_id", sg.ID, "error", cerr) }
+			return nil, err
+		}
+		s.markStep(sg, i, models.StepDone, nil)
+	}
+	sg.Status, sg.Error = models.SagaCompleted, ""
+	s.saveSaga(sg)
+	return s.store.GetOrderByID(sg.OrderID)
+}
+
+// compensate undoes every step that started, newest first. A compensation
+// that fails marks the saga FAILED; it can be retried with RecoverSaga.
+func (s *OrderService) compensate(ctx context.Context, sg *models.CheckoutSaga) error {
+	sg.Status = models.SagaCompensating
+	s.saveSaga(sg)
+	for i := len(checkoutSteps) - 1; i >= 0; i-- {
+		st := sg.Steps[i]
+		if st.Status == models.StepPending || st.Status == models.StepCompensated || checkoutSteps[i].compensate == nil { continue }
+		if err := checkoutSteps[i].compensate(s, ctx, sg); err != nil {
+			sg.Status, sg.Error = models.SagaFailed, fmt.Sprintf("compensating %s: %v", st.Name, err)
+			s.markStep(sg, i, st.Status, err)
+			return err
+		}
+		s.markStep(sg, i, models.StepCompensated, nil)
+	}
+	sg.Status = models.SagaCompensated
+	s.saveSaga(sg)
+	return nil
+}
+
+// committed reports whether the saga passed its point of no return.
+func committed(sg *models.CheckoutSaga) bool {
+	for _, st := range sg.Steps {
+		if st.Name == StepCreateOrder { return st.Status == models.StepDone }
+	}
+	return false
+}
+
+func (s *OrderService) markStep(sg *models.CheckoutSaga, i int, status string, err error) {
+	sg.Steps[i].Status, sg.Steps[i].Error, sg.Steps[i].UpdatedAt = status, "", time.Now()
+	if err != nil { sg.Steps[i].Error = err.Error() }
+	s.saveSaga(sg)
+}
+
+func (s *OrderService) saveSaga(sg *models.CheckoutSaga) {
+	if err := s.store.SaveSaga(sg); err != nil { slog.Error("checkout saga save failed", "saga_id", sg.ID, "request_id", sg.RequestID, "error", err) }
+}
+
+func (s *OrderService) reserveInventory(ctx context.Context, sg *models.CheckoutSaga) error {
+	opts := storage.ReserveOptions{Discounts: sg.Order.Discounts}
+	if a := sg.Order.ShippingAddress; a != nil { opts.DestRegion = a.Region }
+	order, err := s.store.ReserveInventory(ctx, sg.UserID, sg.OrderID, opts)
+	if err != nil { return err }
+	d := sg.Order
+	order.Shipping, order.TaxLines, order.Tax, order.GrandTotal = d.Shipping, d.TaxLines, d.Tax, d.GrandTotal
+	order.ShippingAddress, order.BillingAddress, order.Payment = d.ShippingAddress, d.BillingAddress, d.Payment
+	sg.Order = order
+	return nil
+}
+
+func (s *OrderService) releaseInventory(ctx context.Context, sg *models.CheckoutSaga) error {
+	s.store.ReleaseInventory(ctx, sg.OrderID, storage.ActorSystem)
+	return nil
+}
+
+func (s *OrderService) applyPromotions(ctx context.Context, sg *models.CheckoutSaga) error {
+	_ = ctx
+	return s.store.ClaimPromotions(sg.OrderID, sg.UserID, sg.Order.Discounts)
+}
+
+func (s *OrderService) releasePromotions(ctx context.Context, sg *models.CheckoutSaga) error {
+	_ = ctx
+	s.store.ReleasePromotions(sg.OrderID)
+	return nil
+}
+
+// sagaPayment is the authorization request of a saga. Its idempotency key is
+// per saga, so compensation can replay it to learn the outcome of an
+// authorization whose reply was lost.
+func sagaPayment(sg *models.CheckoutSaga) PaymentRequest {
+	key := fmt.Sprintf("checkout-saga-%d", sg.ID)
+	return PaymentRequest{Reference: key, UserID: sg.UserID, Amount: sg.Order.GrandTotal, IdempotencyKey: key}
+}
+
+func (s *OrderService) authorizePayment(ctx context.Context, sg *models.CheckoutSaga) error {
+	p, err := s.payments.Authorize(ctx, sagaPayment(sg))
+	if err != nil { return err }
+	sg.Order.Payment = p
+	return nil
+}
+
+// voidPayment voids the saga's authorization. When the authorization's reply
+// was lost it is replayed first; a replay that fails for any reason but a
+// timeout means nothing was authorized.
+func (s *OrderService) voidPayment(ctx context.Context, sg *models.CheckoutSaga) error {
+	req := sagaPayment(sg)
+	p := sg.Order.Payment
+	if p == nil {
+		var err error
+		if p, err = s.payments.Authorize(ctx, req); err != nil {
+			if errors.Is(err, ErrPaymentTimeout) { return err }
+			return nil
+		}
+	}
+	if p.Status != models.PaymentAuthorized && p.Status != models.PaymentRequiresAction { return nil }
+	v, err := s.payments.Void(ctx, p.AuthorizationID, "void-"+req.IdempotencyKey)
+	if err != nil { return err }
+	sg.Order.Payment = v
+	return nil
+}
+
+func (s *OrderService) createOrder(ctx context.Context, sg *models.CheckoutSaga) error {
+	order := sg.Order
+	order.Status = placedStatus(order)
+	if order.Payment != nil && order.Payment.Status == models.PaymentRequiresAction { order.Status = models.OrderPendingPayment }
+	now := time.Now()
+	for i := range order.Items {
+		it := &order.Items[i]
+		p, err := s.store.GetProductByID(it.ProductID)
+		if err != nil { return err }
+		it.ExpectedShipDate = expectedShipDate(p, it.Backordered > 0, now)
+	}
+	created, err := s.store.CreateOrder(ctx, order)
+	if err != nil { return err }
+	sg.Order = created
+	return nil
+}
+
+func (s *OrderService) clearCart(ctx context.Context, sg *models.CheckoutSaga) error {
+	s.store.ClearOrderedItems(ctx, sg.UserID, sg.OrderID, sg.Items)
+	return nil
+}
+
+func (s *OrderService) emitEvents(ctx context.Context, sg *models.CheckoutSaga) error {
+	o, err := s.store.GetOrderByID(sg.OrderID)
+	if err != nil { return err }
+	for _, fn := range s.placed { fn(ctx, o) }
+	return nil
+}
+
+// RecoverSagas resumes or compensates sagas left RUNNING or COMPENSATING for
+// longer than staleAfter, such as those interrupted by a crash, and returns
+// how many it handled. A saga whose order was created is finished; any other
+// is compensated.
+func (s *OrderService) RecoverSagas(ctx context.Context, staleAfter time.Duration) int {
+	sagas, _ := s.store.GetSagas()
+	cutoff := time.Now().Add(-staleAfter)
+	n := 0
+	for _, sg := range sagas {
+		if (sg.Status != models.SagaRunning && sg.Status != models.SagaCompensating) || sg.UpdatedAt.After(cutoff) { continue }
+		// log under the request that started the saga
+		rctx := logging.WithRequestID(ctx, sg.RequestID)
+		if err := s.leaseAndRecover(rctx, sg); err != nil {
+			if errors.Is(err, storage.ErrSagaLeased) { continue } // another caller is on it
+			slog.ErrorContext(rctx, "checkout saga recovery failed", "saga_id", sg.ID, "error", err)
+		}
+		n++
+	}
+	return n
+}
+
+// leaseAndRecover leases sg, as read by the caller, and recovers it, so no
+// two callers ever drive the steps of one saga at the same time.
+func (s *OrderService) leaseAndRecover(ctx context.Context, sg *models.CheckoutSaga) error {
+	leased, err := s.store.LeaseSaga(sg.ID, sg.Status, sg.UpdatedAt)
+	if err != nil { return err }
+	defer s.store.ReleaseSaga(sg.ID)
+	return s.recoverSaga(ctx, leased)
+}
+
+// RunSagaRecovery calls RecoverSagas every interval until ctx is done.
+func (s *OrderService) RunSagaRecovery(ctx context.Context, interval, staleAfter time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.RecoverSagas(ctx, staleAfter)
+		}
+	}
+}
+
+func (s *OrderService) recoverSaga(ctx context.Context, sg *models.CheckoutSaga) error {
+	switch sg.Status {
+	case models.SagaRunning:
+		// the order may have been created just before the crash
+		if !committed(sg) {
+			if _, err := s.store.GetOrderByID(sg.OrderID); err == nil {
+				for i := range sg.Steps {
+					if sg.Steps[i].Name == StepCreateOrder { s.markStep(sg, i, models.StepDone, nil) }
+				}
+			}
+		}
+		if committed(sg) {
+			_, err := s.runSaga(ctx, sg)
+			return err
+		}
+		return s.compensate(ctx, sg)
+	case models.SagaCompensating, models.SagaFailed:
+		return s.compensate(ctx, sg)
+	}
+	return ErrSagaSettled
+}
+
+// ListSagas lists checkout sagas. Stuck limits the list to sagas that are
+// FAILED or have not progressed for CheckoutSagaStaleSeconds.
+func (s *OrderService) ListSagas(ctx context.Context, req *dto.ListCheckoutSagasRequest) ([]*dto.CheckoutSaga, error) {
+	_ = ctx
+	sagas, err := s.store.GetSagas()
+	if err != nil || !req.Stuck { return sagas, err }
+	cutoff := time.Now().Add(-CheckoutSagaStaleSeconds * time.Second)
+	stuck := make([]*dto.CheckoutSaga, 0)
+	for _, sg := range sagas {
+		if sg.Status == models.SagaFailed || ((sg.Status == models.SagaRunning || sg.Status == models.SagaCompensating) && sg.UpdatedAt.Before(cutoff)) { stuck = append(stuck, sg) }
+	}
+	return stuck, nil
+}
+
+// RecoverSaga resumes or compensates one saga straight away, including a
+// FAILED one whose compensation should be retried. A RUNNING or COMPENSATING
+// saga that progressed within CheckoutSagaStaleSeconds may still be driven by
+// its request, and a saga its request, the recovery worker or another call
+// is driving right now cannot be leased; both are refused with ErrSagaActive.
+func (s *OrderService) RecoverSaga(ctx context.Context, req *dto.RecoverCheckoutSagaRequest) (*dto.CheckoutSaga, error) {
+	sg, err := s.store.GetSaga(req.ID)
+	if err != nil { return nil, err }
+	if (sg.Status == models.SagaRunning || sg.Status == models.SagaCompensating) && time.Since(sg.UpdatedAt) < s.sagaStaleAfter { return nil, ErrSagaActive }
+	if err := s.leaseAndRecover(ctx, sg); err != nil {
+		if errors.Is(err, storage.ErrSagaLeased) { return nil, ErrSagaActive }
+		return nil, err
+	}
+	return s.store.GetSaga(req.ID)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestCheckoutSaga_CompensatesFailedSteps(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	fake := NewFakePaymentProvider()
+	orderSvc.SetPaymentProvider(fake)
+	promos := NewPromotionService(store)
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "ONCE", Name: "One off", Type: models.PromoFixedAmount, Value: 5, MaxUses: 1}); err != nil { t.Fatalf("create: %v", err) }
+	stock := func() int { p, _ := store.GetProductByID(1); return p.Stock }
+	uses := func() int { p, _ := store.GetPromotionByCode("ONCE"); return p.Uses }
+	placed := 0
+	orderSvc.OnOrderPlaced(func(ctx context.Context, o *models.Order) { placed++ })
+
+	// a declined payment stops the checkout before anything is held
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "ONCE"})
+	fake.SetMode(FakeDecline)
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k1"}); !errors.Is(err, ErrPaymentDeclined) { t.Fatalf("expected decline, got %v", err) }
+	if stock() != 50 || uses() != 0 { t.Fatalf("expected nothing held, stock=%d uses=%d", stock(), uses()) }
+	sg, _ := store.FindSaga(1, "k1")
+	want := []string{models.StepCompensated, models.StepPending, models.StepPending, models.StepPending, models.StepPending, models.StepPending}
+	for i, st := range sg.Steps {
+		if st.Status != want[i] { t.Fatalf("step %s: expected %s, got %s", st.Name, want[i], st.Status) }
+	}
+	if sg.Status != models.SagaCompensated || sg.Error == "" { t.Fatalf("unexpected saga: %+v", sg) }
+	if mvs, _ := store.GetInventoryMovements(1); len(mvs) != 1 { t.Fatalf("expected no stock movement, got %+v", mvs) }
+
+	// the same key may retry a compensated checkout
+	fake.SetMode(FakeApprove)
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k1"})
+	if err != nil { t.Fatalf("retry: %v", err) }
+	if order.DiscountTotal != 5 || stock() != 48 || uses() != 1 || placed != 1 { t.Fatalf("unexpected order %+v stock=%d uses=%d placed=%d", order, stock(), uses(), placed) }
+	if cart, _ := store.GetCartByUser(1); len(cart.Items) != 0 || len(cart.Coupons) != 0 { t.Fatalf("expected cart cleared, got %+v", cart) }
+	again, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k1"})
+	if err != nil || again.ID != order.ID || placed != 1 { t.Fatalf("expected completed checkout replayed, got %+v %v", again, err) }
+
+	// a promotion used up by a concurrent checkout releases the reservation
+	// and voids the authorization
+	last, _ := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "LAST", Name: "Last one", Type: models.PromoFixedAmount, Value: 5, MaxUses: 1})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 1, Quantity: 1})
+	cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 2, Code: "LAST"})
+	orderSvc.sagaHook = func(step string) error {
+		if step == StepApplyPromotions { store.ClaimPromotions(999, 3, []models.DiscountLine{{PromotionID: last.ID}}) }
+		return nil
+	}
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2}); !errors.Is(err, storage.ErrPromotionExhausted) { t.Fatalf("expected exhausted promotion, got %v", err) }
+	if stock() != 48 { t.Fatalf("expected reservation released, got %d", stock()) }
+	mvs, _ := store.GetInventoryMovements(1)
+	if last := mvs[len(mvs)-1]; last.Type != models.MovementCancellation { t.Fatalf("expected release movement, got %+v", last) }
+	if p, _ := fake.Lookup(ctx, "fake_auth_3"); p.Status != models.PaymentVoided { t.Fatalf("expected voided authorization, got %+v", p) }
+	if cart, _ := store.GetCartByUser(2); len(cart.Items) != 1 { t.Fatalf("expected cart kept, got %+v", cart) }
+}
+
+func TestCheckoutSaga_RecoversAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	fake := NewFakePaymentProvider()
+	orderSvc.SetPaymentProvider(fake)
+	stock := func() int { p, _ := store.GetProductByID(1); return p.Stock }
+	crash := errors.New("crash")
+	crashAt := func(step string) { orderSvc.sagaHook = func(s string) error { if s == step { return crash }; return nil } }
+
+	// interrupted before the order exists: recovery compensates
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	crashAt(StepCreateOrder)
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k1"}); err != crash { t.Fatalf("expected crash, got %v", err) }
+	if stock() != 48 { t.Fatalf("expected stock reserved mid-saga, got %d", stock()) }
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k1"}); err != ErrCheckoutInProgress { t.Fatalf("expected in progress, got %v", err) }
+	orderSvc.sagaHook = nil
+	if n := orderSvc.RecoverSagas(ctx, time.Hour); n != 0 { t.Fatalf("expected fresh saga left alone, recovered %d", n) }
+	if n := orderSvc.RecoverSagas(ctx, 0); n != 1 { t.Fatalf("expected one saga recovered, got %d", n) }
+	sg, _ := store.FindSaga(1, "k1")
+	if sg.Status != models.SagaCompensated || stock() != 50 { t.Fatalf("expected compensated saga, got %+v stock=%d", sg, stock()) }
+	if p, _ := fake.Lookup(ctx, "fake_auth_1"); p.Status != models.PaymentVoided { t.Fatalf("expected voided authorization, got %+v", p) }
+	if _, err := store.GetOrderByID(sg.OrderID); !errors.Is(err, storage.ErrOrderNotFound) { t.Fatalf("expected no order, got %v", err) }
+
+	// interrupted after the order exists: recovery finishes the checkout
+	crashAt(StepClearCart)
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k2"})
+	if err != crash || order != nil { t.Fatalf("expected crash, got %+v %v", order, err) }
+	orderSvc.sagaHook = nil
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 2, Quantity: 1})
+	sg, _ = store.FindSaga(1, "k2")
+	// the admin endpoint leaves a saga alone while its request may still drive it
+	if _, err := orderSvc.RecoverSaga(ctx, &dto.RecoverCheckoutSagaRequest{ID: sg.ID}); err != ErrSagaActive { t.Fatalf("expected active saga refused, got %v", err) }
+	orderSvc.sagaStaleAfter = 0
+	recovered, err := orderSvc.RecoverSaga(ctx, &dto.RecoverCheckoutSagaRequest{ID: sg.ID})
+	if err != nil || recovered.Status != models.SagaCompleted { t.Fatalf("expected completed saga, got %+v %v", recovered, err) }
+	// only the ordered lines leave the cart
+	if cart, _ := store.GetCartByUser(1); len(cart.Items) != 1 || cart.Items[0].ProductID != 2 { t.Fatalf("expected later item kept, got %+v", cart.Items) }
+	if o, err := store.GetOrderByID(sg.OrderID); err != nil || o.Status != models.OrderPlaced || stock() != 48 { t.Fatalf("unexpected order %+v %v stock=%d", o, err, stock()) }
+	if _, err := orderSvc.RecoverSaga(ctx, &dto.RecoverCheckoutSagaRequest{ID: sg.ID}); err != ErrSagaSettled { t.Fatalf("expected settled, got %v", err) }
+}
+
+func TestCheckoutSaga_ConcurrentCheckoutsAndRecovery(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	fake := NewFakePaymentProvider()
+	orderSvc.SetPaymentProvider(fake)
+
+	// the store, not a prior lookup, keeps one key to one saga until that
+	// saga is compensated
+	newSaga := func() *models.CheckoutSaga { return &models.CheckoutSaga{UserID: 3, IdempotencyKey: "dup", Status: models.SagaRunning} }
+	first, created, err := store.CreateSaga(newSaga())
+	if err != nil || !created { t.Fatalf("create: %v %v", created, err) }
+	if again, created, _ := store.CreateSaga(newSaga()); created || again.ID != first.ID { t.Fatalf("expected saga %d returned, got %d created=%v", first.ID, again.ID, created) }
+	first.Status = models.SagaCompensated
+	store.SaveSaga(first)
+	store.ReleaseSaga(first.ID)
+	next, created, _ := store.CreateSaga(newSaga())
+	if !created || next.ID == first.ID { t.Fatalf("expected a new saga after compensation, got %d created=%v", next.ID, created) }
+	next.Status = models.SagaCompensated
+	store.SaveSaga(next)
+	store.ReleaseSaga(next.ID)
+
+	// retries racing the first checkout authorize the payment once
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1})
+	results := make(chan error, 8)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "k1"})
+			results <- err
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil && err != ErrCheckoutInProgress { t.Fatalf("checkout: %v", err) }
+	}
+	if _, err := fake.Lookup(ctx, "fake_auth_2"); err == nil { t.Fatalf("expected a single authorization") }
+
+	// while the worker compensates a saga, neither another worker pass nor
+	// the admin endpoint can drive it
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 1, Quantity: 1})
+	orderSvc.sagaHook = func(step string) error { if step == StepCreateOrder { return errors.New("crash") }; return nil }
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2, IdempotencyKey: "k2"}); err == nil { t.Fatalf("expected crash") }
+	orderSvc.sagaHook = nil
+	sg, _ := store.FindSaga(2, "k2")
+	slow := newSlowPayments(fake)
+	orderSvc.SetPaymentProvider(slow)
+	orderSvc.sagaStaleAfter = 0
+	done := make(chan int)
+	go func() { done <- orderSvc.RecoverSagas(ctx, 0) }()
+	<-slow.started
+	if n := orderSvc.RecoverSagas(ctx, 0); n != 0 { t.Errorf("expected leased saga skipped, recovered %d", n) }
+	if _, err := orderSvc.RecoverSaga(ctx, &dto.RecoverCheckoutSagaRequest{ID: sg.ID}); err != ErrSagaActive { t.Errorf("expected leased saga refused, got %v", err) }
+	close(slow.release)
+	if n := <-done; n != 1 { t.Fatalf("expected one saga recovered, got %d", n) }
+	if sg, _ = store.GetSaga(sg.ID); sg.Status != models.SagaCompensated || slow.count("void") != 1 { t.Fatalf("expected one void, got %d, saga %+v", slow.count("void"), sg) }
+}
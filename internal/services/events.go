@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/metrics"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// Dispatch settings. A pass handles at most EventDispatchBatch events. An
+// event a sink refuses is retried after EventRetryBaseSeconds, doubling up to
+// EventRetryMaxSeconds; after EventMaxAttempts failed passes, about an hour,
+// it is parked for the sinks still refusing it.
+const (
+	EventDispatchBatch    = 100
+	EventRetryBaseSeconds = 1
+	EventRetryMaxSeconds  = 300
+	EventMaxAttempts      = 20
+)
+
+var eventsParked = metrics.NewCounter("bookstore_events_parked_total", "Domain events a sink still refused after the last attempt, by sink.", "sink")
+
+// EventSink receives domain events from an EventBus. Delivery is at least
+// once: after a failure or a crash a sink may see an event again, so Deliver
+// should tolerate duplicates (the event ID identifies them). Name must be
+// unique per bus; it records which sinks already have an event.
+type EventSink interface {
+	Name() string
+	Deliver(ctx context.Context, ev models.DomainEvent) error
+}
+
+// EventBus delivers the store's outbox to in-process subscribers and sinks.
+// Events of one aggregate are delivered in order: while one waits for a
+// retry, later events of the same aggregate wait too. An event leaves the
+// outbox once every sink has it, or is parked once MaxAttempts passes have
+// failed, so a sink that never recovers cannot stall its aggregate for good.
+type EventBus struct {
+	store *storage.MemoryStore
+	mu    sync.RWMutex
+	sinks []EventSink
+	// Backoff is the delay before retry attempt n (1-based). The default
+	// doubles from EventRetryBaseSeconds up to EventRetryMaxSeconds.
+	Backoff func(attempt int) time.Duration
+	// MaxAttempts is how many passes may fail before an event is parked;
+	// 0 retries forever.
+	MaxAttempts int
+}
+
+func NewEventBus(store *storage.MemoryStore) *EventBus {
+	return &EventBus{store: store, Backoff: eventBackoff, MaxAttempts: EventMaxAttempts}
+}
+
+func eventBackoff(attempt int) time.Duration {
+	d := EventRetryBaseSeconds * time.Second
+	for i := 1; i < attempt && d < EventRetryMaxSeconds*time.Second; i++ { d *= 2 }
+	if d > EventRetryMaxSeconds*time.Second { d = EventRetryMaxSeconds * time.Second }
+	return d
+}
+
+// AddSink registers a sink for every event.
+func (b *EventBus) AddSink(s EventSink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, s)
+}
+
+// Subscribe registers an in-process handler for the given event types, or
+// for all events when none are given. A returned error retries the event.
+func (b *EventBus) Subscribe(name string, fn func(ctx context.Context, ev models.DomainEvent) error, types ...string) {
+	b.AddSink(&subscriber{name: name, fn: fn, types: types})
+}
+
+type subscriber struct {
+	name  string
+	fn    func(ctx context.Context, ev models.DomainEvent) error
+	types []string
+}
+
+func (s *subscriber) Name() string { return s.name }
+
+func (s *subscriber) Deliver(ctx context.Context, ev models.DomainEvent) error {
+	if len(s.types) > 0 && !listed(ev.Type, s.types) { return nil }
+	return s.fn(ctx, ev)
+}
+
+func listed(v string, list []string) bool {
+	for _, s := range list {
+		if s == v { return true }
+	}
+	return false
+}
+
+// Dispatch makes one delivery pass over the due outbox events and returns how
+// many reached every sink.
+func (b *EventBus) Dispatch(ctx context.Context) int {
+	b.mu.RLock()
+	sinks := append([]EventSink(nil), b.sinks...)
+	b.mu.RUnlock()
+	now := time.Now()
+	held := make(map[string]bool) // aggregates with a failed event this pass
+	n := 0
+	for _, e := range b.store.PendingEvents(now, EventDispatchBatch) {
+		key := e.Event.AggregateKey()
+		if held[key] { continue }
+		delivered := e.Delivered
+		var failed, errs []string
+		// sinks log under the request that caused the event
+		ectx := ctx
+		if e.Event.RequestID != "" { ectx = logging.WithRequestID(ctx, e.Event.RequestID) }
+		for _, s := range sinks {
+			if listed(s.Name(), delivered) { continue }
+			if err := s.Deliver(ectx, e.Event); err != nil {
+				failed = append(failed, s.Name())
+				errs = append(errs, s.Name()+": "+err.Error())
+				continue
+			}
+			delivered = append(delivered, s.Name())
+		}
+		if len(errs) == 0 {
+			b.store.AckEvent(e.Event.ID)
+			n++
+			continue
+		}
+		if b.MaxAttempts > 0 && e.Attempts+1 >= b.MaxAttempts {
+			slog.WarnContext(ectx, "domain event parked", "event_id", e.Event.ID, "type", e.Event.Type, "aggregate", key, "sinks", failed, "error", strings.Join(errs, "; "))
+			for _, s := range failed { eventsParked.Inc(s) }
+			b.store.ParkEvent(e.Event.ID, failed, strings.Join(errs, "; "), now)
+			continue
+		}
+		held[key] = true
+		b.store.RetryEvent(e.Event.ID, delivered, strings.Join(errs, "; "), now.Add(b.Backoff(e.Attempts+1)))
+	}
+	return n
+}
+
+// ParkedEvents lists the events some sink refused after the last attempt,
+// oldest first.
+func (b *EventBus) ParkedEvents(ctx context.Context) []models.ParkedEvent {
+	_ = ctx
+	return b.store.ParkedEvents()
+}
+
+// Run dispatches whenever the outbox grows, and every interval for retries,
+// until ctx is done.
+func (b *EventBus) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	signal := b.store.EventSignal()
+	for {
+		b.Dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-signal:
+		case <-t.C:
+		}
+	}
+}
+
+// LogEventSink writes events to the default slog logger.
+type LogEventSink struct{}
+
+func (LogEventSink) Name() string { return "log" }
+
+func (LogEventSink) Deliver(ctx context.Context, ev models.DomainEvent) error {
+	slog.InfoContext(ctx, "domain event", "event_id", ev.ID, "type", ev.Type, "aggregate", ev.Aggregate, "aggregate_id", ev.AggregateID)
+	return nil
+}
+
+// FileEventSink appends each event as a JSON line to Path.
+type FileEventSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileEventSink) Name() string { return "file:" + s.Path }
+
+func (s *FileEventSink) Deliver(ctx context.Context, ev models.DomainEvent) error {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil { return err }
+	defer f.Close()
+	return json.NewEncoder(f).Encode(ev)
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestEvents_WrittenWithTheChange(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx) // drain the seed's events
+	var got []models.DomainEvent
+	var requests []string
+	bus.Subscribe("test", func(ctx context.Context, ev models.DomainEvent) error { got = append(got, ev); requests = append(requests, logging.RequestID(ctx)); return nil })
+	productSvc := NewProductService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	p, _ := store.GetProductByID(1)
+	if _, err := productSvc.UpdateProduct(ctx, &dto.UpdateProductRequest{ID: 1, ISBN: p.ISBN, Title: p.Title, Author: p.Author, Category: p.Category, Price: 40, Stock: p.Stock, Version: p.Version + 1}); !errors.Is(err, storage.ErrVersionConflict) { t.Fatalf("expected conflict, got %v", err) }
+	if store.OutboxLength() != 0 { t.Fatalf("a rejected change must not record events") }
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	orderSvc.CancelOrder(logging.WithRequestID(ctx, "req-cancel"), &dto.OrderActionRequest{ID: order.ID})
+	if n := bus.Dispatch(ctx); n != len(got) || store.OutboxLength() != 0 { t.Fatalf("expected outbox drained, dispatched %d of %d", n, len(got)) }
+
+	types := make([]string, 0, len(got))
+	for _, ev := range got { types = append(types, ev.Type) }
+	want := []string{models.EventCartItemAdded, models.EventStockChanged, models.EventOrderPlaced, models.EventCartCheckedOut, models.EventStockChanged, models.EventOrderStatusChanged}
+	if len(types) != len(want) { t.Fatalf("expected %v, got %v", want, types) }
+	for i := range want {
+		if types[i] != want[i] { t.Fatalf("expected %v, got %v", want, types) }
+	}
+	var change models.StatusChange
+	json.Unmarshal(got[5].Data, &change)
+	if change.From != models.OrderPlaced || change.To != models.OrderCancelled || got[5].AggregateID != order.ID { t.Fatalf("unexpected status change: %+v", change) }
+	// events carry the request that caused them to the subscribers
+	if got[5].RequestID != "req-cancel" || requests[5] != "req-cancel" || got[4].RequestID != "req-cancel" || got[0].RequestID != "" || requests[0] != "" { t.Fatalf("unexpected request IDs %v on %+v", requests, got) }
+	for i := 1; i < len(got); i++ {
+		if got[i].ID <= got[i-1].ID { t.Fatalf("events out of order: %+v", got) }
+	}
+}
+
+type flakySink struct {
+	fail map[uint64]int // event ID -> failures left
+	got  []uint64
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Deliver(ctx context.Context, ev models.DomainEvent) error {
+	if s.fail[ev.ID] > 0 { s.fail[ev.ID]--; return errors.New("unavailable") }
+	s.got = append(s.got, ev.ID)
+	return nil
+}
+
+func TestEvents_RetriesInOrderPerAggregate(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx)
+	cartSvc := NewCartService(store)
+	var backoffs []int
+	bus.Backoff = func(attempt int) time.Duration { backoffs = append(backoffs, attempt); return 0 }
+	sink := &flakySink{fail: map[uint64]int{}}
+	steady := 0
+	bus.AddSink(sink)
+	bus.Subscribe("steady", func(ctx context.Context, ev models.DomainEvent) error { steady++; return nil }, models.EventCartItemAdded)
+
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1}) // cart 1
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 2, Quantity: 1}) // cart 1
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 1, Quantity: 1}) // cart 2
+	pending := store.PendingEvents(time.Now(), 10)
+	first, second, other := pending[0].Event.ID, pending[1].Event.ID, pending[2].Event.ID
+	sink.fail[first] = 2
+
+	// the failed event holds back its cart's next event but not other carts
+	if n := bus.Dispatch(ctx); n != 1 { t.Fatalf("expected one event delivered, got %d", n) }
+	if len(sink.got) != 1 || sink.got[0] != other { t.Fatalf("expected only the other cart's event, got %v", sink.got) }
+	bus.Dispatch(ctx)
+	if n := bus.Dispatch(ctx); n != 2 { t.Fatalf("expected the held events delivered, got %d", n) }
+	if len(sink.got) != 3 || sink.got[1] != first || sink.got[2] != second { t.Fatalf("expected per-cart order, got %v", sink.got) }
+	// the steady subscriber received the failed event only once
+	if steady != 3 || len(backoffs) != 2 || backoffs[1] != 2 { t.Fatalf("steady=%d backoffs=%v", steady, backoffs) }
+	if store.OutboxLength() != 0 { t.Fatalf("expected outbox drained") }
+
+	if d := eventBackoff(1); d != time.Second { t.Fatalf("unexpected first backoff %v", d) }
+	if d := eventBackoff(4); d != 8*time.Second { t.Fatalf("unexpected fourth backoff %v", d) }
+	if d := eventBackoff(30); d != EventRetryMaxSeconds*time.Second { t.Fatalf("expected backoff capped, got %v", d) }
+}
+
+func TestEvents_ParkedAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx)
+	bus.Backoff = func(int) time.Duration { return 0 }
+	bus.MaxAttempts = 3
+	cartSvc := NewCartService(store)
+	sink := &flakySink{fail: map[uint64]int{}}
+	steady := 0
+	bus.AddSink(sink)
+	bus.Subscribe("steady", func(ctx context.Context, ev models.DomainEvent) error { steady++; return nil })
+
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 2, Quantity: 1})
+	pending := store.PendingEvents(time.Now(), 10)
+	stuck, next := pending[0].Event, pending[1].Event
+	sink.fail[stuck.ID] = 100
+
+	bus.Dispatch(ctx)
+	bus.Dispatch(ctx)
+	if len(sink.got) != 0 || len(store.ParkedEvents()) != 0 { t.Fatalf("expected the cart held back before the last attempt, got %v", sink.got) }
+	// the third failure parks the event for the flaky sink and frees the cart
+	bus.Dispatch(ctx)
+	parked := bus.ParkedEvents(ctx)
+	if len(parked) != 1 || parked[0].Event.ID != stuck.ID || len(parked[0].Sinks) != 1 || parked[0].Sinks[0] != "flaky" || parked[0].Attempts != 3 { t.Fatalf("unexpected parked events: %+v", parked) }
+	if len(sink.got) != 1 || sink.got[0] != next.ID || store.OutboxLength() != 0 { t.Fatalf("expected the next event delivered, got %v", sink.got) }
+	if steady != 2 { t.Fatalf("expected the steady sink to get each event once, got %d", steady) }
+	if stuck.AggregateKey() != "cart/1" { t.Fatalf("unexpected aggregate key %q", stuck.AggregateKey()) }
+}
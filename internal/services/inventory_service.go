@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type InventoryService struct { store *storage.MemoryStore }
+
+func NewInventoryService(store *storage.MemoryStore) *InventoryService { return &InventoryService{store: store} }
+
+// Adjust records a manual stock movement. Sales and cancellations are only
+// written by the order flow, so they are rejected here.
+func (s *InventoryService) Adjust(ctx context.Context, req *dto.AdjustInventoryRequest) (*dto.InventoryMovement, error) {
+	reason := strings.TrimSpace(req.Reason)
+	qty := req.Quantity
+	switch req.Type {
+	case models.MovementReceipt:
+		if qty <= 0 { return nil, errors.New("receipt quantity must be positive") }
+	case models.MovementDamage:
+		if qty <= 0 { return nil, errors.New("damage quantity must be positive") }
+		qty = -qty
+	case models.MovementAdjustment:
+		if qty == 0 { return nil, errors.New("adjustment quantity must be non-zero") }
+	default:
+		return nil, errors.New("movement type must be receipt, adjustment or damage")
+	}
+	if reason == "" && req.Type != models.MovementReceipt { return nil, errors.New("reason is required") }
+	if len(reason) > 500 { return nil, errors.New("reason too long") }
+	return s.store.RecordMovement(ctx, &models.InventoryMovement{ProductID: req.ProductID, WarehouseID: req.WarehouseID, Type: req.Type, Quantity: qty, Reason: reason, Actor: req.Actor})
+}
+
+// Transfer moves stock between two warehouses. The product's total stock is
+// unchanged; the ledger gets a matching pair of transfer movements.
+func (s *InventoryService) Transfer(ctx context.Context, req *dto.TransferStockRequest) ([]*dto.InventoryMovement, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" { return nil, errors.New("reason is required") }
+	if req.Quantity <= 0 { return nil, errors.New("transfer quantity must be positive") }
+	if req.FromWarehouseID == req.ToWarehouseID { return nil, errors.New("source and destination warehouses must differ") }
+	return s.store.TransferStock(ctx, req.ProductID, req.FromWarehouseID, req.ToWarehouseID, req.Quantity, reason, req.Actor)
+}
+
+func (s *InventoryService) CreateWarehouse(ctx context.Context, req *dto.CreateWarehouseRequest) (*dto.Warehouse, error) {
+	_ = ctx
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code == "" || len(code) > 20 { return nil, errors.New("invalid warehouse code") }
+	if strings.TrimSpace(req.Name) == "" { return nil, errors.New("invalid warehouse name") }
+	if req.CostPerUnit < 0 { return nil, errors.New("cost per unit must not be negative") }
+	return s.store.CreateWarehouse(&models.Warehouse{Code: code, Name: strings.TrimSpace(req.Name), Region: strings.TrimSpace(req.Region), CostPerUnit: req.CostPerUnit, Priority: req.Priority})
+}
+
+func (s *InventoryService) ListWarehouses(ctx context.Context, req *dto.ListWarehousesRequest) ([]*dto.Warehouse, error) {
+	_ = ctx
+	return s.store.GetWarehouses()
+}
+
+func (s *InventoryService) WarehouseStock(ctx context.Context, req *dto.GetWarehouseStockRequest) ([]dto.WarehouseStock, error) {
+	_ = ctx
+	return s.store.GetWarehouseStock(req.ProductID)
+}
+
+func (s *InventoryService) ListMovements(ctx context.Context, req *dto.ListMovementsRequest) ([]*dto.InventoryMovement, error) {
+	_ = ctx
+	return s.store.GetInventoryMovements(req.ProductID)
+}
+
+// Reconcile checks that every product's stock equals its ledger sum.
+func (s *InventoryService) Reconcile(ctx context.Context, req *dto.ReconcileInventoryRequest) (*dto.ReconciliationReport, error) {
+	_ = ctx
+	d := s.store.ReconcileInventory()
+	return &dto.ReconciliationReport{Consistent: len(d) == 0, Discrepancies: d}, nil
+}
+
+// LowStock lists products at or below their reorder point.
+func (s *InventoryService) LowStock(ctx context.Context, req *dto.ListLowStockRequest) ([]*dto.Product, error) {
+	_ = ctx
+	return s.store.GetLowStockProducts()
+}
+
+// alertRetryInterval is how often RunAlertDispatcher retries alerts the
+// notifier refused.
+const alertRetryInterval = 30 * time.Second
+
+// DispatchAlerts drains queued low-stock alerts, adds each product's reorder
+// quantity to its supplier's draft purchase order and passes the alert to n.
+// It returns the number of alerts handled. An alert n fails to deliver is
+// logged and queued again, counting the attempt, so the next call only
+// retries the notification; it does not stop the remaining alerts.
+func (s *InventoryService) DispatchAlerts(ctx context.Context, n AlertNotifier) int {
+	alerts := s.store.DrainStockAlerts()
+	var failed []models.StockAlert
+	for _, a := range alerts {
+		if a.Attempts == 0 {
+			if _, err := s.store.AddToDraftPurchaseOrder(a.Supplier, a.ProductID, a.ReorderQuantity); err != nil { slog.ErrorContext(ctx, "draft purchase order failed", "product_id", a.ProductID, "supplier", a.Supplier, "error", err) }
+		}
+		if n == nil { continue }
+		if err := n.NotifyLowStock(ctx, a); err != nil {
+			a.Attempts++
+			slog.WarnContext(ctx, "low-stock notify failed; will retry", "product_id", a.ProductID, "attempts", a.Attempts, "error", err)
+			failed = append(failed, a)
+		}
+	}
+	s.store.RequeueStockAlerts(failed)
+	return len(alerts) - len(failed)
+}
+
+// RunAlertDispatcher calls DispatchAlerts whenever the store queues alerts,
+// and every alertRetryInterval for alerts that failed, until ctx is
+// cancelled.
+func (s *InventoryService) RunAlertDispatcher(ctx context.Context, n AlertNotifier) {
+	signal := s.store.StockAlertSignal()
+	t := time.NewTicker(alertRetryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signal:
+			s.DispatchAlerts(ctx, n)
+		case <-t.C:
+			s.DispatchAlerts(ctx, n)
+		}
+	}
+}
+
+func (s *InventoryService) ListPurchaseOrders(ctx context.Context, req *dto.ListPurchaseOrdersRequest) ([]*dto.PurchaseOrder, error) {
+	_ = ctx
+	status := strings.ToUpper(strings.TrimSpace(req.Status))
+	if status != "" && status != models.PurchaseOrderDraft && status != models.PurchaseOrderApproved { return nil, errors.New("status must be DRAFT or APPROVED") }
+	return s.store.GetPurchaseOrders(status)
+}
+
+// ApprovePurchaseOrder closes a draft so the buyer can send it to the supplier.
+func (s *InventoryService) ApprovePurchaseOrder(ctx context.Context, req *dto.ApprovePurchaseOrderRequest) (*dto.PurchaseOrder, error) {
+	_ = ctx
+	return s.store.ApprovePurchaseOrder(req.ID, req.Actor)
+}
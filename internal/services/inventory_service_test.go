@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestInventoryService_LedgerMatchesStock(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	inv := NewInventoryService(store)
+	prodSvc := NewProductService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	// receipt, damage, signed adjustment
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementReceipt, Quantity: 10, Actor: "user:3"}); err != nil { t.Fatalf("receipt: %v", err) }
+	mv, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementDamage, Quantity: 2, Reason: "water damage", Actor: "user:3"})
+	if err != nil { t.Fatalf("damage: %v", err) }
+	if mv.Quantity != -2 || mv.StockAfter != 58 { t.Fatalf("unexpected damage movement: %+v", mv) }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementAdjustment, Quantity: -3, Reason: "cycle count"}); err != nil { t.Fatalf("adjust: %v", err) }
+	// rejected movements
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementSale, Quantity: 1, Reason: "x"}); err == nil { t.Fatalf("expected sale to be rejected") }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementAdjustment, Quantity: 5}); err == nil { t.Fatalf("expected missing reason error") }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementAdjustment, Quantity: -1000, Reason: "x"}); err == nil { t.Fatalf("expected negative stock error") }
+
+	// sale via checkout and overwrite via update are both recorded
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2}); err != nil { t.Fatalf("add: %v", err) }
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	p, _ := store.GetProductByID(1)
+	if _, err := prodSvc.UpdateProduct(ctx, &dto.UpdateProductRequest{ID: 1, Title: p.Title, Author: p.Author, Price: p.Price, Stock: 40}); err != nil { t.Fatalf("update: %v", err) }
+
+	moves, err := inv.ListMovements(ctx, &dto.ListMovementsRequest{ProductID: 1})
+	if err != nil { t.Fatalf("list: %v", err) }
+	wantTypes := []string{models.MovementReceipt, models.MovementReceipt, models.MovementDamage, models.MovementAdjustment, models.MovementSale, models.MovementAdjustment}
+	if len(moves) != len(wantTypes) { t.Fatalf("expected %d movements, got %d", len(wantTypes), len(moves)) }
+	sum := 0
+	for i, mv := range moves {
+		if mv.Type != wantTypes[i] { t.Fatalf("movement %d: expected %s, got %s", i, wantTypes[i], mv.Type) }
+		sum += mv.Quantity
+	}
+	if moves[4].OrderID != order.ID { t.Fatalf("sale should reference order %d, got %d", order.ID, moves[4].OrderID) }
+	if sum != 40 { t.Fatalf("ledger sum %d != stock 40", sum) }
+
+	report, err := inv.Reconcile(ctx, &dto.ReconcileInventoryRequest{})
+	if err != nil { t.Fatalf("reconcile: %v", err) }
+	if !report.Consistent { t.Fatalf("expected consistent ledger, got %+v", report.Discrepancies) }
+}
+
+func TestInventoryService_StockLimitUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	inv := NewInventoryService(store)
+
+	// product 1 starts at 50: only nine receipts of 1000 fit under the limit
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted, limited := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementReceipt, Quantity: 1000})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil { accepted++ } else if errors.Is(err, storage.ErrStockLimit) { limited++ } else { t.Errorf("receipt: %v", err) }
+		}()
+	}
+	wg.Wait()
+	p, _ := store.GetProductByID(1)
+	if accepted != 9 || limited != 11 || p.Stock != 9050 { t.Fatalf("expected 9 receipts and stock 9050, got %d accepted, %d limited, stock %d", accepted, limited, p.Stock) }
+	if p.Stock > storage.MaxProductStock { t.Fatalf("stock %d exceeds the limit", p.Stock) }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementAdjustment, Quantity: 951, Reason: "count"}); !errors.Is(err, storage.ErrStockLimit) { t.Fatalf("expected the stock limit, got %v", err) }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementAdjustment, Quantity: 950, Reason: "count"}); err != nil { t.Fatalf("adjust to the limit: %v", err) }
+}
+
+func TestInventoryService_WarehouseAllocation(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	inv := NewInventoryService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	const main, east, west = storage.DefaultWarehouseID, 2, 3
+
+	// product 1: MAIN 5, WEST 45
+	if _, err := inv.Transfer(ctx, &dto.TransferStockRequest{ProductID: 1, FromWarehouseID: main, ToWarehouseID: west, Quantity: 45, Reason: "rebalance"}); err != nil { t.Fatalf("transfer: %v", err) }
+	if _, err := inv.Transfer(ctx, &dto.TransferStockRequest{ProductID: 1, FromWarehouseID: main, ToWarehouseID: east, Quantity: 6, Reason: "x"}); err == nil { t.Fatalf("expected insufficient stock at source") }
+	// a leg that cannot land leaves the source untouched
+	if _, err := inv.Transfer(ctx, &dto.TransferStockRequest{ProductID: 1, FromWarehouseID: main, ToWarehouseID: 99, Quantity: 1, Reason: "x"}); !errors.Is(err, storage.ErrWarehouseNotFound) { t.Fatalf("expected unknown destination, got %v", err) }
+	levels, _ := inv.WarehouseStock(ctx, &dto.GetWarehouseStockRequest{ProductID: 1})
+	if len(levels) != 2 || levels[0].Quantity != 5 || levels[1].Quantity != 45 { t.Fatalf("unexpected levels: %+v", levels) }
+	if d := store.ReconcileInventory(); len(d) != 0 { t.Fatalf("ledger out of balance: %+v", d) }
+
+	// whole cart fits in MAIN -> single warehouse
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 5})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 2, Quantity: 1})
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place 1: %v", err) }
+	for _, it := range order.Items {
+		if len(it.Allocations) != 1 || it.Allocations[0].WarehouseID != main { t.Fatalf("expected MAIN for all lines, got %+v", it.Allocations) }
+	}
+
+	// MAIN is empty for product 1 now -> WEST ships it
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 1, Quantity: 3})
+	order, err = orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2})
+	if err != nil { t.Fatalf("place 2: %v", err) }
+	if a := order.Items[0].Allocations; len(a) != 1 || a[0].WarehouseID != west { t.Fatalf("expected WEST, got %+v", a) }
+
+	// product 4: MAIN 2, EAST 2 -> a line of 3 is split in priority order
+	inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 4, Type: models.MovementDamage, Quantity: 31, Reason: "recall"})
+	inv.Transfer(ctx, &dto.TransferStockRequest{ProductID: 4, FromWarehouseID: main, ToWarehouseID: east, Quantity: 2, Reason: "rebalance"})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 3, ProductID: 4, Quantity: 3})
+	order, err = orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 3})
+	if err != nil { t.Fatalf("place 3: %v", err) }
+	a := order.Items[0].Allocations
+	if len(a) != 2 || a[0] != (models.Allocation{WarehouseID: main, Quantity: 2}) || a[1] != (models.Allocation{WarehouseID: east, Quantity: 1}) { t.Fatalf("unexpected split: %+v", a) }
+
+	if report, _ := inv.Reconcile(ctx, &dto.ReconcileInventoryRequest{}); !report.Consistent { t.Fatalf("ledger drifted: %+v", report.Discrepancies) }
+}
+
+func TestInventoryService_AllocationPrefersShippingRegion(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	inv := NewInventoryService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	const east, west = 2, 3
+
+	// product 1: MAIN 30, EAST (NY) 10, WEST (CA) 10; every warehouse can ship
+	inv.Transfer(ctx, &dto.TransferStockRequest{ProductID: 1, FromWarehouseID: storage.DefaultWarehouseID, ToWarehouseID: east, Quantity: 10, Reason: "rebalance"})
+	inv.Transfer(ctx, &dto.TransferStockRequest{ProductID: 1, FromWarehouseID: storage.DefaultWarehouseID, ToWarehouseID: west, Quantity: 10, Reason: "rebalance"})
+	place := func(userID, shipTo uint) uint {
+		t.Helper()
+		cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: userID, ProductID: 1, Quantity: 1})
+		order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: userID, ShippingAddressID: shipTo})
+		if err != nil { t.Fatalf("place for user %d: %v", userID, err) }
+		return order.Items[0].Allocations[0].WarehouseID
+	}
+
+	// users 2 and 3 default to addresses in NY and CA
+	if w := place(2, 0); w != east { t.Fatalf("expected the NY order from EAST, got warehouse %d", w) }
+	if w := place(3, 0); w != west { t.Fatalf("expected the CA order from WEST, got warehouse %d", w) }
+
+	store.SetAllocationStrategy(storage.CheapestFirst)
+	ny, err := NewUserService(store).CreateAddress(ctx, &dto.AddressRequest{UserID: 1, Name: "John Doe", Line1: "1 Main St", City: "Albany", Region: "ny", PostalCode: "12207", Country: "US"})
+	if err != nil { t.Fatalf("address: %v", err) }
+	if w := place(1, ny.ID); w != storage.DefaultWarehouseID { t.Fatalf("expected the cheapest warehouse (MAIN), got %d", w) }
+}
+
+type recordingNotifier struct{ alerts []models.StockAlert }
+
+func (n *recordingNotifier) NotifyLowStock(ctx context.Context, a models.StockAlert) error { n.alerts = append(n.alerts, a); return nil }
+
+// flakyNotifier fails until ok is set, counting the calls.
+type flakyNotifier struct {
+	ok    bool
+	calls int
+	recordingNotifier
+}
+
+func (n *flakyNotifier) NotifyLowStock(ctx context.Context, a models.StockAlert) error {
+	n.calls++
+	if !n.ok { return errors.New("pager unavailable") }
+	return n.recordingNotifier.NotifyLowStock(ctx, a)
+}
+
+func TestInventoryService_FailedAlertsAreRetried(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	inv := NewInventoryService(store)
+	p, _ := store.GetProductByID(1)
+	if _, err := NewProductService(store).PatchProduct(ctx, &dto.PatchProductRequest{ID: 1, Version: p.Version, Patch: []byte(`{"reorderPoint":45,"reorderQuantity":20,"supplier":"Penguin"}`)}); err != nil { t.Fatalf("patch: %v", err) }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementDamage, Quantity: 6, Reason: "flood"}); err != nil { t.Fatalf("damage: %v", err) }
+
+	n := &flakyNotifier{}
+	for i := 0; i < 2; i++ {
+		if got := inv.DispatchAlerts(ctx, n); got != 0 { t.Fatalf("expected the alert kept while the notifier fails, handled %d", got) }
+	}
+	// the draft is approved meanwhile; the retry must not start another
+	drafts, _ := inv.ListPurchaseOrders(ctx, &dto.ListPurchaseOrdersRequest{Status: models.PurchaseOrderDraft})
+	if len(drafts) != 1 { t.Fatalf("expected one draft, got %+v", drafts) }
+	inv.ApprovePurchaseOrder(ctx, &dto.ApprovePurchaseOrderRequest{ID: drafts[0].ID})
+	n.ok = true
+	if got := inv.DispatchAlerts(ctx, n); got != 1 || n.calls != 3 || len(n.alerts) != 1 || n.alerts[0].Attempts != 2 { t.Fatalf("expected the alert delivered on the third call, handled %d, %d calls, %+v", got, n.calls, n.alerts) }
+	if got := inv.DispatchAlerts(ctx, n); got != 0 { t.Fatalf("expected the queue empty, handled %d", got) }
+	if drafts, _ = inv.ListPurchaseOrders(ctx, &dto.ListPurchaseOrdersRequest{Status: models.PurchaseOrderDraft}); len(drafts) != 0 { t.Fatalf("expected no new draft, got %+v", drafts) }
+}
+
+func TestInventoryService_ReorderAlertsAndPurchaseOrders(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	inv := NewInventoryService(store)
+	prodSvc := NewProductService(store)
+	n := &recordingNotifier{}
+
+	p, _ := store.GetProductByID(1)
+	if _, err := prodSvc.PatchProduct(ctx, &dto.PatchProductRequest{ID: 1, Version: p.Version, Patch: []byte(`{"reorderPoint":5}`)}); err == nil { t.Fatalf("expected reorderQuantity to be required") }
+	if _, err := prodSvc.PatchProduct(ctx, &dto.PatchProductRequest{ID: 1, Version: p.Version, Patch: []byte(`{"reorderPoint":45,"reorderQuantity":20,"supplier":"Penguin"}`)}); err != nil { t.Fatalf("patch: %v", err) }
+
+	damage := func(qty int) {
+		t.Helper()
+		if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementDamage, Quantity: qty, Reason: "shelf collapse"}); err != nil { t.Fatalf("damage: %v", err) }
+	}
+	damage(4) // 50 -> 46, still above
+	if got := inv.DispatchAlerts(ctx, n); got != 0 { t.Fatalf("expected no alerts above the reorder point, got %d", got) }
+	damage(2) // 46 -> 44, crosses
+	damage(1) // already below, no new alert
+	if got := inv.DispatchAlerts(ctx, n); got != 1 || n.alerts[0].Stock != 44 || n.alerts[0].Cause != models.MovementDamage { t.Fatalf("expected one crossing alert, got %d %+v", got, n.alerts) }
+	low, _ := inv.LowStock(ctx, &dto.ListLowStockRequest{})
+	if len(low) != 1 || low[0].ID != 1 { t.Fatalf("unexpected low-stock list: %+v", low) }
+
+	// restock and cross again: the open draft already has the product
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementReceipt, Quantity: 20}); err != nil { t.Fatalf("receipt: %v", err) }
+	damage(20)
+	inv.DispatchAlerts(ctx, n)
+	drafts, _ := inv.ListPurchaseOrders(ctx, &dto.ListPurchaseOrdersRequest{Status: "draft"})
+	if len(n.alerts) != 2 || len(drafts) != 1 || drafts[0].Supplier != "Penguin" || len(drafts[0].Lines) != 1 || drafts[0].Lines[0].Quantity != 20 { t.Fatalf("unexpected drafts: %+v", drafts) }
+
+	approved, err := inv.ApprovePurchaseOrder(ctx, &dto.ApprovePurchaseOrderRequest{ID: drafts[0].ID, Actor: "user:3"})
+	if err != nil || approved.Status != models.PurchaseOrderApproved || approved.ApprovedBy != "user:3" { t.Fatalf("approve: %v %+v", err, approved) }
+	if _, err := inv.ApprovePurchaseOrder(ctx, &dto.ApprovePurchaseOrderRequest{ID: drafts[0].ID}); err != storage.ErrPurchaseOrderNotDraft { t.Fatalf("expected not-draft error, got %v", err) }
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: 1, Type: models.MovementReceipt, Quantity: 20}); err != nil { t.Fatalf("receipt: %v", err) }
+	damage(20)
+	inv.DispatchAlerts(ctx, n)
+	drafts, _ = inv.ListPurchaseOrders(ctx, &dto.ListPurchaseOrdersRequest{Status: models.PurchaseOrderDraft})
+	if len(drafts) != 1 || drafts[0].ID == approved.ID { t.Fatalf("expected a new draft after approval, got %+v", drafts) }
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// Live event types.
+const (
+	LiveStock       = "stock"
+	LiveOrderStatus = "order_status"
+)
+
+// LiveReplayBuffer is how many recent events the hub keeps for Last-Event-ID
+// resume; LiveClientBuffer is how far a subscriber may fall behind before it
+// is dropped.
+const (
+	LiveReplayBuffer = 1000
+	LiveClientBuffer = 64
+)
+
+// LiveEvent is one message on the live stream. IDs are the hub's own
+// sequence, so a client resumes with the last ID it saw.
+type LiveEvent struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+	// exactly one of these routes the event
+	productID uint
+	userID    uint
+}
+
+// StockLevel is the data of a LiveStock event: the product's level when the
+// event was handled. Changes handled together may collapse into one event.
+type StockLevel struct {
+	ProductID uint `json:"productId"`
+	Stock     int  `json:"stock"`
+	Held      int  `json:"held"`
+	Available int  `json:"available"`
+}
+
+// LiveHub fans stock levels and order status changes out to live
+// subscribers. It is fed from an EventBus (Attach), never from store writers
+// directly, and publishing never blocks: a subscriber whose buffer is full is
+// dropped and can resume from the replay buffer.
+type LiveHub struct {
+	store     *storage.MemoryStore
+	mu        sync.Mutex
+	seq       uint64
+	replay    []LiveEvent // the last LiveReplayBuffer events, oldest first
+	trimmed   uint64      // ID of the newest event no longer in replay
+	lastStock map[uint]StockLevel
+	subs      map[*LiveSubscription]struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewLiveHub(store *storage.MemoryStore) *LiveHub {
+	return &LiveHub{store: store, lastStock: make(map[uint]StockLevel), subs: make(map[*LiveSubscription]struct{}), done: make(chan struct{})}
+}
+
+// Close tells every stream to end, so a shutting-down server is not held
+// open by clients that never disconnect. Clients reconnect with
+// Last-Event-ID.
+func (h *LiveHub) Close() { h.closeOnce.Do(func() { close(h.done) }) }
+
+// Done is closed by Close.
+func (h *LiveHub) Done() <-chan struct{} { return h.done }
+
+// LiveSubscription receives the events for some products and one user's
+// orders.
+type LiveSubscription struct {
+	userID   uint
+	products map[uint]bool
+	events   chan LiveEvent
+	dropped  chan struct{}
+}
+
+// Events delivers the subscription's events in order.
+func (s *LiveSubscription) Events() <-chan LiveEvent { return s.events }
+
+// Dropped is closed when the hub drops the subscription for falling behind.
+func (s *LiveSubscription) Dropped() <-chan struct{} { return s.dropped }
+
+func (s *LiveSubscription) wants(ev LiveEvent) bool {
+	if ev.userID != 0 { return s.userID != 0 && ev.userID == s.userID }
+	return s.products[ev.productID]
+}
+
+// Subscribe registers a subscriber for productIDs and, when userID is not
+// zero, that user's order status changes. With lastEventID set it also
+// returns the buffered events after it; complete is false when some of those
+// are no longer buffered (or the ID is from before a restart), and the client
+// should reload its state.
+func (h *LiveHub) Subscribe(userID uint, productIDs []uint, lastEventID uint64) (sub *LiveSubscription, replay []LiveEvent, complete bool) {
+	sub = &LiveSubscription{userID: userID, products: make(map[uint]bool), events: make(chan LiveEvent, LiveClientBuffer), dropped: make(chan struct{})}
+	for _, id := range productIDs { sub.products[id] = true }
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	if lastEventID == 0 { return sub, nil, true }
+	for _, ev := range h.replay {
+		if ev.ID > lastEventID && sub.wants(ev) { replay = append(replay, ev) }
+	}
+	return sub, replay, lastEventID >= h.trimmed && lastEventID <= h.seq
+}
+
+// Unsubscribe removes a subscription; it is safe after a drop.
+func (h *LiveHub) Unsubscribe(sub *LiveSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// Subscribers reports how many subscriptions are connected.
+func (h *LiveHub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (h *LiveHub) publish(ev LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	ev.ID = h.seq
+	h.replay = append(h.replay, ev)
+	if over := len(h.replay) - LiveReplayBuffer; over > 0 {
+		h.trimmed = h.replay[over-1].ID
+		h.replay = append([]LiveEvent(nil), h.replay[over:]...)
+	}
+	for sub := range h.subs {
+		if !sub.wants(ev) { continue }
+		select {
+		case sub.events <- ev:
+		default:
+			delete(h.subs, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+// Attach feeds the hub from bus.
+func (h *LiveHub) Attach(bus *EventBus) {
+	bus.Subscribe("live", h.handle, models.EventStockChanged, models.EventHoldsChanged, models.EventCartItemAdded, models.EventCartItemRemoved, models.EventOrderStatusChanged)
+}
+
+func (h *LiveHub) handle(ctx context.Context, ev models.DomainEvent) error {
+	_ = ctx
+	if ev.Type == models.EventOrderStatusChanged {
+		var c models.StatusChange
+		if err := json.Unmarshal(ev.Data, &c); err != nil { return nil }
+		h.publish(LiveEvent{Type: LiveOrderStatus, Data: c, userID: c.UserID})
+		return nil
+	}
+	// stock movements change stock, cart holds change what is available
+	productID := ev.AggregateID
+	if ev.Aggregate == models.AggregateCart {
+		var c models.CartChange
+		if err := json.Unmarshal(ev.Data, &c); err != nil || c.ProductID == 0 { return nil }
+		productID = c.ProductID
+	}
+	p, err := h.store.GetProductByID(productID)
+	if errors.Is(err, storage.ErrProductNotFound) { return nil }
+	if err != nil { return err }
+	level := StockLevel{ProductID: p.ID, Stock: p.Stock, Held: p.Held, Available: p.Available}
+	h.mu.Lock()
+	unchanged := h.lastStock[p.ID] == level
+	h.lastStock[p.ID] = level
+	h.mu.Unlock()
+	if !unchanged { h.publish(LiveEvent{Type: LiveStock, Data: level, productID: p.ID}) }
+	return nil
+}
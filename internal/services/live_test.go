@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestLiveHub_StockAndOwnOrders(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	store.EnableStockHolds(15 * time.Minute)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx)
+	hub := NewLiveHub(store)
+	hub.Attach(bus)
+	mine, _, _ := hub.Subscribe(1, []uint{1}, 0)
+	other, _, _ := hub.Subscribe(2, []uint{2}, 0)
+
+	NewCartService(store).AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	bus.Dispatch(ctx)
+	ev := <-mine.Events()
+	level := ev.Data.(StockLevel)
+	if ev.Type != LiveStock || level.ProductID != 1 || level.Held != 2 || level.Available != level.Stock-2 { t.Fatalf("unexpected stock event %+v", ev) }
+
+	orderSvc := NewOrderService(store)
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	orderSvc.CancelOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	bus.Dispatch(ctx)
+	var types []string
+	for len(mine.Events()) > 0 { types = append(types, (<-mine.Events()).Type) }
+	// the sale and the cancellation are dispatched together, so subscribers
+	// only see the level they end at: the units back and no longer held
+	if len(types) != 2 || types[0] != LiveStock || types[1] != LiveOrderStatus { t.Fatalf("unexpected events %v", types) }
+	if len(other.Events()) != 0 { t.Fatalf("another user's subscription got %d events", len(other.Events())) }
+
+	// resume after the first event replays the rest, in order
+	_, replay, complete := hub.Subscribe(1, []uint{1}, ev.ID)
+	if !complete || len(replay) != 2 || replay[0].Data.(StockLevel).Held != 0 || replay[1].Data.(models.StatusChange).To != models.OrderCancelled { t.Fatalf("unexpected replay %+v complete=%v", replay, complete) }
+	if _, _, complete := hub.Subscribe(1, nil, 999); complete { t.Fatalf("an ID from the future must ask for a resync") }
+}
+
+func TestLiveHub_DropsSlowSubscribersAndTrimsReplay(t *testing.T) {
+	hub := NewLiveHub(storage.NewMemoryStore())
+	slow, _, _ := hub.Subscribe(0, []uint{7}, 0)
+	fast, _, _ := hub.Subscribe(0, []uint{7}, 0)
+	received := 0
+	for i := 0; i < LiveReplayBuffer+10; i++ {
+		hub.publish(LiveEvent{Type: LiveStock, Data: StockLevel{ProductID: 7, Stock: i}, productID: 7})
+		for len(fast.Events()) > 0 { <-fast.Events(); received++ }
+	}
+	select {
+	case <-slow.Dropped():
+	default:
+		t.Fatalf("expected the slow subscriber dropped")
+	}
+	if received != LiveReplayBuffer+10 || hub.Subscribers() != 1 { t.Fatalf("received %d, %d subscribers", received, hub.Subscribers()) }
+	hub.Unsubscribe(slow)
+
+	if _, replay, complete := hub.Subscribe(0, []uint{7}, 5); complete || len(replay) != LiveReplayBuffer { t.Fatalf("expected a partial replay, got %d complete=%v", len(replay), complete) }
+	if _, replay, complete := hub.Subscribe(0, []uint{7}, 10); !complete || len(replay) != LiveReplayBuffer { t.Fatalf("expected a full replay, got %d complete=%v", len(replay), complete) }
+}
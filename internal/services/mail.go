@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Email is a rendered message ready for a MailTransport.
+type Email struct {
+	From      string
+	To        string
+	Subject   string
+	Text      string
+	HTML      string
+	MessageID string
+}
+
+// MailTransport sends email. A failed Send is retried by the
+// NotificationService, so it may be called more than once per message.
+type MailTransport interface {
+	Send(ctx context.Context, m Email) error
+}
+
+// SMTPTransport sends through an SMTP relay at Addr ("host:port"). With a
+// Username it authenticates with PLAIN, which net/smtp only allows over TLS
+// or to localhost. From and To may carry display names ("Book Store
+// <orders@bookstore.example>"); only their bare addresses go into the SMTP
+// envelope.
+type SMTPTransport struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, m Email) error {
+	_ = ctx
+	var auth smtp.Auth
+	if t.Username != "" {
+		host, _, _ := net.SplitHostPort(t.Addr)
+		auth = smtp.PlainAuth("", t.Username, t.Password, host)
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil { return fmt.Errorf("from address: %w", err) }
+	to, err := mail.ParseAddress(m.To)
+	if err != nil { return fmt.Errorf("to address: %w", err) }
+	msg, err := buildMessage(m, time.Now())
+	if err != nil { return err }
+	return smtp.SendMail(t.Addr, auth, from.Address, []string{to.Address}, msg)
+}
+
+// MaildirTransport delivers into a maildir at Dir (creating tmp, new and
+// cur), for local development and tests: any mail client can open it.
+type MaildirTransport struct {
+	Dir string
+}
+
+var maildirSeq atomic.Uint64
+
+func (t *MaildirTransport) Send(ctx context.Context, m Email) error {
+	_ = ctx
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.Dir, sub), 0o755); err != nil { return err }
+	}
+	msg, err := buildMessage(m, time.Now())
+	if err != nil { return err }
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().UnixNano(), os.Getpid(), maildirSeq.Add(1), strings.ReplaceAll(host, "/", "_"))
+	tmp := filepath.Join(t.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, msg, 0o644); err != nil { return err }
+	return os.Rename(tmp, filepath.Join(t.Dir, "new", name))
+}
+
+// buildMessage renders m as a multipart/alternative MIME message with CRLF
+// line endings.
+func buildMessage(m Email, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", m.From)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	if m.MessageID != "" { header("Message-ID", m.MessageID) }
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ typ, body string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		if part.body == "" { continue }
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.typ + "; charset=utf-8"}, "Content-Transfer-Encoding": {"quoted-printable"}})
+		if err != nil { return nil, err }
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write([]byte(strings.ReplaceAll(part.body, "\n", "\r\n"))); err != nil { return nil, err }
+		if err := qw.Close(); err != nil { return nil, err }
+	}
+	if err := mw.Close(); err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"ecom-book-store-sample-api/internal/metrics"
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ordersPlaced   = metrics.NewCounter("bookstore_orders_placed_total", "Orders placed, including those waiting for payment or review.")
+	ordersFlagged  = metrics.NewCounter("bookstore_orders_flagged_total", "Orders held in PENDING_REVIEW.")
+	ruleRejections = metrics.NewCounter("bookstore_rule_rejections_total", "Add-to-cart and checkout requests refused by a business rule, by rule code.", "operation", "rule")
+)
+
+// AttachMetrics subscribes the order counters to bus. Counting delivered
+// events rather than calls keeps idempotent checkout retries from counting
+// twice.
+func AttachMetrics(bus *EventBus) {
+	bus.Subscribe("metrics", countOrderEvent, models.EventOrderPlaced, models.EventOrderStatusChanged)
+}
+
+func countOrderEvent(ctx context.Context, ev models.DomainEvent) error {
+	_ = ctx
+	switch ev.Type {
+	case models.EventOrderPlaced:
+		var o models.Order
+		if json.Unmarshal(ev.Data, &o) != nil { return nil }
+		ordersPlaced.Inc()
+		if o.Status == models.OrderPendingReview { ordersFlagged.Inc() }
+	case models.EventOrderStatusChanged:
+		var c models.StatusChange
+		if json.Unmarshal(ev.Data, &c) != nil { return nil }
+		// flagged once the challenged payment is confirmed
+		if c.From == models.OrderPendingPayment && c.To == models.OrderPendingReview { ordersFlagged.Inc() }
+	}
+	return nil
+}
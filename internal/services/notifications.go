@@ -0,0 +1,263 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// Notification kinds. Users can mute any of them.
+const (
+	NotifyOrderPlaced    = "order_placed"
+	NotifyOrderFlagged   = "order_flagged"
+	NotifyOrderShipped   = "order_shipped"
+	NotifyOrderCancelled = "order_cancelled"
+)
+
+var notificationKinds = []string{NotifyOrderPlaced, NotifyOrderFlagged, NotifyOrderShipped, NotifyOrderCancelled}
+
+// An email gets NotificationMaxAttempts tries before it is given up on, and
+// DeliverDue sends at most NotificationBatch per pass. DefaultLocale's
+// templates are the fallback for every other locale.
+const (
+	NotificationMaxAttempts      = 5
+	NotificationRetryBaseSeconds = 30
+	NotificationBatch            = 50
+	DefaultLocale                = "en"
+)
+
+// Templates are <kind>.<locale>.txt, defining "subject" and "text", and
+// <kind>.<locale>.html, defining "html".
+//
+//go:embed templates/email
+var emailTemplateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// EmailData is what email templates are executed with.
+type EmailData struct {
+	Name    string
+	OrderID uint
+	Status  string
+	Lines   []EmailLine
+	Total   float64 // the order's grand total
+	ShipTo  *models.Address
+}
+
+type EmailLine struct {
+	Title    string
+	Quantity int
+	Subtotal float64
+}
+
+// NotificationService emails customers about their orders. Emails are
+// rendered and queued when the order event arrives from the EventBus
+// (Attach), honouring the user's preferences, and sent by DeliverDue through
+// the MailTransport with retries, never on the request path.
+type NotificationService struct {
+	store     *storage.MemoryStore
+	transport MailTransport
+	from      string
+	templates map[string]*emailTemplate // by "kind.locale"
+	backoff   func(attempt int) time.Duration
+}
+
+func NewNotificationService(store *storage.MemoryStore, transport MailTransport, from string) *NotificationService {
+	return &NotificationService{store: store, transport: transport, from: from, templates: loadEmailTemplates(emailTemplateFS), backoff: notificationBackoff}
+}
+
+// notificationBackoff starts at NotificationRetryBaseSeconds and doubles.
+func notificationBackoff(attempt int) time.Duration {
+	return time.Duration(NotificationRetryBaseSeconds) * time.Second << (attempt - 1)
+}
+
+// loadEmailTemplates parses the embedded templates; they ship with the
+// binary, so a broken one is a programming error.
+func loadEmailTemplates(fsys fs.FS) map[string]*emailTemplate {
+	res := make(map[string]*emailTemplate)
+	paths, _ := fs.Glob(fsys, "templates/email/*.txt")
+	for _, p := range paths {
+		key := strings.TrimSuffix(path.Base(p), ".txt")
+		locale := key[strings.LastIndex(key, ".")+1:]
+		funcs := map[string]any{"money": moneyFormatter(locale)}
+		t := &emailTemplate{text: texttemplate.Must(texttemplate.New(key).Funcs(funcs).ParseFS(fsys, p))}
+		t.html = htmltemplate.Must(htmltemplate.New(key).Funcs(funcs).ParseFS(fsys, strings.TrimSuffix(p, ".txt")+".html"))
+		res[key] = t
+	}
+	return res
+}
+
+// moneyFormatter formats amounts the way the locale's readers expect.
+func moneyFormatter(locale string) func(v float64) string {
+	switch locale {
+	case "de":
+		return func(v float64) string { return strings.Replace(fmt.Sprintf("%.2f", v), ".", ",", 1) + " €" }
+	}
+	return func(v float64) string { return fmt.Sprintf("%.2f", v) }
+}
+
+// template picks the locale's variant, then its language's, then
+// DefaultLocale's.
+func (s *NotificationService) template(kind, locale string) (*emailTemplate, string) {
+	locale = strings.ToLower(locale)
+	lang, _, _ := strings.Cut(locale, "-")
+	for _, l := range []string{locale, lang, DefaultLocale} {
+		if t, ok := s.templates[kind+"."+l]; ok { return t, l }
+	}
+	return nil, ""
+}
+
+// Attach subscribes the service to bus, queueing emails for order events.
+func (s *NotificationService) Attach(bus *EventBus) {
+	bus.Subscribe("notifications", s.enqueue, models.EventOrderPlaced, models.EventOrderStatusChanged)
+}
+
+func (s *NotificationService) enqueue(ctx context.Context, ev models.DomainEvent) error {
+	kind := notificationKindFor(ev)
+	if kind == "" { return nil }
+	o, err := s.store.GetOrderByID(ev.AggregateID)
+	if err != nil { return nil } // nothing to tell about
+	prefs, err := s.store.GetNotificationPreferences(o.UserID)
+	if err != nil || !prefs.EmailEnabled || listed(kind, prefs.Muted) { return nil }
+	u, err := s.store.GetUserByID(o.UserID)
+	if err != nil || u.Email == "" { return nil }
+	e, err := s.render(kind, prefs.Locale, u, o)
+	if err != nil { slog.ErrorContext(ctx, "render notification failed", "kind", kind, "order_id", o.ID, "locale", prefs.Locale, "error", err); return nil }
+	e.EventID = ev.ID
+	_, err = s.store.EnqueueEmail(e)
+	return err
+}
+
+// notificationKindFor names the email for an order event, or returns "" for
+// events customers are not told about. Orders waiting on a payment challenge
+// are confirmed once the payment is.
+func notificationKindFor(ev models.DomainEvent) string {
+	switch ev.Type {
+	case models.EventOrderPlaced:
+		var o models.Order
+		if json.Unmarshal(ev.Data, &o) != nil { return "" }
+		switch o.Status {
+		case models.OrderPendingReview:
+			return NotifyOrderFlagged
+		case models.OrderPendingPayment:
+			return ""
+		}
+		return NotifyOrderPlaced
+	case models.EventOrderStatusChanged:
+		var c models.StatusChange
+		if json.Unmarshal(ev.Data, &c) != nil { return "" }
+		switch {
+		case c.To == models.OrderCancelled:
+			return NotifyOrderCancelled
+		case c.To == models.OrderShipped:
+			return NotifyOrderShipped
+		case c.From == models.OrderPendingPayment && c.To == models.OrderPendingReview:
+			return NotifyOrderFlagged
+		case c.From == models.OrderPendingPayment && c.To == models.OrderPlaced:
+			return NotifyOrderPlaced
+		}
+	}
+	return ""
+}
+
+func (s *NotificationService) render(kind, locale string, u *models.User, o *models.Order) (*models.EmailNotification, error) {
+	t, locale := s.template(kind, locale)
+	if t == nil { return nil, fmt.Errorf("no template for %s", kind) }
+	data := EmailData{Name: u.Name, OrderID: o.ID, Status: o.Status, Total: o.GrandTotal, ShipTo: o.ShippingAddress}
+	for _, it := range o.Items {
+		title := fmt.Sprintf("Product %d", it.ProductID)
+		if p, err := s.store.GetProductByID(it.ProductID); err == nil { title = p.Title }
+		data.Lines = append(data.Lines, EmailLine{Title: title, Quantity: it.Quantity, Subtotal: it.Subtotal})
+	}
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil { return nil, err }
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil { return nil, err }
+	if err := t.html.ExecuteTemplate(&html, "html", data); err != nil { return nil, err }
+	return &models.EmailNotification{UserID: u.ID, Kind: kind, OrderID: o.ID, To: u.Email, Locale: locale, Subject: strings.TrimSpace(subject.String()), Text: strings.TrimSpace(text.String()) + "\n", HTML: strings.TrimSpace(html.String())}, nil
+}
+
+// DeliverDue sends every email that is due and returns how many were sent.
+func (s *NotificationService) DeliverDue(ctx context.Context) int {
+	n := 0
+	_, domain, _ := strings.Cut(s.from, "@")
+	domain = strings.TrimSuffix(domain, ">")
+	for _, e := range s.store.DueEmails(time.Now(), NotificationBatch) {
+		err := s.transport.Send(ctx, Email{From: s.from, To: e.To, Subject: e.Subject, Text: e.Text, HTML: e.HTML, MessageID: fmt.Sprintf("<notification-%d@%s>", e.ID, domain)})
+		if err == nil {
+			s.store.MarkEmailSent(e.ID, time.Now())
+			n++
+			continue
+		}
+		next := time.Now().Add(s.backoff(e.Attempts + 1))
+		if e.Attempts+1 >= NotificationMaxAttempts { next = time.Time{} }
+		s.store.RetryEmail(e.ID, err.Error(), next)
+	}
+	return n
+}
+
+// RunDeliveries calls DeliverDue every interval until ctx is done.
+func (s *NotificationService) RunDeliveries(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.DeliverDue(ctx)
+		}
+	}
+}
+
+func (s *NotificationService) GetPreferences(ctx context.Context, req *dto.GetNotificationPreferencesRequest) (*dto.NotificationPreferences, error) {
+	_ = ctx
+	return s.store.GetNotificationPreferences(req.UserID)
+}
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+// UpdatePreferences changes the fields that are set in the request.
+func (s *NotificationService) UpdatePreferences(ctx context.Context, req *dto.NotificationPreferencesRequest) (*dto.NotificationPreferences, error) {
+	_ = ctx
+	p, err := s.store.GetNotificationPreferences(req.UserID)
+	if err != nil { return nil, err }
+	if req.EmailEnabled != nil { p.EmailEnabled = *req.EmailEnabled }
+	if req.Muted != nil {
+		p.Muted = []string{}
+		for _, k := range req.Muted {
+			if !listed(k, notificationKinds) { return nil, fmt.Errorf("unknown notification %q; kinds are %s", k, strings.Join(notificationKinds, ", ")) }
+			if !listed(k, p.Muted) { p.Muted = append(p.Muted, k) }
+		}
+	}
+	if req.Locale != nil {
+		if *req.Locale != "" && !localePattern.MatchString(*req.Locale) { return nil, errors.New("locale must look like \"de\" or \"en-GB\"") }
+		p.Locale = *req.Locale
+	}
+	return s.store.SaveNotificationPreferences(p)
+}
+
+// ListEmails is the email log, newest first.
+func (s *NotificationService) ListEmails(ctx context.Context, req *dto.ListEmailsRequest) ([]*dto.EmailNotification, error) {
+	_ = ctx
+	status := strings.ToUpper(strings.TrimSpace(req.Status))
+	if status != "" && status != models.EmailPending && status != models.EmailSent && status != models.EmailFailed { return nil, errors.New("status must be PENDING, SENT or FAILED") }
+	return s.store.GetEmails(req.UserID, status), nil
+}
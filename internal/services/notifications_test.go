@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// readMaildir parses the messages delivered to dir/new.
+func readMaildir(t *testing.T, dir string) []*mail.Message {
+	t.Helper()
+	entries, _ := os.ReadDir(filepath.Join(dir, "new"))
+	var res []*mail.Message
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(dir, "new", e.Name()))
+		if err != nil { t.Fatalf("open: %v", err) }
+		defer f.Close()
+		m, err := mail.ReadMessage(f)
+		if err != nil { t.Fatalf("parse %s: %v", e.Name(), err) }
+		res = append(res, m)
+	}
+	return res
+}
+
+// mailParts returns a multipart/alternative message's bodies by content type.
+func mailParts(t *testing.T, m *mail.Message) map[string]string {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil { t.Fatalf("content type: %v", err) }
+	res := map[string]string{}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF { return res }
+		if err != nil { t.Fatalf("part: %v", err) }
+		typ, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(p) // NextPart decodes quoted-printable
+		res[typ] = string(body)
+	}
+}
+
+func decodedSubject(m *mail.Message) string {
+	s, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	return s
+}
+
+func TestNotifications_OrderEmailsWithPreferences(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	for bus.Dispatch(ctx) > 0 {}
+	dir := t.TempDir()
+	svc := NewNotificationService(store, &MaildirTransport{Dir: dir}, "Book Store <orders@bookstore.example>")
+	svc.Attach(bus)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	bus.Dispatch(ctx)
+	if n := svc.DeliverDue(ctx); n != 1 { t.Fatalf("expected one email sent, got %d", n) }
+	msgs := readMaildir(t, dir)
+	if len(msgs) != 1 || msgs[0].Header.Get("To") != "john@email.com" || decodedSubject(msgs[0]) != "Your order #1 is confirmed" { t.Fatalf("unexpected messages %+v", msgs) }
+	parts := mailParts(t, msgs[0])
+	p, _ := store.GetProductByID(1)
+	if !strings.Contains(parts["text/plain"], "2 x "+p.Title) || !strings.Contains(parts["text/html"], "<table>") { t.Fatalf("unexpected bodies %q", parts) }
+
+	// muted kinds are skipped
+	if _, err := svc.UpdatePreferences(ctx, &dto.NotificationPreferencesRequest{UserID: 1, Muted: []string{"order_lost"}}); err == nil { t.Fatalf("expected unknown kind rejected") }
+	if _, err := svc.UpdatePreferences(ctx, &dto.NotificationPreferencesRequest{UserID: 1, Muted: []string{NotifyOrderShipped}}); err != nil { t.Fatalf("preferences: %v", err) }
+	orderSvc.ShipOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	bus.Dispatch(ctx)
+	if n := svc.DeliverDue(ctx); n != 0 { t.Fatalf("expected the muted email skipped, sent %d", n) }
+
+	// nothing while email is off; German once it is back on
+	de, off, on := "de-AT", false, true
+	svc.UpdatePreferences(ctx, &dto.NotificationPreferencesRequest{UserID: 2, Locale: &de, EmailEnabled: &off})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 2, Quantity: 1})
+	second, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2})
+	if err != nil { t.Fatalf("place: %v", err) }
+	bus.Dispatch(ctx)
+	svc.UpdatePreferences(ctx, &dto.NotificationPreferencesRequest{UserID: 2, EmailEnabled: &on})
+	orderSvc.CancelOrder(ctx, &dto.OrderActionRequest{ID: second.ID})
+	bus.Dispatch(ctx)
+	bus.Dispatch(ctx) // replays queue nothing twice
+	if n := svc.DeliverDue(ctx); n != 1 { t.Fatalf("expected one email, sent %d", n) }
+	emails, _ := svc.ListEmails(ctx, &dto.ListEmailsRequest{UserID: 2})
+	if len(emails) != 1 || emails[0].Kind != NotifyOrderCancelled || emails[0].Locale != "de" || emails[0].Subject != "Ihre Bestellung #2 wurde storniert" || emails[0].Status != models.EmailSent { t.Fatalf("unexpected log %+v", emails) }
+	if !strings.Contains(emails[0].Text, " €") { t.Fatalf("expected euro amounts in German, got %q", emails[0].Text) }
+
+	// a capped history keeps the newest sent emails, which still deduplicate
+	store.SetEmailHistory(1)
+	if all, _ := svc.ListEmails(ctx, &dto.ListEmailsRequest{}); len(all) != 1 || all[0].ID != emails[0].ID { t.Fatalf("expected only the newest email kept, got %+v", all) }
+	if again, _ := store.EnqueueEmail(&models.EmailNotification{UserID: 2, Kind: NotifyOrderCancelled, EventID: emails[0].EventID}); again.ID != emails[0].ID { t.Fatalf("expected the kept email returned, got %+v", again) }
+}
+
+func TestNotifications_KindsForOrderEvents(t *testing.T) {
+	placed := func(status string) models.DomainEvent {
+		data, _ := json.Marshal(models.Order{Status: status})
+		return models.DomainEvent{Type: models.EventOrderPlaced, Data: data}
+	}
+	changed := func(from, to string) models.DomainEvent {
+		data, _ := json.Marshal(models.StatusChange{From: from, To: to})
+		return models.DomainEvent{Type: models.EventOrderStatusChanged, Data: data}
+	}
+	cases := []struct {
+		ev   models.DomainEvent
+		want string
+	}{
+		{placed(models.OrderPlaced), NotifyOrderPlaced},
+		{placed(models.OrderPendingReview), NotifyOrderFlagged},
+		{placed(models.OrderPendingPayment), ""},
+		{changed(models.OrderPendingPayment, models.OrderPlaced), NotifyOrderPlaced},
+		{changed(models.OrderPendingPayment, models.OrderPendingReview), NotifyOrderFlagged},
+		{changed(models.OrderPendingReview, models.OrderPlaced), ""},
+		{changed(models.OrderPlaced, models.OrderShipped), NotifyOrderShipped},
+		{changed(models.OrderPendingReview, models.OrderCancelled), NotifyOrderCancelled},
+		{changed(models.OrderShipped, models.OrderDelivered), ""},
+	}
+	for _, c := range cases {
+		if got := notificationKindFor(c.ev); got != c.want { t.Errorf("%s %s: expected %q, got %q", c.ev.Type, c.ev.Data, c.want, got) }
+	}
+}
+
+// fakeSMTP accepts one SMTP session and returns the DATA it received. Like a
+// real relay it refuses envelope addresses that are not bare addresses.
+func fakeSMTP(t *testing.T) (addr string, data <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatalf("listen: %v", err) }
+	got := make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil { return }
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var body strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil { return }
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"), strings.HasPrefix(cmd, "RCPT TO:"):
+				arg := strings.TrimSpace(line[strings.Index(line, ":")+1:])
+				if addr, ok := strings.CutPrefix(arg, "<"); ok && strings.HasSuffix(addr, ">") && !strings.ContainsAny(addr[:len(addr)-1], "<> ") {
+					reply("250 OK")
+				} else {
+					reply("501 malformed address " + arg)
+				}
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" { break }
+					body.WriteString(l)
+				}
+				got <- body.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestNotifications_SMTPTransport(t *testing.T) {
+	addr, data := fakeSMTP(t)
+	err := (&SMTPTransport{Addr: addr}).Send(context.Background(), Email{From: "Book Store <orders@bookstore.example>", To: "john@email.com", Subject: "Bestätigung", Text: "Hallo\n", HTML: "<p>Hallo</p>", MessageID: "<n-1@bookstore.example>"})
+	if err != nil { t.Fatalf("send: %v", err) }
+	m, err := mail.ReadMessage(strings.NewReader(<-data))
+	if err != nil { t.Fatalf("parse: %v", err) }
+	parts := mailParts(t, m)
+	if decodedSubject(m) != "Bestätigung" || m.Header.Get("Message-Id") != "<n-1@bookstore.example>" || parts["text/plain"] != "Hallo\r\n" || parts["text/html"] != "<p>Hallo</p>" || m.Header.Get("From") != "Book Store <orders@bookstore.example>" { t.Fatalf("unexpected message %v %q", m.Header, parts) }
+}
+
+type failingTransport struct{ calls int }
+
+func (f *failingTransport) Send(ctx context.Context, m Email) error { f.calls++; return errors.New("relay unavailable") }
+
+func TestNotifications_RetriesThenFails(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	for bus.Dispatch(ctx) > 0 {}
+	transport := &failingTransport{}
+	svc := NewNotificationService(store, transport, "orders@bookstore.example")
+	svc.backoff = func(int) time.Duration { return 0 }
+	svc.Attach(bus)
+	NewCartService(store).AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1})
+	if _, err := NewOrderService(store).PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1}); err != nil { t.Fatalf("place: %v", err) }
+	bus.Dispatch(ctx)
+	for i := 0; i < NotificationMaxAttempts+2; i++ { svc.DeliverDue(ctx) }
+	failed, _ := svc.ListEmails(ctx, &dto.ListEmailsRequest{Status: "failed"})
+	if transport.calls != NotificationMaxAttempts || len(failed) != 1 || failed[0].Attempts != NotificationMaxAttempts || failed[0].LastError != "relay unavailable" { t.Fatalf("calls=%d failed=%+v", transport.calls, failed) }
+	if d := notificationBackoff(3); d != 4*NotificationRetryBaseSeconds*time.Second { t.Fatalf("unexpected backoff %v", d) }
+}
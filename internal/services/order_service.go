@@ -3,24 +3,82 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
 	"ecom-book-store-sample-api/internal/storage"
 )
 
-type OrderService struct { store *storage.MemoryStore }
+type OrderService struct {
+	store        *storage.MemoryStore
+	pricer       *Pricer
+	payments     PaymentProvider
+	returnWindow time.Duration
+	// sagaStaleAfter is how long a RUNNING or COMPENSATING saga must have
+	// made no progress before RecoverSaga may take it over.
+	sagaStaleAfter time.Duration
+	placed       []func(ctx context.Context, o *models.Order)
+	rejected     rejectionListeners
+	// sagaHook, when set, runs before each checkout step; an error stops the
+	// saga where it is, as a crash would. Used by tests.
+	sagaHook func(step string) error
+}
 
-func NewOrderService(store *storage.MemoryStore) *OrderService { return &OrderService{store: store} }
+// NewOrderService uses the default pricer and an approving
+// FakePaymentProvider.
+func NewOrderService(store *storage.MemoryStore) *OrderService { return &OrderService{store: store, pricer: DefaultPricer(), payments: NewFakePaymentProvider(), returnWindow: ReturnWindowDays * 24 * time.Hour, sagaStaleAfter: CheckoutSagaStaleSeconds * time.Second} }
 
-func (s *OrderService) PlaceOrder(ctx context.Context, req *dto.PlaceOrderRequest) (*dto.Order, error) {
+// SetPaymentProvider replaces the gateway used at checkout.
+func (s *OrderService) SetPaymentProvider(p PaymentProvider) { s.payments = p }
+
+// SetPricer replaces the tax and shipping calculators used at checkout.
+func (s *OrderService) SetPricer(p *Pricer) { s.pricer = p }
+
+// CheckRules reports whether the rule configuration the service runs with is
+// usable: the business rules agree with each other, the pricer's tiers and
+// rates make sense, a payment provider is set and the return and saga
+// windows are positive. /readyz runs it as the "rules" check.
+func (s *OrderService) CheckRules(ctx context.Context) error {
 	_ = ctx
+	errs := []error{ValidateRules()}
+	if err := s.pricer.Validate(); err != nil { errs = append(errs, fmt.Errorf("pricer: %w", err)) }
+	if s.payments == nil { errs = append(errs, errors.New("no payment provider")) }
+	if s.returnWindow <= 0 { errs = append(errs, errors.New("return window must be positive")) }
+	if s.sagaStaleAfter <= 0 { errs = append(errs, errors.New("saga stale time must be positive")) }
+	return errors.Join(errs...)
+}
+
+// OnRuleRejected registers a listener for checkouts refused by a business
+// rule.
+func (s *OrderService) OnRuleRejected(fn func(ctx context.Context, r models.RuleRejection)) { s.rejected = append(s.rejected, fn) }
+
+// PlaceOrder validates the cart and runs checkout as a CheckoutSaga (see
+// checkout_saga.go). An authorization that needs a customer challenge leaves
+// the order PENDING_PAYMENT until the provider confirms it. Retrying with the
+// IdempotencyKey of a completed checkout returns its order. Rule rejections
+// are reported to OnRuleRejected listeners.
+func (s *OrderService) PlaceOrder(ctx context.Context, req *dto.PlaceOrderRequest) (*dto.Order, error) {
+	o, err := s.placeOrder(ctx, req)
+	s.rejected.notify(ctx, err, models.RuleRejection{Operation: models.OperationPlaceOrder, UserID: req.UserID})
+	return o, err
+}
+
+func (s *OrderService) placeOrder(ctx context.Context, req *dto.PlaceOrderRequest) (*dto.Order, error) {
+	// answer replays before validating a cart the first checkout emptied;
+	// CreateSaga below is what keeps two checkouts with one key apart
+	if req.IdempotencyKey != "" {
+		if sg, err := s.store.FindSaga(req.UserID, req.IdempotencyKey); err == nil && sg.Status != models.SagaCompensated {
+			return s.replayCheckout(sg)
+		}
+	}
 	// Duplicate order guard
 	orders, _ := s.store.GetOrdersByUser(req.UserID)
 	if len(orders) > 0 {
 		last := orders[len(orders)-1]
 		if time.Since(last.CreatedAt) <= time.Duration(DuplicateOrderWindowSec)*time.Second {
-			return nil, errors.New("duplicate order detected")
+			return nil, ruleViolation("duplicate_order", "duplicate order detected")
 		}
 	}
 	// Validate cart and compute total without mutating stock/cart
@@ -32,37 +90,154 @@ func (s *OrderService) PlaceOrder(ctx context.Context, req *dto.PlaceOrderReques
 		for _, it := range cart.Items {
 			p, err := s.store.GetProductByID(it.ProductID)
 			if err != nil { return nil, err }
-			if p.IsSpecial { return nil, errors.New("special items must be purchased alone") }
+			if p.IsSpecial { return nil, ruleViolation("special_item_alone", "special items must be purchased alone") }
 		}
 	}
-	total := 0.0
 	for _, it := range cart.Items {
 		p, err := s.store.GetProductByID(it.ProductID)
 		if err != nil { return nil, err }
 		if it.Quantity <= 0 { return nil, errors.New("invalid cart item quantity") }
-		if p.IsSpecial && it.Quantity != 1 { return nil, errors.New("special items must have quantity 1") }
-		if it.UnitPrice != 0 && it.UnitPrice != p.Price { return nil, errors.New("prices changed, refresh cart") }
-		if p.Stock < it.Quantity { return nil, errors.New("insufficient stock for product") }
-		total += float64(it.Quantity) * p.Price
+		if p.IsSpecial && it.Quantity != 1 { return nil, ruleViolation("special_item_quantity", "special items must have quantity 1") }
+		if it.UnitPrice != 0 && it.UnitPrice != p.Price { return nil, ruleViolation("price_drift", "prices changed, refresh cart") }
+		available, err := s.store.AvailableToUser(p.ID, req.UserID)
+		if err != nil { return nil, err }
+		backorderable, err := s.store.BackorderCapacity(p.ID)
+		if err != nil { return nil, err }
+		if available+backorderable < it.Quantity { return nil, ruleViolation("insufficient_stock", "insufficient stock for product") }
 	}
-	if total < MinOrderAmount { return nil, errors.New("order total below minimum") }
+	shipTo, billTo, err := s.orderAddresses(req)
+	if err != nil { return nil, err }
+	// MinOrderAmount and the spend cap apply to the discounted merchandise
+	// total, before shipping and tax
+	now := time.Now()
+	if err := s.pricer.Price(s.store, cart, Destination{Country: shipTo.Country, Region: shipTo.Region}, now); err != nil { return nil, err }
+	total := cart.Total
+	if total < MinOrderAmount { return nil, ruleViolation("min_order_amount", "order total below minimum") }
 	// Daily spend cap
 	// sum today's orders totals
 	todayTotal := 0.0
-	now := time.Now()
 	for _, o := range orders {
-		if sameDay(now, o.CreatedAt) {
+		if sameDay(now, o.CreatedAt) && o.Status != models.OrderCancelled {
 			todayTotal += o.Total
 		}
 	}
-	if todayTotal+total > DailyUserSpendCap { return nil, errors.New("daily spend limit reached") }
-	// Reserve stock and create order
-	order, err := s.store.ReserveStockForOrder(req.UserID)
+	if todayTotal+total > DailyUserSpendCap { return nil, ruleViolation("daily_spend_cap", "daily spend limit reached") }
+	draft := &models.Order{UserID: req.UserID, Discounts: cart.Discounts, Shipping: cart.Shipping, TaxLines: cart.TaxLines, Tax: cart.Tax, GrandTotal: cart.GrandTotal, ShippingAddress: shipTo, BillingAddress: billTo}
+	sg, created, err := s.store.CreateSaga(newCheckoutSaga(ctx, req.UserID, req.IdempotencyKey, draft, cart.Items))
 	if err != nil { return nil, err }
-	if order.Total > HighValueReviewThreshold {
-		order.Status = "PENDING_REVIEW"
+	if !created { return s.replayCheckout(sg) }
+	defer s.store.ReleaseSaga(sg.ID)
+	return s.runSaga(ctx, sg)
+}
+
+// replayCheckout answers a checkout retried with the idempotency key of sg:
+// the order once it completed, ErrCheckoutInProgress until then.
+func (s *OrderService) replayCheckout(sg *models.CheckoutSaga) (*dto.Order, error) {
+	if sg.Status == models.SagaCompleted { return s.store.GetOrderByID(sg.OrderID) }
+	return nil, ErrCheckoutInProgress
+}
+
+// orderAddresses resolves the shipping and billing addresses for checkout
+// from the request's IDs or the user's defaults. Billing falls back to the
+// shipping address.
+func (s *OrderService) orderAddresses(req *dto.PlaceOrderRequest) (shipTo, billTo *models.Address, err error) {
+	defShip, defBill := s.store.GetDefaultAddresses(req.UserID)
+	shipTo = defShip
+	if req.ShippingAddressID != 0 {
+		if shipTo, err = s.store.GetAddress(req.UserID, req.ShippingAddressID); err != nil { return nil, nil, errors.New("shipping address not found") }
+	}
+	if shipTo == nil { return nil, nil, errors.New("shipping address is required") }
+	billTo = defBill
+	if req.BillingAddressID != 0 {
+		if billTo, err = s.store.GetAddress(req.UserID, req.BillingAddressID); err != nil { return nil, nil, errors.New("billing address not found") }
 	}
-	return s.store.CreateOrder(order)
+	if billTo == nil { billTo = shipTo }
+	return shipTo, billTo, nil
+}
+
+// placedStatus is the status of an order whose payment is authorized:
+// high-value orders wait for review.
+func placedStatus(o *models.Order) string {
+	if o.Total > HighValueReviewThreshold { return models.OrderPendingReview }
+	return models.OrderPlaced
+}
+
+func (s *OrderService) GetOrder(ctx context.Context, req *dto.GetOrderRequest) (*dto.Order, error) {
+	_ = ctx
+	o, err := s.store.GetOrderByID(req.ID)
+	if err != nil { return nil, err }
+	if req.UserID != 0 && o.UserID != req.UserID { return nil, storage.ErrOrderNotFound }
+	return o, nil
+}
+
+// ApproveOrder releases an order held for high-value review.
+func (s *OrderService) ApproveOrder(ctx context.Context, req *dto.OrderActionRequest) (*dto.Order, error) {
+	return s.store.ApproveOrder(ctx, req.ID)
+}
+
+// ShipOrder captures the authorized payment and marks the order shipped.
+// Orders with units still on backorder cannot ship. The order is claimed in
+// the store before the capture, so a concurrent ship or cancel of it fails
+// instead of capturing twice or voiding a payment being captured.
+func (s *OrderService) ShipOrder(ctx context.Context, req *dto.OrderActionRequest) (*dto.Order, error) {
+	o, err := s.store.ClaimOrderAction(req.ID, storage.OrderActionShip)
+	if err != nil { return nil, err }
+	res, err := s.shipClaimed(ctx, o)
+	if err != nil { s.store.ReleaseOrderAction(o.ID, storage.OrderActionShip) }
+	return res, err
+}
+
+func (s *OrderService) shipClaimed(ctx context.Context, o *models.Order) (*dto.Order, error) {
+	for _, it := range o.Items {
+		if it.Backordered > 0 { return nil, errors.New("order has backordered items") }
+	}
+	var payment *models.Payment
+	if o.Payment != nil {
+		if o.Payment.Status != models.PaymentAuthorized && o.Payment.Status != models.PaymentCaptured { return nil, fmt.Errorf("payment is %s", o.Payment.Status) }
+		var err error
+		if payment, err = s.payments.Capture(ctx, o.Payment.AuthorizationID, o.Payment.Amount, fmt.Sprintf("capture-order-%d", o.ID)); err != nil { return nil, err }
+	}
+	return s.store.ShipOrder(ctx, o.ID, payment)
+}
+
+// CancelOrder voids the payment of an unshipped order and returns its stock.
+// Like ShipOrder it claims the order before calling the provider.
+func (s *OrderService) CancelOrder(ctx context.Context, req *dto.OrderActionRequest) (*dto.Order, error) {
+	o, err := s.store.ClaimOrderAction(req.ID, storage.OrderActionCancel)
+	if err != nil { return nil, err }
+	res, err := s.cancelClaimed(ctx, o, req.Actor)
+	if err != nil { s.store.ReleaseOrderAction(o.ID, storage.OrderActionCancel) }
+	return res, err
+}
+
+func (s *OrderService) cancelClaimed(ctx context.Context, o *models.Order, actor string) (*dto.Order, error) {
+	var payment *models.Payment
+	if o.Payment != nil && (o.Payment.Status == models.PaymentAuthorized || o.Payment.Status == models.PaymentRequiresAction) {
+		var err error
+		if payment, err = s.payments.Void(ctx, o.Payment.AuthorizationID, fmt.Sprintf("void-order-%d", o.ID)); err != nil { return nil, err }
+	}
+	return s.store.CancelOrder(ctx, o.ID, payment, actor)
+}
+
+// HandlePaymentEvent applies an asynchronous provider notification. The
+// event only names the authorization; its state is looked up again from the
+// provider. A confirmed challenge releases a PENDING_PAYMENT order and a
+// failed one cancels it.
+func (s *OrderService) HandlePaymentEvent(ctx context.Context, ev *dto.PaymentEvent) error {
+	o, err := s.store.GetOrderByAuthorization(ev.AuthorizationID)
+	if err != nil { return err }
+	p, err := s.payments.Lookup(ctx, ev.AuthorizationID)
+	if err != nil { return err }
+	switch {
+	case o.Status == models.OrderPendingPayment && p.Status == models.PaymentAuthorized:
+		_, err = s.store.UpdateOrderPayment(ctx, o.ID, *p, placedStatus(o), models.OrderPendingPayment)
+	case o.Status == models.OrderPendingPayment && p.Status == models.PaymentFailed:
+		if _, err = s.store.ClaimOrderAction(o.ID, storage.OrderActionCancel); err != nil { return err }
+		if _, err = s.store.CancelOrder(ctx, o.ID, p, storage.ActorSystem); err != nil { s.store.ReleaseOrderAction(o.ID, storage.OrderActionCancel) }
+	default:
+		_, err = s.store.UpdateOrderPayment(ctx, o.ID, *p, "")
+	}
+	return err
 }
 
 func sameDay(a, b time.Time) bool {
@@ -71,3 +246,16 @@ func sameDay(a, b time.Time) bool {
 	return y1 == y2 && m1 == m2 && d1 == d2
 }
 
+
+// expectedShipDate promises a dispatch date for a line: the release date for
+// pre-orders, the product's backorder lead time when units are backordered,
+// and InStockShipDays otherwise.
+func expectedShipDate(p *models.Product, backordered bool, now time.Time) time.Time {
+	if p.IsPreOrder(now) { return *p.ReleaseDate }
+	if backordered {
+		lead := p.BackorderLeadDays
+		if lead == 0 { lead = DefaultBackorderLeadDays }
+		return now.AddDate(0, 0, lead)
+	}
+	return now.AddDate(0, 0, InStockShipDays)
+}
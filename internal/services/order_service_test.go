@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"testing"
+	"time"
 
 	"ecom-book-store-sample-api/internal/dto"
 	"ecom-book-store-sample-api/internal/models"
@@ -96,7 +97,7 @@ func TestOrderService_DuplicateAndPriceDriftAndSpecialAndDailyCap(t *testing.T)
 	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: big.ID, Quantity: 1}); err != nil { t.Fatalf("add big u2: %v", err) }
 	if _, err := svc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2}); err != nil { t.Fatalf("place big u2: %v", err) }
 	// simulate second order without waiting duplicate window
-	if _, err := store.CreateOrder(&models.Order{UserID: 2, Items: []models.OrderItem{}, Total: 5000, Status: "PLACED"}); err != nil {
+	if _, err := store.CreateOrder(ctx, &models.Order{UserID: 2, Items: []models.OrderItem{}, Total: 5000, Status: "PLACED"}); err != nil {
 		t.Fatalf("create direct order: %v", err)
 	}
 	// now any positive order should exceed daily cap
@@ -107,3 +108,51 @@ func TestOrderService_DuplicateAndPriceDriftAndSpecialAndDailyCap(t *testing.T)
 	}
 }
 
+
+func TestOrderService_BackordersAndPreOrders(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	store.EnableStockHolds(time.Minute)
+	prodSvc := NewProductService(store)
+	cartSvc := NewCartService(store)
+	inv := NewInventoryService(store)
+	svc := NewOrderService(store)
+
+	// flags are validated
+	if _, err := prodSvc.CreateProduct(ctx, &dto.CreateProductRequest{Title: "B", Author: "A", Price: 20, Stock: 2, AllowBackorder: true}); err == nil {
+		t.Fatalf("expected maxBackorder required error")
+	}
+	reprint, err := prodSvc.CreateProduct(ctx, &dto.CreateProductRequest{Title: "Reprint", Author: "A", Price: 20, Stock: 2, AllowBackorder: true, MaxBackorder: 5, BackorderLeadDays: 10})
+	if err != nil { t.Fatalf("create: %v", err) }
+
+	// 2 from stock + 2 backordered
+	cart, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: reprint.ID, Quantity: 4})
+	if err != nil { t.Fatalf("add u1: %v", err) }
+	if cart.Items[0].Backordered != 2 || cart.Items[0].ExpectedShipDate.Before(time.Now().AddDate(0, 0, 9)) { t.Fatalf("unexpected cart line: %+v", cart.Items[0]) }
+	o1, err := svc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place u1: %v", err) }
+	if o1.Items[0].Backordered != 2 || o1.Items[0].Allocations[0].Quantity != 2 { t.Fatalf("unexpected order line: %+v", o1.Items[0]) }
+
+	// nothing in stock: 3 more fit the cap of 5, a 6th does not
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: reprint.ID, Quantity: 3}); err != nil { t.Fatalf("add u2: %v", err) }
+	if _, err := svc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2}); err != nil { t.Fatalf("place u2: %v", err) }
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 3, ProductID: reprint.ID, Quantity: 1}); err == nil { t.Fatalf("expected backorder cap error") }
+
+	// a receipt of 4 fills user 1 completely, then user 2 partially
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: reprint.ID, Type: models.MovementReceipt, Quantity: 4}); err != nil { t.Fatalf("receipt: %v", err) }
+	u1, _ := store.GetOrdersByUser(1)
+	u2, _ := store.GetOrdersByUser(2)
+	if u1[0].Items[0].Backordered != 0 || u2[0].Items[0].Backordered != 1 { t.Fatalf("expected FIFO fill, got u1=%+v u2=%+v", u1[0].Items[0], u2[0].Items[0]) }
+	p, _ := prodSvc.GetProduct(ctx, &dto.GetProductRequest{ID: reprint.ID})
+	if p.Stock != 0 || p.Backordered != 1 { t.Fatalf("unexpected product after fill: %+v", p) }
+
+	// pre-order ships on the release date, even at zero stock
+	release := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	pre, err := prodSvc.CreateProduct(ctx, &dto.CreateProductRequest{Title: "Sequel", Author: "A", Price: 25, Stock: 0, MaxBackorder: 100, ReleaseDate: &release})
+	if err != nil { t.Fatalf("create pre-order: %v", err) }
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 3, ProductID: pre.ID, Quantity: 2}); err != nil { t.Fatalf("add pre-order: %v", err) }
+	o3, err := svc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 3})
+	if err != nil { t.Fatalf("place pre-order: %v", err) }
+	if !o3.Items[0].ExpectedShipDate.Equal(release) || o3.Items[0].Backordered != 2 { t.Fatalf("unexpected pre-order line: %+v", o3.Items[0]) }
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrPaymentDeclined = errors.New("payment declined")
+	// ErrPaymentTimeout means the outcome is unknown; retrying with the same
+	// idempotency key returns whatever the provider recorded.
+	ErrPaymentTimeout = errors.New("payment provider timed out")
+)
+
+// PaymentRequest asks a provider to authorize Amount for a checkout.
+type PaymentRequest struct {
+	Reference      string // our reference, e.g. the checkout idempotency key
+	UserID         uint
+	Amount         float64
+	IdempotencyKey string
+}
+
+// PaymentProvider is a card gateway. Every mutating call takes an
+// idempotency key: repeating a call with the same key returns the first
+// call's outcome instead of acting twice. Providers report outcomes that
+// complete later (such as a customer challenge) as PaymentEvents; Lookup
+// returns the authoritative state.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req PaymentRequest) (*models.Payment, error)
+	Capture(ctx context.Context, authorizationID string, amount float64, idempotencyKey string) (*models.Payment, error)
+	Void(ctx context.Context, authorizationID, idempotencyKey string) (*models.Payment, error)
+	Refund(ctx context.Context, authorizationID string, amount float64, idempotencyKey string) (*models.Payment, error)
+	Lookup(ctx context.Context, authorizationID string) (*models.Payment, error)
+}
+
+// Fake provider behaviours for authorizations.
+const (
+	FakeApprove   = "approve"
+	FakeDecline   = "decline"
+	FakeTimeout   = "timeout"   // authorizes, but the caller sees ErrPaymentTimeout
+	FakeChallenge = "challenge" // requires a 3-D Secure style challenge first
+)
+
+// FakePaymentChallengePath prefixes the challenge URLs handed out by
+// FakePaymentProvider.
+const FakePaymentChallengePath = "/api/v1/payments/fake/challenges/"
+
+type fakeResponse struct {
+	payment models.Payment
+	err     error
+}
+
+// FakePaymentProvider is a deterministic in-process gateway for tests and
+// local runs. Authorization IDs are sequential and the outcome of new
+// authorizations follows the mode set with SetMode (FakeApprove by default).
+type FakePaymentProvider struct {
+	mu      sync.Mutex
+	mode    string
+	nextID  int
+	auths   map[string]*models.Payment
+	replies map[string]fakeResponse // operation+idempotency key -> first outcome
+
+	// Notify, when set, receives confirmations that complete after the
+	// original call, as a provider's webhook would.
+	Notify func(ctx context.Context, ev models.PaymentEvent) error
+}
+
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{mode: FakeApprove, nextID: 1, auths: make(map[string]*models.Payment), replies: make(map[string]fakeResponse)}
+}
+
+func (f *FakePaymentProvider) Name() string { return "fake" }
+
+// SetMode changes how new authorizations behave.
+func (f *FakePaymentProvider) SetMode(mode string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mode = mode
+}
+
+func (f *FakePaymentProvider) Authorize(ctx context.Context, req PaymentRequest) (*models.Payment, error) {
+	_ = ctx
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.once("authorize", req.IdempotencyKey, func() (models.Payment, error) {
+		if req.Amount <= 0 { return models.Payment{}, errors.New("invalid payment amount") }
+		p := &models.Payment{Provider: f.Name(), AuthorizationID: fmt.Sprintf("fake_auth_%d", f.nextID), Amount: req.Amount, UpdatedAt: time.Now()}
+		f.nextID++
+		switch f.mode {
+		case FakeDecline:
+			p.Status = models.PaymentFailed
+			f.auths[p.AuthorizationID] = p
+			return *p, ErrPaymentDeclined
+		case FakeChallenge:
+			p.Status = models.PaymentRequiresAction
+			p.ChallengeURL = FakePaymentChallengePath + p.AuthorizationID
+		default:
+			p.Status = models.PaymentAuthorized
+		}
+		f.auths[p.AuthorizationID] = p
+		return *p, nil
+	}, func(r *fakeResponse) {
+		// the authorization went through; only the first caller lost the reply
+		if f.mode == FakeTimeout && r.err == nil { r.err = ErrPaymentTimeout }
+	})
+}
+
+func (f *FakePaymentProvider) Capture(ctx context.Context, authorizationID string, amount float64, idempotencyKey string) (*models.Payment, error) {
+	_ = ctx
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.once("capture", idempotencyKey, func() (models.Payment, error) {
+		p, ok := f.auths[authorizationID]
+		if !ok { return models.Payment{}, errors.New("unknown authorization") }
+		if p.Status != models.PaymentAuthorized { return *p, fmt.Errorf("cannot capture a %s payment", p.Status) }
+		if amount <= 0 || amount > p.Amount { return *p, errors.New("capture amount exceeds authorization") }
+		p.Status, p.Captured, p.UpdatedAt = models.PaymentCaptured, amount, time.Now()
+		return *p, nil
+	}, nil)
+}
+
+func (f *FakePaymentProvider) Void(ctx context.Context, authorizationID, idempotencyKey string) (*models.Payment, error) {
+	_ = ctx
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.once("void", idempotencyKey, func() (models.Payment, error) {
+		p, ok := f.auths[authorizationID]
+		if !ok { return models.Payment{}, errors.New("unknown authorization") }
+		if p.Status != models.PaymentAuthorized && p.Status != models.PaymentRequiresAction { return *p, fmt.Errorf("cannot void a %s payment", p.Status) }
+		p.Status, p.ChallengeURL, p.UpdatedAt = models.PaymentVoided, "", time.Now()
+		return *p, nil
+	}, nil)
+}
+
+func (f *FakePaymentProvider) Refund(ctx context.Context, authorizationID string, amount float64, idempotencyKey string) (*models.Payment, error) {
+	_ = ctx
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.once("refund", idempotencyKey, func() (models.Payment, error) {
+		p, ok := f.auths[authorizationID]
+		if !ok { return models.Payment{}, errors.New("unknown authorization") }
+		if p.Status != models.PaymentCaptured && p.Status != models.PaymentPartiallyRefunded { return *p, fmt.Errorf("cannot refund a %s payment", p.Status) }
+		if amount <= 0 || roundMoney(p.Refunded+amount) > p.Captured { return *p, errors.New("refund exceeds captured amount") }
+		p.Refunded = roundMoney(p.Refunded + amount)
+		p.Status = models.PaymentPartiallyRefunded
+		if p.Refunded == p.Captured { p.Status = models.PaymentRefunded }
+		p.UpdatedAt = time.Now()
+		return *p, nil
+	}, nil)
+}
+
+func (f *FakePaymentProvider) Lookup(ctx context.Context, authorizationID string) (*models.Payment, error) {
+	_ = ctx
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.auths[authorizationID]
+	if !ok { return nil, errors.New("unknown authorization") }
+	v := *p
+	return &v, nil
+}
+
+// CompleteChallenge finishes a pending challenge as the customer's bank
+// would and sends the outcome to Notify.
+func (f *FakePaymentProvider) CompleteChallenge(ctx context.Context, authorizationID string, approve bool) error {
+	f.mu.Lock()
+	p, ok := f.auths[authorizationID]
+	if !ok || p.Status != models.PaymentRequiresAction { f.mu.Unlock(); return errors.New("no pending challenge") }
+	ev := models.PaymentEvent{Type: models.PaymentEventAuthorized, AuthorizationID: authorizationID}
+	p.Status, p.ChallengeURL, p.UpdatedAt = models.PaymentAuthorized, "", time.Now()
+	if !approve {
+		p.Status = models.PaymentFailed
+		ev.Type = models.PaymentEventFailed
+	}
+	notify := f.Notify
+	f.mu.Unlock()
+	if notify == nil { return nil }
+	return notify(ctx, ev)
+}
+
+// once runs op the first time an operation sees key and replays its outcome
+// afterwards. firstOnly may alter what the first caller receives without
+// changing what is replayed. Must be called with f.mu held.
+func (f *FakePaymentProvider) once(op, key string, run func() (models.Payment, error), firstOnly func(*fakeResponse)) (*models.Payment, error) {
+	if key == "" { return nil, errors.New("idempotency key is required") }
+	if r, ok := f.replies[op+"/"+key]; ok {
+		v := r.payment
+		return &v, r.err
+	}
+	p, err := run()
+	f.replies[op+"/"+key] = fakeResponse{payment: p, err: err}
+	r := fakeResponse{payment: p, err: err}
+	if firstOnly != nil { firstOnly(&r) }
+	if r.err != nil { return nil, r.err }
+	return &r.payment, nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestPayments_FakeProviderIdempotency(t *testing.T) {
+	ctx := context.Background()
+	f := NewFakePaymentProvider()
+	a, err := f.Authorize(ctx, PaymentRequest{Amount: 40, IdempotencyKey: "k1"})
+	if err != nil || a.Status != models.PaymentAuthorized { t.Fatalf("authorize: %+v %v", a, err) }
+	again, _ := f.Authorize(ctx, PaymentRequest{Amount: 40, IdempotencyKey: "k1"})
+	if again.AuthorizationID != a.AuthorizationID { t.Fatalf("expected replay, got %s and %s", a.AuthorizationID, again.AuthorizationID) }
+	if _, err := f.Capture(ctx, a.AuthorizationID, 50, "c1"); err == nil { t.Fatalf("expected over-capture error") }
+	if _, err := f.Capture(ctx, a.AuthorizationID, 40, "c2"); err != nil { t.Fatalf("capture: %v", err) }
+	if _, err := f.Void(ctx, a.AuthorizationID, "v1"); err == nil { t.Fatalf("expected void of captured payment to fail") }
+	r, err := f.Refund(ctx, a.AuthorizationID, 15, "r1")
+	if err != nil || r.Status != models.PaymentPartiallyRefunded { t.Fatalf("refund: %+v %v", r, err) }
+	r, _ = f.Refund(ctx, a.AuthorizationID, 15, "r1")
+	if r.Refunded != 15 { t.Fatalf("expected refund replay, got %v", r.Refunded) }
+	if _, err := f.Refund(ctx, a.AuthorizationID, 30, "r2"); err == nil { t.Fatalf("expected refund over capture error") }
+	r, _ = f.Refund(ctx, a.AuthorizationID, 25, "r3")
+	if r.Status != models.PaymentRefunded { t.Fatalf("expected fully refunded, got %+v", r) }
+
+	f.SetMode(FakeDecline)
+	if _, err := f.Authorize(ctx, PaymentRequest{Amount: 40, IdempotencyKey: "k2"}); !errors.Is(err, ErrPaymentDeclined) { t.Fatalf("expected decline, got %v", err) }
+	if _, err := f.Authorize(ctx, PaymentRequest{Amount: 40, IdempotencyKey: "k2"}); !errors.Is(err, ErrPaymentDeclined) { t.Fatalf("expected decline replay, got %v", err) }
+}
+
+func TestPayments_CheckoutLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	fake := NewFakePaymentProvider()
+	fake.Notify = func(ctx context.Context, ev models.PaymentEvent) error { return orderSvc.HandlePaymentEvent(ctx, &ev) }
+	orderSvc.SetPaymentProvider(fake)
+	stock := func() int { p, _ := store.GetProductByID(1); return p.Stock }
+
+	// a decline leaves stock and cart alone
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	fake.SetMode(FakeDecline)
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1}); !errors.Is(err, ErrPaymentDeclined) { t.Fatalf("expected decline, got %v", err) }
+	if stock() != 50 { t.Fatalf("stock moved on decline: %d", stock()) }
+
+	// a timeout is rolled back, voiding the authorization the provider did
+	// make, and the checkout can be retried with the same key
+	fake.SetMode(FakeTimeout)
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "co-1"}); !errors.Is(err, ErrPaymentTimeout) { t.Fatalf("expected timeout, got %v", err) }
+	if lost, _ := fake.Lookup(ctx, "fake_auth_2"); lost.Status != models.PaymentVoided { t.Fatalf("expected lost authorization voided, got %+v", lost) }
+	if stock() != 50 { t.Fatalf("stock moved on timeout: %d", stock()) }
+	fake.SetMode(FakeApprove)
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, IdempotencyKey: "co-1"})
+	if err != nil { t.Fatalf("retry: %v", err) }
+	if order.Payment.Status != models.PaymentAuthorized || order.Payment.Amount != order.GrandTotal || order.Payment.AuthorizationID != "fake_auth_3" { t.Fatalf("unexpected payment: %+v", order.Payment) }
+
+	// shipping captures; a shipped order cannot be cancelled
+	shipped, err := orderSvc.ShipOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	if err != nil { t.Fatalf("ship: %v", err) }
+	if shipped.Status != models.OrderShipped || shipped.Payment.Status != models.PaymentCaptured || shipped.Payment.Captured != order.GrandTotal { t.Fatalf("unexpected shipped order: %+v", shipped) }
+	if _, err := orderSvc.CancelOrder(ctx, &dto.OrderActionRequest{ID: order.ID}); !errors.Is(err, storage.ErrOrderStatus) { t.Fatalf("expected status error, got %v", err) }
+
+	// cancelling voids and returns the stock through the ledger
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 1, Quantity: 3})
+	o2, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 2})
+	if err != nil { t.Fatalf("place u2: %v", err) }
+	if stock() != 45 { t.Fatalf("expected 45 after two orders, got %d", stock()) }
+	cancelled, err := orderSvc.CancelOrder(ctx, &dto.OrderActionRequest{ID: o2.ID, Actor: "user:3"})
+	if err != nil { t.Fatalf("cancel: %v", err) }
+	if cancelled.Status != models.OrderCancelled || cancelled.Payment.Status != models.PaymentVoided || stock() != 48 { t.Fatalf("unexpected cancel: %+v stock=%d", cancelled, stock()) }
+	mvs, _ := store.GetInventoryMovements(1)
+	if last := mvs[len(mvs)-1]; last.Type != models.MovementCancellation || last.OrderID != o2.ID || last.Actor != "user:3" { t.Fatalf("unexpected movement: %+v", last) }
+
+	// a challenge holds the order until the provider confirms it
+	fake.SetMode(FakeChallenge)
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 3, ProductID: 1, Quantity: 1})
+	o3, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 3})
+	if err != nil { t.Fatalf("place u3: %v", err) }
+	if o3.Status != models.OrderPendingPayment || o3.Payment.ChallengeURL == "" { t.Fatalf("expected pending payment, got %+v", o3) }
+	if _, err := orderSvc.ShipOrder(ctx, &dto.OrderActionRequest{ID: o3.ID}); err == nil { t.Fatalf("expected unpaid order not to ship") }
+	if err := fake.CompleteChallenge(ctx, o3.Payment.AuthorizationID, true); err != nil { t.Fatalf("challenge: %v", err) }
+	o3, _ = orderSvc.GetOrder(ctx, &dto.GetOrderRequest{ID: o3.ID})
+	if o3.Status != models.OrderPlaced || o3.Payment.Status != models.PaymentAuthorized { t.Fatalf("expected confirmed order, got %+v", o3) }
+
+	// a failed challenge cancels the order and releases its stock
+	u, _ := store.CreateUser(&models.User{Email: "x@email.com", Name: "X"})
+	store.CreateAddress(&models.Address{UserID: u.ID, Name: "X", Line1: "1 Rue", City: "Paris", PostalCode: "75001", Country: "FR"})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: u.ID, ProductID: 1, Quantity: 2})
+	o4, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: u.ID})
+	if err != nil { t.Fatalf("place u4: %v", err) }
+	if err := fake.CompleteChallenge(ctx, o4.Payment.AuthorizationID, false); err != nil { t.Fatalf("challenge: %v", err) }
+	o4, _ = orderSvc.GetOrder(ctx, &dto.GetOrderRequest{ID: o4.ID})
+	if o4.Status != models.OrderCancelled || o4.Payment.Status != models.PaymentFailed || stock() != 47 { t.Fatalf("expected cancelled order, got %+v stock=%d", o4, stock()) }
+}
+
+// slowPayments holds each capture, void and refund until release is closed,
+// so a test can act while one is in flight with the provider, and counts
+// them.
+type slowPayments struct {
+	*FakePaymentProvider
+	started chan string
+	release chan struct{}
+	mu      sync.Mutex
+	calls   map[string]int
+}
+
+func newSlowPayments(f *FakePaymentProvider) *slowPayments {
+	return &slowPayments{FakePaymentProvider: f, started: make(chan string, 1), release: make(chan struct{}), calls: make(map[string]int)}
+}
+
+func (p *slowPayments) hold(op string) {
+	p.mu.Lock()
+	p.calls[op]++
+	p.mu.Unlock()
+	p.started <- op
+	<-p.release
+}
+
+func (p *slowPayments) count(op string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[op]
+}
+
+func (p *slowPayments) Capture(ctx context.Context, authorizationID string, amount float64, idempotencyKey string) (*models.Payment, error) {
+	p.hold("capture")
+	return p.FakePaymentProvider.Capture(ctx, authorizationID, amount, idempotencyKey)
+}
+
+func (p *slowPayments) Void(ctx context.Context, authorizationID, idempotencyKey string) (*models.Payment, error) {
+	p.hold("void")
+	return p.FakePaymentProvider.Void(ctx, authorizationID, idempotencyKey)
+}
+
+func (p *slowPayments) Refund(ctx context.Context, authorizationID string, amount float64, idempotencyKey string) (*models.Payment, error) {
+	p.hold("refund")
+	return p.FakePaymentProvider.Refund(ctx, authorizationID, amount, idempotencyKey)
+}
+
+func TestPayments_ConcurrentShipAndCancelCallProviderOnce(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+	fake := NewFakePaymentProvider()
+	orderSvc.SetPaymentProvider(fake)
+	// each order is another user's, as quick repeat orders are refused
+	place := func(userID uint) *dto.Order {
+		cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: userID, ProductID: 1, Quantity: 1})
+		o, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: userID})
+		if err != nil { t.Fatalf("place: %v", err) }
+		return o
+	}
+	// run starts action on the order and, while its provider call is held,
+	// tries to ship and to cancel the order too
+	run := func(o *dto.Order, action func(context.Context, *dto.OrderActionRequest) (*dto.Order, error)) (*slowPayments, *dto.Order) {
+		slow := newSlowPayments(fake)
+		orderSvc.SetPaymentProvider(slow)
+		done := make(chan *dto.Order)
+		go func() {
+			res, err := action(ctx, &dto.OrderActionRequest{ID: o.ID})
+			if err != nil { t.Errorf("first action: %v", err) }
+			done <- res
+		}()
+		<-slow.started
+		if _, err := orderSvc.ShipOrder(ctx, &dto.OrderActionRequest{ID: o.ID}); !errors.Is(err, storage.ErrOrderStatus) { t.Errorf("expected concurrent ship to fail, got %v", err) }
+		if _, err := orderSvc.CancelOrder(ctx, &dto.OrderActionRequest{ID: o.ID}); !errors.Is(err, storage.ErrOrderStatus) { t.Errorf("expected concurrent cancel to fail, got %v", err) }
+		close(slow.release)
+		return slow, <-done
+	}
+
+	slow, shipped := run(place(1), orderSvc.ShipOrder)
+	if shipped == nil || shipped.Status != models.OrderShipped || slow.count("capture") != 1 || slow.count("void") != 0 { t.Fatalf("ship: %+v, %d captures, %d voids", shipped, slow.count("capture"), slow.count("void")) }
+	slow, cancelled := run(place(2), orderSvc.CancelOrder)
+	if cancelled == nil || cancelled.Status != models.OrderCancelled || slow.count("capture") != 0 || slow.count("void") != 1 { t.Fatalf("cancel: %+v, %d captures, %d voids", cancelled, slow.count("capture"), slow.count("void")) }
+
+	// a failed capture gives the claim back, so a retry reaches the provider
+	o := place(3)
+	orderSvc.SetPaymentProvider(fake)
+	if _, err := fake.Void(ctx, o.Payment.AuthorizationID, "test-void"); err != nil { t.Fatalf("void: %v", err) }
+	for i := 0; i < 2; i++ {
+		if _, err := orderSvc.ShipOrder(ctx, &dto.OrderActionRequest{ID: o.ID}); err == nil || errors.Is(err, storage.ErrOrderStatus) { t.Fatalf("expected capture of a voided payment to fail, got %v", err) }
+	}
+}
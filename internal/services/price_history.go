@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+)
+
+// PriceHistory lists a product's price changes, oldest first.
+func (s *ProductService) PriceHistory(ctx context.Context, req *dto.GetPriceHistoryRequest) ([]dto.PriceChange, error) {
+	_ = ctx
+	return s.store.GetPriceHistory(req.ProductID)
+}
+
+// SchedulePrice queues a future price change. The scheduler applies it once
+// EffectiveAt has passed.
+func (s *ProductService) SchedulePrice(ctx context.Context, req *dto.SchedulePriceRequest) (*dto.ScheduledPrice, error) {
+	_ = ctx
+	if req.Price < 0.01 || req.Price > 10000 { return nil, errors.New("price out of bounds") }
+	if !req.EffectiveAt.After(time.Now()) { return nil, errors.New("effectiveAt must be in the future") }
+	return s.store.SchedulePrice(req.ProductID, req.Price, req.EffectiveAt.UTC(), req.Actor)
+}
+
+func (s *ProductService) ListScheduledPrices(ctx context.Context, req *dto.ListScheduledPricesRequest) ([]dto.ScheduledPrice, error) {
+	_ = ctx
+	return s.store.GetScheduledPrices(req.ProductID)
+}
+
+func (s *ProductService) CancelScheduledPrice(ctx context.Context, req *dto.CancelScheduledPriceRequest) error {
+	_ = ctx
+	return s.store.CancelScheduledPrice(req.ProductID, req.ID)
+}
+
+// RunPriceScheduler applies due scheduled prices every interval until ctx is
+// done.
+func (s *ProductService) RunPriceScheduler(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.store.ApplyDuePrices(ctx, now)
+		}
+	}
+}
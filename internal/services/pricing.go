@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// Destination is where an order ships, as far as pricing is concerned.
+type Destination struct {
+	Country string // ISO 3166-1 alpha-2, e.g. "GB"
+	Region  string // state/province code, e.g. "CA"; optional
+}
+
+// ShippingCalculator quotes shipping for the priced lines. merchandise is the
+// discounted merchandise total, for free-shipping thresholds.
+type ShippingCalculator interface {
+	Quote(lines []pricedLine, dest Destination, merchandise float64) float64
+}
+
+// TaxCalculator computes tax lines. taxable holds each line's amount after
+// its share of the discounts, in the order of lines.
+type TaxCalculator interface {
+	Tax(lines []pricedLine, taxable []float64, shipping float64, dest Destination) []models.TaxLine
+}
+
+// ShippingTier charges Cost when the cart's quantity (or weight) is at most
+// UpTo. A zero UpTo matches everything.
+type ShippingTier struct {
+	UpTo int
+	Cost float64
+}
+
+// TieredShipping picks the first tier the cart fits. ByWeight measures carts
+// in grams via WeightOf instead of counting units. Orders whose merchandise
+// total reaches FreeAbove ship free.
+type TieredShipping struct {
+	Tiers     []ShippingTier
+	ByWeight  bool
+	WeightOf  func(p *models.Product) int // grams per unit; nil means DefaultBookWeightGrams
+	FreeAbove float64                     // 0 disables free shipping
+}
+
+func (t *TieredShipping) Quote(lines []pricedLine, dest Destination, merchandise float64) float64 {
+	_ = dest
+	if len(lines) == 0 || (t.FreeAbove > 0 && merchandise >= t.FreeAbove) { return 0 }
+	size := 0
+	for _, l := range lines {
+		if !t.ByWeight { size += l.quantity; continue }
+		w := DefaultBookWeightGrams
+		if t.WeightOf != nil { w = t.WeightOf(l.product) }
+		size += w * l.quantity
+	}
+	for _, tier := range t.Tiers {
+		if tier.UpTo == 0 || size <= tier.UpTo { return tier.Cost }
+	}
+	if len(t.Tiers) == 0 { return 0 }
+	return t.Tiers[len(t.Tiers)-1].Cost
+}
+
+// Validate checks that every tier costs something non-negative and that the
+// tiers grow, so each one can be reached.
+func (t *TieredShipping) Validate() error {
+	for i, tier := range t.Tiers {
+		if tier.Cost < 0 { return fmt.Errorf("shipping tier %d has a negative cost", i) }
+		if tier.UpTo < 0 || (i > 0 && (t.Tiers[i-1].UpTo == 0 || tier.UpTo != 0 && tier.UpTo <= t.Tiers[i-1].UpTo)) { return fmt.Errorf("shipping tier %d is unreachable", i) }
+	}
+	if t.FreeAbove < 0 { return errors.New("free shipping threshold is negative") }
+	return nil
+}
+
+// TaxRate is one jurisdiction's rates. Books are often zero-rated or reduced,
+// so they carry their own rate; Standard applies to shipping when
+// ShippingTaxable is set.
+type TaxRate struct {
+	Standard        float64
+	Books           float64
+	ShippingTaxable bool
+}
+
+// TableTaxCalculator looks rates up by "COUNTRY-REGION", then "COUNTRY".
+// Destinations not in the table are not taxed.
+type TableTaxCalculator struct {
+	Rates map[string]TaxRate
+}
+
+// Validate checks that every rate is a fraction between 0 and 1.
+func (t *TableTaxCalculator) Validate() error {
+	for key, r := range t.Rates {
+		if r.Standard < 0 || r.Standard >= 1 || r.Books < 0 || r.Books >= 1 { return fmt.Errorf("tax rate for %s is out of range", key) }
+	}
+	return nil
+}
+
+func (t *TableTaxCalculator) Tax(lines []pricedLine, taxable []float64, shipping float64, dest Destination) []models.TaxLine {
+	country, region := strings.ToUpper(dest.Country), strings.ToUpper(dest.Region)
+	key := country + "-" + region
+	rate, ok := t.Rates[key]
+	if !ok || region == "" {
+		key = country
+		rate, ok = t.Rates[key]
+	}
+	if !ok { return nil }
+	// every catalog line is a book
+	base := 0.0
+	for _, v := range taxable { base += v }
+	res := []models.TaxLine{{Jurisdiction: key, Category: "books", Rate: rate.Books, Taxable: roundMoney(base), Amount: roundMoney(base * rate.Books)}}
+	if rate.ShippingTaxable && shipping > 0 {
+		res = append(res, models.TaxLine{Jurisdiction: key, Category: "shipping", Rate: rate.Standard, Taxable: shipping, Amount: roundMoney(shipping * rate.Standard)})
+	}
+	return res
+}
+
+// Pricer runs the cart pricing pipeline: subtotal, discounts, shipping, tax
+// and grand total.
+type Pricer struct {
+	Shipping ShippingCalculator
+	Tax      TaxCalculator
+}
+
+// Validate checks the calculators that can check themselves. A pricer must
+// at least quote shipping.
+func (pr *Pricer) Validate() error {
+	if pr == nil || pr.Shipping == nil { return errors.New("no shipping calculator") }
+	var errs []error
+	for _, c := range []any{pr.Shipping, pr.Tax} {
+		if v, ok := c.(interface{ Validate() error }); ok { errs = append(errs, v.Validate()) }
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultPricer ships by quantity tier, free above FreeShippingThreshold,
+// and taxes a handful of jurisdictions with their book rates.
+func DefaultPricer() *Pricer {
+	return &Pricer{
+		Shipping: &TieredShipping{Tiers: []ShippingTier{{UpTo: 2, Cost: 3.99}, {UpTo: 5, Cost: 5.99}, {Cost: 7.99}}, FreeAbove: FreeShippingThreshold},
+		Tax: &TableTaxCalculator{Rates: map[string]TaxRate{
+			"GB":    {Standard: 0.20, Books: 0, ShippingTaxable: true},
+			"IE":    {Standard: 0.23, Books: 0, ShippingTaxable: true},
+			"DE":    {Standard: 0.19, Books: 0.07, ShippingTaxable: true},
+			"FR":    {Standard: 0.20, Books: 0.055, ShippingTaxable: true},
+			"US-CA": {Standard: 0.0725, Books: 0.0725},
+			"US-NY": {Standard: 0.04, Books: 0.04, ShippingTaxable: true},
+		}},
+	}
+}
+
+// Price fills in the cart's full breakdown for shipping to dest.
+func (pr *Pricer) Price(store *storage.MemoryStore, cart *models.Cart, dest Destination, now time.Time) error {
+	if err := priceCart(store, cart, now); err != nil { return err }
+	lines, err := cartLines(store, cart)
+	if err != nil { return err }
+	cart.Shipping = 0
+	if pr.Shipping != nil && !cart.FreeShipping { cart.Shipping = roundMoney(pr.Shipping.Quote(lines, dest, cart.Total)) }
+	cart.TaxLines, cart.Tax = nil, 0
+	if pr.Tax != nil {
+		cart.TaxLines = pr.Tax.Tax(lines, allocateDiscount(lines, cart.DiscountTotal), cart.Shipping, dest)
+		for _, t := range cart.TaxLines { cart.Tax += t.Amount }
+	}
+	cart.Tax = roundMoney(cart.Tax)
+	cart.GrandTotal = roundMoney(cart.Total + cart.Shipping + cart.Tax)
+	return nil
+}
+
+// allocateDiscount spreads discount over the lines in proportion to their
+// subtotals and returns each line's discounted amount. Rounding leftovers go
+// to the largest line so the parts add up.
+func allocateDiscount(lines []pricedLine, discount float64) []float64 {
+	res := make([]float64, len(lines))
+	subtotal := 0.0
+	for i, l := range lines { res[i] = float64(l.quantity) * l.unitPrice; subtotal += res[i] }
+	if discount <= 0 || subtotal == 0 { return res }
+	order := make([]int, len(lines))
+	for i := range order { order[i] = i }
+	sort.SliceStable(order, func(a, b int) bool { return res[order[a]] > res[order[b]] })
+	given := 0.0
+	for _, i := range order[1:] {
+		share := roundMoney(discount * res[i] / subtotal)
+		res[i] = roundMoney(res[i] - share)
+		given += share
+	}
+	first := order[0]
+	res[first] = roundMoney(math.Max(res[first]-(discount-given), 0))
+	return res
+}
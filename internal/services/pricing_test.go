@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestPricing_TaxAndShippingBreakdown(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	// product 7 is 25.00; one unit ships for 3.99
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 7, Quantity: 1}); err != nil { t.Fatalf("add: %v", err) }
+	cart, err := cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 1, Country: "de"})
+	if err != nil { t.Fatalf("get: %v", err) }
+	// books at 7%, shipping at the 19% standard rate
+	if cart.Shipping != 3.99 || cart.Tax != 2.51 || len(cart.TaxLines) != 2 || cart.GrandTotal != 31.5 { t.Fatalf("unexpected DE breakdown: %+v", cart) }
+	// user 1's default address is in London
+	cart, _ = cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 1})
+	if cart.TaxLines[0].Amount != 0 || cart.Tax != 0.8 || cart.GrandTotal != 29.79 { t.Fatalf("expected zero-rated books in GB, got %+v", cart) }
+	cart, _ = cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 1, Country: "XX"})
+	if cart.Tax != 0 || cart.TaxLines != nil || cart.GrandTotal != 28.99 { t.Fatalf("expected no tax for an unknown destination, got %+v", cart) }
+
+	// 75.00 of merchandise ships free; California taxes the discounted amount
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 7, Quantity: 2}); err != nil { t.Fatalf("add: %v", err) }
+	if _, err := NewPromotionService(store).CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "TEN", Name: "Ten", Type: models.PromoPercentOff, Value: 10}); err != nil { t.Fatalf("promo: %v", err) }
+	if _, err := cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "TEN"}); err != nil { t.Fatalf("apply: %v", err) }
+	cart, _ = cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 1, Country: "US", Region: "ca"})
+	if cart.Total != 67.5 || cart.Shipping != 0 || cart.Tax != 4.89 || cart.GrandTotal != 72.39 { t.Fatalf("unexpected US-CA breakdown: %+v", cart) }
+	if cart.TaxLines[0].Jurisdiction != "US-CA" { t.Fatalf("expected US-CA jurisdiction, got %+v", cart.TaxLines) }
+
+	// the order keeps the breakdown; rules still apply to the merchandise total
+	ca, err := NewUserService(store).CreateAddress(ctx, &dto.AddressRequest{UserID: 1, Name: "John Doe", Line1: "1 Main St", City: "Fresno", Region: "CA", PostalCode: "93650", Country: "US"})
+	if err != nil { t.Fatalf("address: %v", err) }
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1, ShippingAddressID: ca.ID})
+	if err != nil { t.Fatalf("place: %v", err) }
+	if order.Total != 67.5 || order.Tax != 4.89 || order.GrandTotal != 72.39 || len(order.TaxLines) != 1 { t.Fatalf("unexpected order breakdown: %+v", order) }
+	stored, _ := store.GetOrdersByUser(1)
+	if stored[0].GrandTotal != 72.39 || len(stored[0].TaxLines) != 1 { t.Fatalf("breakdown not stored: %+v", stored[0]) }
+}
+
+func TestPricing_WeightTiersAndDiscountAllocation(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	cartSvc.SetPricer(&Pricer{Shipping: &TieredShipping{ByWeight: true, Tiers: []ShippingTier{{UpTo: 1000, Cost: 4}, {Cost: 9}}}})
+
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 7, Quantity: 2}); err != nil { t.Fatalf("add: %v", err) }
+	cart, _ := cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 1})
+	if cart.Shipping != 4 { t.Fatalf("expected 1kg tier, got %v", cart.Shipping) }
+	cart, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 7, Quantity: 1})
+	if err != nil { t.Fatalf("add: %v", err) }
+	if cart.Shipping != 9 || cart.GrandTotal != 84 { t.Fatalf("expected heavy tier, got %+v", cart) }
+
+	lines := []pricedLine{{quantity: 1, unitPrice: 30}, {quantity: 1, unitPrice: 10}, {quantity: 1, unitPrice: 0.01}}
+	got := allocateDiscount(lines, 10)
+	if got[0]+got[1]+got[2] != 30.01 || got[1] != 7.5 { t.Fatalf("unexpected allocation: %v", got) }
+}
@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"ecom-book-store-sample-api/internal/dto"
 	"ecom-book-store-sample-api/internal/models"
@@ -20,6 +23,13 @@ func (s *ProductService) ListProducts(ctx context.Context, req *dto.ListProducts
 	return s.store.GetAllProducts()
 }
 
+// CatalogVersion returns the catalog-wide change counter and the time of the
+// last change, so callers can validate cached product lists cheaply.
+func (s *ProductService) CatalogVersion(ctx context.Context) (uint64, time.Time) {
+	_ = ctx
+	return s.store.CatalogVersion()
+}
+
 func validateProductInput(title, author, description string, price float64, stock int) error {
 	title = strings.TrimSpace(title)
 	author = strings.TrimSpace(author)
@@ -27,17 +37,76 @@ func validateProductInput(title, author, description string, price float64, stoc
 	if author == "" { return errors.New("invalid author") }
 	if len(description) > 2000 { return errors.New("description too long") }
 	if price < 0.01 || price > 10000 { return errors.New("price out of bounds") }
-	if stock < 0 || stock > 10000 { return errors.New("invalid stock") }
+	if stock < 0 || stock > storage.MaxProductStock { return errors.New("invalid stock") }
+	return nil
+}
+
+// validateBackorderSettings checks the backorder/pre-order fields. Accepting
+// backorders, or an unreleased title, needs a positive MaxBackorder cap.
+func validateBackorderSettings(allow bool, max, leadDays int, release *time.Time) error {
+	if max < 0 || max > 10000 { return errors.New("invalid maxBackorder") }
+	if leadDays < 0 || leadDays > 365 { return errors.New("invalid backorderLeadDays") }
+	if allow && max == 0 { return errors.New("maxBackorder is required when allowBackorder is set") }
+	if release != nil && release.After(time.Now()) && max == 0 { return errors.New("maxBackorder is required for pre-orders") }
+	return nil
+}
+
+// validateReorderSettings checks the replenishment fields. A reorder point
+// is only useful with a quantity to reorder.
+func validateReorderSettings(point, qty int, supplier string) error {
+	if point < 0 || point > 10000 { return errors.New("invalid reorderPoint") }
+	if qty < 0 || qty > 10000 { return errors.New("invalid reorderQuantity") }
+	if point > 0 && qty == 0 { return errors.New("reorderQuantity is required when reorderPoint is set") }
+	if len(strings.TrimSpace(supplier)) > 200 { return errors.New("supplier too long") }
 	return nil
 }
 
+// normalizeISBN strips separators and upper-cases the ISBN-10 check digit,
+// then verifies the ISBN-10 or ISBN-13 checksum. Empty input is allowed.
+func normalizeISBN(isbn string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(isbn) {
+		if r == '-' || r == ' ' { continue }
+		b.WriteRune(r)
+	}
+	n := b.String()
+	switch len(n) {
+	case 0:
+		return "", nil
+	case 10:
+		sum := 0
+		for i, r := range n {
+			d := int(r - '0')
+			if r == 'X' && i == 9 { d = 10 } else if r < '0' || r > '9' { return "", errors.New("invalid isbn") }
+			sum += d * (10 - i)
+		}
+		if sum%11 != 0 { return "", errors.New("invalid isbn") }
+	case 13:
+		sum := 0
+		for i, r := range n {
+			if r < '0' || r > '9' { return "", errors.New("invalid isbn") }
+			w := 1
+			if i%2 == 1 { w = 3 }
+			sum += int(r-'0') * w
+		}
+		if sum%10 != 0 { return "", errors.New("invalid isbn") }
+	default:
+		return "", errors.New("invalid isbn")
+	}
+	return n, nil
+}
+
 func (s *ProductService) CreateProduct(ctx context.Context, req *dto.CreateProductRequest) (*dto.Product, error) {
-	_ = ctx
 	if err := validateProductInput(req.Title, req.Author, req.Description, req.Price, req.Stock); err != nil {
 		return nil, err
 	}
-	p := &models.Product{Title: req.Title, Author: req.Author, Description: req.Description, Price: req.Price, Stock: req.Stock, Discontinued: req.Discontinued, IsSpecial: req.IsSpecial}
-	return s.store.CreateProduct(p)
+	isbn, err := normalizeISBN(req.ISBN)
+	if err != nil { return nil, err }
+	if err := validateBackorderSettings(req.AllowBackorder, req.MaxBackorder, req.BackorderLeadDays, req.ReleaseDate); err != nil { return nil, err }
+	if err := validateReorderSettings(req.ReorderPoint, req.ReorderQuantity, req.Supplier); err != nil { return nil, err }
+	if len(strings.TrimSpace(req.Category)) > 100 { return nil, errors.New("category too long") }
+	p := &models.Product{ISBN: isbn, Title: req.Title, Author: req.Author, Description: req.Description, Category: strings.TrimSpace(req.Category), Price: req.Price, Stock: req.Stock, Discontinued: req.Discontinued, IsSpecial: req.IsSpecial, AllowBackorder: req.AllowBackorder, MaxBackorder: req.MaxBackorder, BackorderLeadDays: req.BackorderLeadDays, ReleaseDate: req.ReleaseDate, ReorderPoint: req.ReorderPoint, ReorderQuantity: req.ReorderQuantity, Supplier: strings.TrimSpace(req.Supplier)}
+	return s.store.CreateProduct(ctx, p, req.Actor)
 }
 
 func (s *ProductService) GetProduct(ctx context.Context, req *dto.GetProductRequest) (*dto.Product, error) {
@@ -46,18 +115,90 @@ func (s *ProductService) GetProduct(ctx context.Context, req *dto.GetProductRequ
 }
 
 func (s *ProductService) UpdateProduct(ctx context.Context, req *dto.UpdateProductRequest) (*dto.Product, error) {
-	_ = ctx
+	p, err := productFromUpdate(req)
+	if err != nil { return nil, err }
+	return s.store.UpdateProduct(ctx, req.ID, p, req.Actor)
+}
+
+// productFromUpdate validates a full update and builds the product to write.
+func productFromUpdate(req *dto.UpdateProductRequest) (*models.Product, error) {
 	if err := validateProductInput(req.Title, req.Author, req.Description, req.Price, req.Stock); err != nil {
 		return nil, err
 	}
-	p := &models.Product{Title: req.Title, Author: req.Author, Description: req.Description, Price: req.Price, Stock: req.Stock, Discontinued: req.Discontinued, IsSpecial: req.IsSpecial}
-	return s.store.UpdateProduct(req.ID, p)
+	isbn, err := normalizeISBN(req.ISBN)
+	if err != nil { return nil, err }
+	if err := validateBackorderSettings(req.AllowBackorder, req.MaxBackorder, req.BackorderLeadDays, req.ReleaseDate); err != nil { return nil, err }
+	if err := validateReorderSettings(req.ReorderPoint, req.ReorderQuantity, req.Supplier); err != nil { return nil, err }
+	if len(strings.TrimSpace(req.Category)) > 100 { return nil, errors.New("category too long") }
+	return &models.Product{ISBN: isbn, Title: req.Title, Author: req.Author, Description: req.Description, Category: strings.TrimSpace(req.Category), Price: req.Price, Stock: req.Stock, Discontinued: req.Discontinued, IsSpecial: req.IsSpecial, AllowBackorder: req.AllowBackorder, MaxBackorder: req.MaxBackorder, BackorderLeadDays: req.BackorderLeadDays, ReleaseDate: req.ReleaseDate, ReorderPoint: req.ReorderPoint, ReorderQuantity: req.ReorderQuantity, Supplier: strings.TrimSpace(req.Supplier), Version: req.Version}, nil
+}
+
+// PatchProduct applies a JSON Merge Patch to the stored product. Fields not
+// present in the patch keep their current values; the result is validated
+// like a full update and written only if req.Version is still current, or
+// unconditionally when it is zero (If-Match: *). The
+// merge happens under the store lock, so a sale or scheduled price landing
+// meanwhile is kept rather than overwritten with the value read before it.
+func (s *ProductService) PatchProduct(ctx context.Context, req *dto.PatchProductRequest) (*dto.Product, error) {
+	return s.store.ModifyProduct(ctx, req.ID, req.Version, req.Actor, func(cur *models.Product) (*models.Product, error) {
+		upd := &dto.UpdateProductRequest{ID: cur.ID, ISBN: cur.ISBN, Title: cur.Title, Author: cur.Author, Description: cur.Description, Category: cur.Category, Price: cur.Price, Stock: cur.Stock, Discontinued: cur.Discontinued, IsSpecial: cur.IsSpecial, AllowBackorder: cur.AllowBackorder, MaxBackorder: cur.MaxBackorder, BackorderLeadDays: cur.BackorderLeadDays, ReleaseDate: cur.ReleaseDate, ReorderPoint: cur.ReorderPoint, ReorderQuantity: cur.ReorderQuantity, Supplier: cur.Supplier, Version: req.Version, Actor: req.Actor}
+		if err := applyProductMergePatch(upd, req.Patch); err != nil { return nil, err }
+		return productFromUpdate(upd)
+	})
+}
+
+// applyProductMergePatch merges patch into dst following RFC 7386. Product
+// documents are flat, so a null member resets the field to its zero value
+// and any other member replaces it.
+func applyProductMergePatch(dst *dto.UpdateProductRequest, patch []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return errors.New("merge patch must be a JSON object")
+	}
+	return mergeProductMembers(dst, members)
+}
+
+func mergeProductMembers(dst *dto.UpdateProductRequest, members map[string]json.RawMessage) error {
+	for name, raw := range members {
+		var target any
+		switch name {
+		case "isbn": target = &dst.ISBN
+		case "title": target = &dst.Title
+		case "author": target = &dst.Author
+		case "description": target = &dst.Description
+		case "category": target = &dst.Category
+		case "price": target = &dst.Price
+		case "stock": target = &dst.Stock
+		case "discontinued": target = &dst.Discontinued
+		case "isSpecial": target = &dst.IsSpecial
+		case "allowBackorder": target = &dst.AllowBackorder
+		case "maxBackorder": target = &dst.MaxBackorder
+		case "backorderLeadDays": target = &dst.BackorderLeadDays
+		case "releaseDate": target = &dst.ReleaseDate
+		case "reorderPoint": target = &dst.ReorderPoint
+		case "reorderQuantity": target = &dst.ReorderQuantity
+		case "supplier": target = &dst.Supplier
+		default:
+			return fmt.Errorf("field %q cannot be patched", name)
+		}
+		if string(raw) == "null" {
+			switch v := target.(type) {
+			case *string: *v = ""
+			case *float64: *v = 0
+			case *int: *v = 0
+			case *bool: *v = false
+			case **time.Time: *v = nil
+			}
+			continue
+		}
+		if err := json.Unmarshal(raw, target); err != nil { return fmt.Errorf("invalid value for %q", name) }
+	}
+	return nil
 }
 
 func (s *ProductService) DeleteProduct(ctx context.Context, req *dto.DeleteProductRequest) error {
-	_ = ctx
 	if s.store.IsProductInAnyCart(req.ID) {
 		return errors.New("product is present in carts")
 	}
-	return s.store.DeleteProduct(req.ID)
+	return s.store.DeleteProduct(ctx, req.ID)
 }
@@ -2,9 +2,13 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
 	"ecom-book-store-sample-api/internal/storage"
 )
 
@@ -65,3 +69,124 @@ func TestProductService_ValidationsAndDeleteGuard(t *testing.T) {
 	}
 }
 
+
+func TestProductService_PatchProduct(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	svc := NewProductService(store)
+
+	created, err := svc.CreateProduct(ctx, &dto.CreateProductRequest{Title: "Patch", Author: "A", Description: "D", Price: 10, Stock: 8, IsSpecial: true})
+	if err != nil { t.Fatalf("create: %v", err) }
+	if created.Version != 1 { t.Fatalf("expected version 1, got %d", created.Version) }
+
+	// omitted fields are kept
+	patched, err := svc.PatchProduct(ctx, &dto.PatchProductRequest{ID: created.ID, Patch: []byte(`{"price":12.5}`), Version: 1})
+	if err != nil { t.Fatalf("patch: %v", err) }
+	if patched.Price != 12.5 || patched.Stock != 8 || !patched.IsSpecial { t.Fatalf("unexpected patch result: %+v", patched) }
+	if patched.Version != 2 { t.Fatalf("expected version 2, got %d", patched.Version) }
+
+	// null resets a field
+	patched, err = svc.PatchProduct(ctx, &dto.PatchProductRequest{ID: created.ID, Patch: []byte(`{"isSpecial":null}`), Version: 2})
+	if err != nil { t.Fatalf("patch null: %v", err) }
+	if patched.IsSpecial { t.Fatalf("expected isSpecial cleared") }
+
+	// stale version
+	if _, err := svc.PatchProduct(ctx, &dto.PatchProductRequest{ID: created.ID, Patch: []byte(`{"stock":1}`), Version: 2}); !errors.Is(err, storage.ErrVersionConflict) {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	// patched result still validated
+	if _, err := svc.PatchProduct(ctx, &dto.PatchProductRequest{ID: created.ID, Patch: []byte(`{"title":null}`), Version: 3}); err == nil {
+		t.Fatalf("expected validation error")
+	}
+	// unknown member
+	if _, err := svc.PatchProduct(ctx, &dto.PatchProductRequest{ID: created.ID, Patch: []byte(`{"id":99}`), Version: 3}); err == nil {
+		t.Fatalf("expected unknown field error")
+	}
+}
+
+type recordingRestockNotifier struct {
+	users []uint
+	fail  bool
+}
+
+func (n *recordingRestockNotifier) NotifyBackInStock(ctx context.Context, msg models.RestockNotification) error {
+	if n.fail { return errors.New("smtp down") }
+	n.users = append(n.users, msg.UserID)
+	return nil
+}
+
+func TestProductService_BackInStockSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	store.SetRestockFanout(2)
+	svc := NewProductService(store)
+	inv := NewInventoryService(store)
+
+	p, err := svc.CreateProduct(ctx, &dto.CreateProductRequest{Title: "Reprint", Author: "A", Price: 10})
+	if err != nil { t.Fatalf("create: %v", err) }
+	if _, err := svc.NotifyMe(ctx, &dto.RestockSubscriptionRequest{UserID: 1, ProductID: 2}); !errors.Is(err, storage.ErrProductInStock) { t.Fatalf("expected in-stock error, got %v", err) }
+	var users []uint
+	for i := 0; i < 5; i++ {
+		u, _ := store.CreateUser(&models.User{Email: fmt.Sprintf("reader%d@email.com", i), Name: "Reader"})
+		users = append(users, u.ID)
+		if _, err := svc.NotifyMe(ctx, &dto.RestockSubscriptionRequest{UserID: u.ID, ProductID: p.ID}); err != nil { t.Fatalf("subscribe: %v", err) }
+	}
+	// resubscribing keeps the original place
+	if _, err := svc.NotifyMe(ctx, &dto.RestockSubscriptionRequest{UserID: users[0], ProductID: p.ID}); err != nil { t.Fatalf("resubscribe: %v", err) }
+
+	// one unit releases two subscribers, oldest first; delivery failures keep them queued
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: p.ID, Type: models.MovementReceipt, Quantity: 1}); err != nil { t.Fatalf("receipt: %v", err) }
+	n := &recordingRestockNotifier{fail: true}
+	if sent := svc.DispatchRestockNotifications(ctx, n, 10); sent != 0 { t.Fatalf("expected no deliveries on failure, got %d", sent) }
+	n.fail = false
+	if sent := svc.DispatchRestockNotifications(ctx, n, 10); sent != 2 || n.users[0] != users[0] || n.users[1] != users[1] { t.Fatalf("unexpected deliveries %d %v", sent, n.users) }
+	if left := store.RestockQueueLength(p.ID); left != 3 { t.Fatalf("expected 3 still waiting, got %d", left) }
+
+	// a receipt while already in stock releases nobody; selling out and restocking via update does
+	if _, err := inv.Adjust(ctx, &dto.AdjustInventoryRequest{ProductID: p.ID, Type: models.MovementReceipt, Quantity: 1}); err != nil { t.Fatalf("receipt: %v", err) }
+	if sent := svc.DispatchRestockNotifications(ctx, n, 10); sent != 0 { t.Fatalf("expected no deliveries, got %d", sent) }
+	cur, _ := store.GetProductByID(p.ID)
+	if _, err := svc.UpdateProduct(ctx, &dto.UpdateProductRequest{ID: p.ID, Title: cur.Title, Author: cur.Author, Price: cur.Price, Stock: 0}); err != nil { t.Fatalf("sell out: %v", err) }
+	if _, err := svc.UpdateProduct(ctx, &dto.UpdateProductRequest{ID: p.ID, Title: cur.Title, Author: cur.Author, Price: cur.Price, Stock: 5}); err != nil { t.Fatalf("restock: %v", err) }
+	if sent := svc.DispatchRestockNotifications(ctx, n, 2); sent != 2 { t.Fatalf("expected batch limit of 2, got %d", sent) }
+	if sent := svc.DispatchRestockNotifications(ctx, n, 10); sent != 1 || n.users[4] != users[4] { t.Fatalf("unexpected deliveries %d %v", sent, n.users) }
+}
+
+func TestProductService_PriceHistoryAndSchedule(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	svc := NewProductService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	if _, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1}); err != nil { t.Fatalf("add: %v", err) }
+	p, _ := store.GetProductByID(1)
+	if _, err := svc.PatchProduct(ctx, &dto.PatchProductRequest{ID: 1, Version: p.Version, Patch: []byte(`{"price":40}`), Actor: "user:3"}); err != nil { t.Fatalf("patch: %v", err) }
+	cart, _ := cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 1})
+	if it := cart.Items[0]; it.UnitPrice != 40 || it.PreviousUnitPrice != 45 { t.Fatalf("expected markdown to reprice the cart, got %+v", it) }
+
+	if _, err := svc.SchedulePrice(ctx, &dto.SchedulePriceRequest{ProductID: 1, Price: 30, EffectiveAt: time.Now().Add(-time.Minute)}); err == nil { t.Fatalf("expected past effectiveAt to be rejected") }
+	friday := time.Now().Add(time.Hour).UTC()
+	if _, err := svc.SchedulePrice(ctx, &dto.SchedulePriceRequest{ProductID: 1, Price: 30, EffectiveAt: friday, Actor: "user:3"}); err != nil { t.Fatalf("schedule: %v", err) }
+	if _, err := svc.SchedulePrice(ctx, &dto.SchedulePriceRequest{ProductID: 1, Price: 31, EffectiveAt: friday}); !errors.Is(err, storage.ErrScheduleConflict) { t.Fatalf("expected schedule conflict, got %v", err) }
+	later, _ := svc.SchedulePrice(ctx, &dto.SchedulePriceRequest{ProductID: 1, Price: 60, EffectiveAt: friday.Add(time.Hour)})
+	if err := svc.CancelScheduledPrice(ctx, &dto.CancelScheduledPriceRequest{ProductID: 1, ID: later.ID}); err != nil { t.Fatalf("cancel: %v", err) }
+
+	if n := store.ApplyDuePrices(ctx, time.Now()); n != 0 { t.Fatalf("nothing should be due yet, applied %d", n) }
+	if n := store.ApplyDuePrices(ctx, friday); n != 1 { t.Fatalf("expected 1 price applied, got %d", n) }
+	p, _ = store.GetProductByID(1)
+	if p.Price != 30 { t.Fatalf("expected scheduled price, got %v", p.Price) }
+	if pending, _ := svc.ListScheduledPrices(ctx, &dto.ListScheduledPricesRequest{ProductID: 1}); len(pending) != 0 { t.Fatalf("expected no pending prices, got %+v", pending) }
+
+	hist, err := svc.PriceHistory(ctx, &dto.GetPriceHistoryRequest{ProductID: 1})
+	if err != nil { t.Fatalf("history: %v", err) }
+	if len(hist) != 3 || hist[0].Price != 45 || hist[1].Actor != "user:3" || hist[2].Price != 30 || hist[2].PreviousPrice != 40 || !hist[2].EffectiveFrom.Equal(friday) || hist[2].ScheduleID == 0 { t.Fatalf("unexpected history: %+v", hist) }
+
+	// the repriced cart still checks out
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	if order.Items[0].UnitPrice != 30 { t.Fatalf("expected order at the scheduled price, got %v", order.Items[0].UnitPrice) }
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type PromotionService struct { store *storage.MemoryStore }
+
+func NewPromotionService(store *storage.MemoryStore) *PromotionService { return &PromotionService{store: store} }
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+func normalizeCoupon(code string) string { return strings.ToUpper(strings.TrimSpace(code)) }
+
+func (s *PromotionService) CreatePromotion(ctx context.Context, req *dto.CreatePromotionRequest) (*dto.Promotion, error) {
+	_ = ctx
+	p := &models.Promotion{Code: normalizeCoupon(req.Code), Name: strings.TrimSpace(req.Name), Type: req.Type, Value: req.Value, BuyQuantity: req.BuyQuantity, GetQuantity: req.GetQuantity, Scope: req.Scope, ProductIDs: req.ProductIDs, Categories: req.Categories, Authors: req.Authors, StartsAt: req.StartsAt, EndsAt: req.EndsAt, MaxUses: req.MaxUses, MaxUsesPerUser: req.MaxUsesPerUser, Stackable: req.Stackable, Active: true}
+	if p.Scope == "" { p.Scope = models.PromoScopeCart }
+	if p.Code != "" && !couponCodePattern.MatchString(p.Code) { return nil, errors.New("coupon code must be 3-32 letters, digits, '-' or '_'") }
+	if p.Name == "" || len(p.Name) > 200 { return nil, errors.New("invalid promotion name") }
+	switch p.Type {
+	case models.PromoPercentOff:
+		if p.Value <= 0 || p.Value > 100 { return nil, errors.New("percent_off value must be in (0, 100]") }
+	case models.PromoFixedAmount:
+		if p.Value <= 0 || p.Value > 10000 { return nil, errors.New("fixed_amount value must be positive") }
+	case models.PromoBuyXGetY:
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 { return nil, errors.New("buy_x_get_y needs buyQuantity and getQuantity of at least 1") }
+	case models.PromoFreeShipping:
+	default:
+		return nil, errors.New("type must be percent_off, fixed_amount, buy_x_get_y or free_shipping")
+	}
+	switch p.Scope {
+	case models.PromoScopeCart:
+	case models.PromoScopeProducts:
+		if len(p.ProductIDs) == 0 { return nil, errors.New("productIds are required for products scope") }
+		for _, id := range p.ProductIDs {
+			if _, err := s.store.GetProductByID(id); err != nil { return nil, fmt.Errorf("product %d not found", id) }
+		}
+	case models.PromoScopeCategories:
+		if len(p.Categories) == 0 { return nil, errors.New("categories are required for categories scope") }
+	case models.PromoScopeAuthors:
+		if len(p.Authors) == 0 { return nil, errors.New("authors are required for authors scope") }
+	default:
+		return nil, errors.New("scope must be cart, products, categories or authors")
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) { return nil, errors.New("endsAt must be after startsAt") }
+	if p.MaxUses < 0 || p.MaxUsesPerUser < 0 { return nil, errors.New("usage limits must not be negative") }
+	return s.store.CreatePromotion(p)
+}
+
+func (s *PromotionService) ListPromotions(ctx context.Context, req *dto.ListPromotionsRequest) ([]*dto.Promotion, error) {
+	_ = ctx
+	return s.store.GetPromotions()
+}
+
+func (s *PromotionService) DeactivatePromotion(ctx context.Context, req *dto.DeactivatePromotionRequest) error {
+	_ = ctx
+	return s.store.DeactivatePromotion(req.ID)
+}
+
+// pricedLine is a cart line as the promotion engine sees it.
+type pricedLine struct {
+	product   *models.Product
+	quantity  int
+	unitPrice float64
+}
+
+// cartLines loads the products behind a cart. Lines are priced at the cart's
+// unit price, which checkout guarantees is the current price.
+func cartLines(store *storage.MemoryStore, cart *models.Cart) ([]pricedLine, error) {
+	lines := make([]pricedLine, 0, len(cart.Items))
+	for _, it := range cart.Items {
+		p, err := store.GetProductByID(it.ProductID)
+		if err != nil { return nil, err }
+		price := it.UnitPrice
+		if price == 0 { price = p.Price }
+		lines = append(lines, pricedLine{product: p, quantity: it.Quantity, unitPrice: price})
+	}
+	return lines, nil
+}
+
+// priceCart fills in the cart's subtotal, discount lines and total. Of the
+// promotions that apply, either all stackable ones combine or one
+// non-stackable one applies alone, whichever saves the customer more.
+// Discounts never exceed the subtotal.
+func priceCart(store *storage.MemoryStore, cart *models.Cart, now time.Time) error {
+	lines, err := cartLines(store, cart)
+	if err != nil { return err }
+	subtotal := 0.0
+	for _, l := range lines { subtotal += float64(l.quantity) * l.unitPrice }
+	promos, err := store.GetPromotions()
+	if err != nil { return err }
+	applied := make(map[string]bool, len(cart.Coupons))
+	for _, c := range cart.Coupons { applied[c] = true }
+
+	var stackable []models.DiscountLine
+	var best []models.DiscountLine
+	for _, p := range promos {
+		if !promotionUsable(store, p, cart.UserID, now) || (p.Code != "" && !applied[p.Code]) { continue }
+		d, ok := discountFor(p, lines)
+		if !ok { continue }
+		if p.Stackable { stackable = append(stackable, d); continue }
+		if best == nil || betterDiscounts([]models.DiscountLine{d}, best) { best = []models.DiscountLine{d} }
+	}
+	if best == nil || betterDiscounts(stackable, best) { best = stackable }
+
+	cart.Subtotal = roundMoney(subtotal)
+	cart.Discounts, cart.DiscountTotal, cart.FreeShipping = nil, 0, false
+	remaining := cart.Subtotal
+	for _, d := range best {
+		d.Amount = roundMoney(math.Min(d.Amount, remaining))
+		remaining -= d.Amount
+		cart.DiscountTotal += d.Amount
+		cart.FreeShipping = cart.FreeShipping || d.FreeShipping
+		cart.Discounts = append(cart.Discounts, d)
+	}
+	cart.DiscountTotal = roundMoney(cart.DiscountTotal)
+	cart.Total = roundMoney(cart.Subtotal - cart.DiscountTotal)
+	return nil
+}
+
+// promotionUsable reports whether p is live and has uses left for userID.
+func promotionUsable(store *storage.MemoryStore, p *models.Promotion, userID uint, now time.Time) bool {
+	if !p.ActiveAt(now) { return false }
+	if p.MaxUses > 0 && p.Uses >= p.MaxUses { return false }
+	if p.MaxUsesPerUser > 0 && store.PromotionUsesByUser(p.ID, userID) >= p.MaxUsesPerUser { return false }
+	return true
+}
+
+func betterDiscounts(a, b []models.DiscountLine) bool {
+	sum := func(ds []models.DiscountLine) (total float64, free bool) {
+		for _, d := range ds { total += d.Amount; free = free || d.FreeShipping }
+		return
+	}
+	at, af := sum(a)
+	bt, bf := sum(b)
+	if at != bt { return at > bt }
+	return af && !bf
+}
+
+// discountFor computes p's discount on the eligible lines. ok is false when
+// nothing in the cart qualifies.
+func discountFor(p *models.Promotion, lines []pricedLine) (d models.DiscountLine, ok bool) {
+	var eligible []pricedLine
+	for _, l := range lines {
+		if promotionCovers(p, l.product) { eligible = append(eligible, l) }
+	}
+	if len(eligible) == 0 { return d, false }
+	base := 0.0
+	for _, l := range eligible { base += float64(l.quantity) * l.unitPrice }
+	d = models.DiscountLine{PromotionID: p.ID, Code: p.Code, Description: p.Name}
+	switch p.Type {
+	case models.PromoPercentOff:
+		d.Amount = base * p.Value / 100
+	case models.PromoFixedAmount:
+		d.Amount = math.Min(p.Value, base)
+	case models.PromoBuyXGetY:
+		var units []float64
+		for _, l := range eligible {
+			for i := 0; i < l.quantity; i++ { units = append(units, l.unitPrice) }
+		}
+		sort.Float64s(units)
+		free := len(units) / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+		for _, price := range units[:free] { d.Amount += price }
+	case models.PromoFreeShipping:
+		d.FreeShipping = true
+		return d, true
+	}
+	d.Amount = roundMoney(d.Amount)
+	return d, d.Amount > 0
+}
+
+func promotionCovers(p *models.Promotion, prod *models.Product) bool {
+	switch p.Scope {
+	case models.PromoScopeProducts:
+		for _, id := range p.ProductIDs {
+			if id == prod.ID { return true }
+		}
+		return false
+	case models.PromoScopeCategories:
+		for _, c := range p.Categories {
+			if strings.EqualFold(c, prod.Category) { return true }
+		}
+		return false
+	case models.PromoScopeAuthors:
+		for _, a := range p.Authors {
+			if strings.EqualFold(a, prod.Author) { return true }
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func roundMoney(v float64) float64 { return math.Round(v*100) / 100 }
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestPromotions_ScopesAndStacking(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	promos := NewPromotionService(store)
+	cartSvc := NewCartService(store)
+
+	// automatic 10% off software-engineering, stackable
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Name: "SE week", Type: models.PromoPercentOff, Value: 10, Scope: models.PromoScopeCategories, Categories: []string{"software-engineering"}, Stackable: true}); err != nil { t.Fatalf("create: %v", err) }
+	// coupon: 5 off the cart, stackable
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "five", Name: "Five off", Type: models.PromoFixedAmount, Value: 5, Stackable: true}); err != nil { t.Fatalf("create: %v", err) }
+	// coupon: buy 2 get 1 on CLRS author, not stackable
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "B2G1", Name: "3 for 2", Type: models.PromoBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Scope: models.PromoScopeAuthors, Authors: []string{"clrs"}}); err != nil { t.Fatalf("create: %v", err) }
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "FIVE", Name: "dup", Type: models.PromoFreeShipping}); err != storage.ErrDuplicateCoupon { t.Fatalf("expected duplicate coupon, got %v", err) }
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Name: "bad", Type: models.PromoPercentOff, Value: 150}); err == nil { t.Fatalf("expected invalid percent") }
+
+	// product 1 is 45.00 software-engineering, product 4 is 89.50 by CLRS
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1})
+	cart, err := cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 4, Quantity: 3})
+	if err != nil { t.Fatalf("add: %v", err) }
+	if cart.Subtotal != 313.5 || cart.DiscountTotal != 4.5 || cart.Total != 309 { t.Fatalf("expected automatic 10%% on product 1 only, got %+v", cart) }
+
+	if _, err := cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "nope"}); err == nil { t.Fatalf("expected unknown coupon error") }
+	cart, err = cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: " five "})
+	if err != nil { t.Fatalf("apply: %v", err) }
+	if len(cart.Discounts) != 2 || cart.DiscountTotal != 9.5 { t.Fatalf("expected stacked discounts, got %+v", cart.Discounts) }
+
+	// the non-stackable 3-for-2 (89.50 off) beats the stackable pair
+	cart, err = cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "b2g1"})
+	if err != nil { t.Fatalf("apply: %v", err) }
+	if len(cart.Discounts) != 1 || cart.Discounts[0].Code != "B2G1" || cart.DiscountTotal != 89.5 { t.Fatalf("expected buy-x-get-y alone, got %+v", cart.Discounts) }
+	cart, _ = cartSvc.RemoveCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "B2G1"})
+	if cart.DiscountTotal != 9.5 || len(cart.Coupons) != 1 { t.Fatalf("expected stacked pair back, got %+v", cart) }
+}
+
+func TestPromotions_LimitsWindowsAndCheckout(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	promos := NewPromotionService(store)
+	cartSvc := NewCartService(store)
+	orderSvc := NewOrderService(store)
+
+	future := time.Now().Add(time.Hour)
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "LATER", Name: "Not yet", Type: models.PromoPercentOff, Value: 50, StartsAt: &future}); err != nil { t.Fatalf("create: %v", err) }
+	once, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "ONCE", Name: "One use", Type: models.PromoPercentOff, Value: 20, MaxUses: 1})
+	if err != nil { t.Fatalf("create: %v", err) }
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Code: "SHIPFREE", Name: "Free shipping", Type: models.PromoFreeShipping, Stackable: true}); err != nil { t.Fatalf("create: %v", err) }
+
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 7, Quantity: 1}) // 25.00
+	if _, err := cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "LATER"}); err == nil { t.Fatalf("expected not-yet-active coupon to be rejected") }
+	if _, err := cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 1, Code: "ONCE"}); err != nil { t.Fatalf("apply: %v", err) }
+	// user 2 applies the same single-use code; only the first checkout may use it
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 2, ProductID: 7, Quantity: 1})
+	if _, err := cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 2, Code: "ONCE"}); err != nil { t.Fatalf("apply: %v", err) }
+
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	if order.Subtotal != 25 || order.DiscountTotal != 5 || order.Total != 20 || len(order.Discounts) != 1 || order.Discounts[0].PromotionID != once.ID { t.Fatalf("unexpected order pricing: %+v", order) }
+	cart, _ := cartSvc.GetCart(ctx, &dto.GetCartRequest{UserID: 2})
+	if cart.DiscountTotal != 0 { t.Fatalf("exhausted coupon should no longer discount, got %+v", cart.Discounts) }
+	if _, err := cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 2, Code: "ONCE"}); err == nil { t.Fatalf("expected exhausted coupon to be rejected") }
+	cart, err = cartSvc.ApplyCoupon(ctx, &dto.CouponRequest{UserID: 2, Code: "shipfree"})
+	if err != nil || !cart.FreeShipping || cart.DiscountTotal != 0 { t.Fatalf("expected free shipping flag, got %v %+v", err, cart) }
+
+	// MinOrderAmount is checked after discounts: 6.00 - 2.00 falls below 5.00
+	cheap, _ := NewProductService(store).CreateProduct(ctx, &dto.CreateProductRequest{Title: "Pamphlet", Author: "A", Price: 6, Stock: 10})
+	if _, err := promos.CreatePromotion(ctx, &dto.CreatePromotionRequest{Name: "Two off pamphlets", Type: models.PromoFixedAmount, Value: 2, Scope: models.PromoScopeProducts, ProductIDs: []uint{cheap.ID}}); err != nil { t.Fatalf("create: %v", err) }
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 3, ProductID: cheap.ID, Quantity: 1})
+	if _, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 3}); err == nil || err.Error() != "order total below minimum" { t.Fatalf("expected post-discount minimum error, got %v", err) }
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// RuleError is a request refused by a business rule. The message is the
+// rule's, as clients have always seen it; Code is a stable identifier for
+// logs and metrics.
+type RuleError struct{ Code, Rule string }
+
+func (e *RuleError) Error() string { return e.Rule }
+
+func ruleViolation(code, rule string) error { return &RuleError{Code: code, Rule: rule} }
+
+type rejectionListeners []func(ctx context.Context, r models.RuleRejection)
+
+// notify logs and counts the rejection, records its code for the request's
+// access log and passes r to every listener when err is a RuleError.
+func (l rejectionListeners) notify(ctx context.Context, err error, r models.RuleRejection) {
+	var re *RuleError
+	if !errors.As(err, &re) { return }
+	r.Rule, r.Code, r.At = re.Rule, re.Code, time.Now()
+	logging.SetRuleCode(ctx, re.Code)
+	ruleRejections.Inc(r.Operation, r.Code)
+	slog.InfoContext(ctx, "rule violation", "operation", r.Operation, "rule_code", r.Code, "product_id", r.ProductID, "quantity", r.Quantity)
+	for _, fn := range l { fn(ctx, r) }
+}
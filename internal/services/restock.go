@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// RestockNotifier tells a subscriber that a product is back in stock.
+type RestockNotifier interface {
+	NotifyBackInStock(ctx context.Context, n models.RestockNotification) error
+}
+
+// LogRestockNotifier writes notifications to the default slog logger.
+type LogRestockNotifier struct{}
+
+func (LogRestockNotifier) NotifyBackInStock(ctx context.Context, n models.RestockNotification) error {
+	slog.InfoContext(ctx, "back in stock", "user_id", n.UserID, "product_id", n.ProductID, "title", n.Title)
+	return nil
+}
+
+// NotifyMe subscribes the user to a back-in-stock notification for a product
+// that is out of stock or discontinued.
+func (s *ProductService) NotifyMe(ctx context.Context, req *dto.RestockSubscriptionRequest) (*dto.RestockSubscription, error) {
+	_ = ctx
+	if _, err := s.store.GetUserByID(req.UserID); err != nil { return nil, err }
+	return s.store.SubscribeRestock(req.UserID, req.ProductID)
+}
+
+func (s *ProductService) CancelNotifyMe(ctx context.Context, req *dto.RestockSubscriptionRequest) error {
+	_ = ctx
+	return s.store.UnsubscribeRestock(req.UserID, req.ProductID)
+}
+
+// DispatchRestockNotifications delivers up to limit queued notifications in
+// FIFO order. Delivery stops at the first failure so order is preserved; the
+// failed notification is retried on the next call. It returns how many were
+// delivered.
+func (s *ProductService) DispatchRestockNotifications(ctx context.Context, n RestockNotifier, limit int) int {
+	sent := 0
+	for _, msg := range s.store.PendingRestockNotifications(limit) {
+		if err := n.NotifyBackInStock(ctx, msg); err != nil {
+			slog.WarnContext(ctx, "back-in-stock notify failed", "notification_id", msg.ID, "error", err)
+			break
+		}
+		s.store.AckRestockNotification(msg.ID)
+		sent++
+	}
+	return sent
+}
+
+// RunRestockNotifier drains the notification outbox every interval, at most
+// RestockNotifyBatch per tick, until ctx is done.
+func (s *ProductService) RunRestockNotifier(ctx context.Context, n RestockNotifier, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.DispatchRestockNotifications(ctx, n, RestockNotifyBatch)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// SetReturnWindow changes how long after delivery returns may be requested.
+func (s *OrderService) SetReturnWindow(d time.Duration) { s.returnWindow = d }
+
+// DeliverOrder marks a shipped order delivered, opening its return window.
+func (s *OrderService) DeliverOrder(ctx context.Context, req *dto.OrderActionRequest) (*dto.Order, error) {
+	return s.store.DeliverOrder(ctx, req.ID)
+}
+
+// RequestReturn opens a return for some units of a delivered order within
+// the return window.
+func (s *OrderService) RequestReturn(ctx context.Context, req *dto.CreateReturnRequest) (*dto.ReturnAuthorization, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" || len(reason) > 500 { return nil, errors.New("a reason of at most 500 characters is required") }
+	if len(req.Items) == 0 { return nil, errors.New("at least one item is required") }
+	items := make([]models.ReturnItem, 0, len(req.Items))
+	seen := make(map[uint]bool, len(req.Items))
+	for _, it := range req.Items {
+		if it.Quantity <= 0 { return nil, errors.New("return quantity must be positive") }
+		if seen[it.ProductID] { return nil, errors.New("each product may appear once") }
+		seen[it.ProductID] = true
+		items = append(items, models.ReturnItem{ProductID: it.ProductID, Quantity: it.Quantity})
+	}
+	o, err := s.store.GetOrderByID(req.OrderID)
+	if err != nil || o.UserID != req.UserID { return nil, storage.ErrOrderNotFound }
+	if o.DeliveredAt != nil && time.Since(*o.DeliveredAt) > s.returnWindow { return nil, errors.New("return window has closed") }
+	return s.store.CreateReturn(ctx, &models.ReturnAuthorization{OrderID: o.ID, UserID: o.UserID, Items: items, Reason: reason})
+}
+
+func (s *OrderService) ListReturns(ctx context.Context, req *dto.ListReturnsRequest) ([]*dto.ReturnAuthorization, error) {
+	_ = ctx
+	status := strings.ToUpper(strings.TrimSpace(req.Status))
+	switch status {
+	case "", models.ReturnRequested, models.ReturnApproved, models.ReturnRejected, models.ReturnReceived, models.ReturnRefunded:
+	default:
+		return nil, errors.New("unknown return status")
+	}
+	return s.store.GetReturns(status)
+}
+
+func (s *OrderService) ApproveReturn(ctx context.Context, req *dto.ReturnActionRequest) (*dto.ReturnAuthorization, error) {
+	return s.store.DecideReturn(ctx, req.ID, true)
+}
+
+func (s *OrderService) RejectReturn(ctx context.Context, req *dto.ReturnActionRequest) (*dto.ReturnAuthorization, error) {
+	return s.store.DecideReturn(ctx, req.ID, false)
+}
+
+// ReceiveReturn books the returned units back into stock or writes them off.
+func (s *OrderService) ReceiveReturn(ctx context.Context, req *dto.ReceiveReturnRequest) (*dto.ReturnAuthorization, error) {
+	items := make([]models.ReturnItem, 0, len(req.Items))
+	for _, it := range req.Items { items = append(items, models.ReturnItem{ProductID: it.ProductID, Restocked: it.Restock, WrittenOff: it.WriteOff}) }
+	return s.store.ReceiveReturn(ctx, req.ID, items, req.WarehouseID, req.Actor)
+}
+
+// RefundReturn refunds a received return through the payment provider. The
+// refundable amount is the returned units' share of the discounted total and
+// of the books tax, plus shipping (with its tax) once every unit of the order
+// is back or when RefundShipping is set. Shipping is refunded at most once.
+//
+// The store claims the return, and settles whether it carries shipping,
+// before the provider is called, so concurrent refunds of one order cannot
+// both include shipping and one return cannot be refunded twice.
+func (s *OrderService) RefundReturn(ctx context.Context, req *dto.RefundReturnRequest) (*dto.ReturnAuthorization, error) {
+	o, r, shipping, err := s.store.ClaimReturnRefund(req.ID, func(o *models.Order) bool { return req.RefundShipping || allUnitsReturned(o) })
+	if err != nil { return nil, err }
+	res, err := s.refundClaimed(ctx, o, r, shipping, req.Amount)
+	if err != nil { s.store.ReleaseReturnRefund(r.ID) }
+	return res, err
+}
+
+// refundClaimed refunds a return claimed by RefundReturn; requested is the
+// amount asked for, 0 meaning all that is refundable.
+func (s *OrderService) refundClaimed(ctx context.Context, o *models.Order, r *models.ReturnAuthorization, shipping bool, requested float64) (*dto.ReturnAuthorization, error) {
+	if o.Payment == nil || o.Payment.Captured == 0 { return nil, errors.New("order has no captured payment") }
+	amount := refundableAmount(o, r.Items, shipping)
+	if requested < 0 || requested > amount { return nil, fmt.Errorf("refund amount must be between 0 and %.2f", amount) }
+	if requested > 0 { amount = requested }
+	if left := roundMoney(o.Payment.Captured - o.Payment.Refunded); amount > left { amount = left }
+	payment := o.Payment
+	if amount > 0 {
+		var err error
+		if payment, err = s.payments.Refund(ctx, o.Payment.AuthorizationID, amount, fmt.Sprintf("refund-return-%d", r.ID)); err != nil { return nil, err }
+	}
+	return s.store.RecordReturnRefund(ctx, r.ID, amount, payment)
+}
+
+// refundableAmount prices returned units: their share of the subtotal
+// scales the discounted total and the books tax.
+func refundableAmount(o *models.Order, items []models.ReturnItem, shipping bool) float64 {
+	if o.Subtotal == 0 { return 0 }
+	price := make(map[uint]float64, len(o.Items))
+	for _, it := range o.Items { price[it.ProductID] = it.UnitPrice }
+	returned := 0.0
+	for _, it := range items { returned += float64(it.Quantity) * price[it.ProductID] }
+	share := returned / o.Subtotal
+	amount := o.Total * share
+	for _, t := range o.TaxLines {
+		switch {
+		case t.Category == "shipping" && shipping:
+			amount += t.Amount
+		case t.Category != "shipping":
+			amount += t.Amount * share
+		}
+	}
+	if shipping { amount += o.Shipping }
+	return roundMoney(amount)
+}
+
+// allUnitsReturned reports whether the order's non-rejected returns cover
+// every unit ordered.
+func allUnitsReturned(o *models.Order) bool {
+	back := make(map[uint]int)
+	for _, r := range o.Returns {
+		if r.Status == models.ReturnRejected { continue }
+		for _, it := range r.Items { back[it.ProductID] += it.Quantity }
+	}
+	for _, it := range o.Items {
+		if back[it.ProductID] < it.Quantity { return false }
+	}
+	return true
+}
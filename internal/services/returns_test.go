@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+func TestReturns_WorkflowAndRefunds(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	cartSvc := NewCartService(store)
+	svc := NewOrderService(store)
+	// flat 5.00 shipping; user 1 ships to GB where books are zero-rated and
+	// shipping carries 20%
+	svc.SetPricer(&Pricer{Shipping: &TieredShipping{Tiers: []ShippingTier{{Cost: 5}}}, Tax: DefaultPricer().Tax})
+	if _, err := NewPromotionService(store).CreatePromotion(ctx, &dto.CreatePromotionRequest{Name: "Ten", Type: models.PromoPercentOff, Value: 10}); err != nil { t.Fatalf("promo: %v", err) }
+
+	// 2 x 45.00 + 1 x 25.00 = 115.00, less 10% = 103.50, + 5.00 + 1.00 tax
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	cartSvc.AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 7, Quantity: 1})
+	order, err := svc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	if order.GrandTotal != 109.5 { t.Fatalf("unexpected grand total %v", order.GrandTotal) }
+	line := func(qty int) []dto.ReturnLine { return []dto.ReturnLine{{ProductID: 1, Quantity: qty}} }
+
+	if _, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: line(1), Reason: "damaged"}); !errors.Is(err, storage.ErrOrderStatus) { t.Fatalf("expected undelivered order error, got %v", err) }
+	svc.ShipOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	if _, err := svc.DeliverOrder(ctx, &dto.OrderActionRequest{ID: order.ID}); err != nil { t.Fatalf("deliver: %v", err) }
+	if _, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 2, OrderID: order.ID, Items: line(1), Reason: "x"}); !errors.Is(err, storage.ErrOrderNotFound) { t.Fatalf("expected other user's order to be hidden, got %v", err) }
+	if _, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: line(3), Reason: "x"}); err == nil { t.Fatalf("expected over-quantity error") }
+	svc.SetReturnWindow(time.Nanosecond)
+	if _, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: line(1), Reason: "x"}); err == nil { t.Fatalf("expected closed window error") }
+	svc.SetReturnWindow(ReturnWindowDays * 24 * time.Hour)
+
+	// a rejected return frees its units again
+	r0, _ := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: line(2), Reason: "changed my mind"})
+	if _, err := svc.RejectReturn(ctx, &dto.ReturnActionRequest{ID: r0.ID}); err != nil { t.Fatalf("reject: %v", err) }
+	r1, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: line(1), Reason: "damaged"})
+	if err != nil { t.Fatalf("request: %v", err) }
+	if _, err := svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r1.ID}); !errors.Is(err, storage.ErrReturnStatus) { t.Fatalf("expected refund before receipt to fail, got %v", err) }
+	svc.ApproveReturn(ctx, &dto.ReturnActionRequest{ID: r1.ID})
+	if _, err := svc.ReceiveReturn(ctx, &dto.ReceiveReturnRequest{ID: r1.ID, Items: []dto.ReceiveLine{{ProductID: 1, Restock: 2}}}); err == nil { t.Fatalf("expected quantity mismatch") }
+	if _, err := svc.ReceiveReturn(ctx, &dto.ReceiveReturnRequest{ID: r1.ID, Items: []dto.ReceiveLine{{ProductID: 1, Restock: 1}}, Actor: "user:3"}); err != nil { t.Fatalf("receive: %v", err) }
+	// 45/115 of the discounted 103.50; shipping stays with the kept units
+	if _, err := svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r1.ID, Amount: 41}); err == nil { t.Fatalf("expected refund above 40.50 to fail") }
+	r1, err = svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r1.ID, Amount: 30})
+	if err != nil || r1.RefundAmount != 30 || r1.ShippingRefunded { t.Fatalf("partial refund: %+v %v", r1, err) }
+
+	// returning the rest brings shipping and its tax back too
+	r2, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: []dto.ReturnLine{{ProductID: 1, Quantity: 1}, {ProductID: 7, Quantity: 1}}, Reason: "wrong edition"})
+	if err != nil { t.Fatalf("request 2: %v", err) }
+	svc.ApproveReturn(ctx, &dto.ReturnActionRequest{ID: r2.ID})
+	if _, err := svc.ReceiveReturn(ctx, &dto.ReceiveReturnRequest{ID: r2.ID, Items: []dto.ReceiveLine{{ProductID: 1, WriteOff: 1}, {ProductID: 7, Restock: 1}}}); err != nil { t.Fatalf("receive 2: %v", err) }
+	r2, err = svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r2.ID})
+	if err != nil || r2.RefundAmount != 69 || !r2.ShippingRefunded { t.Fatalf("full refund: %+v %v", r2, err) }
+
+	o, _ := svc.GetOrder(ctx, &dto.GetOrderRequest{ID: order.ID, UserID: 1})
+	if len(o.Returns) != 3 || o.Payment.Refunded != 99 || o.Payment.Status != models.PaymentPartiallyRefunded { t.Fatalf("unexpected order after refunds: %+v", o.Payment) }
+	// product 1: 50 - 2 sold + 1 restocked; the written-off unit came and went
+	p, _ := store.GetProductByID(1)
+	mvs, _ := store.GetInventoryMovements(1)
+	if p.Stock != 49 || mvs[len(mvs)-1].Type != models.MovementDamage || mvs[len(mvs)-2].Type != models.MovementReturn { t.Fatalf("unexpected stock %d / ledger tail %+v", p.Stock, mvs[len(mvs)-1]) }
+	if d := store.ReconcileInventory(); len(d) != 0 { t.Fatalf("ledger out of balance: %+v", d) }
+}
+
+func TestReturns_ConcurrentRefundsClaimShippingOnce(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	svc := NewOrderService(store)
+	svc.SetPricer(&Pricer{Shipping: &TieredShipping{Tiers: []ShippingTier{{Cost: 5}}}, Tax: DefaultPricer().Tax})
+	fake := NewFakePaymentProvider()
+	svc.SetPaymentProvider(fake)
+
+	NewCartService(store).AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 2})
+	order, err := svc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	svc.ShipOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	svc.DeliverOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	received := func() *dto.ReturnAuthorization {
+		r, err := svc.RequestReturn(ctx, &dto.CreateReturnRequest{UserID: 1, OrderID: order.ID, Items: []dto.ReturnLine{{ProductID: 1, Quantity: 1}}, Reason: "damaged"})
+		if err != nil { t.Fatalf("request: %v", err) }
+		svc.ApproveReturn(ctx, &dto.ReturnActionRequest{ID: r.ID})
+		if _, err := svc.ReceiveReturn(ctx, &dto.ReceiveReturnRequest{ID: r.ID, Items: []dto.ReceiveLine{{ProductID: 1, Restock: 1}}}); err != nil { t.Fatalf("receive: %v", err) }
+		return r
+	}
+	r1, r2 := received(), received()
+	slow := newSlowPayments(fake)
+	svc.SetPaymentProvider(slow)
+
+	// r1's refund claims shipping and waits in the provider
+	first := make(chan *dto.ReturnAuthorization)
+	go func() {
+		r, err := svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r1.ID, RefundShipping: true})
+		if err != nil { t.Errorf("refund 1: %v", err) }
+		first <- r
+	}()
+	<-slow.started
+	if _, err := svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r1.ID}); !errors.Is(err, storage.ErrReturnStatus) { t.Fatalf("expected in-flight refund to block a second one, got %v", err) }
+	second := make(chan *dto.ReturnAuthorization)
+	go func() {
+		r, err := svc.RefundReturn(ctx, &dto.RefundReturnRequest{ID: r2.ID, RefundShipping: true})
+		if err != nil { t.Errorf("refund 2: %v", err) }
+		second <- r
+	}()
+	<-slow.started
+	close(slow.release)
+	got1, got2 := <-first, <-second
+	if got1 == nil || got2 == nil { t.FailNow() }
+	if !got1.ShippingRefunded || got2.ShippingRefunded { t.Fatalf("expected shipping refunded once, on the first claim: %v %v", got1.ShippingRefunded, got2.ShippingRefunded) }
+	if got1.RefundAmount <= got2.RefundAmount { t.Fatalf("expected the first refund to carry shipping: %v vs %v", got1.RefundAmount, got2.RefundAmount) }
+}
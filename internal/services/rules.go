@@ -1,5 +1,7 @@
 package services
 
+import "errors"
+
 const (
 	MaxDistinctCartItems    = 3
 	MaxQuantityPerLineItem  = 5
@@ -9,4 +11,40 @@ const (
 	HighValueReviewThreshold = 3000.0
 	DailyUserSpendCap       = 10000.0
 	DuplicateOrderWindowSec = 5
+	// InStockShipDays is the promised dispatch time for in-stock units;
+	// DefaultBackorderLeadDays applies to backordered units when the product
+	// sets no lead time of its own.
+	InStockShipDays          = 1
+	DefaultBackorderLeadDays = 14
+	// RestockNotifyBatch caps back-in-stock notifications sent per
+	// dispatcher tick, on top of the store's per-unit fanout.
+	RestockNotifyBatch = 50
+	// FreeShippingThreshold is the discounted merchandise total from which
+	// the default shipping calculator charges nothing; DefaultBookWeightGrams
+	// is assumed per unit when shipping by weight.
+	FreeShippingThreshold  = 50.0
+	DefaultBookWeightGrams = 500
+	// ReturnWindowDays is the default time after delivery in which a return
+	// may be requested (OrderService.SetReturnWindow).
+	ReturnWindowDays = 30
+	// CheckoutSagaStaleSeconds is how long a checkout saga may go without
+	// progress before it is reported as stuck and picked up by recovery.
+	CheckoutSagaStaleSeconds = 60
 )
+
+// ValidateRules checks that the business rules are consistent with each
+// other. OrderService.CheckRules runs it along with the rule settings the
+// service was configured with.
+func ValidateRules() error {
+	var errs []error
+	check := func(ok bool, msg string) {
+		if !ok { errs = append(errs, errors.New(msg)) }
+	}
+	check(MaxDistinctCartItems > 0 && MaxQuantityPerLineItem > 0, "cart item limits must be positive")
+	check(MaxTotalItemsInCart >= MaxQuantityPerLineItem, "MaxTotalItemsInCart is below MaxQuantityPerLineItem")
+	check(MinOrderAmount >= 0 && MinOrderAmount < CartRiskLimitTotal, "MinOrderAmount must be below CartRiskLimitTotal")
+	check(DailyUserSpendCap >= CartRiskLimitTotal, "DailyUserSpendCap is below CartRiskLimitTotal")
+	check(HighValueReviewThreshold > MinOrderAmount, "HighValueReviewThreshold is below MinOrderAmount")
+	check(DuplicateOrderWindowSec >= 0, "rule windows must not be negative")
+	return errors.Join(errs...)
+}
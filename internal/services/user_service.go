@@ -0,0 +1,17 @@
+package services
+
+import (
+	"context"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+type UserService struct { store *storage.MemoryStore }
+
+func NewUserService(store *storage.MemoryStore) *UserService { return &UserService{store: store} }
+
+func (s *UserService) GetUser(ctx context.Context, req *dto.GetUserRequest) (*dto.User, error) {
+	_ = ctx
+	return s.store.GetUserByID(req.ID)
+}
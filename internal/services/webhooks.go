@@ -0,0 +1,313 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// Webhook event types. Subscriptions list these, or "*" for all of them.
+const (
+	WebhookOrderPlaced           = "order.placed"
+	WebhookOrderApproved         = "order.approved"
+	WebhookOrderPaymentConfirmed = "order.payment_confirmed"
+	WebhookOrderShipped          = "order.shipped"
+	WebhookOrderDelivered        = "order.delivered"
+	WebhookOrderCancelled        = "order.cancelled"
+	WebhookProductCreated        = "product.created"
+	WebhookProductUpdated        = "product.updated"
+	WebhookProductDeleted        = "product.deleted"
+	WebhookPriceChanged          = "product.price_changed"
+	WebhookStockChanged          = "product.stock_changed"
+	WebhookReturnStatusChanged   = "return.status_changed"
+)
+
+// Delivery settings. A delivery gets WebhookMaxAttempts tries, spaced by
+// webhookBackoff, each cut off after WebhookTimeoutSeconds; DeliverDue sends
+// at most WebhookDeliveryBatch per pass. Signatures are valid for
+// WebhookToleranceSeconds either side of their timestamp.
+const (
+	WebhookMaxAttempts      = 6
+	WebhookRetryBaseSeconds = 10
+	WebhookTimeoutSeconds   = 5
+	WebhookDeliveryBatch    = 50
+	WebhookToleranceSeconds = 300
+)
+
+var webhookEvents = []string{WebhookOrderPlaced, WebhookOrderApproved, WebhookOrderPaymentConfirmed, WebhookOrderShipped, WebhookOrderDelivered, WebhookOrderCancelled, WebhookProductCreated, WebhookProductUpdated, WebhookProductDeleted, WebhookPriceChanged, WebhookStockChanged, WebhookReturnStatusChanged}
+
+// Headers sent with every webhook request. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookPayload is the JSON body of a webhook request. EventID is the same
+// on every attempt, so receivers can drop duplicates.
+type WebhookPayload struct {
+	EventID    uint64          `json:"eventId"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// WebhookService manages webhook subscriptions and delivers domain events to
+// them. Events arrive from an EventBus (Attach); each matching subscription
+// gets a delivery that is POSTed by DeliverDue and retried with exponential
+// backoff until WebhookMaxAttempts, after which it is DEAD until redelivered.
+type WebhookService struct {
+	store   *storage.MemoryStore
+	client  *http.Client
+	backoff func(attempt int) time.Duration
+}
+
+func NewWebhookService(store *storage.MemoryStore) *WebhookService {
+	return &WebhookService{store: store, client: &http.Client{Timeout: WebhookTimeoutSeconds * time.Second}, backoff: webhookBackoff}
+}
+
+// SetHTTPClient replaces the client used for deliveries.
+func (s *WebhookService) SetHTTPClient(c *http.Client) { s.client = c }
+
+// webhookBackoff waits WebhookRetryBaseSeconds before the first retry and
+// twice as long before each later one.
+func webhookBackoff(attempt int) time.Duration {
+	return time.Duration(WebhookRetryBaseSeconds) * time.Second << (attempt - 1)
+}
+
+// Attach subscribes the service to bus, queueing a delivery for every
+// subscription interested in each event.
+func (s *WebhookService) Attach(bus *EventBus) { bus.Subscribe("webhooks", s.enqueue) }
+
+func (s *WebhookService) enqueue(ctx context.Context, ev models.DomainEvent) error {
+	_ = ctx
+	typ := webhookEventFor(ev)
+	if typ == "" { return nil }
+	subs, err := s.store.GetWebhooks()
+	if err != nil { return err }
+	var payload []byte
+	for _, sub := range subs {
+		if !sub.Active || (!listed("*", sub.Events) && !listed(typ, sub.Events)) { continue }
+		if payload == nil {
+			if payload, err = json.Marshal(WebhookPayload{EventID: ev.ID, Type: typ, OccurredAt: ev.OccurredAt, Data: ev.Data}); err != nil { return err }
+		}
+		// a subscription deleted meanwhile simply gets nothing
+		if _, err := s.store.EnqueueWebhookDelivery(&models.WebhookDelivery{SubscriptionID: sub.ID, EventID: ev.ID, Event: typ, Payload: payload}); err != nil && !errors.Is(err, storage.ErrWebhookNotFound) { return err }
+	}
+	return nil
+}
+
+// webhookEventFor names the webhook event for a domain event, or returns ""
+// for events that are not sent.
+func webhookEventFor(ev models.DomainEvent) string {
+	switch ev.Type {
+	case models.EventOrderPlaced:
+		return WebhookOrderPlaced
+	case models.EventOrderStatusChanged:
+		var c models.StatusChange
+		if json.Unmarshal(ev.Data, &c) != nil { return "" }
+		switch {
+		case c.To == models.OrderCancelled:
+			return WebhookOrderCancelled
+		case c.From == models.OrderPendingPayment:
+			return WebhookOrderPaymentConfirmed
+		case c.To == models.OrderPlaced:
+			return WebhookOrderApproved
+		case c.To == models.OrderShipped:
+			return WebhookOrderShipped
+		case c.To == models.OrderDelivered:
+			return WebhookOrderDelivered
+		}
+	case models.EventProductCreated:
+		return WebhookProductCreated
+	case models.EventProductUpdated:
+		return WebhookProductUpdated
+	case models.EventProductDeleted:
+		return WebhookProductDeleted
+	case models.EventPriceChanged:
+		return WebhookPriceChanged
+	case models.EventStockChanged:
+		return WebhookStockChanged
+	case models.EventReturnStatusChanged:
+		return WebhookReturnStatusChanged
+	}
+	return ""
+}
+
+// SignWebhook computes the signature header value for a payload.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a received webhook's signature and rejects timestamps
+// more than WebhookToleranceSeconds away from now, so captured requests
+// cannot be replayed later.
+func VerifyWebhook(secret, timestamp, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil { return errors.New("invalid webhook timestamp") }
+	if d := now.Sub(time.Unix(ts, 0)); d > WebhookToleranceSeconds*time.Second || d < -WebhookToleranceSeconds*time.Second { return errors.New("webhook timestamp outside tolerance") }
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body))) { return errors.New("invalid webhook signature") }
+	return nil
+}
+
+// DeliverDue sends every delivery that is due and returns how many
+// succeeded.
+func (s *WebhookService) DeliverDue(ctx context.Context) int {
+	n := 0
+	for _, d := range s.store.DueWebhookDeliveries(time.Now(), WebhookDeliveryBatch) {
+		sub, err := s.store.GetWebhook(d.SubscriptionID)
+		if err != nil {
+			s.store.RecordWebhookAttempt(d.ID, models.WebhookAttempt{At: time.Now(), Error: err.Error()}, models.DeliveryDead, time.Time{})
+			continue
+		}
+		a := s.send(ctx, sub, d)
+		status, next := models.DeliverySucceeded, time.Time{}
+		if a.Error != "" {
+			status, next = models.DeliveryPending, time.Now().Add(s.backoff(d.Tries+1))
+			if d.Tries+1 >= WebhookMaxAttempts { status, next = models.DeliveryDead, time.Time{} }
+		} else {
+			n++
+		}
+		s.store.RecordWebhookAttempt(d.ID, a, status, next)
+	}
+	return n
+}
+
+func (s *WebhookService) send(ctx context.Context, sub *models.WebhookSubscription, d *models.WebhookDelivery) models.WebhookAttempt {
+	start := time.Now()
+	a := models.WebhookAttempt{At: start}
+	ts := strconv.FormatInt(start.Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil { a.Error = err.Error(); return a }
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set(WebhookEventHeader, d.Event)
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, ts, d.Payload))
+	resp, err := s.client.Do(req)
+	a.DurationMs = time.Since(start).Milliseconds()
+	if err != nil { a.Error = err.Error(); return a }
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 { a.Error = fmt.Sprintf("receiver returned %s", resp.Status) }
+	return a
+}
+
+// RunDeliveries calls DeliverDue every interval until ctx is done.
+func (s *WebhookService) RunDeliveries(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.DeliverDue(ctx)
+		}
+	}
+}
+
+// CreateWebhook adds a subscription. Without a secret one is generated; the
+// response is the only place it is shown.
+func (s *WebhookService) CreateWebhook(ctx context.Context, req *dto.WebhookRequest) (*dto.WebhookSubscription, error) {
+	_ = ctx
+	w, err := webhookFromRequest(req)
+	if err != nil { return nil, err }
+	if w.Secret == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil { return nil, err }
+		w.Secret = "whsec_" + hex.EncodeToString(buf)
+	}
+	return s.store.CreateWebhook(w)
+}
+
+// UpdateWebhook replaces a subscription; an empty secret keeps the current
+// one.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, req *dto.WebhookRequest) (*dto.WebhookSubscription, error) {
+	_ = ctx
+	w, err := webhookFromRequest(req)
+	if err != nil { return nil, err }
+	w.ID = req.ID
+	w, err = s.store.UpdateWebhook(w)
+	if err != nil { return nil, err }
+	w.Secret = ""
+	return w, nil
+}
+
+func webhookFromRequest(req *dto.WebhookRequest) (*models.WebhookSubscription, error) {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" { return nil, errors.New("url must be an absolute http or https URL") }
+	if len(req.Events) == 0 { return nil, errors.New("at least one event is required") }
+	events := make([]string, 0, len(req.Events))
+	for _, e := range req.Events {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e != "*" && !listed(e, webhookEvents) { return nil, fmt.Errorf("unknown event %q", e) }
+		if !listed(e, events) { events = append(events, e) }
+	}
+	if req.Secret != "" && len(req.Secret) < 16 { return nil, errors.New("secret must be at least 16 characters") }
+	active := req.Active == nil || *req.Active
+	return &models.WebhookSubscription{URL: u.String(), Events: events, Secret: req.Secret, Active: active}, nil
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, req *dto.GetWebhookRequest) (*dto.WebhookSubscription, error) {
+	_ = ctx
+	w, err := s.store.GetWebhook(req.ID)
+	if err != nil { return nil, err }
+	w.Secret = ""
+	return w, nil
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context, req *dto.ListWebhooksRequest) ([]*dto.WebhookSubscription, error) {
+	_ = ctx
+	items, err := s.store.GetWebhooks()
+	if err != nil { return nil, err }
+	for _, w := range items { w.Secret = "" }
+	return items, nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, req *dto.GetWebhookRequest) error {
+	_ = ctx
+	return s.store.DeleteWebhook(req.ID)
+}
+
+// ListDeliveries is the delivery log, newest first. Status DEAD lists the
+// dead letters.
+func (s *WebhookService) ListDeliveries(ctx context.Context, req *dto.ListWebhookDeliveriesRequest) ([]*dto.WebhookDelivery, error) {
+	_ = ctx
+	status := strings.ToUpper(strings.TrimSpace(req.Status))
+	if status != "" && status != models.DeliveryPending && status != models.DeliverySucceeded && status != models.DeliveryDead { return nil, errors.New("status must be PENDING, SUCCEEDED or DEAD") }
+	if req.SubscriptionID != 0 {
+		if _, err := s.store.GetWebhook(req.SubscriptionID); err != nil { return nil, err }
+	}
+	return s.store.GetWebhookDeliveries(req.SubscriptionID, status)
+}
+
+// Redeliver queues a delivery (typically a dead letter) to be sent again on
+// the next pass, with a fresh set of attempts.
+func (s *WebhookService) Redeliver(ctx context.Context, req *dto.RedeliverWebhookRequest) (*dto.WebhookDelivery, error) {
+	_ = ctx
+	return s.store.RedeliverWebhook(req.ID)
+}
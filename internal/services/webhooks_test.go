@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"ecom-book-store-sample-api/internal/dto"
+	"ecom-book-store-sample-api/internal/models"
+	"ecom-book-store-sample-api/internal/storage"
+)
+
+// webhookReceiver records verified webhook requests; while failing is set it
+// answers 503.
+type webhookReceiver struct {
+	secret  string
+	mu      sync.Mutex
+	failing bool
+	got     []WebhookPayload
+	errs    []error
+}
+
+func (rv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	if rv.failing { w.WriteHeader(http.StatusServiceUnavailable); return }
+	if err := VerifyWebhook(rv.secret, r.Header.Get(WebhookTimestampHeader), r.Header.Get(WebhookSignatureHeader), body, time.Now()); err != nil {
+		rv.errs = append(rv.errs, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var p WebhookPayload
+	json.Unmarshal(body, &p)
+	if h := r.Header.Get(WebhookEventHeader); h != p.Type { rv.errs = append(rv.errs, fmt.Errorf("event header %q, payload %q", h, p.Type)) }
+	rv.got = append(rv.got, p)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhooks_SignedOrderEvents(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx)
+	svc := NewWebhookService(store)
+	svc.Attach(bus)
+	rv := &webhookReceiver{secret: "test-secret-0123456789"}
+	srv := httptest.NewServer(rv)
+	defer srv.Close()
+	sub, err := svc.CreateWebhook(ctx, &dto.WebhookRequest{URL: srv.URL, Events: []string{"order.placed", "order.cancelled"}, Secret: rv.secret})
+	if err != nil { t.Fatalf("create: %v", err) }
+
+	NewCartService(store).AddToCart(ctx, &dto.AddToCartRequest{UserID: 1, ProductID: 1, Quantity: 1})
+	orderSvc := NewOrderService(store)
+	order, err := orderSvc.PlaceOrder(ctx, &dto.PlaceOrderRequest{UserID: 1})
+	if err != nil { t.Fatalf("place: %v", err) }
+	orderSvc.CancelOrder(ctx, &dto.OrderActionRequest{ID: order.ID})
+	bus.Dispatch(ctx)
+	bus.Dispatch(ctx) // replaying the outbox queues nothing twice
+	if n := svc.DeliverDue(ctx); n != 2 { t.Fatalf("expected 2 deliveries, got %d", n) }
+	if len(rv.errs) != 0 || len(rv.got) != 2 || rv.got[0].Type != WebhookOrderPlaced || rv.got[1].Type != WebhookOrderCancelled { t.Fatalf("received %+v, errors %v", rv.got, rv.errs) }
+	var placed models.Order
+	json.Unmarshal(rv.got[0].Data, &placed)
+	if placed.ID != order.ID { t.Fatalf("expected the order in the payload, got %s", rv.got[0].Data) }
+	log, _ := svc.ListDeliveries(ctx, &dto.ListWebhookDeliveriesRequest{SubscriptionID: sub.ID, Status: "succeeded"})
+	if len(log) != 2 || log[0].Attempts[0].StatusCode != http.StatusNoContent { t.Fatalf("unexpected delivery log %+v", log) }
+
+	// a capped history keeps the newest successes, which still deduplicate
+	store.SetDeliveryHistory(1)
+	kept, _ := svc.ListDeliveries(ctx, &dto.ListWebhookDeliveriesRequest{SubscriptionID: sub.ID})
+	if len(kept) != 1 || kept[0].ID != log[0].ID { t.Fatalf("expected only the newest delivery kept, got %+v", kept) }
+	if again, _ := store.EnqueueWebhookDelivery(&models.WebhookDelivery{SubscriptionID: sub.ID, EventID: kept[0].EventID}); again.ID != kept[0].ID { t.Fatalf("expected the kept delivery returned, got %+v", again) }
+
+	// a tampered body, wrong secret or stale timestamp fails verification
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := SignWebhook(rv.secret, ts, []byte(`{"a":1}`))
+	if VerifyWebhook(rv.secret, ts, sig, []byte(`{"a":2}`), time.Now()) == nil { t.Fatalf("expected tampered body rejected") }
+	if VerifyWebhook("another-secret-0123", ts, sig, []byte(`{"a":1}`), time.Now()) == nil { t.Fatalf("expected wrong secret rejected") }
+	if VerifyWebhook(rv.secret, ts, sig, []byte(`{"a":1}`), time.Now().Add(time.Hour)) == nil { t.Fatalf("expected stale timestamp rejected") }
+}
+
+func TestWebhooks_RetriesThenDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	storage.Seed(store)
+	bus := NewEventBus(store)
+	bus.Dispatch(ctx)
+	svc := NewWebhookService(store)
+	svc.backoff = func(int) time.Duration { return 0 }
+	svc.Attach(bus)
+	rv := &webhookReceiver{secret: "test-secret-0123456789", failing: true}
+	srv := httptest.NewServer(rv)
+	defer srv.Close()
+	svc.CreateWebhook(ctx, &dto.WebhookRequest{URL: srv.URL, Events: []string{"*"}, Secret: rv.secret})
+	inactive := false
+	svc.CreateWebhook(ctx, &dto.WebhookRequest{URL: srv.URL, Events: []string{"*"}, Active: &inactive})
+
+	NewProductService(store).DeleteProduct(ctx, &dto.DeleteProductRequest{ID: 5})
+	bus.Dispatch(ctx)
+	for i := 0; i < WebhookMaxAttempts+2; i++ { svc.DeliverDue(ctx) }
+	dead, _ := svc.ListDeliveries(ctx, &dto.ListWebhookDeliveriesRequest{Status: models.DeliveryDead})
+	if len(dead) != 1 || len(dead[0].Attempts) != WebhookMaxAttempts || dead[0].Event != WebhookProductDeleted { t.Fatalf("expected one dead letter after %d attempts, got %+v", WebhookMaxAttempts, dead) }
+	if dead[0].Attempts[0].StatusCode != http.StatusServiceUnavailable || dead[0].Attempts[0].Error == "" { t.Fatalf("expected the failure recorded, got %+v", dead[0].Attempts[0]) }
+
+	rv.failing = false
+	if _, err := svc.Redeliver(ctx, &dto.RedeliverWebhookRequest{ID: dead[0].ID}); err != nil { t.Fatalf("redeliver: %v", err) }
+	if n := svc.DeliverDue(ctx); n != 1 || len(rv.got) != 1 || rv.got[0].EventID != dead[0].EventID { t.Fatalf("expected the dead letter delivered, got %d %+v", n, rv.got) }
+	all, _ := svc.ListDeliveries(ctx, &dto.ListWebhookDeliveriesRequest{})
+	if len(all) != 1 || all[0].Status != models.DeliverySucceeded || len(all[0].Attempts) != WebhookMaxAttempts+1 { t.Fatalf("unexpected log %+v", all) }
+
+	if d := webhookBackoff(1); d != WebhookRetryBaseSeconds*time.Second { t.Fatalf("unexpected first backoff %v", d) }
+	if d := webhookBackoff(3); d != 4*WebhookRetryBaseSeconds*time.Second { t.Fatalf("unexpected third backoff %v", d) }
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrAddressNotFound = errors.New("address not found")
+)
+
+// CreateAddress adds an address to the user's book. A user's first address
+// becomes both default shipping and billing; flagging a later one as a
+// default takes the flag from the previous holder.
+func (m *MemoryStore) CreateAddress(a *models.Address) (*models.Address, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[a.UserID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	now := time.Now()
+	v := *a
+	v.ID = m.nextAddressID
+	m.nextAddressID++
+	v.CreatedAt, v.UpdatedAt = now, now
+	if len(m.addressesOf(a.UserID)) == 0 {
+		v.DefaultShipping, v.DefaultBilling = true, true
+	}
+	m.claimDefaults(&v)
+	m.addresses[v.ID] = &v
+	res := v
+	return &res, nil
+}
+
+// UpdateAddress replaces an address's fields, keeping its ID, owner and
+// creation time.
+func (m *MemoryStore) UpdateAddress(a *models.Address) (*models.Address, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.addresses[a.ID]
+	if !ok || cur.UserID != a.UserID {
+		return nil, ErrAddressNotFound
+	}
+	v := *a
+	v.CreatedAt, v.UpdatedAt = cur.CreatedAt, time.Now()
+	m.claimDefaults(&v)
+	m.addresses[v.ID] = &v
+	res := v
+	return &res, nil
+}
+
+func (m *MemoryStore) DeleteAddress(userID, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.addresses[id]
+	if !ok || a.UserID != userID {
+		return ErrAddressNotFound
+	}
+	delete(m.addresses, id)
+	return nil
+}
+
+func (m *MemoryStore) GetAddress(userID, id uint) (*models.Address, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.addresses[id]
+	if !ok || a.UserID != userID {
+		return nil, ErrAddressNotFound
+	}
+	v := *a
+	return &v, nil
+}
+
+// GetAddresses returns the user's address book, oldest first.
+func (m *MemoryStore) GetAddresses(userID uint) ([]*models.Address, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	res := []*models.Address{}
+	for _, a := range m.addressesOf(userID) {
+		v := *a
+		res = append(res, &v)
+	}
+	return res, nil
+}
+
+// GetDefaultAddresses returns the user's default shipping and billing
+// addresses; either is nil when unset.
+func (m *MemoryStore) GetDefaultAddresses(userID uint) (shipping, billing *models.Address) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, a := range m.addressesOf(userID) {
+		if a.DefaultShipping {
+			v := *a
+			shipping = &v
+		}
+		if a.DefaultBilling {
+			v := *a
+			billing = &v
+		}
+	}
+	return shipping, billing
+}
+
+// addressesOf lists the user's addresses by ID.
+// Must be called with m.mu held.
+func (m *MemoryStore) addressesOf(userID uint) []*models.Address {
+	var res []*models.Address
+	for _, a := range m.addresses {
+		if a.UserID == userID {
+			res = append(res, a)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// claimDefaults clears the default flags a now holds from the user's other
+// addresses. Must be called with m.mu held.
+func (m *MemoryStore) claimDefaults(a *models.Address) {
+	for _, o := range m.addresses {
+		if o.UserID != a.UserID || o.ID == a.ID {
+			continue
+		}
+		if a.DefaultShipping {
+			o.DefaultShipping = false
+		}
+		if a.DefaultBilling {
+			o.DefaultBilling = false
+		}
+	}
+}
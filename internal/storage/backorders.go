@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// backorder is the unfilled part of one order line.
+type backorder struct {
+	orderID   uint
+	productID uint
+	remaining int
+}
+
+// splitLine divides a requested quantity into what the user can take from
+// stock now and what would have to be backordered. Must be called with m.mu
+// held.
+func (m *MemoryStore) splitLine(p *models.Product, userID uint, qty int, now time.Time) (have, short int) {
+	avail := p.Stock - m.heldExcept(p.ID, userID, now)
+	if avail < 0 {
+		avail = 0
+	}
+	if qty <= avail {
+		return qty, 0
+	}
+	return avail, qty - avail
+}
+
+// outstandingBackorders sums the unfilled backordered units of a product.
+// Must be called with m.mu held.
+func (m *MemoryStore) outstandingBackorders(productID uint) int {
+	n := 0
+	for _, b := range m.backorders {
+		if b.productID == productID {
+			n += b.remaining
+		}
+	}
+	return n
+}
+
+// backorderCapacity is how many more units of p may be backordered or
+// pre-ordered. Must be called with m.mu held.
+func (m *MemoryStore) backorderCapacity(p *models.Product, now time.Time) int {
+	if !p.AcceptsBackorders(now) {
+		return 0
+	}
+	c := p.MaxBackorder - m.outstandingBackorders(p.ID)
+	if c < 0 {
+		return 0
+	}
+	return c
+}
+
+// BackorderCapacity reports how many more units of a product may be ordered
+// beyond available stock.
+func (m *MemoryStore) BackorderCapacity(productID uint) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.products[productID]
+	if !ok {
+		return 0, ErrProductNotFound
+	}
+	return m.backorderCapacity(p, time.Now()), nil
+}
+
+// fillBackorders hands stock just received at warehouseID to outstanding
+// backorders of p, oldest first, recording a sale per fill and updating the
+// order line. Must be called with m.mu held for writing.
+func (m *MemoryStore) fillBackorders(ctx context.Context, p *models.Product, warehouseID uint) {
+	kept := m.backorders[:0]
+	for _, b := range m.backorders {
+		if b.productID == p.ID {
+			take := m.stockByWarehouse[p.ID][warehouseID]
+			if take > b.remaining {
+				take = b.remaining
+			}
+			if take > 0 {
+				if _, err := m.applyMovement(ctx, p, models.InventoryMovement{WarehouseID: warehouseID, Type: models.MovementSale, Quantity: -take, Reason: "backorder fill", Actor: ActorSystem, OrderID: b.orderID}); err == nil {
+					b.remaining -= take
+					m.recordBackorderFill(b.orderID, p.ID, warehouseID, take)
+				}
+			}
+		}
+		if b.remaining > 0 {
+			kept = append(kept, b)
+		}
+	}
+	m.backorders = kept
+}
+
+// recordBackorderFill moves qty units of an order line from backordered to
+// allocated. Must be called with m.mu held for writing.
+func (m *MemoryStore) recordBackorderFill(orderID, productID, warehouseID uint, qty int) {
+	o, ok := m.orders[orderID]
+	if !ok {
+		// the order may still be pending in a checkout saga
+		if o, ok = m.pendingOrders[orderID]; !ok {
+			return
+		}
+	}
+	for i := range o.Items {
+		it := &o.Items[i]
+		if it.ProductID != productID {
+			continue
+		}
+		it.Backordered -= qty
+		for j := range it.Allocations {
+			if it.Allocations[j].WarehouseID == warehouseID {
+				it.Allocations[j].Quantity += qty
+				return
+			}
+		}
+		it.Allocations = append(it.Allocations, models.Allocation{WarehouseID: warehouseID, Quantity: qty})
+		return
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// pingRetryInterval is how often Ping retries a lock held by a writer.
+const pingRetryInterval = 5 * time.Millisecond
+
+// ErrStoreClosed is returned by Ping once the store has been closed.
+var ErrStoreClosed = errors.New("store is closed")
+
+// Ping reports whether the store can serve requests: it must not be closed
+// and its lock must be obtainable before ctx is done, so a stuck writer shows
+// up as unhealthy rather than as hung requests. Ping polls with TryRLock
+// instead of blocking, so a probe that gives up leaves nothing waiting on the
+// lock behind it.
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	for !m.mu.TryRLock() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pingRetryInterval):
+		}
+	}
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrStoreClosed
+	}
+	return nil
+}
+
+// Close waits for in-flight writes and marks the store closed. The in-memory
+// store has nothing to persist; a durable backend would flush here.
+func (m *MemoryStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// EnableStockHolds turns on cart holds lasting ttl. With holds enabled, adding
+// to a cart reserves the line quantity for that user until the hold expires,
+// is released by removing the item, or is converted into a sale at checkout.
+func (m *MemoryStore) EnableStockHolds(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.holdTTL = ttl
+}
+
+// productView clones p and fills in the computed Held, Available and
+// Backordered counts. A hold that lapsed before the sweeper removed it still
+// changed Held, so UpdatedAt is moved up to its expiry.
+// Must be called with m.mu held.
+func (m *MemoryStore) productView(p *models.Product, now time.Time) *models.Product {
+	v := cloneProduct(p)
+	for _, h := range m.holds[p.ID] {
+		if !h.ExpiresAt.After(now) && h.ExpiresAt.After(v.UpdatedAt) {
+			v.UpdatedAt = h.ExpiresAt
+		}
+	}
+	v.Held = m.heldExcept(p.ID, 0, now)
+	v.Backordered = m.outstandingBackorders(p.ID)
+	v.Available = v.Stock - v.Held
+	if v.Available < 0 {
+		v.Available = 0
+	}
+	return v
+}
+
+// heldExcept sums the active holds on a product, ignoring exceptUser's own
+// hold (0 ignores none). Must be called with m.mu held.
+func (m *MemoryStore) heldExcept(productID, exceptUser uint, now time.Time) int {
+	total := 0
+	for userID, h := range m.holds[productID] {
+		if userID != exceptUser && h.ExpiresAt.After(now) {
+			total += h.Quantity
+		}
+	}
+	return total
+}
+
+// AvailableToUser is the on-hand stock of a product minus active holds of
+// other users, i.e. the most this user's cart line may contain.
+func (m *MemoryStore) AvailableToUser(productID, userID uint) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.products[productID]
+	if !ok {
+		return 0, ErrProductNotFound
+	}
+	return p.Stock - m.heldExcept(productID, userID, time.Now()), nil
+}
+
+// placeHold sets the user's hold on a product to qty and restarts its timer.
+// No-op when holds are disabled. Must be called with m.mu held for writing.
+func (m *MemoryStore) placeHold(ctx context.Context, userID, productID uint, qty int, now time.Time) {
+	if m.holdTTL <= 0 {
+		return
+	}
+	if qty <= 0 {
+		m.releaseHold(ctx, userID, productID, now)
+		return
+	}
+	byUser, ok := m.holds[productID]
+	if !ok {
+		byUser = make(map[uint]*models.StockHold)
+		m.holds[productID] = byUser
+	}
+	prev := byUser[userID]
+	byUser[userID] = &models.StockHold{UserID: userID, ProductID: productID, Quantity: qty, ExpiresAt: now.Add(m.holdTTL)}
+	if prev == nil || prev.Quantity != qty || !prev.ExpiresAt.After(now) {
+		m.holdsChanged(ctx, productID, now)
+	}
+}
+
+// releaseHold drops the user's hold on a product. Must be called with m.mu
+// held for writing.
+func (m *MemoryStore) releaseHold(ctx context.Context, userID, productID uint, now time.Time) {
+	byUser, ok := m.holds[productID]
+	if !ok {
+		return
+	}
+	h, ok := byUser[userID]
+	if !ok {
+		return
+	}
+	delete(byUser, userID)
+	if len(byUser) == 0 {
+		delete(m.holds, productID)
+	}
+	// an expired hold no longer counted; the sweep reports it
+	if h.ExpiresAt.After(now) {
+		m.holdsChanged(ctx, productID, now)
+	}
+}
+
+// holdsChanged records that a product's Held and Available counts changed:
+// it touches the product and the catalog, so ETags and conditional reads see
+// the change, and emits HoldsChanged. Version is left alone; holds are not
+// catalog edits. Must be called with m.mu held for writing.
+func (m *MemoryStore) holdsChanged(ctx context.Context, productID uint, now time.Time) {
+	p, ok := m.products[productID]
+	if !ok {
+		return
+	}
+	m.touchProduct(p, now)
+	v := m.productView(p, now)
+	m.recordEvent(ctx, models.EventHoldsChanged, models.AggregateProduct, p.ID, models.HoldChange{ProductID: p.ID, Version: v.Version, Held: v.Held, Available: v.Available})
+}
+
+// GetHoldsByUser lists the user's active holds.
+func (m *MemoryStore) GetHoldsByUser(userID uint) ([]*models.StockHold, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	res := make([]*models.StockHold, 0)
+	for _, byUser := range m.holds {
+		if h, ok := byUser[userID]; ok && h.ExpiresAt.After(now) {
+			v := *h
+			res = append(res, &v)
+		}
+	}
+	return res, nil
+}
+
+// ExpireHolds removes holds that expired at or before now and returns how
+// many were removed. Expired holds are already ignored by availability
+// checks; the sweep reclaims memory and reports the change (holdsChanged)
+// for each product whose holds lapsed, so cached views catch up.
+func (m *MemoryStore) ExpireHolds(ctx context.Context, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	var changed []uint
+	for productID, byUser := range m.holds {
+		before := n
+		for userID, h := range byUser {
+			if !h.ExpiresAt.After(now) {
+				delete(byUser, userID)
+				n++
+			}
+		}
+		if len(byUser) == 0 {
+			delete(m.holds, productID)
+		}
+		if n > before {
+			changed = append(changed, productID)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i] < changed[j] })
+	for _, id := range changed {
+		m.holdsChanged(ctx, id, now)
+	}
+	return n
+}
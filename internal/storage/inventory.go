@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// Actors recorded on movements the store generates itself.
+const (
+	ActorSystem  = "system"
+	ActorCatalog = "catalog"
+)
+
+// MaxProductStock caps a product's total stock. Receipts and adjustments that
+// would push it higher are refused; movements that return previously held
+// stock (cancellations, returns, transfers) are not.
+const MaxProductStock = 10000
+
+var (
+	ErrNegativeStock = errors.New("movement would make stock negative")
+	ErrStockLimit    = errors.New("movement would exceed the stock limit")
+)
+
+// applyMovement changes the product's stock at mv.WarehouseID by mv.Quantity
+// and appends the matching ledger entry. Together with appendMovement it is
+// the only way stock may change. Must be called with m.mu held for writing.
+func (m *MemoryStore) applyMovement(ctx context.Context, p *models.Product, mv models.InventoryMovement) (*models.InventoryMovement, error) {
+	if err := m.checkMovement(p, mv); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	m.addWarehouseStock(p.ID, mv.WarehouseID, mv.Quantity)
+	p.Stock += mv.Quantity
+	m.touchProduct(p, now)
+	return m.appendMovement(ctx, p, mv, now), nil
+}
+
+// checkMovement reports why applyMovement would refuse mv. Must be called
+// with m.mu held.
+func (m *MemoryStore) checkMovement(p *models.Product, mv models.InventoryMovement) error {
+	if _, ok := m.warehouses[mv.WarehouseID]; !ok {
+		return ErrWarehouseNotFound
+	}
+	if m.stockByWarehouse[p.ID][mv.WarehouseID]+mv.Quantity < 0 {
+		return ErrNegativeStock
+	}
+	if (mv.Type == models.MovementReceipt || mv.Type == models.MovementAdjustment) && mv.Quantity > 0 && p.Stock+mv.Quantity > MaxProductStock {
+		return ErrStockLimit
+	}
+	return nil
+}
+
+// appendMovement records a change that has already been applied to p.Stock
+// and the warehouse level. Must be called with m.mu held for writing.
+func (m *MemoryStore) appendMovement(ctx context.Context, p *models.Product, mv models.InventoryMovement, at time.Time) *models.InventoryMovement {
+	mv.ID = m.nextMovementID
+	mv.ProductID = p.ID
+	mv.StockAfter = p.Stock
+	mv.CreatedAt = at
+	m.nextMovementID++
+	m.movements = append(m.movements, &mv)
+	m.recordEvent(ctx, models.EventStockChanged, models.AggregateProduct, p.ID, mv)
+	m.checkReorderPoint(p, mv.Quantity, mv.Type, at)
+	return &mv
+}
+
+// addWarehouseStock adjusts a single (product, warehouse) level. Must be
+// called with m.mu held for writing.
+func (m *MemoryStore) addWarehouseStock(productID, warehouseID uint, qty int) {
+	levels, ok := m.stockByWarehouse[productID]
+	if !ok {
+		levels = make(map[uint]int)
+		m.stockByWarehouse[productID] = levels
+	}
+	levels[warehouseID] += qty
+}
+
+// RecordMovement applies a ledger movement to a product's stock. A zero
+// WarehouseID means the default warehouse. Stock received this way goes to
+// outstanding backorders first; if the product becomes sellable, waiting
+// back-in-stock subscribers are queued for notification.
+func (m *MemoryStore) RecordMovement(ctx context.Context, mv *models.InventoryMovement) (*models.InventoryMovement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.products[mv.ProductID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	in := *mv
+	if in.WarehouseID == 0 {
+		in.WarehouseID = DefaultWarehouseID
+	}
+	wasSellable := sellable(p)
+	rec, err := m.applyMovement(ctx, p, in)
+	if err != nil {
+		return nil, err
+	}
+	v := *rec
+	if in.Quantity > 0 {
+		m.fillBackorders(ctx, p, in.WarehouseID)
+		m.checkBackInStock(p, wasSellable)
+	}
+	return &v, nil
+}
+
+// TransferStock moves qty units of a product between warehouses as a pair of
+// transfer movements. Total stock is unchanged. Both legs are checked before
+// either is applied, so a transfer is recorded whole or not at all.
+func (m *MemoryStore) TransferStock(ctx context.Context, productID, from, to uint, qty int, reason, actor string) ([]*models.InventoryMovement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.products[productID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	if from == to || qty <= 0 {
+		return nil, errors.New("transfer needs two different warehouses and a positive quantity")
+	}
+	legs := []models.InventoryMovement{
+		{WarehouseID: from, Type: models.MovementTransfer, Quantity: -qty, Reason: reason, Actor: actor},
+		{WarehouseID: to, Type: models.MovementTransfer, Quantity: qty, Reason: reason, Actor: actor},
+	}
+	for _, mv := range legs {
+		if err := m.checkMovement(p, mv); err != nil {
+			return nil, err
+		}
+	}
+	out, err := m.applyMovement(ctx, p, legs[0])
+	if err != nil {
+		return nil, err
+	}
+	in, err := m.applyMovement(ctx, p, legs[1])
+	if err != nil {
+		return nil, err // checked above; cannot happen
+	}
+	a, b := *out, *in
+	return []*models.InventoryMovement{&a, &b}, nil
+}
+
+// GetInventoryMovements returns a product's ledger, oldest first. Movements
+// of deleted products are kept.
+func (m *MemoryStore) GetInventoryMovements(productID uint) ([]*models.InventoryMovement, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.InventoryMovement, 0)
+	for _, mv := range m.movements {
+		if mv.ProductID == productID {
+			v := *mv
+			res = append(res, &v)
+		}
+	}
+	return res, nil
+}
+
+// GetWarehouseStock returns a product's on-hand level at each warehouse that
+// has ever held it, in warehouse ID order.
+func (m *MemoryStore) GetWarehouseStock(productID uint) ([]models.WarehouseStock, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.products[productID]; !ok {
+		return nil, ErrProductNotFound
+	}
+	res := make([]models.WarehouseStock, 0)
+	for wid, qty := range m.stockByWarehouse[productID] {
+		res = append(res, models.WarehouseStock{WarehouseID: wid, Quantity: qty})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].WarehouseID < res[j].WarehouseID })
+	return res, nil
+}
+
+// ReconcileInventory compares every product's total and per-warehouse stock
+// with the ledger and returns the entries that disagree, in ID order.
+func (m *MemoryStore) ReconcileInventory() []models.InventoryDiscrepancy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sums := make(map[uint]int, len(m.products))
+	perWarehouse := make(map[uint]map[uint]int, len(m.products))
+	for _, mv := range m.movements {
+		sums[mv.ProductID] += mv.Quantity
+		if perWarehouse[mv.ProductID] == nil {
+			perWarehouse[mv.ProductID] = make(map[uint]int)
+		}
+		perWarehouse[mv.ProductID][mv.WarehouseID] += mv.Quantity
+	}
+	res := make([]models.InventoryDiscrepancy, 0)
+	for id, p := range m.products {
+		if sums[id] != p.Stock {
+			res = append(res, models.InventoryDiscrepancy{ProductID: id, Stock: p.Stock, LedgerSum: sums[id]})
+		}
+		seen := make(map[uint]bool)
+		for wid, qty := range m.stockByWarehouse[id] {
+			seen[wid] = true
+			if perWarehouse[id][wid] != qty {
+				res = append(res, models.InventoryDiscrepancy{ProductID: id, WarehouseID: wid, Stock: qty, LedgerSum: perWarehouse[id][wid]})
+			}
+		}
+		for wid, sum := range perWarehouse[id] {
+			if !seen[wid] && sum != 0 {
+				res = append(res, models.InventoryDiscrepancy{ProductID: id, WarehouseID: wid, LedgerSum: sum})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].ProductID != res[j].ProductID {
+			return res[i].ProductID < res[j].ProductID
+		}
+		return res[i].WarehouseID < res[j].WarehouseID
+	})
+	return res
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"ecom-book-store-sample-api/internal/metrics"
+)
+
+// lockWait is named for what it measures: only acquisitions that had to
+// wait are observed, so its _count is contentions, not acquisitions, and its
+// quantiles describe contended waits only.
+var lockWait = metrics.NewHistogram("bookstore_store_lock_contended_wait_seconds",
+	"Time spent waiting for the store lock by acquisitions that found it held, by mode (read or write). Uncontended acquisitions are not observed.",
+	[]float64{0.000001, 0.00001, 0.0001, 0.001, 0.01, 0.1, 1}, "mode")
+
+// timedRWMutex is a sync.RWMutex that records how long callers wait for it.
+// Only contended acquisitions are recorded: the uncontended fast path, which
+// is nearly every read, stays free of the histogram's own locking.
+type timedRWMutex struct {
+	sync.RWMutex
+}
+
+func (l *timedRWMutex) Lock() {
+	if l.TryLock() {
+		return
+	}
+	start := time.Now()
+	l.RWMutex.Lock()
+	lockWait.ObserveDuration(time.Since(start), "write")
+}
+
+func (l *timedRWMutex) RLock() {
+	if l.TryRLock() {
+		return
+	}
+	start := time.Now()
+	l.RWMutex.RLock()
+	lockWait.ObserveDuration(time.Since(start), "read")
+}
+
+// CatalogStats returns the number of products and their total stock.
+func (m *MemoryStore) CatalogStats() (products, stock int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, p := range m.products {
+		stock += p.Stock
+	}
+	return len(m.products), stock
+}
+
+// RegisterMetrics adds gauges for the catalog's size and total stock to r.
+func (m *MemoryStore) RegisterMetrics(r *metrics.Registry) {
+	r.NewGaugeFunc("bookstore_catalog_products", "Products in the catalog.", func() float64 {
+		n, _ := m.CatalogStats()
+		return float64(n)
+	})
+	r.NewGaugeFunc("bookstore_catalog_stock_units", "Units in stock across the catalog.", func() float64 {
+		_, n := m.CatalogStats()
+		return float64(n)
+	})
+}
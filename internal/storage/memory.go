@@ -1,38 +1,167 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"math"
 	"sort"
-	"sync"
 	"time"
 
 	"ecom-book-store-sample-api/internal/models"
 )
 
+// Sentinel errors callers may match with errors.Is.
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrVersionConflict = errors.New("product was modified concurrently")
+	ErrDuplicateISBN   = errors.New("isbn already exists")
+	// ErrInsufficientAvailable keeps the cart rule's wording for hold conflicts.
+	ErrInsufficientAvailable = errors.New("insufficient stock for requested quantity")
+)
+
 type MemoryStore struct {
-	mu sync.RWMutex
+	mu timedRWMutex // records lock wait times; see lock.go
 
 	users       map[uint]*models.User
 	products    map[uint]*models.Product
+	productISBN map[string]uint // isbn -> product ID, for products that have one
 	carts       map[uint]*models.Cart     // keyed by userID
 	orders      map[uint]*models.Order
 	nextUserID   uint
 	nextProductID uint
 	nextCartID    uint
 	nextOrderID   uint
+
+	movements      []*models.InventoryMovement // append-only stock ledger
+	nextMovementID uint
+	backorders     []*backorder // outstanding backordered lines, oldest first
+
+	stockAlerts         []models.StockAlert // queued until drained by a dispatcher
+	alertSignal         chan struct{}
+	purchaseOrders      map[uint]*models.PurchaseOrder
+	nextPurchaseOrderID uint
+
+	restockSubs        map[uint][]*models.RestockSubscription // FIFO per product
+	restockOutbox      []*models.RestockNotification          // undelivered, oldest first
+	nextNotificationID uint
+	restockFanout      int
+
+	priceHistory    map[uint][]models.PriceChange
+	scheduledPrices []*models.ScheduledPrice
+	nextScheduleID  uint
+
+	promotions      map[uint]*models.Promotion
+	nextPromotionID uint
+	promotionUses   map[uint]map[uint]int // promotion ID -> user ID -> placed orders
+
+	// pendingOrders holds reserved orders whose checkout has not committed.
+	pendingOrders map[uint]*models.Order
+	sagas         map[uint]*models.CheckoutSaga
+	nextSagaID    uint
+	sagaLeases    map[uint]bool // saga IDs a caller is driving; see LeaseSaga
+	cartClears    map[uint]bool // order IDs whose lines have left the cart
+	promotionClaims map[uint]promotionClaim // by order ID
+	orderClaims     map[uint]string         // order ID -> action in flight; see ClaimOrderAction
+
+	addresses     map[uint]*models.Address
+	nextAddressID uint
+
+	outbox      []*models.OutboxEntry // domain events not yet delivered, oldest first
+	parkedEvents []*models.ParkedEvent // given up on by some sinks, oldest first
+	nextEventID uint64
+	eventSignal chan struct{}
+
+	webhooks          map[uint]*models.WebhookSubscription
+	nextWebhookID     uint
+	webhookDeliveries []*models.WebhookDelivery // oldest first
+	nextDeliveryID    uint
+	deliveryIndex     map[deliveryKey]*models.WebhookDelivery
+	deliveryHistory   int // SUCCEEDED deliveries kept; 0 keeps all
+	succeeded         int // SUCCEEDED deliveries in webhookDeliveries
+
+	notificationPrefs map[uint]*models.NotificationPreferences
+	emails            []*models.EmailNotification // oldest first
+	nextEmailID       uint
+	emailIndex        map[emailKey]*models.EmailNotification
+	emailHistory      int // SENT emails kept; 0 keeps all
+	sent              int // SENT emails in emails
+
+	nextReturnID   uint          // returns live on their orders
+	refundClaims   map[uint]bool // return IDs with a refund in flight
+	shippingClaims map[uint]uint // order ID -> return whose refund includes shipping
+
+	closed bool // set by Close; reported by Ping
+
+	// catalogVersion increases on every product create, update, delete or
+	// stock movement; catalogModified records when that last happened.
+	catalogVersion  uint64
+	catalogModified time.Time
+
+	warehouses       map[uint]*models.Warehouse
+	nextWarehouseID  uint
+	stockByWarehouse map[uint]map[uint]int // productID -> warehouseID -> on hand
+	allocate         AllocationStrategy
+
+	// holds is keyed by productID then userID; holdTTL of 0 disables holds.
+	holds   map[uint]map[uint]*models.StockHold
+	holdTTL time.Duration
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
+	m := &MemoryStore{
 		users:        make(map[uint]*models.User),
 		products:     make(map[uint]*models.Product),
+		productISBN:  make(map[string]uint),
 		carts:        make(map[uint]*models.Cart),
 		orders:       make(map[uint]*models.Order),
 		nextUserID:    1,
 		nextProductID: 1,
 		nextCartID:    1,
 		nextOrderID:   1,
+		nextMovementID: 1,
+		nextWarehouseID: 1,
+		holds:           make(map[uint]map[uint]*models.StockHold),
+		warehouses:      make(map[uint]*models.Warehouse),
+		stockByWarehouse: make(map[uint]map[uint]int),
+		allocate:        NearestFirst,
+		alertSignal:     make(chan struct{}, 1),
+		purchaseOrders:  make(map[uint]*models.PurchaseOrder),
+		nextPurchaseOrderID: 1,
+		restockSubs:     make(map[uint][]*models.RestockSubscription),
+		nextNotificationID: 1,
+		restockFanout:   DefaultRestockFanout,
+		priceHistory:    make(map[uint][]models.PriceChange),
+		nextScheduleID:  1,
+		promotions:      make(map[uint]*models.Promotion),
+		nextPromotionID: 1,
+		promotionUses:   make(map[uint]map[uint]int),
+		addresses:       make(map[uint]*models.Address),
+		nextAddressID:   1,
+		nextReturnID:    1,
+		refundClaims:    make(map[uint]bool),
+		shippingClaims:  make(map[uint]uint),
+		pendingOrders:   make(map[uint]*models.Order),
+		sagas:           make(map[uint]*models.CheckoutSaga),
+		nextSagaID:      1,
+		sagaLeases:      make(map[uint]bool),
+		cartClears:      make(map[uint]bool),
+		promotionClaims: make(map[uint]promotionClaim),
+		orderClaims:     make(map[uint]string),
+		nextEventID:     1,
+		eventSignal:     make(chan struct{}, 1),
+		webhooks:        make(map[uint]*models.WebhookSubscription),
+		nextWebhookID:   1,
+		nextDeliveryID:  1,
+		deliveryIndex:   make(map[deliveryKey]*models.WebhookDelivery),
+		deliveryHistory: DefaultDeliveryHistory,
+		notificationPrefs: make(map[uint]*models.NotificationPreferences),
+		nextEmailID:     1,
+		emailIndex:      make(map[emailKey]*models.EmailNotification),
+		emailHistory:    DefaultEmailHistory,
+		catalogModified: time.Now(),
 	}
+	m.CreateWarehouse(&models.Warehouse{Code: "MAIN", Name: "Main warehouse"})
+	return m
 }
 
 // Users
@@ -41,7 +170,7 @@ func (m *MemoryStore) CreateUser(u *models.User) (*models.User, error) {
 	defer m.mu.Unlock()
 	u.ID = m.nextUserID
 	m.nextUserID++
-	m.users[u.ID] = &models.User{ID: u.ID, Email: u.Email, Name: u.Name}
+	m.users[u.ID] = &models.User{ID: u.ID, Email: u.Email, Name: u.Name, Role: u.Role}
 	return m.users[u.ID], nil
 }
 
@@ -50,7 +179,7 @@ func (m *MemoryStore) GetUserByID(id uint) (*models.User, error) {
 	defer m.mu.RUnlock()
 	u, ok := m.users[id]
 	if !ok {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 	return cloneUser(u), nil
 }
@@ -59,9 +188,10 @@ func (m *MemoryStore) GetUserByID(id uint) (*models.User, error) {
 func (m *MemoryStore) GetAllProducts() ([]*models.Product, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	now := time.Now()
 	res := make([]*models.Product, 0, len(m.products))
 	for _, p := range m.products {
-		res = append(res, cloneProduct(p))
+		res = append(res, m.productView(p, now))
 	}
 	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
 	return res, nil
@@ -72,62 +202,214 @@ func (m *MemoryStore) GetProductByID(id uint) (*models.Product, error) {
 	defer m.mu.RUnlock()
 	p, ok := m.products[id]
 	if !ok {
-		return nil, errors.New("product not found")
+		return nil, ErrProductNotFound
+	}
+	return m.productView(p, time.Now()), nil
+}
+
+// GetProductByISBN looks a product up by its normalized ISBN.
+func (m *MemoryStore) GetProductByISBN(isbn string) (*models.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	id, ok := m.productISBN[isbn]
+	if !ok {
+		return nil, ErrProductNotFound
 	}
-	return cloneProduct(p), nil
+	return m.productView(m.products[id], time.Now()), nil
 }
 
-func (m *MemoryStore) CreateProduct(p *models.Product) (*models.Product, error) {
+// CreateProduct stores a new product. actor is recorded on the initial price
+// history entry and stock receipt; "" means ActorCatalog.
+func (m *MemoryStore) CreateProduct(ctx context.Context, p *models.Product, actor string) (*models.Product, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, taken := m.productISBN[p.ISBN]; p.ISBN != "" && taken {
+		return nil, ErrDuplicateISBN
+	}
 	p.ID = m.nextProductID
 	m.nextProductID++
 	now := time.Now()
 	p.CreatedAt = now
 	p.UpdatedAt = now
+	p.Version = 1
 	m.products[p.ID] = &models.Product{
 		ID:           p.ID,
+		ISBN:         p.ISBN,
 		Title:        p.Title,
 		Author:       p.Author,
 		Description:  p.Description,
+		Category:     p.Category,
 		Price:        p.Price,
 		Stock:        p.Stock,
 		Discontinued: p.Discontinued,
 		IsSpecial:    p.IsSpecial,
+		AllowBackorder:    p.AllowBackorder,
+		MaxBackorder:      p.MaxBackorder,
+		BackorderLeadDays: p.BackorderLeadDays,
+		ReleaseDate:       cloneTime(p.ReleaseDate),
+		ReorderPoint:      p.ReorderPoint,
+		ReorderQuantity:   p.ReorderQuantity,
+		Supplier:          p.Supplier,
+		Version:      p.Version,
 		CreatedAt:    p.CreatedAt,
 		UpdatedAt:    p.UpdatedAt,
 	}
-	return cloneProduct(m.products[p.ID]), nil
+	if p.ISBN != "" {
+		m.productISBN[p.ISBN] = p.ID
+	}
+	if actor == "" {
+		actor = ActorCatalog
+	}
+	m.priceHistory[p.ID] = append(m.priceHistory[p.ID], models.PriceChange{ProductID: p.ID, Price: p.Price, EffectiveFrom: now, Actor: actor})
+	if p.Stock != 0 {
+		m.addWarehouseStock(p.ID, DefaultWarehouseID, p.Stock)
+		m.appendMovement(ctx, m.products[p.ID], models.InventoryMovement{WarehouseID: DefaultWarehouseID, Type: models.MovementReceipt, Quantity: p.Stock, Reason: "initial stock", Actor: actor}, now)
+	}
+	m.touchCatalog(now)
+	view := m.productView(m.products[p.ID], now)
+	m.recordEvent(ctx, models.EventProductCreated, models.AggregateProduct, p.ID, view)
+	return view, nil
 }
 
-func (m *MemoryStore) UpdateProduct(id uint, update *models.Product) (*models.Product, error) {
+// UpdateProduct replaces the editable fields of a product and bumps its
+// Version. When update.Version is non-zero it must match the stored version,
+// otherwise ErrVersionConflict is returned and nothing is written. actor is
+// recorded on price history and stock movements; "" means ActorCatalog.
+//
+// Version counts catalog edits only: stock movements, holds and scheduled
+// prices change the product without bumping it, so they never make an
+// editor's If-Match stale.
+func (m *MemoryStore) UpdateProduct(ctx context.Context, id uint, update *models.Product, actor string) (*models.Product, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.updateProduct(ctx, id, update, actor)
+}
+
+// ModifyProduct is UpdateProduct for read-modify-write edits: edit receives a
+// copy of the current product and returns the update to write, both under the
+// store lock, so fields the edit leaves alone (stock sold meanwhile, a
+// scheduled price) keep their current values. version must match as in
+// UpdateProduct.
+func (m *MemoryStore) ModifyProduct(ctx context.Context, id uint, version uint64, actor string, edit func(cur *models.Product) (*models.Product, error)) (*models.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.products[id]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	if version != 0 && version != existing.Version {
+		return nil, ErrVersionConflict
+	}
+	update, err := edit(m.productView(existing, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	update.Version = version
+	return m.updateProduct(ctx, id, update, actor)
+}
+
+// updateProduct implements UpdateProduct. Must be called with m.mu held for
+// writing.
+func (m *MemoryStore) updateProduct(ctx context.Context, id uint, update *models.Product, actor string) (*models.Product, error) {
 	existing, ok := m.products[id]
 	if !ok {
-		return nil, errors.New("product not found")
+		return nil, ErrProductNotFound
+	}
+	if update.Version != 0 && update.Version != existing.Version {
+		return nil, ErrVersionConflict
+	}
+	if owner, taken := m.productISBN[update.ISBN]; update.ISBN != "" && taken && owner != id {
+		return nil, ErrDuplicateISBN
 	}
+	if existing.ISBN != update.ISBN {
+		delete(m.productISBN, existing.ISBN)
+		if update.ISBN != "" {
+			m.productISBN[update.ISBN] = id
+		}
+	}
+	wasSellable := sellable(existing)
+	existing.ISBN = update.ISBN
 	existing.Title = update.Title
 	existing.Author = update.Author
 	existing.Description = update.Description
-	existing.Price = update.Price
-	existing.Stock = update.Stock
+	existing.Category = update.Category
+	delta := update.Stock - existing.Stock
 	existing.Discontinued = update.Discontinued
 	existing.IsSpecial = update.IsSpecial
+	existing.AllowBackorder = update.AllowBackorder
+	existing.MaxBackorder = update.MaxBackorder
+	existing.BackorderLeadDays = update.BackorderLeadDays
+	existing.ReleaseDate = cloneTime(update.ReleaseDate)
+	existing.ReorderPoint = update.ReorderPoint
+	existing.ReorderQuantity = update.ReorderQuantity
+	existing.Supplier = update.Supplier
+	existing.Version++
 	existing.UpdatedAt = time.Now()
-	return cloneProduct(existing), nil
+	if actor == "" {
+		actor = ActorCatalog
+	}
+	if update.Price != existing.Price {
+		m.setPrice(ctx, existing, update.Price, actor, existing.UpdatedAt, 0)
+	}
+	if delta != 0 {
+		m.spreadAdjustment(ctx, existing, delta, "product update", actor, existing.UpdatedAt)
+	}
+	if delta > 0 {
+		m.fillBackorders(ctx, existing, DefaultWarehouseID)
+	}
+	m.checkBackInStock(existing, wasSellable)
+	m.touchCatalog(existing.UpdatedAt)
+	view := m.productView(existing, existing.UpdatedAt)
+	m.recordEvent(ctx, models.EventProductUpdated, models.AggregateProduct, id, view)
+	return view, nil
 }
 
-func (m *MemoryStore) DeleteProduct(id uint) error {
+func (m *MemoryStore) DeleteProduct(ctx context.Context, id uint) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if _, ok := m.products[id]; !ok {
-		return errors.New("product not found")
+	p, ok := m.products[id]
+	if !ok {
+		return ErrProductNotFound
+	}
+	delete(m.productISBN, p.ISBN)
+	delete(m.holds, id)
+	delete(m.restockSubs, id)
+	kept := m.scheduledPrices[:0]
+	for _, sp := range m.scheduledPrices {
+		if sp.ProductID != id {
+			kept = append(kept, sp)
+		}
 	}
+	m.scheduledPrices = kept
+	delete(m.stockByWarehouse, id)
 	delete(m.products, id)
+	m.touchCatalog(time.Now())
+	m.recordEvent(ctx, models.EventProductDeleted, models.AggregateProduct, id, map[string]uint{"id": id})
 	return nil
 }
 
+// CatalogVersion reports a counter that changes whenever any product changes,
+// together with the time of that change. Used for list-level cache validators.
+func (m *MemoryStore) CatalogVersion() (uint64, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.catalogVersion, m.catalogModified
+}
+
+// touchProduct records that p's representation changed at now without a
+// catalog edit, so its Version stays. Must be called with m.mu held for
+// writing.
+func (m *MemoryStore) touchProduct(p *models.Product, now time.Time) {
+	p.UpdatedAt = now
+	m.touchCatalog(now)
+}
+
+// touchCatalog must be called with m.mu held for writing.
+func (m *MemoryStore) touchCatalog(at time.Time) {
+	m.catalogVersion++
+	m.catalogModified = at
+}
+
 // Helper: check if a product is present in any cart
 func (m *MemoryStore) IsProductInAnyCart(productID uint) bool {
 	m.mu.RLock()
@@ -153,7 +435,7 @@ func (m *MemoryStore) getOrCreateCart(userID uint) *models.Cart {
 	return c
 }
 
-func (m *MemoryStore) AddToCart(userID, productID uint, quantity int) (*models.Cart, error) {
+func (m *MemoryStore) AddToCart(ctx context.Context, userID, productID uint, quantity int) (*models.Cart, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if _, ok := m.users[userID]; !ok {
@@ -161,17 +443,31 @@ func (m *MemoryStore) AddToCart(userID, productID uint, quantity int) (*models.C
 	}
 	p, ok := m.products[productID]
 	if !ok {
-		return nil, errors.New("product not found")
+		return nil, ErrProductNotFound
 	}
 	if quantity <= 0 {
 		return nil, errors.New("quantity must be positive")
 	}
+	now := time.Now()
+	lineQty := 0
+	if c, ok := m.carts[userID]; ok {
+		lineQty = cartQtyForProduct(c, productID)
+	}
+	// With holds on, other users' active holds are off limits; anything past
+	// available stock must fit in the product's backorder capacity
+	have, short := m.splitLine(p, userID, lineQty+quantity, now)
+	if m.holdTTL > 0 && short > m.backorderCapacity(p, now) {
+		return nil, ErrInsufficientAvailable
+	}
 	c := m.getOrCreateCart(userID)
 	// add or increment
 	found := false
 	for i := range c.Items {
 		if c.Items[i].ProductID == productID {
 			c.Items[i].Quantity += quantity
+			// re-adding accepts the current price
+			c.Items[i].UnitPrice = p.Price
+			c.Items[i].PreviousUnitPrice = 0
 			found = true
 			break
 		}
@@ -179,14 +475,13 @@ func (m *MemoryStore) AddToCart(userID, productID uint, quantity int) (*models.C
 	if !found {
 		c.Items = append(c.Items, models.CartItem{ProductID: productID, Quantity: quantity, UnitPrice: p.Price})
 	}
-	// Optional soft check: cap at available stock but do not fail
-	if cItemQty := cartQtyForProduct(c, productID); cItemQty > p.Stock {
-		// keep as-is; strict validation happens at order time
-	}
+	// only units actually on the shelf can be held
+	m.placeHold(ctx, userID, productID, have, now)
+	m.recordEvent(ctx, models.EventCartItemAdded, models.AggregateCart, userID, models.CartChange{UserID: userID, ProductID: productID, Quantity: lineQty + quantity})
 	return cloneCart(c), nil
 }
 
-func (m *MemoryStore) RemoveFromCart(userID, productID uint) (*models.Cart, error) {
+func (m *MemoryStore) RemoveFromCart(ctx context.Context, userID, productID uint) (*models.Cart, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	c, ok := m.carts[userID]
@@ -200,6 +495,8 @@ func (m *MemoryStore) RemoveFromCart(userID, productID uint) (*models.Cart, erro
 		}
 	}
 	c.Items = items
+	m.releaseHold(ctx, userID, productID, time.Now())
+	m.recordEvent(ctx, models.EventCartItemRemoved, models.AggregateCart, userID, models.CartChange{UserID: userID, ProductID: productID})
 	return cloneCart(c), nil
 }
 
@@ -213,26 +510,68 @@ func (m *MemoryStore) GetCartByUser(userID uint) (*models.Cart, error) {
 	return cloneCart(c), nil
 }
 
-func (m *MemoryStore) clearCart(userID uint) {
-	delete(m.carts, userID)
+// ClearOrderedItems takes an order's lines out of the user's cart, along with
+// its coupons. Only the ordered quantities are removed, so items added since
+// checkout began stay. Repeated calls for the same order do nothing.
+func (m *MemoryStore) ClearOrderedItems(ctx context.Context, userID, orderID uint, items []models.CartItem) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cartClears[orderID] {
+		return
+	}
+	m.cartClears[orderID] = true
+	m.recordEvent(ctx, models.EventCartCheckedOut, models.AggregateCart, userID, models.CartChange{UserID: userID, OrderID: orderID})
+	c, ok := m.carts[userID]
+	if !ok {
+		return
+	}
+	ordered := make(map[uint]int, len(items))
+	for _, it := range items {
+		ordered[it.ProductID] += it.Quantity
+	}
+	kept := c.Items[:0]
+	for _, it := range c.Items {
+		it.Quantity -= ordered[it.ProductID]
+		if it.Quantity > 0 {
+			kept = append(kept, it)
+		}
+	}
+	c.Items = kept
+	c.Coupons = nil
+	if len(c.Items) == 0 {
+		delete(m.carts, userID)
+	}
 }
 
 // Orders
-func (m *MemoryStore) CreateOrder(o *models.Order) (*models.Order, error) {
+func (m *MemoryStore) CreateOrder(ctx context.Context, o *models.Order) (*models.Order, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	o.ID = m.nextOrderID
-	m.nextOrderID++
-	o.CreatedAt = time.Now()
-	m.orders[o.ID] = &models.Order{
-		ID:        o.ID,
-		UserID:    o.UserID,
-		Items:     append([]models.OrderItem(nil), o.Items...),
-		Total:     o.Total,
-		Status:    o.Status,
-		CreatedAt: o.CreatedAt,
-	}
-	return cloneOrder(m.orders[o.ID]), nil
+	// orders coming from ReserveInventory already hold their ID
+	if o.ID == 0 {
+		o.ID = m.nextOrderID
+		m.nextOrderID++
+	}
+	if existing, ok := m.orders[o.ID]; ok {
+		return cloneOrder(existing), nil
+	}
+	v := cloneOrder(o)
+	// backorder fills may have landed on the pending draft since it was read
+	if pending, ok := m.pendingOrders[o.ID]; ok {
+		for i := range v.Items {
+			for _, pit := range pending.Items {
+				if pit.ProductID == v.Items[i].ProductID {
+					v.Items[i].Allocations = append([]models.Allocation(nil), pit.Allocations...)
+					v.Items[i].Backordered = pit.Backordered
+				}
+			}
+		}
+		delete(m.pendingOrders, o.ID)
+	}
+	v.CreatedAt = time.Now()
+	m.orders[o.ID] = v
+	m.recordEvent(ctx, models.EventOrderPlaced, models.AggregateOrder, v.ID, cloneOrder(v))
+	return cloneOrder(v), nil
 }
 
 func (m *MemoryStore) GetOrdersByUser(userID uint) ([]*models.Order, error) {
@@ -249,9 +588,21 @@ func (m *MemoryStore) GetOrdersByUser(userID uint) ([]*models.Order, error) {
 }
 
 // Business helpers used by services
-func (m *MemoryStore) ReserveStockForOrder(userID uint) (*models.Order, error) {
+// ReserveInventory validates the user's cart, allocates every line to
+// warehouses with the configured AllocationStrategy and records the sales in
+// the ledger under orderID (a CheckoutSaga's OrderID). The ordered lines' holds
+// are released now that they are sales. The draft order is kept pending
+// until CreateOrder commits it or ReleaseInventory undoes the reservation;
+// calling this again for a pending orderID returns the same draft.
+func (m *MemoryStore) ReserveInventory(ctx context.Context, userID, orderID uint, opts ReserveOptions) (*models.Order, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if o, ok := m.pendingOrders[orderID]; ok {
+		return cloneOrder(o), nil
+	}
+	if _, ok := m.orders[orderID]; ok {
+		return nil, ErrOrderStatus
+	}
 	c, ok := m.carts[userID]
 	if !ok || len(c.Items) == 0 {
 		return nil, errors.New("cart is empty")
@@ -264,9 +615,9 @@ func (m *MemoryStore) ReserveStockForOrder(userID uint) (*models.Order, error) {
 			}
 		}
 	}
-	// Validate and reserve (decrement stock)
-	items := make([]models.OrderItem, 0, len(c.Items))
-	var total float64
+	// Validate every line first so a failure leaves no stock half-reserved.
+	// Other users' active holds count against stock; the buyer's own do not.
+	now := time.Now()
 	for _, it := range c.Items {
 		p, ok := m.products[it.ProductID]
 		if !ok {
@@ -281,30 +632,115 @@ func (m *MemoryStore) ReserveStockForOrder(userID uint) (*models.Order, error) {
 		if p.Price != 0 && it.UnitPrice != 0 && it.UnitPrice != p.Price {
 			return nil, errors.New("prices changed, refresh cart")
 		}
-		if p.Stock < it.Quantity {
+		if _, short := m.splitLine(p, userID, it.Quantity, now); short > m.backorderCapacity(p, now) {
 			return nil, errors.New("insufficient stock for product")
 		}
-		p.Stock -= it.Quantity
+	}
+	// Lines beyond available stock become backorders; only the in-stock part
+	// is allocated to warehouses now.
+	inStock := make([]models.CartItem, 0, len(c.Items))
+	short := make(map[uint]int)
+	for _, it := range c.Items {
+		have, missing := m.splitLine(m.products[it.ProductID], userID, it.Quantity, now)
+		if have > 0 {
+			inStock = append(inStock, models.CartItem{ProductID: it.ProductID, Quantity: have})
+		}
+		short[it.ProductID] = missing
+	}
+	allocations := m.allocateOrder(inStock, opts.DestRegion)
+	items := make([]models.OrderItem, 0, len(c.Items))
+	var total float64
+	for _, it := range c.Items {
+		p := m.products[it.ProductID]
+		if short[p.ID] > 0 {
+			m.backorders = append(m.backorders, &backorder{orderID: orderID, productID: p.ID, remaining: short[p.ID]})
+			m.touchProduct(p, now) // its backordered count changed
+		}
+		for _, a := range allocations[p.ID] {
+			if _, err := m.applyMovement(ctx, p, models.InventoryMovement{WarehouseID: a.WarehouseID, Type: models.MovementSale, Quantity: -a.Quantity, Reason: "order checkout", Actor: ActorSystem, OrderID: orderID}); err != nil {
+				return nil, err
+			}
+		}
 		sub := float64(it.Quantity) * p.Price
 		total += sub
-		items = append(items, models.OrderItem{ProductID: p.ID, Quantity: it.Quantity, UnitPrice: p.Price, Subtotal: sub})
+		items = append(items, models.OrderItem{ProductID: p.ID, Quantity: it.Quantity, UnitPrice: p.Price, Subtotal: sub, Allocations: allocations[p.ID], Backordered: short[p.ID]})
+	}
+	var discount float64
+	freeShipping := false
+	for _, d := range opts.Discounts {
+		discount += d.Amount
+		freeShipping = freeShipping || d.FreeShipping
+	}
+	if discount > total {
+		discount = total
 	}
 	order := &models.Order{
-		UserID: userID,
-		Items:  items,
-		Total:  total,
-		Status: "PLACED",
+		ID:            orderID,
+		UserID:        userID,
+		Items:         items,
+		Subtotal:      total,
+		Discounts:     append([]models.DiscountLine(nil), opts.Discounts...),
+		DiscountTotal: discount,
+		FreeShipping:  freeShipping,
+		Total:         math.Round((total-discount)*100) / 100,
+		Status:        models.OrderPlaced,
+	}
+	for _, it := range c.Items {
+		m.releaseHold(ctx, userID, it.ProductID, time.Now())
 	}
-	// clear cart after reserving stock; the transaction is in-memory and mutex-guarded
-	delete(m.carts, userID)
+	m.pendingOrders[orderID] = cloneOrder(order)
 	return order, nil
 }
 
 // clones to avoid exposing internal pointers/state
 func cloneUser(u *models.User) *models.User { v := *u; return &v }
-func cloneProduct(p *models.Product) *models.Product { v := *p; return &v }
-func cloneCart(c *models.Cart) *models.Cart { v := *c; v.Items = append([]models.CartItem(nil), c.Items...); return &v }
-func cloneOrder(o *models.Order) *models.Order { v := *o; v.Items = append([]models.OrderItem(nil), o.Items...); return &v }
+func cloneProduct(p *models.Product) *models.Product { v := *p; v.ReleaseDate = cloneTime(p.ReleaseDate); return &v }
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
+func cloneCart(c *models.Cart) *models.Cart {
+	v := *c
+	v.Items = append([]models.CartItem(nil), c.Items...)
+	v.Coupons = append([]string(nil), c.Coupons...)
+	return &v
+}
+func cloneOrder(o *models.Order) *models.Order {
+	v := *o
+	v.Items = cloneOrderItems(o.Items)
+	v.Discounts = append([]models.DiscountLine(nil), o.Discounts...)
+	v.TaxLines = append([]models.TaxLine(nil), o.TaxLines...)
+	v.ShippedAt = cloneTime(o.ShippedAt)
+	v.DeliveredAt = cloneTime(o.DeliveredAt)
+	v.CancelledAt = cloneTime(o.CancelledAt)
+	v.Returns = nil
+	for _, r := range o.Returns {
+		v.Returns = append(v.Returns, *cloneReturn(&r))
+	}
+	if o.Payment != nil {
+		p := *o.Payment
+		v.Payment = &p
+	}
+	if o.ShippingAddress != nil {
+		a := *o.ShippingAddress
+		v.ShippingAddress = &a
+	}
+	if o.BillingAddress != nil {
+		a := *o.BillingAddress
+		v.BillingAddress = &a
+	}
+	return &v
+}
+func cloneOrderItems(items []models.OrderItem) []models.OrderItem {
+	res := append([]models.OrderItem(nil), items...)
+	for i := range res {
+		res[i].Allocations = append([]models.Allocation(nil), items[i].Allocations...)
+	}
+	return res
+}
 
 func cartQtyForProduct(c *models.Cart, productID uint) int {
 	for _, it := range c.Items {
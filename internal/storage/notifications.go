@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// DefaultEmailHistory is how many SENT emails are kept. Pending and FAILED
+// emails are always kept.
+const DefaultEmailHistory = 1000
+
+var ErrEmailNotFound = errors.New("email notification not found")
+
+// emailKey identifies one kind of email sent to a user for an event.
+type emailKey struct {
+	userID  uint
+	kind    string
+	eventID uint64
+}
+
+// SetEmailHistory changes how many SENT emails are kept; the oldest beyond
+// that are dropped. 0 keeps all of them.
+func (m *MemoryStore) SetEmailHistory(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emailHistory = n
+	m.trimEmails()
+}
+
+// GetNotificationPreferences returns a user's preferences, or the defaults
+// (email on, nothing muted, no locale) when none were saved.
+func (m *MemoryStore) GetNotificationPreferences(userID uint) (*models.NotificationPreferences, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	p, ok := m.notificationPrefs[userID]
+	if !ok {
+		return &models.NotificationPreferences{UserID: userID, EmailEnabled: true, Muted: []string{}}, nil
+	}
+	return clonePreferences(p), nil
+}
+
+func (m *MemoryStore) SaveNotificationPreferences(p *models.NotificationPreferences) (*models.NotificationPreferences, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[p.UserID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	v := clonePreferences(p)
+	v.UpdatedAt = time.Now()
+	m.notificationPrefs[v.UserID] = v
+	return clonePreferences(v), nil
+}
+
+// EnqueueEmail queues an email. The same (user, kind, event) is only queued
+// once while its email is kept, so replayed events do not send twice.
+func (m *MemoryStore) EnqueueEmail(e *models.EmailNotification) (*models.EmailNotification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := emailKey{e.UserID, e.Kind, e.EventID}
+	if existing, ok := m.emailIndex[key]; ok {
+		v := *existing
+		return &v, nil
+	}
+	v := *e
+	v.ID = m.nextEmailID
+	m.nextEmailID++
+	now := time.Now()
+	v.Status = models.EmailPending
+	v.Attempts, v.LastError, v.SentAt = 0, "", nil
+	v.NextAttemptAt, v.CreatedAt = now, now
+	m.emails = append(m.emails, &v)
+	m.emailIndex[key] = &v
+	res := v
+	return &res, nil
+}
+
+// DueEmails returns up to limit pending emails whose next attempt is due at
+// now, oldest first.
+func (m *MemoryStore) DueEmails(now time.Time, limit int) []*models.EmailNotification {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.EmailNotification, 0)
+	for _, e := range m.emails {
+		if len(res) >= limit {
+			break
+		}
+		if e.Status == models.EmailPending && !e.NextAttemptAt.After(now) {
+			v := *e
+			res = append(res, &v)
+		}
+	}
+	return res
+}
+
+// MarkEmailSent records a successful send.
+func (m *MemoryStore) MarkEmailSent(id uint, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.findEmail(id)
+	if e == nil {
+		return ErrEmailNotFound
+	}
+	if e.Status != models.EmailSent {
+		m.sent++
+	}
+	e.Status = models.EmailSent
+	e.Attempts++
+	e.LastError = ""
+	e.SentAt = &at
+	m.trimEmails()
+	return nil
+}
+
+// RetryEmail records a failed send. The email is tried again at next, or
+// becomes FAILED when next is zero.
+func (m *MemoryStore) RetryEmail(id uint, lastError string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.findEmail(id)
+	if e == nil {
+		return ErrEmailNotFound
+	}
+	e.Attempts++
+	e.LastError = lastError
+	e.NextAttemptAt = next
+	if next.IsZero() {
+		e.Status = models.EmailFailed
+	}
+	return nil
+}
+
+// GetEmails lists emails, newest first, optionally for one user (0 means
+// all) and one status.
+func (m *MemoryStore) GetEmails(userID uint, status string) []*models.EmailNotification {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.EmailNotification, 0)
+	for i := len(m.emails) - 1; i >= 0; i-- {
+		e := m.emails[i]
+		if (userID == 0 || e.UserID == userID) && (status == "" || e.Status == status) {
+			v := *e
+			res = append(res, &v)
+		}
+	}
+	return res
+}
+
+// trimEmails drops the oldest SENT emails beyond emailHistory. Must be
+// called with m.mu held for writing.
+func (m *MemoryStore) trimEmails() {
+	drop := m.sent - m.emailHistory
+	if m.emailHistory <= 0 || drop <= 0 {
+		return
+	}
+	kept := m.emails[:0]
+	for _, e := range m.emails {
+		if drop > 0 && e.Status == models.EmailSent {
+			delete(m.emailIndex, emailKey{e.UserID, e.Kind, e.EventID})
+			m.sent--
+			drop--
+			continue
+		}
+		kept = append(kept, e)
+	}
+	clear(m.emails[len(kept):])
+	m.emails = kept
+}
+
+// findEmail must be called with m.mu held.
+func (m *MemoryStore) findEmail(id uint) *models.EmailNotification {
+	for _, e := range m.emails {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+func clonePreferences(p *models.NotificationPreferences) *models.NotificationPreferences {
+	v := *p
+	v.Muted = append([]string{}, p.Muted...)
+	return &v
+}
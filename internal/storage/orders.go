@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderStatus is returned when an order's status does not allow the
+	// requested transition.
+	ErrOrderStatus = errors.New("order status does not allow this action")
+)
+
+// Order actions claimed with ClaimOrderAction while the payment provider
+// captures or voids the order's payment.
+const (
+	OrderActionShip   = "SHIPPING"
+	OrderActionCancel = "CANCELLING"
+)
+
+// cancellableStatuses are the statuses CancelOrder accepts.
+var cancellableStatuses = []string{models.OrderPlaced, models.OrderPendingReview, models.OrderPendingPayment}
+
+func (m *MemoryStore) GetOrderByID(id uint) (*models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return cloneOrder(o), nil
+}
+
+// GetOrderByAuthorization finds the order paid for by a provider
+// authorization.
+func (m *MemoryStore) GetOrderByAuthorization(authorizationID string) (*models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, o := range m.orders {
+		if o.Payment != nil && o.Payment.AuthorizationID == authorizationID {
+			return cloneOrder(o), nil
+		}
+	}
+	return nil, ErrOrderNotFound
+}
+
+// ClaimOrderAction reserves an order for action, OrderActionShip or
+// OrderActionCancel, while the caller captures or voids its payment. The
+// order's status must be one its action accepts, and until the claim ends
+// with ShipOrder, CancelOrder or ReleaseOrderAction every other status change
+// of the order fails with ErrOrderStatus. It returns a copy of the order.
+func (m *MemoryStore) ClaimOrderAction(id uint, action string) (*models.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	from := cancellableStatuses
+	if action == OrderActionShip {
+		from = []string{models.OrderPlaced}
+	}
+	if m.orderClaims[id] != "" || !statusIn(o.Status, from) {
+		return nil, ErrOrderStatus
+	}
+	m.orderClaims[id] = action
+	return cloneOrder(o), nil
+}
+
+// ReleaseOrderAction ends a claim whose payment step failed, leaving the
+// order as it was.
+func (m *MemoryStore) ReleaseOrderAction(id uint, action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.orderClaims[id] == action {
+		delete(m.orderClaims, id)
+	}
+}
+
+// UpdateOrderPayment records the provider's latest payment state. When status
+// is non-empty the order also moves to it, provided its current status is one
+// of from and no action is claimed on it.
+func (m *MemoryStore) UpdateOrderPayment(ctx context.Context, id uint, p models.Payment, status string, from ...string) (*models.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if status != "" && (!statusIn(o.Status, from) || m.orderClaims[id] != "") {
+		return nil, ErrOrderStatus
+	}
+	o.Payment = &p
+	if status != "" {
+		m.setOrderStatus(ctx, o, status)
+	}
+	return cloneOrder(o), nil
+}
+
+// ApproveOrder releases an order held for high-value review.
+func (m *MemoryStore) ApproveOrder(ctx context.Context, id uint) (*models.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status != models.OrderPendingReview || m.orderClaims[id] != "" {
+		return nil, ErrOrderStatus
+	}
+	m.setOrderStatus(ctx, o, models.OrderPlaced)
+	return cloneOrder(o), nil
+}
+
+// ShipOrder marks a placed order claimed with OrderActionShip as shipped,
+// ending the claim and recording the captured payment when p is non-nil.
+func (m *MemoryStore) ShipOrder(ctx context.Context, id uint, p *models.Payment) (*models.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status != models.OrderPlaced || m.orderClaims[id] != OrderActionShip {
+		return nil, ErrOrderStatus
+	}
+	delete(m.orderClaims, id)
+	now := time.Now()
+	m.setOrderStatus(ctx, o, models.OrderShipped)
+	o.ShippedAt = &now
+	if p != nil {
+		o.Payment = p
+	}
+	return cloneOrder(o), nil
+}
+
+// DeliverOrder records that a shipped order reached the customer, which
+// starts its return window.
+func (m *MemoryStore) DeliverOrder(ctx context.Context, id uint) (*models.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status != models.OrderShipped {
+		return nil, ErrOrderStatus
+	}
+	now := time.Now()
+	m.setOrderStatus(ctx, o, models.OrderDelivered)
+	o.DeliveredAt = &now
+	return cloneOrder(o), nil
+}
+
+// CancelOrder cancels an unshipped order claimed with OrderActionCancel,
+// ending the claim. Allocated units go back to their warehouses as
+// cancellation movements (filling other backorders first), outstanding
+// backorders are dropped and promotion uses released.
+func (m *MemoryStore) CancelOrder(ctx context.Context, id uint, p *models.Payment, actor string) (*models.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if !statusIn(o.Status, cancellableStatuses) || m.orderClaims[id] != OrderActionCancel {
+		return nil, ErrOrderStatus
+	}
+	delete(m.orderClaims, id)
+	m.restockOrder(ctx, o, "order cancelled", actor)
+	m.releasePromotions(id)
+	now := time.Now()
+	m.setOrderStatus(ctx, o, models.OrderCancelled)
+	o.CancelledAt = &now
+	if p != nil {
+		o.Payment = p
+	}
+	return cloneOrder(o), nil
+}
+
+// restockOrder returns an order's allocated units to their warehouses as
+// cancellation movements, filling other backorders first, and drops its
+// outstanding backorders. Must be called with m.mu held for writing.
+func (m *MemoryStore) restockOrder(ctx context.Context, o *models.Order, reason, actor string) {
+	kept := m.backorders[:0]
+	for _, b := range m.backorders {
+		if b.orderID != o.ID {
+			kept = append(kept, b)
+		}
+	}
+	m.backorders = kept
+	now := time.Now()
+	for i := range o.Items {
+		it := &o.Items[i]
+		pr, ok := m.products[it.ProductID]
+		if ok && it.Backordered > 0 {
+			m.touchProduct(pr, now) // its backordered count changed
+		}
+		it.Backordered = 0
+		if !ok {
+			continue
+		}
+		wasSellable := sellable(pr)
+		for _, a := range it.Allocations {
+			if _, ok := m.warehouses[a.WarehouseID]; !ok {
+				continue
+			}
+			m.applyMovement(ctx, pr, models.InventoryMovement{WarehouseID: a.WarehouseID, Type: models.MovementCancellation, Quantity: a.Quantity, Reason: reason, Actor: actor, OrderID: o.ID})
+			m.fillBackorders(ctx, pr, a.WarehouseID)
+		}
+		m.checkBackInStock(pr, wasSellable)
+	}
+}
+
+// setOrderStatus moves an order to status and records OrderStatusChanged.
+// Must be called with m.mu held for writing.
+func (m *MemoryStore) setOrderStatus(ctx context.Context, o *models.Order, status string) {
+	if o.Status == status {
+		return
+	}
+	m.recordEvent(ctx, models.EventOrderStatusChanged, models.AggregateOrder, o.ID, models.StatusChange{ID: o.ID, UserID: o.UserID, From: o.Status, To: status})
+	o.Status = status
+}
+
+func statusIn(status string, from []string) bool {
+	for _, s := range from {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"ecom-book-store-sample-api/internal/logging"
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// recordEvent appends a domain event to the outbox. It is called inside the
+// mutation it describes, so the event commits (or not) with the change. The
+// event carries the request ID found in ctx. Must be called with m.mu held
+// for writing.
+func (m *MemoryStore) recordEvent(ctx context.Context, typ, aggregate string, aggregateID uint, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		raw, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	now := time.Now()
+	m.outbox = append(m.outbox, &models.OutboxEntry{
+		Event:         models.DomainEvent{ID: m.nextEventID, Type: typ, Aggregate: aggregate, AggregateID: aggregateID, Data: raw, OccurredAt: now, RequestID: logging.RequestID(ctx)},
+		NextAttemptAt: now,
+	})
+	m.nextEventID++
+	select {
+	case m.eventSignal <- struct{}{}:
+	default:
+	}
+}
+
+// EventSignal is signalled whenever an event is added to the outbox.
+func (m *MemoryStore) EventSignal() <-chan struct{} { return m.eventSignal }
+
+// PendingEvents returns up to limit outbox entries that may be delivered at
+// now, oldest first. An entry waiting for a retry holds back the later
+// entries of its aggregate, so each aggregate's events are delivered in order.
+func (m *MemoryStore) PendingEvents(now time.Time, limit int) []models.OutboxEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blocked := make(map[string]bool)
+	res := make([]models.OutboxEntry, 0)
+	for _, e := range m.outbox {
+		if len(res) >= limit {
+			break
+		}
+		key := e.Event.AggregateKey()
+		if blocked[key] {
+			continue
+		}
+		if e.NextAttemptAt.After(now) {
+			blocked[key] = true
+			continue
+		}
+		v := *e
+		v.Delivered = append([]string(nil), e.Delivered...)
+		res = append(res, v)
+	}
+	return res
+}
+
+// AckEvent removes an event that every consumer has received.
+func (m *MemoryStore) AckEvent(id uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.outbox {
+		if e.Event.ID == id {
+			m.outbox = append(m.outbox[:i], m.outbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// RetryEvent records a failed delivery attempt: delivered lists the consumers
+// that already have the event, and the next attempt is not before next.
+func (m *MemoryStore) RetryEvent(id uint64, delivered []string, lastError string, next time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.outbox {
+		if e.Event.ID == id {
+			e.Delivered = append([]string(nil), delivered...)
+			e.Attempts++
+			e.LastError = lastError
+			e.NextAttemptAt = next
+			return
+		}
+	}
+}
+
+// ParkEvent takes an event out of the outbox after its last attempt and keeps
+// it as parked for the sinks that still failed it.
+func (m *MemoryStore) ParkEvent(id uint64, sinks []string, lastError string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.outbox {
+		if e.Event.ID == id {
+			m.parkedEvents = append(m.parkedEvents, &models.ParkedEvent{Event: e.Event, Sinks: append([]string(nil), sinks...), Attempts: e.Attempts + 1, LastError: lastError, ParkedAt: at})
+			m.outbox = append(m.outbox[:i], m.outbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// ParkedEvents lists the parked events, oldest first.
+func (m *MemoryStore) ParkedEvents() []models.ParkedEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]models.ParkedEvent, 0, len(m.parkedEvents))
+	for _, e := range m.parkedEvents {
+		v := *e
+		v.Sinks = append([]string(nil), e.Sinks...)
+		res = append(res, v)
+	}
+	return res
+}
+
+// OutboxLength reports how many events are waiting for delivery.
+func (m *MemoryStore) OutboxLength() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.outbox)
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrScheduledPriceNotFound = errors.New("scheduled price not found")
+	ErrScheduleConflict       = errors.New("a price is already scheduled for that time")
+)
+
+// setPrice changes p's price and records it in the price history. Cart lines
+// holding p are repriced when the price drops, so a markdown doesn't fail the
+// checkout drift check; increases still do until the customer re-adds the
+// line. Must be called with m.mu held for writing.
+func (m *MemoryStore) setPrice(ctx context.Context, p *models.Product, price float64, actor string, at time.Time, scheduleID uint) {
+	if actor == "" {
+		actor = ActorCatalog
+	}
+	change := models.PriceChange{ProductID: p.ID, Price: price, PreviousPrice: p.Price, EffectiveFrom: at, Actor: actor, ScheduleID: scheduleID}
+	m.priceHistory[p.ID] = append(m.priceHistory[p.ID], change)
+	m.recordEvent(ctx, models.EventPriceChanged, models.AggregateProduct, p.ID, change)
+	for _, c := range m.carts {
+		for i := range c.Items {
+			it := &c.Items[i]
+			if it.ProductID != p.ID || it.UnitPrice <= price {
+				continue
+			}
+			if it.PreviousUnitPrice == 0 {
+				it.PreviousUnitPrice = it.UnitPrice
+			}
+			it.UnitPrice = price
+		}
+	}
+	p.Price = price
+}
+
+// GetPriceHistory returns a product's price changes, oldest first. History
+// is kept after the product is deleted.
+func (m *MemoryStore) GetPriceHistory(productID uint) ([]models.PriceChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.products[productID]; !ok && len(m.priceHistory[productID]) == 0 {
+		return nil, ErrProductNotFound
+	}
+	return append([]models.PriceChange{}, m.priceHistory[productID]...), nil
+}
+
+// SchedulePrice queues a price change for productID at effectiveAt.
+func (m *MemoryStore) SchedulePrice(productID uint, price float64, effectiveAt time.Time, actor string) (*models.ScheduledPrice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.products[productID]; !ok {
+		return nil, ErrProductNotFound
+	}
+	for _, sp := range m.scheduledPrices {
+		if sp.ProductID == productID && sp.EffectiveAt.Equal(effectiveAt) {
+			return nil, ErrScheduleConflict
+		}
+	}
+	sp := &models.ScheduledPrice{ID: m.nextScheduleID, ProductID: productID, Price: price, EffectiveAt: effectiveAt, Actor: actor, CreatedAt: time.Now()}
+	m.nextScheduleID++
+	m.scheduledPrices = append(m.scheduledPrices, sp)
+	v := *sp
+	return &v, nil
+}
+
+// GetScheduledPrices lists a product's pending price changes, soonest first.
+func (m *MemoryStore) GetScheduledPrices(productID uint) ([]models.ScheduledPrice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.products[productID]; !ok {
+		return nil, ErrProductNotFound
+	}
+	res := make([]models.ScheduledPrice, 0)
+	for _, sp := range m.scheduledPrices {
+		if sp.ProductID == productID {
+			res = append(res, *sp)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].EffectiveAt.Before(res[j].EffectiveAt) })
+	return res, nil
+}
+
+func (m *MemoryStore) CancelScheduledPrice(productID, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, sp := range m.scheduledPrices {
+		if sp.ID == id && sp.ProductID == productID {
+			m.scheduledPrices = append(m.scheduledPrices[:i:i], m.scheduledPrices[i+1:]...)
+			return nil
+		}
+	}
+	return ErrScheduledPriceNotFound
+}
+
+// ApplyDuePrices applies every scheduled price whose EffectiveAt is not after
+// now, in EffectiveAt order, and returns how many were applied. The history
+// entry is dated EffectiveAt rather than the time the scheduler ran.
+func (m *MemoryStore) ApplyDuePrices(ctx context.Context, now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.SliceStable(m.scheduledPrices, func(i, j int) bool { return m.scheduledPrices[i].EffectiveAt.Before(m.scheduledPrices[j].EffectiveAt) })
+	applied := 0
+	for _, sp := range m.scheduledPrices {
+		if sp.EffectiveAt.After(now) {
+			break
+		}
+		if p, ok := m.products[sp.ProductID]; ok {
+			m.setPrice(ctx, p, sp.Price, sp.Actor, sp.EffectiveAt, sp.ID)
+			m.touchProduct(p, now)
+		}
+		applied++
+	}
+	m.scheduledPrices = append([]*models.ScheduledPrice(nil), m.scheduledPrices[applied:]...)
+	return applied
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrPromotionNotFound  = errors.New("promotion not found")
+	ErrDuplicateCoupon    = errors.New("coupon code already exists")
+	ErrPromotionExhausted = errors.New("promotion usage limit reached")
+)
+
+func (m *MemoryStore) CreatePromotion(p *models.Promotion) (*models.Promotion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.Code != "" {
+		for _, existing := range m.promotions {
+			if existing.Code == p.Code {
+				return nil, ErrDuplicateCoupon
+			}
+		}
+	}
+	v := clonePromotion(p)
+	v.ID = m.nextPromotionID
+	m.nextPromotionID++
+	v.Uses = 0
+	v.CreatedAt = time.Now()
+	m.promotions[v.ID] = v
+	return clonePromotion(v), nil
+}
+
+// GetPromotions lists every promotion, including inactive ones, in ID order.
+func (m *MemoryStore) GetPromotions() ([]*models.Promotion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.Promotion, 0, len(m.promotions))
+	for _, p := range m.promotions {
+		res = append(res, clonePromotion(p))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+func (m *MemoryStore) GetPromotionByCode(code string) (*models.Promotion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, p := range m.promotions {
+		if p.Code != "" && p.Code == code {
+			return clonePromotion(p), nil
+		}
+	}
+	return nil, ErrPromotionNotFound
+}
+
+// DeactivatePromotion stops a promotion from applying. It is kept so placed
+// orders can still refer to it.
+func (m *MemoryStore) DeactivatePromotion(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.promotions[id]
+	if !ok {
+		return ErrPromotionNotFound
+	}
+	p.Active = false
+	return nil
+}
+
+// PromotionUsesByUser counts placed orders by userID that used promotionID.
+func (m *MemoryStore) PromotionUsesByUser(promotionID, userID uint) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.promotionUses[promotionID][userID]
+}
+
+// ApplyCoupon records code on the user's cart. Applying the same code twice
+// is a no-op.
+func (m *MemoryStore) ApplyCoupon(userID uint, code string) (*models.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return nil, errors.New("user not found")
+	}
+	c := m.getOrCreateCart(userID)
+	for _, existing := range c.Coupons {
+		if existing == code {
+			return cloneCart(c), nil
+		}
+	}
+	c.Coupons = append(c.Coupons, code)
+	return cloneCart(c), nil
+}
+
+func (m *MemoryStore) RemoveCoupon(userID uint, code string) (*models.Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.carts[userID]
+	if !ok {
+		return nil, errors.New("cart not found")
+	}
+	kept := c.Coupons[:0]
+	for _, existing := range c.Coupons {
+		if existing != code {
+			kept = append(kept, existing)
+		}
+	}
+	c.Coupons = kept
+	return cloneCart(c), nil
+}
+
+// ClaimPromotions checks the usage limits of every discount's promotion and
+// counts one use per promotion for userID against orderID. Nothing is counted
+// if any limit is reached, and claiming again for the same order does nothing.
+func (m *MemoryStore) ClaimPromotions(orderID, userID uint, discounts []models.DiscountLine) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.promotionClaims[orderID]; ok {
+		return nil
+	}
+	seen := make(map[uint]bool, len(discounts))
+	for _, d := range discounts {
+		p, ok := m.promotions[d.PromotionID]
+		if !ok || seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		if (p.MaxUses > 0 && p.Uses >= p.MaxUses) || (p.MaxUsesPerUser > 0 && m.promotionUses[p.ID][userID] >= p.MaxUsesPerUser) {
+			return fmt.Errorf("%w: %s", ErrPromotionExhausted, p.Name)
+		}
+	}
+	for id := range seen {
+		m.promotions[id].Uses++
+		if m.promotionUses[id] == nil {
+			m.promotionUses[id] = make(map[uint]int)
+		}
+		m.promotionUses[id][userID]++
+	}
+	m.promotionClaims[orderID] = promotionClaim{userID: userID, promotionIDs: seen}
+	return nil
+}
+
+// ReleasePromotions gives back the uses ClaimPromotions counted for an order
+// whose checkout was abandoned. Orders without a claim are ignored.
+func (m *MemoryStore) ReleasePromotions(orderID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.releasePromotions(orderID)
+}
+
+// promotionClaim is what ClaimPromotions counted for one order.
+type promotionClaim struct {
+	userID       uint
+	promotionIDs map[uint]bool
+}
+
+// releasePromotions gives back an order's claimed promotion uses. Must be
+// called with m.mu held for writing.
+func (m *MemoryStore) releasePromotions(orderID uint) {
+	claim, ok := m.promotionClaims[orderID]
+	if !ok {
+		return
+	}
+	delete(m.promotionClaims, orderID)
+	for id := range claim.promotionIDs {
+		p, ok := m.promotions[id]
+		if !ok {
+			continue
+		}
+		if p.Uses > 0 {
+			p.Uses--
+		}
+		if m.promotionUses[id][claim.userID] > 0 {
+			m.promotionUses[id][claim.userID]--
+		}
+	}
+}
+
+func clonePromotion(p *models.Promotion) *models.Promotion {
+	v := *p
+	v.ProductIDs = append([]uint(nil), p.ProductIDs...)
+	v.Categories = append([]string(nil), p.Categories...)
+	v.Authors = append([]string(nil), p.Authors...)
+	v.StartsAt = cloneTime(p.StartsAt)
+	v.EndsAt = cloneTime(p.EndsAt)
+	return &v
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// UnassignedSupplier collects reorder lines for products without a supplier.
+const UnassignedSupplier = "UNASSIGNED"
+
+var (
+	ErrPurchaseOrderNotFound = errors.New("purchase order not found")
+	ErrPurchaseOrderNotDraft = errors.New("only draft purchase orders can be approved")
+)
+
+// checkReorderPoint queues a StockAlert when stock just dropped from above
+// p.ReorderPoint to at or below it. Must be called with m.mu held for
+// writing, after p.Stock has been updated by qty.
+func (m *MemoryStore) checkReorderPoint(p *models.Product, qty int, cause string, at time.Time) {
+	if p.ReorderPoint <= 0 || qty >= 0 {
+		return
+	}
+	before := p.Stock - qty
+	if before > p.ReorderPoint && p.Stock <= p.ReorderPoint {
+		m.stockAlerts = append(m.stockAlerts, models.StockAlert{
+			ProductID:       p.ID,
+			Title:           p.Title,
+			Supplier:        p.Supplier,
+			Stock:           p.Stock,
+			ReorderPoint:    p.ReorderPoint,
+			ReorderQuantity: p.ReorderQuantity,
+			Cause:           cause,
+			At:              at,
+		})
+		select {
+		case m.alertSignal <- struct{}{}:
+		default: // a wake-up is already pending
+		}
+	}
+}
+
+// StockAlertSignal is signalled whenever new alerts are queued.
+func (m *MemoryStore) StockAlertSignal() <-chan struct{} { return m.alertSignal }
+
+// DrainStockAlerts returns and clears the queued alerts, oldest first.
+func (m *MemoryStore) DrainStockAlerts() []models.StockAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := m.stockAlerts
+	m.stockAlerts = nil
+	return res
+}
+
+// RequeueStockAlerts puts drained alerts that could not be delivered back at
+// the head of the queue, ahead of any queued since. It does not signal: the
+// caller decides when to retry.
+func (m *MemoryStore) RequeueStockAlerts(alerts []models.StockAlert) {
+	if len(alerts) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stockAlerts = append(append([]models.StockAlert(nil), alerts...), m.stockAlerts...)
+}
+
+// GetLowStockProducts lists products at or below their reorder point.
+func (m *MemoryStore) GetLowStockProducts() ([]*models.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	res := make([]*models.Product, 0)
+	for _, p := range m.products {
+		if p.ReorderPoint > 0 && p.Stock <= p.ReorderPoint {
+			res = append(res, m.productView(p, now))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// AddToDraftPurchaseOrder adds a line to the supplier's open draft, creating
+// the draft if needed. A product already on the draft is left as is so
+// repeated alerts do not over-order.
+func (m *MemoryStore) AddToDraftPurchaseOrder(supplier string, productID uint, qty int) (*models.PurchaseOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if supplier == "" {
+		supplier = UnassignedSupplier
+	}
+	var po *models.PurchaseOrder
+	for _, o := range m.purchaseOrders {
+		if o.Supplier == supplier && o.Status == models.PurchaseOrderDraft {
+			po = o
+			break
+		}
+	}
+	if po == nil {
+		po = &models.PurchaseOrder{ID: m.nextPurchaseOrderID, Supplier: supplier, Status: models.PurchaseOrderDraft, Lines: []models.PurchaseOrderLine{}, CreatedAt: time.Now()}
+		m.nextPurchaseOrderID++
+		m.purchaseOrders[po.ID] = po
+	}
+	for _, l := range po.Lines {
+		if l.ProductID == productID {
+			return clonePurchaseOrder(po), nil
+		}
+	}
+	po.Lines = append(po.Lines, models.PurchaseOrderLine{ProductID: productID, Quantity: qty})
+	return clonePurchaseOrder(po), nil
+}
+
+// GetPurchaseOrders lists purchase orders, optionally filtered by status.
+func (m *MemoryStore) GetPurchaseOrders(status string) ([]*models.PurchaseOrder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.PurchaseOrder, 0)
+	for _, o := range m.purchaseOrders {
+		if status == "" || o.Status == status {
+			res = append(res, clonePurchaseOrder(o))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// ApprovePurchaseOrder moves a draft to APPROVED. The next alert for that
+// supplier starts a new draft.
+func (m *MemoryStore) ApprovePurchaseOrder(id uint, actor string) (*models.PurchaseOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	po, ok := m.purchaseOrders[id]
+	if !ok {
+		return nil, ErrPurchaseOrderNotFound
+	}
+	if po.Status != models.PurchaseOrderDraft {
+		return nil, ErrPurchaseOrderNotDraft
+	}
+	now := time.Now()
+	po.Status = models.PurchaseOrderApproved
+	po.ApprovedAt = &now
+	po.ApprovedBy = actor
+	return clonePurchaseOrder(po), nil
+}
+
+func clonePurchaseOrder(o *models.PurchaseOrder) *models.PurchaseOrder {
+	v := *o
+	v.Lines = append([]models.PurchaseOrderLine(nil), o.Lines...)
+	v.ApprovedAt = cloneTime(o.ApprovedAt)
+	return &v
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// DefaultRestockFanout is how many subscribers are released per unit of
+// stock when a product comes back in stock.
+const DefaultRestockFanout = 5
+
+var ErrProductInStock = errors.New("product is in stock")
+
+// SetRestockFanout changes how many subscribers are notified per unit of
+// restocked stock. Subscribers beyond that stay queued for the next restock.
+func (m *MemoryStore) SetRestockFanout(perUnit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restockFanout = perUnit
+}
+
+func sellable(p *models.Product) bool { return !p.Discontinued && p.Stock > 0 }
+
+// SubscribeRestock queues userID for a back-in-stock notification. Only
+// products that cannot currently be bought accept subscriptions; subscribing
+// twice keeps the original place in the queue.
+func (m *MemoryStore) SubscribeRestock(userID, productID uint) (*models.RestockSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.products[productID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	for _, s := range m.restockSubs[productID] {
+		if s.UserID == userID {
+			v := *s
+			return &v, nil
+		}
+	}
+	if sellable(p) {
+		return nil, ErrProductInStock
+	}
+	s := &models.RestockSubscription{UserID: userID, ProductID: productID, CreatedAt: time.Now()}
+	m.restockSubs[productID] = append(m.restockSubs[productID], s)
+	v := *s
+	return &v, nil
+}
+
+// UnsubscribeRestock removes userID from the product's queue, if present.
+func (m *MemoryStore) UnsubscribeRestock(userID, productID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.products[productID]; !ok {
+		return ErrProductNotFound
+	}
+	subs := m.restockSubs[productID]
+	for i, s := range subs {
+		if s.UserID == userID {
+			m.restockSubs[productID] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// checkBackInStock moves the oldest subscribers into the notification outbox
+// when p has just become sellable, up to restockFanout per unit of stock.
+// Must be called with m.mu held for writing, after the whole mutation
+// (including backorder fills) has been applied.
+func (m *MemoryStore) checkBackInStock(p *models.Product, wasSellable bool) {
+	if wasSellable || !sellable(p) {
+		return
+	}
+	subs := m.restockSubs[p.ID]
+	n := len(subs)
+	if m.restockFanout > 0 && p.Stock*m.restockFanout < n {
+		n = p.Stock * m.restockFanout
+	}
+	now := time.Now()
+	for _, s := range subs[:n] {
+		m.restockOutbox = append(m.restockOutbox, &models.RestockNotification{ID: m.nextNotificationID, UserID: s.UserID, ProductID: p.ID, Title: p.Title, SubscribedAt: s.CreatedAt, QueuedAt: now})
+		m.nextNotificationID++
+	}
+	m.restockSubs[p.ID] = append([]*models.RestockSubscription(nil), subs[n:]...)
+}
+
+// PendingRestockNotifications returns up to limit undelivered notifications,
+// oldest first.
+func (m *MemoryStore) PendingRestockNotifications(limit int) []models.RestockNotification {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if limit <= 0 || limit > len(m.restockOutbox) {
+		limit = len(m.restockOutbox)
+	}
+	res := make([]models.RestockNotification, 0, limit)
+	for _, n := range m.restockOutbox[:limit] {
+		res = append(res, *n)
+	}
+	return res
+}
+
+// AckRestockNotification removes a delivered notification from the outbox.
+func (m *MemoryStore) AckRestockNotification(id uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, n := range m.restockOutbox {
+		if n.ID == id {
+			m.restockOutbox = append(m.restockOutbox[:i:i], m.restockOutbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// RestockQueueLength reports how many subscribers are still waiting for a
+// product.
+func (m *MemoryStore) RestockQueueLength(productID uint) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.restockSubs[productID])
+}
@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrReturnNotFound = errors.New("return not found")
+	// ErrReturnStatus is returned when a return's status does not allow the
+	// requested step.
+	ErrReturnStatus = errors.New("return status does not allow this action")
+)
+
+// CreateReturn opens a return on a delivered order. Each line may only be
+// returned up to its ordered quantity across all returns that were not
+// rejected.
+func (m *MemoryStore) CreateReturn(ctx context.Context, r *models.ReturnAuthorization) (*models.ReturnAuthorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orders[r.OrderID]
+	if !ok || o.UserID != r.UserID {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status != models.OrderDelivered {
+		return nil, ErrOrderStatus
+	}
+	open := returnedUnits(o)
+	for _, it := range r.Items {
+		ordered := 0
+		for _, oi := range o.Items {
+			if oi.ProductID == it.ProductID {
+				ordered += oi.Quantity
+			}
+		}
+		if ordered == 0 {
+			return nil, fmt.Errorf("product %d is not on the order", it.ProductID)
+		}
+		if open[it.ProductID]+it.Quantity > ordered {
+			return nil, fmt.Errorf("only %d of product %d can still be returned", ordered-open[it.ProductID], it.ProductID)
+		}
+		open[it.ProductID] += it.Quantity
+	}
+	now := time.Now()
+	v := *cloneReturn(r)
+	v.ID = m.nextReturnID
+	m.nextReturnID++
+	v.CreatedAt, v.UpdatedAt = now, now
+	m.setReturnStatus(ctx, &v, models.ReturnRequested)
+	o.Returns = append(o.Returns, v)
+	return cloneReturn(&v), nil
+}
+
+func (m *MemoryStore) GetReturn(id uint) (*models.ReturnAuthorization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, r := m.findReturn(id)
+	if r == nil {
+		return nil, ErrReturnNotFound
+	}
+	return cloneReturn(r), nil
+}
+
+// GetReturns lists returns with the given status (all when empty), oldest
+// first.
+func (m *MemoryStore) GetReturns(status string) ([]*models.ReturnAuthorization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*models.ReturnAuthorization{}
+	for _, o := range m.orders {
+		for i := range o.Returns {
+			if status == "" || o.Returns[i].Status == status {
+				res = append(res, cloneReturn(&o.Returns[i]))
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// DecideReturn approves or rejects a requested return.
+func (m *MemoryStore) DecideReturn(ctx context.Context, id uint, approve bool) (*models.ReturnAuthorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, r := m.findReturn(id)
+	if r == nil {
+		return nil, ErrReturnNotFound
+	}
+	if r.Status != models.ReturnRequested {
+		return nil, ErrReturnStatus
+	}
+	status := models.ReturnRejected
+	if approve {
+		status = models.ReturnApproved
+	}
+	m.setReturnStatus(ctx, r, status)
+	r.UpdatedAt = time.Now()
+	return cloneReturn(r), nil
+}
+
+// ReceiveReturn records the parcel of an approved return. Every unit comes
+// back as a return movement at warehouseID; written-off units then leave
+// again as damage, so the ledger shows both. items must account for each
+// line's full quantity.
+func (m *MemoryStore) ReceiveReturn(ctx context.Context, id uint, items []models.ReturnItem, warehouseID uint, actor string) (*models.ReturnAuthorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, r := m.findReturn(id)
+	if r == nil {
+		return nil, ErrReturnNotFound
+	}
+	if r.Status != models.ReturnApproved {
+		return nil, ErrReturnStatus
+	}
+	if warehouseID == 0 {
+		warehouseID = DefaultWarehouseID
+	}
+	if _, ok := m.warehouses[warehouseID]; !ok {
+		return nil, ErrWarehouseNotFound
+	}
+	got := make(map[uint]models.ReturnItem, len(items))
+	for _, it := range items {
+		got[it.ProductID] = it
+	}
+	for _, it := range r.Items {
+		g := got[it.ProductID]
+		if g.Restocked < 0 || g.WrittenOff < 0 || g.Restocked+g.WrittenOff != it.Quantity {
+			return nil, fmt.Errorf("product %d: restocked and written-off units must add up to %d", it.ProductID, it.Quantity)
+		}
+	}
+	for i := range r.Items {
+		it := &r.Items[i]
+		g := got[it.ProductID]
+		it.Restocked, it.WrittenOff = g.Restocked, g.WrittenOff
+		p, ok := m.products[it.ProductID]
+		if !ok {
+			continue
+		}
+		wasSellable := sellable(p)
+		reason := fmt.Sprintf("return %d", r.ID)
+		m.applyMovement(ctx, p, models.InventoryMovement{WarehouseID: warehouseID, Type: models.MovementReturn, Quantity: it.Quantity, Reason: reason, Actor: actor, OrderID: r.OrderID})
+		if it.WrittenOff > 0 {
+			m.applyMovement(ctx, p, models.InventoryMovement{WarehouseID: warehouseID, Type: models.MovementDamage, Quantity: -it.WrittenOff, Reason: reason + " written off", Actor: actor, OrderID: r.OrderID})
+		}
+		if it.Restocked > 0 {
+			m.fillBackorders(ctx, p, warehouseID)
+			m.checkBackInStock(p, wasSellable)
+		}
+	}
+	m.setReturnStatus(ctx, r, models.ReturnReceived)
+	r.UpdatedAt = time.Now()
+	return cloneReturn(r), nil
+}
+
+// ClaimReturnRefund starts the refund of a received return, so a concurrent
+// refund of it fails with ErrReturnStatus until the claim ends with
+// RecordReturnRefund or ReleaseReturnRefund. It also settles, once per order,
+// whether this refund includes shipping: it does when wantShipping, called
+// under the lock with the order, says so and no other return of the order has
+// refunded or claimed shipping. It returns copies of the order and the return.
+func (m *MemoryStore) ClaimReturnRefund(id uint, wantShipping func(o *models.Order) bool) (*models.Order, *models.ReturnAuthorization, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, r := m.findReturn(id)
+	if r == nil {
+		return nil, nil, false, ErrReturnNotFound
+	}
+	if r.Status != models.ReturnReceived || m.refundClaims[id] {
+		return nil, nil, false, ErrReturnStatus
+	}
+	m.refundClaims[id] = true
+	_, claimed := m.shippingClaims[o.ID]
+	for _, other := range o.Returns {
+		claimed = claimed || other.ShippingRefunded
+	}
+	shipping := !claimed && wantShipping(cloneOrder(o))
+	if shipping {
+		m.shippingClaims[o.ID] = id
+	}
+	return cloneOrder(o), cloneReturn(r), shipping, nil
+}
+
+// ReleaseReturnRefund ends a refund claim that did not complete, giving back
+// its claim on shipping.
+func (m *MemoryStore) ReleaseReturnRefund(id uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.releaseRefundClaim(id)
+}
+
+// releaseRefundClaim must be called with m.mu held for writing.
+func (m *MemoryStore) releaseRefundClaim(id uint) {
+	delete(m.refundClaims, id)
+	for orderID, returnID := range m.shippingClaims {
+		if returnID == id {
+			delete(m.shippingClaims, orderID)
+		}
+	}
+}
+
+// RecordReturnRefund marks a return claimed with ClaimReturnRefund refunded,
+// with shipping as the claim decided, and stores the provider's updated
+// payment on the order.
+func (m *MemoryStore) RecordReturnRefund(ctx context.Context, id uint, amount float64, p *models.Payment) (*models.ReturnAuthorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, r := m.findReturn(id)
+	if r == nil {
+		return nil, ErrReturnNotFound
+	}
+	if r.Status != models.ReturnReceived || !m.refundClaims[id] {
+		return nil, ErrReturnStatus
+	}
+	shipping := m.shippingClaims[o.ID] == id
+	m.releaseRefundClaim(id)
+	m.setReturnStatus(ctx, r, models.ReturnRefunded)
+	r.RefundAmount = amount
+	r.ShippingRefunded = shipping
+	r.UpdatedAt = time.Now()
+	if p != nil {
+		o.Payment = p
+	}
+	return cloneReturn(r), nil
+}
+
+// setReturnStatus moves a return to status and records ReturnStatusChanged.
+// Must be called with m.mu held for writing.
+func (m *MemoryStore) setReturnStatus(ctx context.Context, r *models.ReturnAuthorization, status string) {
+	m.recordEvent(ctx, models.EventReturnStatusChanged, models.AggregateReturn, r.ID, models.StatusChange{ID: r.ID, OrderID: r.OrderID, UserID: r.UserID, From: r.Status, To: status})
+	r.Status = status
+}
+
+// findReturn locates a return and its order. Must be called with m.mu held.
+func (m *MemoryStore) findReturn(id uint) (*models.Order, *models.ReturnAuthorization) {
+	for _, o := range m.orders {
+		for i := range o.Returns {
+			if o.Returns[i].ID == id {
+				return o, &o.Returns[i]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// returnedUnits counts the units of each product on the order's returns
+// that were not rejected.
+func returnedUnits(o *models.Order) map[uint]int {
+	res := make(map[uint]int)
+	for _, r := range o.Returns {
+		if r.Status == models.ReturnRejected {
+			continue
+		}
+		for _, it := range r.Items {
+			res[it.ProductID] += it.Quantity
+		}
+	}
+	return res
+}
+
+func cloneReturn(r *models.ReturnAuthorization) *models.ReturnAuthorization {
+	v := *r
+	v.Items = append([]models.ReturnItem(nil), r.Items...)
+	return &v
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+var (
+	ErrSagaNotFound = errors.New("checkout saga not found")
+	// ErrSagaLeased is returned by LeaseSaga when another caller is driving
+	// the saga or it changed since the caller read it.
+	ErrSagaLeased = errors.New("checkout saga is being driven by another caller")
+)
+
+// CreateSaga persists a new checkout saga and reserves the ID its order will
+// get, so every step can refer to the order before it exists. The new saga
+// is leased to the caller, who must ReleaseSaga it. When the user already has
+// a saga with the same idempotency key that was not compensated, nothing is
+// created: that saga is returned instead, with created false.
+func (m *MemoryStore) CreateSaga(s *models.CheckoutSaga) (saga *models.CheckoutSaga, created bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.IdempotencyKey != "" {
+		if found := m.findSaga(s.UserID, s.IdempotencyKey); found != nil && found.Status != models.SagaCompensated {
+			return cloneSaga(found), false, nil
+		}
+	}
+	v := cloneSaga(s)
+	v.ID = m.nextSagaID
+	m.nextSagaID++
+	v.OrderID = m.nextOrderID
+	m.nextOrderID++
+	if v.Order != nil {
+		v.Order.ID = v.OrderID
+	}
+	now := time.Now()
+	v.CreatedAt, v.UpdatedAt = now, now
+	m.sagas[v.ID] = v
+	m.sagaLeases[v.ID] = true
+	return cloneSaga(v), true, nil
+}
+
+// LeaseSaga gives the caller sole right to drive a saga's steps until it
+// calls ReleaseSaga. The saga must still have the status and UpdatedAt the
+// caller read, so decisions taken on that copy still hold.
+func (m *MemoryStore) LeaseSaga(id uint, status string, updatedAt time.Time) (*models.CheckoutSaga, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sagas[id]
+	if !ok {
+		return nil, ErrSagaNotFound
+	}
+	if m.sagaLeases[id] || s.Status != status || !s.UpdatedAt.Equal(updatedAt) {
+		return nil, ErrSagaLeased
+	}
+	m.sagaLeases[id] = true
+	return cloneSaga(s), nil
+}
+
+// ReleaseSaga ends a lease taken with CreateSaga or LeaseSaga.
+func (m *MemoryStore) ReleaseSaga(id uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sagaLeases, id)
+}
+
+// SaveSaga records a saga's progress.
+func (m *MemoryStore) SaveSaga(s *models.CheckoutSaga) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sagas[s.ID]; !ok {
+		return ErrSagaNotFound
+	}
+	v := cloneSaga(s)
+	v.UpdatedAt = time.Now()
+	s.UpdatedAt = v.UpdatedAt
+	m.sagas[v.ID] = v
+	return nil
+}
+
+func (m *MemoryStore) GetSaga(id uint) (*models.CheckoutSaga, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sagas[id]
+	if !ok {
+		return nil, ErrSagaNotFound
+	}
+	return cloneSaga(s), nil
+}
+
+// GetSagas lists all checkout sagas, oldest first.
+func (m *MemoryStore) GetSagas() ([]*models.CheckoutSaga, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.CheckoutSaga, 0, len(m.sagas))
+	for _, s := range m.sagas {
+		res = append(res, cloneSaga(s))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// FindSaga returns the user's latest saga started with an idempotency key.
+func (m *MemoryStore) FindSaga(userID uint, key string) (*models.CheckoutSaga, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found := m.findSaga(userID, key)
+	if found == nil {
+		return nil, ErrSagaNotFound
+	}
+	return cloneSaga(found), nil
+}
+
+// findSaga must be called with m.mu held.
+func (m *MemoryStore) findSaga(userID uint, key string) *models.CheckoutSaga {
+	var found *models.CheckoutSaga
+	for _, s := range m.sagas {
+		if s.UserID == userID && s.IdempotencyKey == key && (found == nil || s.ID > found.ID) {
+			found = s
+		}
+	}
+	return found
+}
+
+// ReleaseInventory undoes ReserveInventory for an order that was never
+// created: its allocated units go back as cancellation movements and its
+// backorders are dropped. Orders that are not pending are ignored.
+func (m *MemoryStore) ReleaseInventory(ctx context.Context, orderID uint, actor string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.pendingOrders[orderID]
+	if !ok {
+		return
+	}
+	m.restockOrder(ctx, o, "checkout abandoned", actor)
+	delete(m.pendingOrders, orderID)
+}
+
+func cloneSaga(s *models.CheckoutSaga) *models.CheckoutSaga {
+	v := *s
+	v.Steps = append([]models.SagaStep(nil), s.Steps...)
+	v.Items = append([]models.CartItem(nil), s.Items...)
+	if s.Order != nil {
+		v.Order = cloneOrder(s.Order)
+	}
+	return &v
+}
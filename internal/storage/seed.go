@@ -1,26 +1,37 @@
 package storage
 
-import "ecom-book-store-sample-api/internal/models"
+import (
+	"context"
+
+	"ecom-book-store-sample-api/internal/models"
+)
 
 // Seed seeds users and products for demo
 func Seed(store *MemoryStore) {
 	// Users
 	store.CreateUser(&models.User{Email: "john@email.com", Name: "John Doe"})
 	store.CreateUser(&models.User{Email: "jane@email.com", Name: "Jane Smith"})
-	store.CreateUser(&models.User{Email: "admin@email.com", Name: "Admin User"})
+	store.CreateUser(&models.User{Email: "admin@email.com", Name: "Admin User", Role: models.RoleAdmin})
+	store.CreateAddress(&models.Address{UserID: 1, Label: "home", Name: "John Doe", Line1: "221B Baker Street", City: "London", PostalCode: "NW1 6XE", Country: "GB"})
+	store.CreateAddress(&models.Address{UserID: 2, Label: "home", Name: "Jane Smith", Line1: "350 Fifth Avenue", City: "New York", Region: "NY", PostalCode: "10118", Country: "US"})
+	store.CreateAddress(&models.Address{UserID: 3, Label: "office", Name: "Admin User", Line1: "1 Market Street", City: "San Francisco", Region: "CA", PostalCode: "94105", Country: "US"})
+
+	// Warehouses (MAIN, ID 1, is created by NewMemoryStore and holds seeded stock)
+	store.CreateWarehouse(&models.Warehouse{Code: "EAST", Name: "East distribution centre", Region: "NY", CostPerUnit: 1.20, Priority: 1})
+	store.CreateWarehouse(&models.Warehouse{Code: "WEST", Name: "West distribution centre", Region: "CA", CostPerUnit: 0.90, Priority: 2})
 
 	// Products (books)
 	products := []models.Product{
-		{Title: "The Pragmatic Programmer", Author: "Andrew Hunt", Description: "Journey to Mastery", Price: 45.00, Stock: 50},
-		{Title: "Clean Code", Author: "Robert C. Martin", Description: "A Handbook of Agile Software Craftsmanship", Price: 39.99, Stock: 60},
-		{Title: "Design Patterns", Author: "Erich Gamma", Description: "Elements of Reusable OO Software", Price: 59.99, Stock: 40},
-		{Title: "Introduction to Algorithms", Author: "CLRS", Description: "Comprehensive algorithms text", Price: 89.50, Stock: 35},
-		{Title: "Refactoring", Author: "Martin Fowler", Description: "Improving the Design of Existing Code", Price: 49.99, Stock: 45},
-		{Title: "You Don't Know JS Yet", Author: "Kyle Simpson", Description: "Deep dive into JavaScript", Price: 29.99, Stock: 70},
-		{Title: "Operating Systems: Three Easy Pieces", Author: "Remzi Arpaci-Dusseau", Description: "OS concepts", Price: 25.00, Stock: 80},
-		{Title: "Deep Learning", Author: "Goodfellow, Bengio, Courville", Description: "Foundational deep learning book", Price: 120.00, Stock: 20},
-		{Title: "Domain-Driven Design", Author: "Eric Evans", Description: "Tackling Complexity in the Heart of Software", Price: 74.99, Stock: 30},
-		{Title: "Computer Networks", Author: "Andrew S. Tanenbaum", Description: "Networking principles", Price: 65.00, Stock: 55},
+		{Title: "The Pragmatic Programmer", Author: "Andrew Hunt", Description: "Journey to Mastery", Category: "software-engineering", Price: 45.00, Stock: 50},
+		{Title: "Clean Code", Author: "Robert C. Martin", Description: "A Handbook of Agile Software Craftsmanship", Category: "software-engineering", Price: 39.99, Stock: 60},
+		{Title: "Design Patterns", Author: "Erich Gamma", Description: "Elements of Reusable OO Software", Category: "software-engineering", Price: 59.99, Stock: 40},
+		{Title: "Introduction to Algorithms", Author: "CLRS", Description: "Comprehensive algorithms text", Category: "computer-science", Price: 89.50, Stock: 35},
+		{Title: "Refactoring", Author: "Martin Fowler", Description: "Improving the Design of Existing Code", Category: "software-engineering", Price: 49.99, Stock: 45},
+		{Title: "You Don't Know JS Yet", Author: "Kyle Simpson", Description: "Deep dive into JavaScript", Category: "programming-languages", Price: 29.99, Stock: 70},
+		{Title: "Operating Systems: Three Easy Pieces", Author: "Remzi Arpaci-Dusseau", Description: "OS concepts", Category: "computer-science", Price: 25.00, Stock: 80},
+		{Title: "Deep Learning", Author: "Goodfellow, Bengio, Courville", Description: "Foundational deep learning book", Category: "machine-learning", Price: 120.00, Stock: 20},
+		{Title: "Domain-Driven Design", Author: "Eric Evans", Description: "Tackling Complexity in the Heart of Software", Category: "software-engineering", Price: 74.99, Stock: 30},
+		{Title: "Computer Networks", Author: "Andrew S. Tanenbaum", Description: "Networking principles", Category: "computer-science", Price: 65.00, Stock: 55},
 	}
-	for i := range products { store.CreateProduct(&products[i]) }
+	for i := range products { store.CreateProduct(context.Background(), &products[i], ActorCatalog) }
 }
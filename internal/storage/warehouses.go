@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// DefaultWarehouseID is the warehouse every store starts with. Stock that is
+// not placed anywhere specific (product create, PUT/PATCH stock changes,
+// adjustments without a warehouse) lands here.
+const DefaultWarehouseID uint = 1
+
+var (
+	ErrWarehouseNotFound  = errors.New("warehouse not found")
+	ErrDuplicateWarehouse = errors.New("warehouse code already exists")
+)
+
+// AllocationStrategy orders candidate warehouses from most to least
+// preferred for shipping to destRegion ("" when unknown).
+type AllocationStrategy func(ws []*models.Warehouse, destRegion string)
+
+// AllocationStrategies names the built-in strategies for configuration.
+var AllocationStrategies = map[string]AllocationStrategy{
+	"nearest":  NearestFirst,
+	"cheapest": CheapestFirst,
+}
+
+// NearestFirst prefers warehouses in the destination region (compared
+// case-insensitively with the shipping address's region), then Priority.
+func NearestFirst(ws []*models.Warehouse, destRegion string) {
+	local := func(w *models.Warehouse) bool { return destRegion != "" && strings.EqualFold(w.Region, destRegion) }
+	sort.SliceStable(ws, func(i, j int) bool {
+		ni, nj := local(ws[i]), local(ws[j])
+		if ni != nj {
+			return ni
+		}
+		return lessPriority(ws[i], ws[j])
+	})
+}
+
+// CheapestFirst prefers the lowest CostPerUnit, then Priority.
+func CheapestFirst(ws []*models.Warehouse, destRegion string) {
+	sort.SliceStable(ws, func(i, j int) bool {
+		if ws[i].CostPerUnit != ws[j].CostPerUnit {
+			return ws[i].CostPerUnit < ws[j].CostPerUnit
+		}
+		return lessPriority(ws[i], ws[j])
+	})
+}
+
+func lessPriority(a, b *models.Warehouse) bool {
+	if a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	return a.ID < b.ID
+}
+
+// ReserveOptions tunes how ReserveInventory allocates stock.
+type ReserveOptions struct {
+	// DestRegion is the shipping address's region, matched against
+	// Warehouse.Region by NearestFirst.
+	DestRegion string
+	// Discounts are persisted on the order. Their promotions' usage limits
+	// are checked and counted later, by ClaimPromotions.
+	Discounts []models.DiscountLine
+}
+
+// SetAllocationStrategy replaces the strategy used at checkout. The default
+// is NearestFirst.
+func (m *MemoryStore) SetAllocationStrategy(s AllocationStrategy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.allocate = s
+}
+
+func (m *MemoryStore) CreateWarehouse(w *models.Warehouse) (*models.Warehouse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.warehouses {
+		if existing.Code == w.Code {
+			return nil, ErrDuplicateWarehouse
+		}
+	}
+	w.ID = m.nextWarehouseID
+	m.nextWarehouseID++
+	w.CreatedAt = time.Now()
+	v := *w
+	m.warehouses[w.ID] = &v
+	return cloneWarehouse(&v), nil
+}
+
+func (m *MemoryStore) GetWarehouses() ([]*models.Warehouse, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.Warehouse, 0, len(m.warehouses))
+	for _, w := range m.warehouses {
+		res = append(res, cloneWarehouse(w))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// allocateOrder splits each cart line across warehouses. If one warehouse
+// can ship the whole cart it is used for every line; otherwise each line goes
+// to the best warehouse that can ship it alone, or is split in strategy
+// order. Stock must already have been validated. Must be called with m.mu
+// held.
+func (m *MemoryStore) allocateOrder(items []models.CartItem, destRegion string) map[uint][]models.Allocation {
+	ranked := make([]*models.Warehouse, 0, len(m.warehouses))
+	for _, w := range m.warehouses {
+		ranked = append(ranked, w)
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].ID < ranked[j].ID })
+	strategy := m.allocate
+	if strategy == nil {
+		strategy = NearestFirst
+	}
+	strategy(ranked, destRegion)
+
+	res := make(map[uint][]models.Allocation, len(items))
+	for _, w := range ranked {
+		all := true
+		for _, it := range items {
+			if m.stockByWarehouse[it.ProductID][w.ID] < it.Quantity {
+				all = false
+				break
+			}
+		}
+		if all {
+			for _, it := range items {
+				res[it.ProductID] = []models.Allocation{{WarehouseID: w.ID, Quantity: it.Quantity}}
+			}
+			return res
+		}
+	}
+	for _, it := range items {
+		levels := m.stockByWarehouse[it.ProductID]
+		single := false
+		for _, w := range ranked {
+			if levels[w.ID] >= it.Quantity {
+				res[it.ProductID] = []models.Allocation{{WarehouseID: w.ID, Quantity: it.Quantity}}
+				single = true
+				break
+			}
+		}
+		if single {
+			continue
+		}
+		remaining := it.Quantity
+		for _, w := range ranked {
+			if remaining == 0 {
+				break
+			}
+			take := levels[w.ID]
+			if take > remaining {
+				take = remaining
+			}
+			if take > 0 {
+				res[it.ProductID] = append(res[it.ProductID], models.Allocation{WarehouseID: w.ID, Quantity: take})
+				remaining -= take
+			}
+		}
+	}
+	return res
+}
+
+// spreadAdjustment applies a stock change made by overwriting Product.Stock
+// (PUT, PATCH, import). Increases go to the default warehouse; decreases
+// drain the default warehouse first, then the others in ID order. Must be
+// called with m.mu held for writing.
+func (m *MemoryStore) spreadAdjustment(ctx context.Context, p *models.Product, delta int, reason, actor string, at time.Time) {
+	mv := models.InventoryMovement{Type: models.MovementAdjustment, Reason: reason, Actor: actor}
+	if delta > 0 {
+		m.addWarehouseStock(p.ID, DefaultWarehouseID, delta)
+		p.Stock += delta
+		mv.WarehouseID, mv.Quantity = DefaultWarehouseID, delta
+		m.appendMovement(ctx, p, mv, at)
+		return
+	}
+	ids := make([]uint, 0, len(m.stockByWarehouse[p.ID]))
+	for wid := range m.stockByWarehouse[p.ID] {
+		ids = append(ids, wid)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if (ids[i] == DefaultWarehouseID) != (ids[j] == DefaultWarehouseID) {
+			return ids[i] == DefaultWarehouseID
+		}
+		return ids[i] < ids[j]
+	})
+	remaining := -delta
+	for _, wid := range ids {
+		take := m.stockByWarehouse[p.ID][wid]
+		if take > remaining {
+			take = remaining
+		}
+		if take <= 0 {
+			continue
+		}
+		m.addWarehouseStock(p.ID, wid, -take)
+		p.Stock -= take
+		mv.WarehouseID, mv.Quantity = wid, -take
+		m.appendMovement(ctx, p, mv, at)
+		remaining -= take
+		if remaining == 0 {
+			break
+		}
+	}
+}
+
+func cloneWarehouse(w *models.Warehouse) *models.Warehouse { v := *w; return &v }
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"ecom-book-store-sample-api/internal/models"
+)
+
+// DefaultDeliveryHistory is how many SUCCEEDED webhook deliveries are kept.
+// Pending and DEAD deliveries are always kept.
+const DefaultDeliveryHistory = 1000
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// deliveryKey identifies the delivery of one event to one subscription.
+type deliveryKey struct {
+	subscriptionID uint
+	eventID        uint64
+}
+
+// SetDeliveryHistory changes how many SUCCEEDED deliveries are kept; the
+// oldest beyond that are dropped. 0 keeps all of them.
+func (m *MemoryStore) SetDeliveryHistory(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveryHistory = n
+	m.trimDeliveries()
+}
+
+func (m *MemoryStore) CreateWebhook(w *models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := cloneWebhook(w)
+	v.ID = m.nextWebhookID
+	m.nextWebhookID++
+	now := time.Now()
+	v.CreatedAt, v.UpdatedAt = now, now
+	m.webhooks[v.ID] = v
+	return cloneWebhook(v), nil
+}
+
+// UpdateWebhook replaces a subscription's URL, events and active flag. An
+// empty Secret keeps the current one.
+func (m *MemoryStore) UpdateWebhook(w *models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.webhooks[w.ID]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	existing.URL = w.URL
+	existing.Events = append([]string(nil), w.Events...)
+	existing.Active = w.Active
+	if w.Secret != "" {
+		existing.Secret = w.Secret
+	}
+	existing.UpdatedAt = time.Now()
+	return cloneWebhook(existing), nil
+}
+
+// DeleteWebhook removes a subscription. Its pending deliveries become DEAD;
+// the delivery log is kept.
+func (m *MemoryStore) DeleteWebhook(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(m.webhooks, id)
+	for _, d := range m.webhookDeliveries {
+		if d.SubscriptionID == id && d.Status == models.DeliveryPending {
+			d.Status = models.DeliveryDead
+			d.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetWebhook(id uint) (*models.WebhookSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return cloneWebhook(w), nil
+}
+
+// GetWebhooks lists subscriptions by ID.
+func (m *MemoryStore) GetWebhooks() ([]*models.WebhookSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.WebhookSubscription, 0, len(m.webhooks))
+	for _, w := range m.webhooks {
+		res = append(res, cloneWebhook(w))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// EnqueueWebhookDelivery queues an event for a subscription. The same event
+// is only queued once per subscription while its delivery is kept, so
+// replayed events are harmless.
+func (m *MemoryStore) EnqueueWebhookDelivery(d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[d.SubscriptionID]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+	key := deliveryKey{d.SubscriptionID, d.EventID}
+	if existing, ok := m.deliveryIndex[key]; ok {
+		return cloneDelivery(existing), nil
+	}
+	v := cloneDelivery(d)
+	v.ID = m.nextDeliveryID
+	m.nextDeliveryID++
+	now := time.Now()
+	v.Status = models.DeliveryPending
+	v.Attempts, v.Tries = nil, 0
+	v.NextAttemptAt, v.CreatedAt, v.UpdatedAt = now, now, now
+	m.webhookDeliveries = append(m.webhookDeliveries, v)
+	m.deliveryIndex[key] = v
+	return cloneDelivery(v), nil
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due at now, oldest first.
+func (m *MemoryStore) DueWebhookDeliveries(now time.Time, limit int) []*models.WebhookDelivery {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.WebhookDelivery, 0)
+	for _, d := range m.webhookDeliveries {
+		if len(res) >= limit {
+			break
+		}
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(now) {
+			res = append(res, cloneDelivery(d))
+		}
+	}
+	return res
+}
+
+// RecordWebhookAttempt appends an attempt to a delivery and moves it to
+// status; next is when a PENDING delivery is tried again.
+func (m *MemoryStore) RecordWebhookAttempt(id uint, a models.WebhookAttempt, status string, next time.Time) (*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.findDelivery(id)
+	if d == nil {
+		return nil, ErrDeliveryNotFound
+	}
+	d.Attempts = append(d.Attempts, a)
+	d.Tries++
+	m.setDeliveryStatus(d, status)
+	d.NextAttemptAt = next
+	d.UpdatedAt = time.Now()
+	res := cloneDelivery(d)
+	m.trimDeliveries()
+	return res, nil
+}
+
+// GetWebhookDeliveries lists deliveries, newest first, optionally for one
+// subscription (0 means all) and one status.
+func (m *MemoryStore) GetWebhookDeliveries(subscriptionID uint, status string) ([]*models.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.WebhookDelivery, 0)
+	for i := len(m.webhookDeliveries) - 1; i >= 0; i-- {
+		d := m.webhookDeliveries[i]
+		if (subscriptionID == 0 || d.SubscriptionID == subscriptionID) && (status == "" || d.Status == status) {
+			res = append(res, cloneDelivery(d))
+		}
+	}
+	return res, nil
+}
+
+// RedeliverWebhook queues a delivery again straight away, keeping its attempt
+// history. The subscription must still exist.
+func (m *MemoryStore) RedeliverWebhook(id uint) (*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.findDelivery(id)
+	if d == nil {
+		return nil, ErrDeliveryNotFound
+	}
+	if _, ok := m.webhooks[d.SubscriptionID]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+	now := time.Now()
+	m.setDeliveryStatus(d, models.DeliveryPending)
+	d.Tries = 0
+	d.NextAttemptAt, d.UpdatedAt = now, now
+	return cloneDelivery(d), nil
+}
+
+// setDeliveryStatus moves d to status, keeping the SUCCEEDED count. Must be
+// called with m.mu held for writing.
+func (m *MemoryStore) setDeliveryStatus(d *models.WebhookDelivery, status string) {
+	if d.Status == models.DeliverySucceeded {
+		m.succeeded--
+	}
+	if status == models.DeliverySucceeded {
+		m.succeeded++
+	}
+	d.Status = status
+}
+
+// trimDeliveries drops the oldest SUCCEEDED deliveries beyond
+// deliveryHistory. Must be called with m.mu held for writing.
+func (m *MemoryStore) trimDeliveries() {
+	drop := m.succeeded - m.deliveryHistory
+	if m.deliveryHistory <= 0 || drop <= 0 {
+		return
+	}
+	kept := m.webhookDeliveries[:0]
+	for _, d := range m.webhookDeliveries {
+		if drop > 0 && d.Status == models.DeliverySucceeded {
+			delete(m.deliveryIndex, deliveryKey{d.SubscriptionID, d.EventID})
+			m.succeeded--
+			drop--
+			continue
+		}
+		kept = append(kept, d)
+	}
+	clear(m.webhookDeliveries[len(kept):])
+	m.webhookDeliveries = kept
+}
+
+// findDelivery must be called with m.mu held.
+func (m *MemoryStore) findDelivery(id uint) *models.WebhookDelivery {
+	for _, d := range m.webhookDeliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+func cloneWebhook(w *models.WebhookSubscription) *models.WebhookSubscription {
+	v := *w
+	v.Events = append([]string(nil), w.Events...)
+	return &v
+}
+
+func cloneDelivery(d *models.WebhookDelivery) *models.WebhookDelivery {
+	v := *d
+	v.Payload = append([]byte(nil), d.Payload...)
+	v.Attempts = append([]models.WebhookAttempt(nil), d.Attempts...)
+	return &v
+}